@@ -0,0 +1,218 @@
+// Package armbian reads and writes the settings files of Armbian and
+// libretech images. armbianEnv.txt is a plain text env that boot.scr
+// imports with "env import -t" and folds into bootargs, boot.sel is a
+// regular binary env. Both are available through uenv.Vars so that a
+// tool can manage them like the binary env.
+package armbian
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/mvo5/uboot-go/bootargs"
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+var _ uenv.Vars = (*TextEnv)(nil)
+
+// The variables of armbianEnv.txt that are folded into bootargs or
+// select the device tree overlays
+const (
+	ExtraArgsVar     = "extraargs"
+	OverlaysVar      = "overlays"
+	UserOverlaysVar  = "user_overlays"
+	OverlayPrefixVar = "overlay_prefix"
+	RootDevVar       = "rootdev"
+	VerbosityVar     = "verbosity"
+	ConsoleVar       = "console"
+	FdtFileVar       = "fdtfile"
+)
+
+// TextEnv is a plain text env with one name=value per line as read by
+// "env import -t". Comments and the order of the lines are kept.
+type TextEnv struct {
+	fname string
+	lines []textLine
+}
+
+type textLine struct {
+	// raw is set for comments and lines without "="
+	raw         string
+	name, value string
+}
+
+// ParseText parses the content of a plain text env
+func ParseText(content []byte) *TextEnv {
+	env := &TextEnv{}
+	text := strings.TrimSuffix(strings.Replace(string(content), "\r\n", "\n", -1), "\n")
+	if text == "" {
+		return env
+	}
+	for _, line := range strings.Split(text, "\n") {
+		i := strings.IndexByte(line, '=')
+		if i <= 0 || strings.HasPrefix(line, "#") {
+			env.lines = append(env.lines, textLine{raw: line})
+			continue
+		}
+		env.lines = append(env.lines, textLine{name: line[:i], value: line[i+1:]})
+	}
+	return env
+}
+
+// OpenText opens the plain text env at fname, e.g.
+// /boot/armbianEnv.txt
+func OpenText(fname string) (*TextEnv, error) {
+	content, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	env := ParseText(content)
+	env.fname = fname
+	return env, nil
+}
+
+// CreateText returns a new empty plain text env that is written to
+// fname on Save, an existing file is replaced.
+func CreateText(fname string) *TextEnv {
+	return &TextEnv{fname: fname}
+}
+
+// Bytes returns the content of the plain text env
+func (env *TextEnv) Bytes() []byte {
+	var out strings.Builder
+	for _, l := range env.lines {
+		if l.name == "" {
+			out.WriteString(l.raw)
+		} else {
+			out.WriteString(l.name + "=" + l.value)
+		}
+		out.WriteByte('\n')
+	}
+	return []byte(out.String())
+}
+
+// Get returns the value of the variable, like "env import" the last
+// line wins if a variable is set more than once
+func (env *TextEnv) Get(name string) string {
+	value := ""
+	for _, l := range env.lines {
+		if l.name == name && name != "" {
+			value = l.value
+		}
+	}
+	return value
+}
+
+// Map returns a copy of all variables
+func (env *TextEnv) Map() map[string]string {
+	m := make(map[string]string)
+	for _, l := range env.lines {
+		if l.name != "" {
+			m[l.name] = l.value
+		}
+	}
+	return m
+}
+
+// Set sets the variable, an empty value removes it. The first line of
+// the variable is replaced and others are removed, new variables are
+// appended. Values must fit on a single line.
+func (env *TextEnv) Set(name, value string) error {
+	switch {
+	case name == "":
+		return &uenv.InvalidVarError{Name: name, Reason: "name is empty"}
+	case strings.ContainsAny(name, "=\n\r"):
+		return &uenv.InvalidVarError{Name: name, Reason: `name contains "=" or a newline`}
+	case strings.HasPrefix(name, "#"):
+		return &uenv.InvalidVarError{Name: name, Reason: `name starts with "#"`}
+	case strings.ContainsAny(value, "\n\r"):
+		return &uenv.InvalidVarError{Name: name, Reason: "value contains a newline"}
+	}
+
+	lines := env.lines[:0]
+	found := false
+	for _, l := range env.lines {
+		if l.name == name {
+			if found || value == "" {
+				continue
+			}
+			l.value = value
+			found = true
+		}
+		lines = append(lines, l)
+	}
+	env.lines = lines
+	if !found && value != "" {
+		env.lines = append(env.lines, textLine{name: name, value: value})
+	}
+	return nil
+}
+
+// Save atomically replaces the file of the plain text env
+func (env *TextEnv) Save() error {
+	if env.fname == "" {
+		return fmt.Errorf("cannot save env without file")
+	}
+	fs := &uenv.FileStorage{Path: env.fname}
+	return fs.WriteAtomic(env.Bytes())
+}
+
+// ExtraArgs returns the extraargs that boot.scr appends to bootargs
+func (env *TextEnv) ExtraArgs() (*bootargs.Cmdline, error) {
+	return bootargs.Parse(env.Get(ExtraArgsVar))
+}
+
+// SetExtraArgs sets the extraargs that boot.scr appends to bootargs
+func (env *TextEnv) SetExtraArgs(cmdline *bootargs.Cmdline) error {
+	return env.Set(ExtraArgsVar, cmdline.String())
+}
+
+// Overlays returns the device tree overlays, the list is separated by
+// spaces
+func (env *TextEnv) Overlays() []string {
+	return strings.Fields(env.Get(OverlaysVar))
+}
+
+// SetOverlays sets the device tree overlays
+func (env *TextEnv) SetOverlays(overlays []string) error {
+	return env.Set(OverlaysVar, strings.Join(overlays, " "))
+}
+
+// OpenBootSel opens a boot.sel file, a binary env whose layout is
+// detected like uenv.DetectLayout does
+func OpenBootSel(fname string) (*uenv.Env, error) {
+	cfg, err := uenv.DetectLayout(fname)
+	if err != nil {
+		return nil, err
+	}
+	return uenv.OpenWithConfig(fname, cfg)
+}
+
+// Open opens the settings file at fname, armbianEnv.txt and other text
+// files are opened as TextEnv and everything else as binary env.
+func Open(fname string) (uenv.Vars, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	head := make([]byte, 64)
+	n, _ := f.Read(head)
+	if isText(head[:n]) {
+		return OpenText(fname)
+	}
+	return OpenBootSel(fname)
+}
+
+// isText returns true if content looks like a plain text env, binary
+// envs start with a CRC and end their variables with NUL bytes
+func isText(content []byte) bool {
+	for _, b := range content {
+		if b < 0x20 && b != '\n' && b != '\r' && b != '\t' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,111 @@
+package armbian
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type armbianTestSuite struct{}
+
+var _ = Suite(&armbianTestSuite{})
+
+const armbianEnvTxt = `verbosity=1
+bootlogo=false
+# added by armbian-config
+overlay_prefix=rockchip
+overlays=i2c7 uart4
+rootdev=UUID=1234-abcd
+rootfstype=ext4
+extraargs=cma=256M net.ifnames=0
+`
+
+func (s *armbianTestSuite) TestParseText(c *C) {
+	env := ParseText([]byte(armbianEnvTxt))
+	c.Check(string(env.Bytes()), Equals, armbianEnvTxt)
+	c.Check(env.Get(RootDevVar), Equals, "UUID=1234-abcd")
+	c.Check(env.Get("missing"), Equals, "")
+	c.Check(env.Map(), HasLen, 7)
+	c.Check(env.Overlays(), DeepEquals, []string{"i2c7", "uart4"})
+
+	extra, err := env.ExtraArgs()
+	c.Assert(err, IsNil)
+	value, _ := extra.Get("cma")
+	c.Check(value, Equals, "256M")
+
+	// the last line wins
+	c.Check(ParseText([]byte("a=1\na=2\n")).Get("a"), Equals, "2")
+}
+
+func (s *armbianTestSuite) TestSet(c *C) {
+	env := ParseText([]byte(armbianEnvTxt + "verbosity=2\n"))
+	c.Assert(env.Set(VerbosityVar, "7"), IsNil)
+	c.Assert(env.Set("bootlogo", ""), IsNil)
+	c.Assert(env.SetOverlays([]string{"i2c7", "uart4", "spi-spidev"}), IsNil)
+	extra, err := env.ExtraArgs()
+	c.Assert(err, IsNil)
+	extra.Remove("net.ifnames")
+	extra.SetFlag("quiet")
+	c.Assert(env.SetExtraArgs(extra), IsNil)
+	c.Assert(env.Set(FdtFileVar, "rockchip/rk3588-rock-5b.dtb"), IsNil)
+
+	c.Check(string(env.Bytes()), Equals, `verbosity=7
+# added by armbian-config
+overlay_prefix=rockchip
+overlays=i2c7 uart4 spi-spidev
+rootdev=UUID=1234-abcd
+rootfstype=ext4
+extraargs=cma=256M quiet
+fdtfile=rockchip/rk3588-rock-5b.dtb
+`)
+
+	err = env.Set("a=b", "1")
+	c.Check(err, ErrorMatches, `invalid variable "a=b": name contains "=" or a newline`)
+	c.Check(err, FitsTypeOf, &uenv.InvalidVarError{})
+	c.Check(env.Set("#a", "1"), ErrorMatches, `invalid variable "#a": name starts with "#"`)
+	c.Check(env.Set("a", "1\n2"), ErrorMatches, `invalid variable "a": value contains a newline`)
+	c.Check(env.Set("", "1"), ErrorMatches, `invalid variable "": name is empty`)
+}
+
+func (s *armbianTestSuite) TestOpenSave(c *C) {
+	fname := filepath.Join(c.MkDir(), "armbianEnv.txt")
+	c.Assert(ioutil.WriteFile(fname, []byte(armbianEnvTxt), 0644), IsNil)
+
+	vars, err := Open(fname)
+	c.Assert(err, IsNil)
+	c.Check(vars, FitsTypeOf, &TextEnv{})
+	c.Assert(vars.Set(ConsoleVar, "serial"), IsNil)
+	c.Assert(vars.Save(), IsNil)
+
+	content, err := ioutil.ReadFile(fname)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, armbianEnvTxt+"console=serial\n")
+
+	created := CreateText(filepath.Join(c.MkDir(), "new.txt"))
+	c.Assert(created.Set("a", "1"), IsNil)
+	c.Assert(created.Save(), IsNil)
+	c.Check(ParseText(nil).Save(), ErrorMatches, "cannot save env without file")
+}
+
+func (s *armbianTestSuite) TestOpenBootSel(c *C) {
+	fname := filepath.Join(c.MkDir(), "boot.sel")
+	env, err := uenv.Create(fname, 1024)
+	c.Assert(err, IsNil)
+	c.Assert(env.Set("boot_slot", "b"), IsNil)
+	c.Assert(env.Save(), IsNil)
+
+	vars, err := Open(fname)
+	c.Assert(err, IsNil)
+	c.Check(vars, FitsTypeOf, &uenv.Env{})
+	c.Check(vars.Get("boot_slot"), Equals, "b")
+
+	_, err = Open(filepath.Join(c.MkDir(), "missing"))
+	c.Check(err, NotNil)
+}
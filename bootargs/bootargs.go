@@ -0,0 +1,207 @@
+// Package bootargs parses and builds kernel command lines as found in
+// the bootargs variable, /chosen/bootargs or the cmdline.txt of a
+// Raspberry Pi. Parameters keep their order and parameters that may
+// be given more than once, like console, are supported.
+package bootargs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Param is a single parameter of a command line
+type Param struct {
+	Key string
+	// Value is the value after the "=", HasValue is false for
+	// flags like "quiet"
+	Value    string
+	HasValue bool
+}
+
+// String returns the parameter as it is written on the command line
+func (p Param) String() string {
+	if !p.HasValue {
+		return p.Key
+	}
+	return p.Key + "=" + quote(p.Value)
+}
+
+// quote quotes values with spaces like the kernel expects them
+func quote(s string) string {
+	if strings.ContainsAny(s, " \t\n") {
+		return `"` + s + `"`
+	}
+	return s
+}
+
+// Cmdline is a kernel command line
+type Cmdline struct {
+	params []Param
+	// init are the arguments after "--" that are passed to init
+	init []string
+}
+
+// Parse parses a kernel command line like next_arg() in the kernel
+// does, double quotes group words with spaces.
+func Parse(s string) (*Cmdline, error) {
+	cmdline := &Cmdline{}
+	words, err := split(s)
+	if err != nil {
+		return nil, err
+	}
+	for i, word := range words {
+		if word == "--" {
+			cmdline.init = append(cmdline.init, words[i+1:]...)
+			break
+		}
+		cmdline.params = append(cmdline.params, parseParam(word))
+	}
+	return cmdline, nil
+}
+
+func parseParam(word string) Param {
+	i := strings.IndexByte(word, '=')
+	if i < 0 {
+		return Param{Key: word}
+	}
+	return Param{Key: word[:i], Value: word[i+1:], HasValue: true}
+}
+
+// split splits the command line into words, the quotes are removed
+func split(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord, inQuote := false, false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			inWord = true
+		case !inQuote && (r == ' ' || r == '\t' || r == '\n' || r == '\r'):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if inQuote {
+		return nil, fmt.Errorf("cannot parse command line: unterminated quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// String returns the command line
+func (c *Cmdline) String() string {
+	words := make([]string, 0, len(c.params)+len(c.init)+1)
+	for _, p := range c.params {
+		words = append(words, p.String())
+	}
+	if len(c.init) > 0 {
+		words = append(words, "--")
+		for _, arg := range c.init {
+			words = append(words, quote(arg))
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// Params returns the parameters in the order of the command line
+func (c *Cmdline) Params() []Param {
+	return append([]Param(nil), c.params...)
+}
+
+// InitArgs returns the arguments after "--"
+func (c *Cmdline) InitArgs() []string {
+	return append([]string(nil), c.init...)
+}
+
+// Has returns true if the command line has the given parameter, with
+// or without a value
+func (c *Cmdline) Has(key string) bool {
+	return c.index(key) >= 0
+}
+
+// Get returns the value of the last occurrence of the given parameter,
+// like the kernel the last one wins
+func (c *Cmdline) Get(key string) (value string, ok bool) {
+	for i := len(c.params) - 1; i >= 0; i-- {
+		if c.params[i].Key == key {
+			return c.params[i].Value, true
+		}
+	}
+	return "", false
+}
+
+// Values returns the values of all occurrences of the given parameter,
+// e.g. of all consoles
+func (c *Cmdline) Values(key string) []string {
+	var values []string
+	for _, p := range c.params {
+		if p.Key == key {
+			values = append(values, p.Value)
+		}
+	}
+	return values
+}
+
+func (c *Cmdline) index(key string) int {
+	for i, p := range c.params {
+		if p.Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *Cmdline) set(p Param) {
+	i := c.index(p.Key)
+	if i < 0 {
+		c.params = append(c.params, p)
+		return
+	}
+	// replace the first occurrence and drop the others
+	c.params[i] = p
+	params := c.params[:i+1]
+	for _, q := range c.params[i+1:] {
+		if q.Key != p.Key {
+			params = append(params, q)
+		}
+	}
+	c.params = params
+}
+
+// Set sets the given parameter to value, the first occurrence is
+// replaced and other occurrences are removed. New parameters are
+// appended.
+func (c *Cmdline) Set(key, value string) {
+	c.set(Param{Key: key, Value: value, HasValue: true})
+}
+
+// SetFlag sets a parameter without value like "quiet"
+func (c *Cmdline) SetFlag(key string) {
+	c.set(Param{Key: key})
+}
+
+// Add appends the given parameter even if it is already set, e.g. for
+// a second console
+func (c *Cmdline) Add(key, value string) {
+	c.params = append(c.params, Param{Key: key, Value: value, HasValue: true})
+}
+
+// Remove removes all occurrences of the given parameter
+func (c *Cmdline) Remove(key string) {
+	params := c.params[:0]
+	for _, p := range c.params {
+		if p.Key != key {
+			params = append(params, p)
+		}
+	}
+	c.params = params
+}
@@ -0,0 +1,59 @@
+package bootargs
+
+import (
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type bootargsTestSuite struct{}
+
+var _ = Suite(&bootargsTestSuite{})
+
+func (s *bootargsTestSuite) TestParse(c *C) {
+	cmdline, err := Parse(`console=ttyS0,115200  console=tty1 root=/dev/mmcblk0p2 quiet dyndbg="file foo.c +p" -- single "a b"` + "\n")
+	c.Assert(err, IsNil)
+	c.Check(cmdline.Params(), DeepEquals, []Param{
+		{Key: "console", Value: "ttyS0,115200", HasValue: true},
+		{Key: "console", Value: "tty1", HasValue: true},
+		{Key: "root", Value: "/dev/mmcblk0p2", HasValue: true},
+		{Key: "quiet"},
+		{Key: "dyndbg", Value: "file foo.c +p", HasValue: true},
+	})
+	c.Check(cmdline.InitArgs(), DeepEquals, []string{"single", "a b"})
+	c.Check(cmdline.String(), Equals, `console=ttyS0,115200 console=tty1 root=/dev/mmcblk0p2 quiet dyndbg="file foo.c +p" -- single "a b"`)
+
+	value, ok := cmdline.Get("console")
+	c.Check(ok, Equals, true)
+	c.Check(value, Equals, "tty1")
+	c.Check(cmdline.Values("console"), DeepEquals, []string{"ttyS0,115200", "tty1"})
+	c.Check(cmdline.Has("quiet"), Equals, true)
+	_, ok = cmdline.Get("splash")
+	c.Check(ok, Equals, false)
+
+	_, err = Parse(`init="/bin/sh`)
+	c.Check(err, ErrorMatches, "cannot parse command line: unterminated quote")
+}
+
+func (s *bootargsTestSuite) TestEdit(c *C) {
+	cmdline, err := Parse("console=ttyS0 root=/dev/sda1 console=tty1 quiet")
+	c.Assert(err, IsNil)
+
+	cmdline.Set("console", "ttyAMA0,115200")
+	cmdline.Set("rootwait", "")
+	cmdline.SetFlag("splash")
+	cmdline.Remove("quiet")
+	cmdline.Add("console", "tty1")
+	c.Check(cmdline.String(), Equals, "console=ttyAMA0,115200 root=/dev/sda1 rootwait= splash console=tty1")
+
+	cmdline.SetFlag("root")
+	cmdline.Remove("missing")
+	c.Check(cmdline.String(), Equals, "console=ttyAMA0,115200 root rootwait= splash console=tty1")
+
+	empty, err := Parse("")
+	c.Assert(err, IsNil)
+	c.Check(empty.String(), Equals, "")
+}
@@ -0,0 +1,68 @@
+// Package decompress decompresses the payloads of uboot images. The
+// compressions are named like the -C option of mkimage. gzip, bzip2,
+// lzma, lz4 and zstd are built in, others can be added with Register.
+package decompress
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Func returns a reader over the decompressed data of r
+type Func func(r io.Reader) (io.Reader, error)
+
+var decompressors = map[string]Func{
+	"none": func(r io.Reader) (io.Reader, error) {
+		return r, nil
+	},
+	"gzip": func(r io.Reader) (io.Reader, error) {
+		return gzip.NewReader(r)
+	},
+	"bzip2": func(r io.Reader) (io.Reader, error) {
+		return bzip2.NewReader(r), nil
+	},
+	"lzma": inMemory(lzmaDecode),
+	"lz4":  inMemory(lz4Decode),
+	"zstd": inMemory(zstdDecode),
+}
+
+// MaxSize is the size of the largest output of the decompressors that
+// work in memory (lzma, lz4 and zstd). Corrupt or malicious data that
+// would decompress to more is rejected, it can be raised for huge
+// payloads.
+var MaxSize = 1 << 30
+
+// inMemory returns a Func that decompresses all data at once
+func inMemory(decode func([]byte) ([]byte, error)) Func {
+	return func(r io.Reader) (io.Reader, error) {
+		content, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		out, err := decode(content)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(out), nil
+	}
+}
+
+// Register adds a decompressor for the given compression, an existing
+// one with the same name is replaced.
+func Register(name string, f Func) {
+	decompressors[name] = f
+}
+
+// NewReader returns a reader over the data of r decompressed with the
+// given compression
+func NewReader(name string, r io.Reader) (io.Reader, error) {
+	f, ok := decompressors[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported compression %q", name)
+	}
+	return f(r)
+}
@@ -0,0 +1,182 @@
+package decompress
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type decompressTestSuite struct{}
+
+var _ = Suite(&decompressTestSuite{})
+
+func readTestdata(c *C, name string) []byte {
+	content, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	c.Assert(err, IsNil)
+	return content
+}
+
+func (d *decompressTestSuite) TestNewReader(c *C) {
+	sample := readTestdata(c, "sample")
+	for _, t := range []struct {
+		comp string
+		file string
+	}{
+		{"none", "sample"},
+		{"gzip", "sample.gz"},
+		{"bzip2", "sample.bz2"},
+		// with the size in the header
+		{"lzma", "sample.lzma"},
+		// with an end marker
+		{"lzma", "sample.eos.lzma"},
+		{"lz4", "sample.lz4"},
+		// lz4 -l
+		{"lz4", "sample.legacy.lz4"},
+		// lz4 -BD
+		{"lz4", "sample.linked.lz4"},
+		{"zstd", "sample.zst"},
+	} {
+		r, err := NewReader(t.comp, bytes.NewReader(readTestdata(c, t.file)))
+		c.Assert(err, IsNil, Commentf("%s", t.file))
+		out, err := ioutil.ReadAll(r)
+		c.Assert(err, IsNil, Commentf("%s", t.file))
+		c.Check(bytes.Equal(out, sample), Equals, true, Commentf("%s", t.file))
+	}
+}
+
+func (d *decompressTestSuite) TestNewReaderConcatenatedLZ4(c *C) {
+	sample := readTestdata(c, "sample")
+	frame := readTestdata(c, "sample.lz4")
+	// a skippable frame between two frames
+	skip := []byte{0x5a, 0x2a, 0x4d, 0x18, 2, 0, 0, 0, 'h', 'i'}
+	in := append(append(append([]byte(nil), frame...), skip...), frame...)
+
+	r, err := NewReader("lz4", bytes.NewReader(in))
+	c.Assert(err, IsNil)
+	out, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Check(bytes.Equal(out, append(append([]byte(nil), sample...), sample...)), Equals, true)
+}
+
+func (d *decompressTestSuite) TestNewReaderConcatenatedZstd(c *C) {
+	sample := readTestdata(c, "sample")
+	frame := readTestdata(c, "sample.zst")
+	// a skippable frame and a frame with a raw and an rle block
+	skip := []byte{0x50, 0x2a, 0x4d, 0x18, 2, 0, 0, 0, 'h', 'i'}
+	blocks := []byte{0x28, 0xb5, 0x2f, 0xfd, 0x20, 4, 0x10, 0, 0, 'h', 'e', 0x13, 0, 0, 'l'}
+	in := append(append(append([]byte(nil), frame...), skip...), blocks...)
+
+	r, err := NewReader("zstd", bytes.NewReader(in))
+	c.Assert(err, IsNil)
+	out, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Check(bytes.Equal(out, append(append([]byte(nil), sample...), "hell"...)), Equals, true)
+}
+
+func (d *decompressTestSuite) TestNewReaderCorrupt(c *C) {
+	for _, t := range []struct {
+		comp string
+		file string
+		err  string
+	}{
+		{"lzma", "sample.lzma", "corrupt lzma data|lzma data truncated"},
+		{"lz4", "sample.lz4", "lz4 .*truncated|corrupt lz4 block"},
+		{"lz4", "sample.legacy.lz4", "lz4 .*truncated|corrupt lz4 block"},
+		{"zstd", "sample.zst", "zstd .*truncated|corrupt zstd data"},
+	} {
+		in := readTestdata(c, t.file)
+		_, err := NewReader(t.comp, bytes.NewReader(in[:len(in)/2]))
+		c.Check(err, ErrorMatches, t.err, Commentf("%s", t.file))
+	}
+
+	_, err := NewReader("lz4", strings.NewReader("nope"))
+	c.Check(err, ErrorMatches, "bad lz4 magic: 0x65706f6e")
+	_, err = NewReader("zstd", strings.NewReader("nope"))
+	c.Check(err, ErrorMatches, "bad zstd magic: 0x65706f6e")
+	// a frame that needs dictionary 1
+	_, err = NewReader("zstd", strings.NewReader("\x28\xb5\x2f\xfd\x21\x01\x00"))
+	c.Check(err, ErrorMatches, "unsupported zstd dictionary 1")
+	_, err = NewReader("lzma", strings.NewReader("\xff\x00\x00\x80\x00\xff\xff\xff\xff\xff\xff\xff\xff\x00"))
+	c.Check(err, ErrorMatches, "bad lzma properties: 0xff")
+	_, err = NewReader("gzip", strings.NewReader("nope"))
+	c.Check(err, NotNil)
+}
+
+func (d *decompressTestSuite) TestNewReaderTooLarge(c *C) {
+	// the header claims 1 TiB
+	_, err := NewReader("lzma", strings.NewReader("\x5d\x00\x00\x80\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00"))
+	c.Check(err, ErrorMatches, "lzma data too large: 1099511627776 bytes")
+
+	defer func(old int) { MaxSize = old }(MaxSize)
+	MaxSize = 64
+	for _, t := range []struct {
+		comp string
+		file string
+		err  string
+	}{
+		{"lzma", "sample.eos.lzma", "lzma data exceeds 64 bytes"},
+		{"lz4", "sample.lz4", "lz4 data exceeds 64 bytes"},
+		{"zstd", "sample.zst", "zstd data too large: 22400 bytes"},
+	} {
+		_, err := NewReader(t.comp, bytes.NewReader(readTestdata(c, t.file)))
+		c.Check(err, ErrorMatches, t.err, Commentf("%s", t.file))
+	}
+}
+
+func (d *decompressTestSuite) TestNewReaderUnsupported(c *C) {
+	_, err := NewReader("lzo", strings.NewReader(""))
+	c.Check(err, ErrorMatches, `unsupported compression "lzo"`)
+}
+
+func (d *decompressTestSuite) TestRegister(c *C) {
+	defer delete(decompressors, "upper")
+	Register("upper", func(r io.Reader) (io.Reader, error) {
+		content, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(bytes.ToUpper(content)), nil
+	})
+
+	r, err := NewReader("upper", strings.NewReader("foo"))
+	c.Assert(err, IsNil)
+	out, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Check(string(out), Equals, "FOO")
+}
+
+func fuzzInMemory(f *testing.F, decode func([]byte) ([]byte, error), seeds ...string) {
+	for _, name := range seeds {
+		content, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(content)
+	}
+	f.Fuzz(func(t *testing.T, in []byte) {
+		out, err := decode(in)
+		if err == nil && len(out) > MaxSize {
+			t.Fatalf("output of %v bytes exceeds MaxSize", len(out))
+		}
+	})
+}
+
+func FuzzLZMA(f *testing.F) {
+	fuzzInMemory(f, lzmaDecode, "sample.lzma", "sample.eos.lzma")
+}
+
+func FuzzLZ4(f *testing.F) {
+	fuzzInMemory(f, lz4Decode, "sample.lz4", "sample.legacy.lz4", "sample.linked.lz4")
+}
+
+func FuzzZstd(f *testing.F) {
+	fuzzInMemory(f, zstdDecode, "sample.zst")
+}
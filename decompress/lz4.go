@@ -0,0 +1,203 @@
+package decompress
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// The magic numbers of the lz4 formats
+const (
+	lz4FrameMagic  = 0x184d2204
+	lz4LegacyMagic = 0x184c2102
+	// skippable frames use 0x184d2a50 to 0x184d2a5f
+	lz4SkipMagic = 0x184d2a50
+)
+
+// lz4Decode decodes lz4 frames as written by "lz4" and legacy frames
+// as written by "lz4 -l" (used for kernels). Checksums are not
+// verified.
+func lz4Decode(in []byte) ([]byte, error) {
+	var out []byte
+	for len(in) > 0 {
+		if len(in) < 4 {
+			return nil, fmt.Errorf("lz4 data truncated")
+		}
+		var err error
+		magic := binary.LittleEndian.Uint32(in)
+		switch {
+		case magic == lz4FrameMagic:
+			out, in, err = lz4Frame(out, in[4:])
+		case magic == lz4LegacyMagic:
+			out, in, err = lz4Legacy(out, in[4:])
+		case magic&0xfffffff0 == lz4SkipMagic:
+			if len(in) < 8 {
+				return nil, fmt.Errorf("lz4 data truncated")
+			}
+			size := uint64(binary.LittleEndian.Uint32(in[4:]))
+			if 8+size > uint64(len(in)) {
+				return nil, fmt.Errorf("lz4 data truncated")
+			}
+			in = in[8+size:]
+		default:
+			return nil, fmt.Errorf("bad lz4 magic: %#x", magic)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// lz4Frame decodes a frame without its magic and returns the data that
+// follows the frame
+func lz4Frame(out, in []byte) ([]byte, []byte, error) {
+	if len(in) < 3 {
+		return nil, nil, fmt.Errorf("lz4 frame truncated")
+	}
+	flg := in[0]
+	if flg>>6 != 1 {
+		return nil, nil, fmt.Errorf("unsupported lz4 frame version %v", flg>>6)
+	}
+	blockChecksum := flg&0x10 != 0
+	contentChecksum := flg&0x04 != 0
+	hdrSize := 3
+	if flg&0x08 != 0 {
+		// content size
+		hdrSize += 8
+	}
+	if flg&0x01 != 0 {
+		// dictionary ID
+		hdrSize += 4
+	}
+	if len(in) < hdrSize {
+		return nil, nil, fmt.Errorf("lz4 frame truncated")
+	}
+	in = in[hdrSize:]
+
+	for {
+		if len(in) < 4 {
+			return nil, nil, fmt.Errorf("lz4 frame truncated")
+		}
+		size := binary.LittleEndian.Uint32(in)
+		in = in[4:]
+		if size == 0 {
+			break
+		}
+		raw := size&0x80000000 != 0
+		size &= 0x7fffffff
+		if uint64(size) > uint64(len(in)) {
+			return nil, nil, fmt.Errorf("lz4 block truncated")
+		}
+		var err error
+		if raw {
+			out = append(out, in[:size]...)
+		} else if out, err = lz4Block(out, in[:size]); err != nil {
+			return nil, nil, err
+		}
+		in = in[size:]
+		if blockChecksum {
+			if len(in) < 4 {
+				return nil, nil, fmt.Errorf("lz4 frame truncated")
+			}
+			in = in[4:]
+		}
+	}
+	if contentChecksum {
+		if len(in) < 4 {
+			return nil, nil, fmt.Errorf("lz4 frame truncated")
+		}
+		in = in[4:]
+	}
+	return out, in, nil
+}
+
+// lz4Legacy decodes a legacy frame without its magic, the frame ends
+// at the end of the data or at the magic of the next frame
+func lz4Legacy(out, in []byte) ([]byte, []byte, error) {
+	for len(in) >= 4 {
+		size := binary.LittleEndian.Uint32(in)
+		if size == lz4FrameMagic || size == lz4LegacyMagic {
+			break
+		}
+		in = in[4:]
+		if uint64(size) > uint64(len(in)) {
+			return nil, nil, fmt.Errorf("lz4 block truncated")
+		}
+		var err error
+		if out, err = lz4Block(out, in[:size]); err != nil {
+			return nil, nil, err
+		}
+		in = in[size:]
+	}
+	if len(in) > 0 && len(in) < 4 {
+		return nil, nil, fmt.Errorf("lz4 frame truncated")
+	}
+	return out, in, nil
+}
+
+// lz4Block decodes a compressed block and appends it to out. Matches
+// may refer to data of previous blocks in out.
+func lz4Block(out, src []byte) ([]byte, error) {
+	errCorrupt := fmt.Errorf("corrupt lz4 block")
+	// length reads the extension bytes of a literal or match length
+	length := func(i, n int) (int, int, error) {
+		if n != 15 {
+			return i, n, nil
+		}
+		for {
+			if i >= len(src) {
+				return 0, 0, errCorrupt
+			}
+			b := src[i]
+			i++
+			n += int(b)
+			if b != 255 {
+				return i, n, nil
+			}
+		}
+	}
+
+	i := 0
+	for {
+		if i >= len(src) {
+			return nil, errCorrupt
+		}
+		token := src[i]
+		i++
+		var litLen int
+		var err error
+		if i, litLen, err = length(i, int(token>>4)); err != nil {
+			return nil, err
+		}
+		if litLen > len(src)-i {
+			return nil, errCorrupt
+		}
+		out = append(out, src[i:i+litLen]...)
+		i += litLen
+		if i == len(src) {
+			// the last sequence only has literals
+			return out, nil
+		}
+
+		if i+2 > len(src) {
+			return nil, errCorrupt
+		}
+		offset := int(src[i]) | int(src[i+1])<<8
+		i += 2
+		if offset == 0 || offset > len(out) {
+			return nil, errCorrupt
+		}
+		var matchLen int
+		if i, matchLen, err = length(i, int(token&15)); err != nil {
+			return nil, err
+		}
+		matchLen += 4
+		if len(out)+matchLen > MaxSize {
+			return nil, fmt.Errorf("lz4 data exceeds %v bytes", MaxSize)
+		}
+		start := len(out) - offset
+		for j := 0; j < matchLen; j++ {
+			out = append(out, out[start+j])
+		}
+	}
+}
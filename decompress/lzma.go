@@ -0,0 +1,378 @@
+package decompress
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// The LZMA decoder follows the LZMA specification of the LZMA SDK
+const (
+	lzmaHeaderSize     = 13
+	lzmaBitModelTotal  = 1 << 11
+	lzmaNumMoveBits    = 5
+	lzmaTopValue       = 1 << 24
+	lzmaNumStates      = 12
+	lzmaNumPosBitsMax  = 4
+	lzmaNumLenStates   = 4
+	lzmaEndPosModel    = 14
+	lzmaNumFullDist    = 1 << (lzmaEndPosModel >> 1)
+	lzmaNumAlignBits   = 4
+	lzmaMatchMinLen    = 2
+	lzmaProbInitValue  = lzmaBitModelTotal / 2
+	lzmaUnknownSize    = ^uint64(0)
+	lzmaMinDictSize    = 1 << 12
+	lzmaNumLitProbs    = 0x300
+	lzmaMaxPropsByte   = 9 * 5 * 5
+	lzmaEndMarkerDist  = 0xffffffff
+	lzmaStateLitLimit  = 7
+	lzmaNumPosSlotBits = 6
+)
+
+var errLZMACorrupt = fmt.Errorf("corrupt lzma data")
+
+type rangeDecoder struct {
+	in   []byte
+	pos  int
+	rng  uint32
+	code uint32
+	err  error
+}
+
+func (rd *rangeDecoder) init() error {
+	if len(rd.in) < 5 {
+		return errLZMACorrupt
+	}
+	if rd.in[0] != 0 {
+		return errLZMACorrupt
+	}
+	rd.rng = 0xffffffff
+	rd.code = binary.BigEndian.Uint32(rd.in[1:])
+	rd.pos = 5
+	if rd.code == rd.rng {
+		return errLZMACorrupt
+	}
+	return nil
+}
+
+func (rd *rangeDecoder) normalize() {
+	if rd.rng >= lzmaTopValue {
+		return
+	}
+	rd.rng <<= 8
+	var b byte
+	if rd.pos < len(rd.in) {
+		b = rd.in[rd.pos]
+		rd.pos++
+	} else {
+		rd.err = errLZMACorrupt
+	}
+	rd.code = rd.code<<8 | uint32(b)
+}
+
+func (rd *rangeDecoder) directBits(n uint32) uint32 {
+	var res uint32
+	for ; n > 0; n-- {
+		rd.rng >>= 1
+		rd.code -= rd.rng
+		t := 0 - (rd.code >> 31)
+		rd.code += rd.rng & t
+		if rd.code == rd.rng {
+			rd.err = errLZMACorrupt
+		}
+		rd.normalize()
+		res = res<<1 + t + 1
+	}
+	return res
+}
+
+func (rd *rangeDecoder) bit(p *uint16) uint32 {
+	v := uint32(*p)
+	bound := (rd.rng >> 11) * v
+	var symbol uint32
+	if rd.code < bound {
+		v += (lzmaBitModelTotal - v) >> lzmaNumMoveBits
+		rd.rng = bound
+	} else {
+		v -= v >> lzmaNumMoveBits
+		rd.code -= bound
+		rd.rng -= bound
+		symbol = 1
+	}
+	*p = uint16(v)
+	rd.normalize()
+	return symbol
+}
+
+func (rd *rangeDecoder) bitTree(probs []uint16, numBits uint32) uint32 {
+	m := uint32(1)
+	for i := uint32(0); i < numBits; i++ {
+		m = m<<1 + rd.bit(&probs[m])
+	}
+	return m - 1<<numBits
+}
+
+func (rd *rangeDecoder) bitTreeReverse(probs []uint16, numBits uint32) uint32 {
+	m := uint32(1)
+	var symbol uint32
+	for i := uint32(0); i < numBits; i++ {
+		bit := rd.bit(&probs[m])
+		m = m<<1 + bit
+		symbol |= bit << i
+	}
+	return symbol
+}
+
+func initProbs(probs []uint16) {
+	for i := range probs {
+		probs[i] = lzmaProbInitValue
+	}
+}
+
+type lenDecoder struct {
+	choice  uint16
+	choice2 uint16
+	low     [1 << lzmaNumPosBitsMax][1 << 3]uint16
+	mid     [1 << lzmaNumPosBitsMax][1 << 3]uint16
+	high    [1 << 8]uint16
+}
+
+func (ld *lenDecoder) init() {
+	ld.choice = lzmaProbInitValue
+	ld.choice2 = lzmaProbInitValue
+	for i := range ld.low {
+		initProbs(ld.low[i][:])
+		initProbs(ld.mid[i][:])
+	}
+	initProbs(ld.high[:])
+}
+
+func (ld *lenDecoder) decode(rd *rangeDecoder, posState uint32) uint32 {
+	if rd.bit(&ld.choice) == 0 {
+		return rd.bitTree(ld.low[posState][:], 3)
+	}
+	if rd.bit(&ld.choice2) == 0 {
+		return 8 + rd.bitTree(ld.mid[posState][:], 3)
+	}
+	return 16 + rd.bitTree(ld.high[:], 8)
+}
+
+type lzmaDecoder struct {
+	rd         rangeDecoder
+	lc, lp, pb uint32
+	dictSize   uint32
+	out        []byte
+
+	literal     []uint16
+	posSlot     [lzmaNumLenStates][1 << lzmaNumPosSlotBits]uint16
+	posDecoders [1 + lzmaNumFullDist - lzmaEndPosModel]uint16
+	align       [1 << lzmaNumAlignBits]uint16
+	isMatch     [lzmaNumStates << lzmaNumPosBitsMax]uint16
+	isRep       [lzmaNumStates]uint16
+	isRepG0     [lzmaNumStates]uint16
+	isRepG1     [lzmaNumStates]uint16
+	isRepG2     [lzmaNumStates]uint16
+	isRep0Long  [lzmaNumStates << lzmaNumPosBitsMax]uint16
+	lenDec      lenDecoder
+	repLenDec   lenDecoder
+}
+
+// lzmaDecode decodes data in the .lzma format as written by "lzma" and
+// used by mkimage -C lzma
+func lzmaDecode(in []byte) ([]byte, error) {
+	if len(in) < lzmaHeaderSize {
+		return nil, fmt.Errorf("lzma data truncated")
+	}
+	props := uint32(in[0])
+	if props >= lzmaMaxPropsByte {
+		return nil, fmt.Errorf("bad lzma properties: %#x", props)
+	}
+	d := &lzmaDecoder{
+		lc:       props % 9,
+		lp:       props / 9 % 5,
+		pb:       props / 45,
+		dictSize: binary.LittleEndian.Uint32(in[1:]),
+		rd:       rangeDecoder{in: in[lzmaHeaderSize:]},
+	}
+	if d.dictSize < lzmaMinDictSize {
+		d.dictSize = lzmaMinDictSize
+	}
+	unpackSize := binary.LittleEndian.Uint64(in[5:])
+	if unpackSize != lzmaUnknownSize && unpackSize > uint64(MaxSize) {
+		return nil, fmt.Errorf("lzma data too large: %v bytes", unpackSize)
+	}
+	if err := d.rd.init(); err != nil {
+		return nil, err
+	}
+	if err := d.decode(unpackSize); err != nil {
+		return nil, err
+	}
+	return d.out, nil
+}
+
+func (d *lzmaDecoder) init() {
+	d.literal = make([]uint16, lzmaNumLitProbs<<(d.lc+d.lp))
+	initProbs(d.literal)
+	for i := range d.posSlot {
+		initProbs(d.posSlot[i][:])
+	}
+	initProbs(d.posDecoders[:])
+	initProbs(d.align[:])
+	initProbs(d.isMatch[:])
+	initProbs(d.isRep[:])
+	initProbs(d.isRepG0[:])
+	initProbs(d.isRepG1[:])
+	initProbs(d.isRepG2[:])
+	initProbs(d.isRep0Long[:])
+	d.lenDec.init()
+	d.repLenDec.init()
+}
+
+func (d *lzmaDecoder) decodeLiteral(state, rep0 uint32) {
+	var prevByte uint32
+	if len(d.out) > 0 {
+		prevByte = uint32(d.out[len(d.out)-1])
+	}
+	litState := ((uint32(len(d.out)) & (1<<d.lp - 1)) << d.lc) + prevByte>>(8-d.lc)
+	probs := d.literal[lzmaNumLitProbs*litState:]
+
+	symbol := uint32(1)
+	if state >= lzmaStateLitLimit {
+		matchByte := uint32(d.out[len(d.out)-int(rep0)-1])
+		for symbol < 0x100 {
+			matchBit := matchByte >> 7 & 1
+			matchByte <<= 1
+			bit := d.rd.bit(&probs[(1+matchBit)<<8+symbol])
+			symbol = symbol<<1 | bit
+			if matchBit != bit {
+				break
+			}
+		}
+	}
+	for symbol < 0x100 {
+		symbol = symbol<<1 | d.rd.bit(&probs[symbol])
+	}
+	d.out = append(d.out, byte(symbol))
+}
+
+func (d *lzmaDecoder) decodeDistance(length uint32) uint32 {
+	lenState := length
+	if lenState > lzmaNumLenStates-1 {
+		lenState = lzmaNumLenStates - 1
+	}
+	posSlot := d.rd.bitTree(d.posSlot[lenState][:], lzmaNumPosSlotBits)
+	if posSlot < 4 {
+		return posSlot
+	}
+	numDirectBits := posSlot>>1 - 1
+	dist := (2 | posSlot&1) << numDirectBits
+	if posSlot < lzmaEndPosModel {
+		return dist + d.rd.bitTreeReverse(d.posDecoders[dist-posSlot:], numDirectBits)
+	}
+	dist += d.rd.directBits(numDirectBits-lzmaNumAlignBits) << lzmaNumAlignBits
+	return dist + d.rd.bitTreeReverse(d.align[:], lzmaNumAlignBits)
+}
+
+func (d *lzmaDecoder) decode(unpackSize uint64) error {
+	d.init()
+	sizeDefined := unpackSize != lzmaUnknownSize
+	var rep0, rep1, rep2, rep3, state uint32
+
+	for {
+		if d.rd.err != nil {
+			return d.rd.err
+		}
+		if sizeDefined && uint64(len(d.out)) == unpackSize {
+			// the end marker is optional if the size is known
+			return nil
+		}
+		if len(d.out) > MaxSize {
+			return fmt.Errorf("lzma data exceeds %v bytes", MaxSize)
+		}
+		posState := uint32(len(d.out)) & (1<<d.pb - 1)
+
+		if d.rd.bit(&d.isMatch[state<<lzmaNumPosBitsMax+posState]) == 0 {
+			d.decodeLiteral(state, rep0)
+			switch {
+			case state < 4:
+				state = 0
+			case state < 10:
+				state -= 3
+			default:
+				state -= 6
+			}
+			continue
+		}
+
+		var length uint32
+		if d.rd.bit(&d.isRep[state]) != 0 {
+			if len(d.out) == 0 {
+				return errLZMACorrupt
+			}
+			if d.rd.bit(&d.isRepG0[state]) == 0 {
+				if d.rd.bit(&d.isRep0Long[state<<lzmaNumPosBitsMax+posState]) == 0 {
+					// short rep, a single byte at rep0
+					if state < lzmaStateLitLimit {
+						state = 9
+					} else {
+						state = 11
+					}
+					d.out = append(d.out, d.out[len(d.out)-int(rep0)-1])
+					continue
+				}
+			} else {
+				var dist uint32
+				if d.rd.bit(&d.isRepG1[state]) == 0 {
+					dist = rep1
+				} else {
+					if d.rd.bit(&d.isRepG2[state]) == 0 {
+						dist = rep2
+					} else {
+						dist = rep3
+						rep3 = rep2
+					}
+					rep2 = rep1
+				}
+				rep1 = rep0
+				rep0 = dist
+			}
+			length = d.repLenDec.decode(&d.rd, posState)
+			if state < lzmaStateLitLimit {
+				state = 8
+			} else {
+				state = 11
+			}
+		} else {
+			rep3 = rep2
+			rep2 = rep1
+			rep1 = rep0
+			length = d.lenDec.decode(&d.rd, posState)
+			if state < lzmaStateLitLimit {
+				state = 7
+			} else {
+				state = 10
+			}
+			rep0 = d.decodeDistance(length)
+			if rep0 == lzmaEndMarkerDist {
+				if d.rd.err != nil || d.rd.code != 0 {
+					return errLZMACorrupt
+				}
+				if sizeDefined && uint64(len(d.out)) != unpackSize {
+					return fmt.Errorf("lzma data truncated")
+				}
+				return nil
+			}
+			if rep0 >= d.dictSize || int(rep0) >= len(d.out) {
+				return errLZMACorrupt
+			}
+		}
+
+		length += lzmaMatchMinLen
+		if sizeDefined && uint64(length) > unpackSize-uint64(len(d.out)) {
+			return errLZMACorrupt
+		}
+		start := len(d.out) - int(rep0) - 1
+		for i := 0; i < int(length); i++ {
+			d.out = append(d.out, d.out[start+i])
+		}
+	}
+}
@@ -0,0 +1,897 @@
+package decompress
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// The zstd decoder follows RFC 8878
+const (
+	zstdMagic = 0xfd2fb528
+	// skippable frames use 0x184d2a50 to 0x184d2a5f
+	zstdSkipMagic    = 0x184d2a50
+	zstdMaxBlockSize = 128 << 10
+	zstdMaxHufBits   = 11
+	zstdMaxHufSyms   = 256
+)
+
+var errZstdCorrupt = fmt.Errorf("corrupt zstd data")
+
+// The baselines and number of extra bits of the literals length and
+// match length codes
+var (
+	zstdLLBase = [36]uint32{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536,
+	}
+	zstdLLBits = [36]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+		13, 14, 15, 16,
+	}
+	zstdMLBase = [53]uint32{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539,
+	}
+	zstdMLBits = [53]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16,
+	}
+)
+
+// The predefined distributions of the literals length, offset and
+// match length codes
+var (
+	zstdLLDefault = []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}
+	zstdOFDefault = []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}
+	zstdMLDefault = []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}
+)
+
+// highBit returns the position of the highest set bit of v > 0
+func highBit(v uint64) int {
+	n := -1
+	for ; v != 0; v >>= 1 {
+		n++
+	}
+	return n
+}
+
+// fwdBits reads bits from the start of in, lowest bits first
+type fwdBits struct {
+	in  []byte
+	pos int
+}
+
+func (br *fwdBits) peek(n int) uint32 {
+	var v uint32
+	for i := 0; i < n; i++ {
+		p := br.pos + i
+		if p/8 < len(br.in) {
+			v |= uint32(br.in[p/8]>>uint(p%8)&1) << uint(i)
+		}
+	}
+	return v
+}
+
+func (br *fwdBits) read(n int) uint32 {
+	v := br.peek(n)
+	br.pos += n
+	return v
+}
+
+// overflow reports whether more bits were read than there are
+func (br *fwdBits) overflow() bool {
+	return br.pos > len(br.in)*8
+}
+
+// used returns the number of bytes that were read
+func (br *fwdBits) used() int {
+	return (br.pos + 7) / 8
+}
+
+// revBits reads bits from the end of in, the stream starts after the
+// highest set bit of the last byte. Bits past the beginning of in read
+// as zero.
+type revBits struct {
+	in  []byte
+	off int
+}
+
+func newRevBits(in []byte) (*revBits, error) {
+	if len(in) == 0 || in[len(in)-1] == 0 {
+		return nil, errZstdCorrupt
+	}
+	return &revBits{in: in, off: (len(in)-1)*8 + highBit(uint64(in[len(in)-1]))}, nil
+}
+
+func (br *revBits) read(n int) uint64 {
+	if n == 0 {
+		return 0
+	}
+	br.off -= n
+	off, cnt := br.off, n
+	if off < 0 {
+		cnt += off
+		off = 0
+	}
+	var v uint64
+	for i := 0; i < cnt; {
+		p := off + i
+		take := 8 - p%8
+		if take > cnt-i {
+			take = cnt - i
+		}
+		v |= uint64(br.in[p/8]>>uint(p%8)) & (1<<uint(take) - 1) << uint(i)
+		i += take
+	}
+	if br.off < 0 {
+		if -br.off >= 64 {
+			return 0
+		}
+		v <<= uint(-br.off)
+	}
+	return v
+}
+
+// fseTable is an FSE decoding table
+type fseTable struct {
+	log     int
+	symbols []uint8
+	bits    []uint8
+	base    []uint16
+}
+
+// newFSETable builds the decoding table of the given normalized
+// distribution, a count of -1 is a probability of less than 1
+func newFSETable(counts []int16, log int) (*fseTable, error) {
+	size := 1 << uint(log)
+	t := &fseTable{
+		log:     log,
+		symbols: make([]uint8, size),
+		bits:    make([]uint8, size),
+		base:    make([]uint16, size),
+	}
+	next := make([]int, len(counts))
+	high := size
+	for s, n := range counts {
+		if n == -1 {
+			high--
+			t.symbols[high] = uint8(s)
+			next[s] = 1
+		} else {
+			next[s] = int(n)
+		}
+	}
+	step := size>>1 + size>>3 + 3
+	pos := 0
+	for s, n := range counts {
+		for i := 0; i < int(n); i++ {
+			t.symbols[pos] = uint8(s)
+			for {
+				pos = (pos + step) & (size - 1)
+				if pos < high {
+					break
+				}
+			}
+		}
+	}
+	if pos != 0 {
+		return nil, errZstdCorrupt
+	}
+	for i := 0; i < size; i++ {
+		s := t.symbols[i]
+		n := next[s]
+		next[s]++
+		t.bits[i] = uint8(log - highBit(uint64(n)))
+		t.base[i] = uint16(n<<t.bits[i] - size)
+	}
+	return t, nil
+}
+
+// rleFSETable returns a table that always decodes to the given symbol
+func rleFSETable(symbol uint8) *fseTable {
+	return &fseTable{
+		symbols: []uint8{symbol},
+		bits:    []uint8{0},
+		base:    []uint16{0},
+	}
+}
+
+// readFSETable reads the description of an FSE table and returns the
+// table and the number of bytes that were read
+func readFSETable(in []byte, maxLog, maxSymbol int) (*fseTable, int, error) {
+	br := &fwdBits{in: in}
+	log := int(br.read(4)) + 5
+	if log > maxLog {
+		return nil, 0, errZstdCorrupt
+	}
+	remaining := 1 << uint(log)
+	var counts []int16
+	for remaining > 0 && len(counts) <= maxSymbol {
+		bits := highBit(uint64(remaining+1)) + 1
+		val := br.peek(bits)
+		lowerMask := uint32(1)<<uint(bits-1) - 1
+		threshold := uint32(1)<<uint(bits) - 1 - uint32(remaining+1)
+		if val&lowerMask < threshold {
+			val &= lowerMask
+			br.pos += bits - 1
+		} else {
+			if val > lowerMask {
+				val -= threshold
+			}
+			br.pos += bits
+		}
+		count := int16(val) - 1
+		if count < 0 {
+			remaining += int(count)
+		} else {
+			remaining -= int(count)
+		}
+		counts = append(counts, count)
+		if count == 0 {
+			for {
+				repeat := int(br.read(2))
+				for i := 0; i < repeat; i++ {
+					counts = append(counts, 0)
+				}
+				if repeat != 3 || br.overflow() {
+					break
+				}
+			}
+		}
+		if br.overflow() {
+			return nil, 0, errZstdCorrupt
+		}
+	}
+	if remaining != 0 || len(counts) > maxSymbol+1 {
+		return nil, 0, errZstdCorrupt
+	}
+	t, err := newFSETable(counts, log)
+	if err != nil {
+		return nil, 0, err
+	}
+	return t, br.used(), nil
+}
+
+// fseState is the state of an FSE decoder
+type fseState struct {
+	t     *fseTable
+	state int
+}
+
+func (st *fseState) init(t *fseTable, br *revBits) {
+	st.t = t
+	st.state = int(br.read(t.log))
+}
+
+func (st *fseState) symbol() uint8 {
+	return st.t.symbols[st.state]
+}
+
+func (st *fseState) update(br *revBits) {
+	st.state = int(st.t.base[st.state]) + int(br.read(int(st.t.bits[st.state])))
+}
+
+// hufTable is a Huffman decoding table
+type hufTable struct {
+	maxBits int
+	symbols []uint8
+	bits    []uint8
+}
+
+// readHufTable reads the description of a Huffman table and returns
+// the table and the number of bytes that were read
+func readHufTable(in []byte) (*hufTable, int, error) {
+	if len(in) == 0 {
+		return nil, 0, errZstdCorrupt
+	}
+	hdr := int(in[0])
+	var weights []uint8
+	var used int
+	if hdr >= 128 {
+		n := hdr - 127
+		used = 1 + (n+1)/2
+		if used > len(in) {
+			return nil, 0, errZstdCorrupt
+		}
+		for i := 0; i < n; i++ {
+			w := in[1+i/2]
+			if i%2 == 0 {
+				w >>= 4
+			}
+			weights = append(weights, w&15)
+		}
+	} else {
+		used = 1 + hdr
+		if used > len(in) {
+			return nil, 0, errZstdCorrupt
+		}
+		var err error
+		if weights, err = fseHufWeights(in[1:used]); err != nil {
+			return nil, 0, err
+		}
+	}
+	t, err := newHufTable(weights)
+	if err != nil {
+		return nil, 0, err
+	}
+	return t, used, nil
+}
+
+// fseHufWeights decodes FSE compressed Huffman weights with two
+// interleaved states
+func fseHufWeights(in []byte) ([]uint8, error) {
+	t, n, err := readFSETable(in, 6, zstdMaxHufSyms-1)
+	if err != nil {
+		return nil, err
+	}
+	br, err := newRevBits(in[n:])
+	if err != nil {
+		return nil, err
+	}
+	var s1, s2 fseState
+	s1.init(t, br)
+	s2.init(t, br)
+	var weights []uint8
+	for {
+		if len(weights) > zstdMaxHufSyms {
+			return nil, errZstdCorrupt
+		}
+		weights = append(weights, s1.symbol())
+		s1.update(br)
+		if br.off < 0 {
+			weights = append(weights, s2.symbol())
+			break
+		}
+		weights = append(weights, s2.symbol())
+		s2.update(br)
+		if br.off < 0 {
+			weights = append(weights, s1.symbol())
+			break
+		}
+	}
+	return weights, nil
+}
+
+// newHufTable builds the decoding table of the given weights, the
+// weight of the last symbol is implied
+func newHufTable(weights []uint8) (*hufTable, error) {
+	if len(weights)+1 > zstdMaxHufSyms {
+		return nil, errZstdCorrupt
+	}
+	var sum uint64
+	for _, w := range weights {
+		if w > zstdMaxHufBits {
+			return nil, errZstdCorrupt
+		}
+		if w > 0 {
+			sum += 1 << (w - 1)
+		}
+	}
+	if sum == 0 {
+		return nil, errZstdCorrupt
+	}
+	maxBits := highBit(sum) + 1
+	if maxBits > zstdMaxHufBits {
+		return nil, errZstdCorrupt
+	}
+	left := uint64(1)<<uint(maxBits) - sum
+	if left&(left-1) != 0 {
+		return nil, errZstdCorrupt
+	}
+	bits := make([]int, len(weights)+1)
+	for i, w := range weights {
+		if w > 0 {
+			bits[i] = maxBits + 1 - int(w)
+		}
+	}
+	bits[len(weights)] = maxBits - highBit(left)
+
+	// the codes of each length start after the longer codes
+	var count [zstdMaxHufBits + 1]int
+	for _, b := range bits {
+		count[b]++
+	}
+	var start [zstdMaxHufBits + 1]int
+	for b := maxBits; b > 1; b-- {
+		start[b-1] = start[b] + count[b]<<uint(maxBits-b)
+	}
+	size := 1 << uint(maxBits)
+	t := &hufTable{
+		maxBits: maxBits,
+		symbols: make([]uint8, size),
+		bits:    make([]uint8, size),
+	}
+	for s, b := range bits {
+		if b == 0 {
+			continue
+		}
+		n := 1 << uint(maxBits-b)
+		for i := start[b]; i < start[b]+n; i++ {
+			t.symbols[i] = uint8(s)
+			t.bits[i] = uint8(b)
+		}
+		start[b] += n
+	}
+	if start[1] != size {
+		return nil, errZstdCorrupt
+	}
+	return t, nil
+}
+
+// decode decodes a Huffman stream of n symbols and appends it to out
+func (t *hufTable) decode(out, in []byte, n int) ([]byte, error) {
+	br, err := newRevBits(in)
+	if err != nil {
+		return nil, err
+	}
+	mask := 1<<uint(t.maxBits) - 1
+	state := int(br.read(t.maxBits))
+	for ; n > 0 && br.off > -t.maxBits; n-- {
+		out = append(out, t.symbols[state])
+		b := int(t.bits[state])
+		state = (state<<uint(b) + int(br.read(b))) & mask
+	}
+	if n != 0 || br.off != -t.maxBits {
+		return nil, errZstdCorrupt
+	}
+	return out, nil
+}
+
+// zstdDecoder keeps the state that is shared by the blocks of a frame
+type zstdDecoder struct {
+	out      []byte
+	start    int
+	huf      *hufTable
+	ll       *fseTable
+	of       *fseTable
+	ml       *fseTable
+	rep      [3]int
+	literals []byte
+}
+
+// zstdDecode decodes zstd frames as written by "zstd". Checksums are
+// not verified and dictionaries are not supported.
+func zstdDecode(in []byte) ([]byte, error) {
+	d := &zstdDecoder{}
+	for len(in) > 0 {
+		if len(in) < 4 {
+			return nil, fmt.Errorf("zstd data truncated")
+		}
+		var err error
+		magic := binary.LittleEndian.Uint32(in)
+		switch {
+		case magic == zstdMagic:
+			in, err = d.frame(in[4:])
+		case magic&0xfffffff0 == zstdSkipMagic:
+			if len(in) < 8 {
+				return nil, fmt.Errorf("zstd data truncated")
+			}
+			size := uint64(binary.LittleEndian.Uint32(in[4:]))
+			if 8+size > uint64(len(in)) {
+				return nil, fmt.Errorf("zstd data truncated")
+			}
+			in = in[8+size:]
+		default:
+			return nil, fmt.Errorf("bad zstd magic: %#x", magic)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return d.out, nil
+}
+
+// frame decodes a frame without its magic and returns the data that
+// follows the frame
+func (d *zstdDecoder) frame(in []byte) ([]byte, error) {
+	if len(in) < 1 {
+		return nil, fmt.Errorf("zstd frame truncated")
+	}
+	fhd := in[0]
+	if fhd&0x08 != 0 {
+		return nil, errZstdCorrupt
+	}
+	singleSegment := fhd&0x20 != 0
+	checksum := fhd&0x04 != 0
+	dictSize := [4]int{0, 1, 2, 4}[fhd&3]
+	fcsSize := [4]int{0, 2, 4, 8}[fhd>>6]
+	if fcsSize == 0 && singleSegment {
+		fcsSize = 1
+	}
+	hdrSize := 1 + dictSize + fcsSize
+	if !singleSegment {
+		hdrSize++
+	}
+	if len(in) < hdrSize {
+		return nil, fmt.Errorf("zstd frame truncated")
+	}
+	pos := 1
+	if !singleSegment {
+		pos++
+	}
+	var dictID uint32
+	for i := 0; i < dictSize; i++ {
+		dictID |= uint32(in[pos+i]) << uint(8*i)
+	}
+	if dictID != 0 {
+		return nil, fmt.Errorf("unsupported zstd dictionary %v", dictID)
+	}
+	pos += dictSize
+	contentSize := int64(-1)
+	if fcsSize > 0 {
+		var size uint64
+		for i := 0; i < fcsSize; i++ {
+			size |= uint64(in[pos+i]) << uint(8*i)
+		}
+		if fcsSize == 2 {
+			size += 256
+		}
+		if size > uint64(MaxSize) {
+			return nil, fmt.Errorf("zstd data too large: %v bytes", size)
+		}
+		contentSize = int64(size)
+	}
+	in = in[hdrSize:]
+
+	d.start = len(d.out)
+	d.huf, d.ll, d.of, d.ml = nil, nil, nil, nil
+	d.rep = [3]int{1, 4, 8}
+	for {
+		if len(in) < 3 {
+			return nil, fmt.Errorf("zstd frame truncated")
+		}
+		hdr := int(in[0]) | int(in[1])<<8 | int(in[2])<<16
+		in = in[3:]
+		last := hdr&1 != 0
+		size := hdr >> 3
+		if size > zstdMaxBlockSize {
+			return nil, errZstdCorrupt
+		}
+		switch hdr >> 1 & 3 {
+		case 0:
+			if size > len(in) {
+				return nil, fmt.Errorf("zstd block truncated")
+			}
+			if err := d.grow(size); err != nil {
+				return nil, err
+			}
+			d.out = append(d.out, in[:size]...)
+			in = in[size:]
+		case 1:
+			if len(in) < 1 {
+				return nil, fmt.Errorf("zstd block truncated")
+			}
+			if err := d.grow(size); err != nil {
+				return nil, err
+			}
+			for i := 0; i < size; i++ {
+				d.out = append(d.out, in[0])
+			}
+			in = in[1:]
+		case 2:
+			if size > len(in) {
+				return nil, fmt.Errorf("zstd block truncated")
+			}
+			if err := d.block(in[:size]); err != nil {
+				return nil, err
+			}
+			in = in[size:]
+		default:
+			return nil, errZstdCorrupt
+		}
+		if last {
+			break
+		}
+	}
+	if contentSize >= 0 && int64(len(d.out)-d.start) != contentSize {
+		return nil, errZstdCorrupt
+	}
+	if checksum {
+		if len(in) < 4 {
+			return nil, fmt.Errorf("zstd frame truncated")
+		}
+		in = in[4:]
+	}
+	return in, nil
+}
+
+// grow checks that n more bytes of output stay within MaxSize
+func (d *zstdDecoder) grow(n int) error {
+	if len(d.out)+n > MaxSize {
+		return fmt.Errorf("zstd data exceeds %v bytes", MaxSize)
+	}
+	return nil
+}
+
+// block decodes a compressed block and appends it to the output
+func (d *zstdDecoder) block(in []byte) error {
+	n, err := d.readLiterals(in)
+	if err != nil {
+		return err
+	}
+	return d.sequences(in[n:])
+}
+
+// readLiterals reads the literals section of a compressed block and
+// returns its size
+func (d *zstdDecoder) readLiterals(in []byte) (int, error) {
+	if len(in) < 1 {
+		return 0, errZstdCorrupt
+	}
+	typ := in[0] & 3
+	sizeFormat := in[0] >> 2 & 3
+	d.literals = d.literals[:0]
+
+	if typ < 2 {
+		// raw and rle literals
+		var size, hdrSize int
+		switch sizeFormat {
+		case 0, 2:
+			size, hdrSize = int(in[0]>>3), 1
+		case 1:
+			if len(in) < 2 {
+				return 0, errZstdCorrupt
+			}
+			size, hdrSize = int(in[0]>>4)|int(in[1])<<4, 2
+		case 3:
+			if len(in) < 3 {
+				return 0, errZstdCorrupt
+			}
+			size, hdrSize = int(in[0]>>4)|int(in[1])<<4|int(in[2])<<12, 3
+		}
+		if size > zstdMaxBlockSize {
+			return 0, errZstdCorrupt
+		}
+		if typ == 0 {
+			if hdrSize+size > len(in) {
+				return 0, errZstdCorrupt
+			}
+			d.literals = append(d.literals, in[hdrSize:hdrSize+size]...)
+			return hdrSize + size, nil
+		}
+		if hdrSize+1 > len(in) {
+			return 0, errZstdCorrupt
+		}
+		for i := 0; i < size; i++ {
+			d.literals = append(d.literals, in[hdrSize])
+		}
+		return hdrSize + 1, nil
+	}
+
+	// huffman compressed literals, with a new or the previous table
+	hdrSize := [4]int{3, 3, 4, 5}[sizeFormat]
+	if len(in) < hdrSize {
+		return 0, errZstdCorrupt
+	}
+	var hdr uint64
+	for i := 0; i < hdrSize; i++ {
+		hdr |= uint64(in[i]) << uint(8*i)
+	}
+	sizeBits := uint([4]int{10, 10, 14, 18}[sizeFormat])
+	size := int(hdr >> 4 & (1<<sizeBits - 1))
+	compSize := int(hdr >> (4 + sizeBits) & (1<<sizeBits - 1))
+	streams := 4
+	if sizeFormat == 0 {
+		streams = 1
+	}
+	if size > zstdMaxBlockSize || hdrSize+compSize > len(in) {
+		return 0, errZstdCorrupt
+	}
+	data := in[hdrSize : hdrSize+compSize]
+	if typ == 2 {
+		t, n, err := readHufTable(data)
+		if err != nil {
+			return 0, err
+		}
+		d.huf = t
+		data = data[n:]
+	} else if d.huf == nil {
+		return 0, errZstdCorrupt
+	}
+
+	var err error
+	if streams == 1 {
+		if d.literals, err = d.huf.decode(d.literals, data, size); err != nil {
+			return 0, err
+		}
+		return hdrSize + compSize, nil
+	}
+	if len(data) < 6 {
+		return 0, errZstdCorrupt
+	}
+	var sizes [4]int
+	total := 6
+	for i := 0; i < 3; i++ {
+		sizes[i] = int(binary.LittleEndian.Uint16(data[2*i:]))
+		total += sizes[i]
+	}
+	if total > len(data) {
+		return 0, errZstdCorrupt
+	}
+	sizes[3] = len(data) - total
+	data = data[6:]
+	per := (size + 3) / 4
+	for i := 0; i < 4; i++ {
+		n := per
+		if i == 3 {
+			n = size - 3*per
+		}
+		if n < 0 {
+			return 0, errZstdCorrupt
+		}
+		if d.literals, err = d.huf.decode(d.literals, data[:sizes[i]], n); err != nil {
+			return 0, err
+		}
+		data = data[sizes[i]:]
+	}
+	return hdrSize + compSize, nil
+}
+
+// sequenceTable returns the table of the given compression mode and
+// the number of bytes read for its description
+func sequenceTable(mode byte, in []byte, prev *fseTable, def []int16, defLog, maxLog, maxSymbol int) (*fseTable, int, error) {
+	switch mode {
+	case 0:
+		t, err := newFSETable(def, defLog)
+		return t, 0, err
+	case 1:
+		if len(in) < 1 || int(in[0]) > maxSymbol {
+			return nil, 0, errZstdCorrupt
+		}
+		return rleFSETable(in[0]), 1, nil
+	case 2:
+		return readFSETable(in, maxLog, maxSymbol)
+	default:
+		if prev == nil {
+			return nil, 0, errZstdCorrupt
+		}
+		return prev, 0, nil
+	}
+}
+
+// sequences decodes the sequences section of a compressed block and
+// executes the sequences
+func (d *zstdDecoder) sequences(in []byte) error {
+	if len(in) < 1 {
+		return errZstdCorrupt
+	}
+	var num int
+	switch b := int(in[0]); {
+	case b == 0:
+		if len(in) != 1 {
+			return errZstdCorrupt
+		}
+		if err := d.grow(len(d.literals)); err != nil {
+			return err
+		}
+		d.out = append(d.out, d.literals...)
+		return nil
+	case b < 128:
+		num, in = b, in[1:]
+	case b < 255:
+		if len(in) < 2 {
+			return errZstdCorrupt
+		}
+		num, in = (b-128)<<8+int(in[1]), in[2:]
+	default:
+		if len(in) < 3 {
+			return errZstdCorrupt
+		}
+		num, in = int(in[1])+int(in[2])<<8+0x7f00, in[3:]
+	}
+	if len(in) < 1 || in[0]&3 != 0 {
+		return errZstdCorrupt
+	}
+	modes := in[0]
+	in = in[1:]
+
+	var n int
+	var err error
+	if d.ll, n, err = sequenceTable(modes>>6, in, d.ll, zstdLLDefault, 6, 9, 35); err != nil {
+		return err
+	}
+	in = in[n:]
+	if d.of, n, err = sequenceTable(modes>>4&3, in, d.of, zstdOFDefault, 5, 8, 31); err != nil {
+		return err
+	}
+	in = in[n:]
+	if d.ml, n, err = sequenceTable(modes>>2&3, in, d.ml, zstdMLDefault, 6, 9, 52); err != nil {
+		return err
+	}
+	in = in[n:]
+
+	br, err := newRevBits(in)
+	if err != nil {
+		return err
+	}
+	var ll, of, ml fseState
+	ll.init(d.ll, br)
+	of.init(d.of, br)
+	ml.init(d.ml, br)
+	lits := d.literals
+	for i := 0; i < num; i++ {
+		ofCode := int(of.symbol())
+		llCode := int(ll.symbol())
+		mlCode := int(ml.symbol())
+		if ofCode > 31 || llCode >= len(zstdLLBase) || mlCode >= len(zstdMLBase) {
+			return errZstdCorrupt
+		}
+		offset := 1<<uint(ofCode) + int(br.read(ofCode))
+		matchLen := int(zstdMLBase[mlCode]) + int(br.read(int(zstdMLBits[mlCode])))
+		litLen := int(zstdLLBase[llCode]) + int(br.read(int(zstdLLBits[llCode])))
+		if i != num-1 {
+			ll.update(br)
+			ml.update(br)
+			of.update(br)
+		}
+		if br.off < 0 {
+			return errZstdCorrupt
+		}
+
+		// the offsets 1 to 3 refer to previous offsets
+		if offset > 3 {
+			offset -= 3
+			d.rep = [3]int{offset, d.rep[0], d.rep[1]}
+		} else {
+			idx := offset - 1
+			if litLen == 0 {
+				idx++
+			}
+			switch idx {
+			case 0:
+				offset = d.rep[0]
+			case 1:
+				offset = d.rep[1]
+				d.rep[0], d.rep[1] = offset, d.rep[0]
+			case 2:
+				offset = d.rep[2]
+				d.rep = [3]int{offset, d.rep[0], d.rep[1]}
+			default:
+				offset = d.rep[0] - 1
+				d.rep = [3]int{offset, d.rep[0], d.rep[1]}
+			}
+		}
+
+		if litLen > len(lits) {
+			return errZstdCorrupt
+		}
+		if err := d.grow(litLen + matchLen); err != nil {
+			return err
+		}
+		d.out = append(d.out, lits[:litLen]...)
+		lits = lits[litLen:]
+		if offset <= 0 || offset > len(d.out)-d.start {
+			return errZstdCorrupt
+		}
+		start := len(d.out) - offset
+		for j := 0; j < matchLen; j++ {
+			d.out = append(d.out, d.out[start+j])
+		}
+	}
+	if br.off != 0 {
+		return errZstdCorrupt
+	}
+	if err := d.grow(len(lits)); err != nil {
+		return err
+	}
+	d.out = append(d.out, lits...)
+	return nil
+}
@@ -0,0 +1,228 @@
+// Package envcheck cross-references the variables of an env with the
+// running system, e.g. before a reboot: the device tree in fdtfile
+// must exist on the boot partition, the root device in bootargs must
+// exist and the load addresses must not overlap. The findings are
+// structured so that validation tools can act on them or report them
+// as JSON.
+package envcheck
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mvo5/uboot-go/bootargs"
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// AddrVars are the variables with the load addresses of the distro
+// boot scripts that are checked for overlaps
+var AddrVars = []string{
+	"kernel_addr_r",
+	"kernel_comp_addr_r",
+	"ramdisk_addr_r",
+	"fdt_addr_r",
+	"fdtoverlay_addr_r",
+	"scriptaddr",
+	"pxefile_addr_r",
+}
+
+// fdtDirs are the directories of the boot partition that are searched
+// for fdtfile if fdtdir is not set
+var fdtDirs = []string{".", "dtb", "dtbs"}
+
+// rootLinks are the directories below /dev/disk for the root= forms
+// that name a partition by one of its ids
+var rootLinks = map[string]string{
+	"UUID":      "by-uuid",
+	"PARTUUID":  "by-partuuid",
+	"LABEL":     "by-label",
+	"PARTLABEL": "by-partlabel",
+}
+
+// Finding is a single inconsistency between the env and the system
+type Finding struct {
+	// Check is the check that found it: "fdtfile", "root",
+	// "bootargs", "address" or "overlap"
+	Check string `json:"check"`
+	// Vars are the variables that are involved
+	Vars []string `json:"variables"`
+	// Message describes the finding
+	Message string `json:"message"`
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s", f.Check, f.Message)
+}
+
+// System describes the running system an env is checked against
+type System struct {
+	// BootDir is where the boot partition is mounted, fdtfile is
+	// looked up below it. If empty fdtfile is not checked.
+	BootDir string
+	// DevDir is the directory with the device nodes and the
+	// disk/by-* links, if empty /dev is used.
+	DevDir string
+	// Sizes are the sizes of the images that get loaded to the
+	// AddrVars, e.g. the size of the kernel for "kernel_addr_r".
+	// Without a size only images at the same address are found to
+	// overlap.
+	Sizes map[string]int64
+}
+
+// Check runs all checks and returns the findings, it returns nil if
+// the env is consistent with the system
+func (sys *System) Check(vars uenv.Vars) []Finding {
+	var findings []Finding
+	findings = append(findings, sys.checkFdtfile(vars)...)
+	findings = append(findings, sys.checkRoot(vars)...)
+	findings = append(findings, sys.checkOverlap(vars)...)
+	return findings
+}
+
+func (sys *System) devDir() string {
+	if sys.DevDir == "" {
+		return "/dev"
+	}
+	return sys.DevDir
+}
+
+// checkFdtfile checks that the device tree exists on the boot
+// partition, below fdtdir if that is set
+func (sys *System) checkFdtfile(vars uenv.Vars) []Finding {
+	fdtfile := vars.Get("fdtfile")
+	if fdtfile == "" || sys.BootDir == "" {
+		return nil
+	}
+	dirs := fdtDirs
+	if fdtdir := vars.Get("fdtdir"); fdtdir != "" {
+		dirs = []string{fdtdir}
+	}
+	for _, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(sys.BootDir, dir, fdtfile)); err == nil {
+			return nil
+		}
+	}
+	return []Finding{{
+		Check:   "fdtfile",
+		Vars:    []string{"fdtfile"},
+		Message: fmt.Sprintf("device tree %s not found in %s", fdtfile, sys.BootDir),
+	}}
+}
+
+// checkRoot checks that the root device of bootargs exists, root=
+// forms that can not be checked, like NFS or UBI, are skipped
+func (sys *System) checkRoot(vars uenv.Vars) []Finding {
+	cmdline, err := bootargs.Parse(vars.Get("bootargs"))
+	if err != nil {
+		return []Finding{{
+			Check:   "bootargs",
+			Vars:    []string{"bootargs"},
+			Message: fmt.Sprintf("cannot parse bootargs: %v", err),
+		}}
+	}
+	root, ok := cmdline.Get("root")
+	if !ok || root == "" {
+		return nil
+	}
+	candidates := sys.rootPaths(root)
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err == nil {
+			return nil
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	return []Finding{{
+		Check:   "root",
+		Vars:    []string{"bootargs"},
+		Message: fmt.Sprintf("root device %s does not exist", root),
+	}}
+}
+
+// rootPaths returns the paths one of which must exist for root, none
+// if root can not be checked
+func (sys *System) rootPaths(root string) []string {
+	devDir := sys.devDir()
+	if strings.HasPrefix(root, "/dev/") {
+		if root == "/dev/nfs" {
+			return nil
+		}
+		return []string{filepath.Join(devDir, strings.TrimPrefix(root, "/dev/"))}
+	}
+	i := strings.IndexByte(root, '=')
+	if i < 0 {
+		return nil
+	}
+	links, ok := rootLinks[root[:i]]
+	id := root[i+1:]
+	if !ok || id == "" || strings.Contains(id, "/") {
+		// e.g. PARTUUID=<uuid>/PARTNROFF=1
+		return nil
+	}
+	dir := filepath.Join(devDir, "disk", links)
+	return []string{filepath.Join(dir, id), filepath.Join(dir, strings.ToLower(id))}
+}
+
+// region is the memory an image is loaded to
+type region struct {
+	name        string
+	start, size uint64
+}
+
+func (r region) end() uint64 {
+	return r.start + r.size
+}
+
+func (r region) String() string {
+	return fmt.Sprintf("%s (%#x-%#x)", r.name, r.start, r.end())
+}
+
+// checkOverlap checks that the images loaded to the AddrVars do not
+// overlap
+func (sys *System) checkOverlap(vars uenv.Vars) []Finding {
+	var findings []Finding
+	var regions []region
+	for _, name := range AddrVars {
+		value := vars.Get(name)
+		if value == "" {
+			continue
+		}
+		// uboot numbers are hex, also without a 0x prefix
+		start, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(value), "0x"), 16, 64)
+		if err != nil {
+			findings = append(findings, Finding{
+				Check:   "address",
+				Vars:    []string{name},
+				Message: fmt.Sprintf("%s is not an address: %q", name, value),
+			})
+			continue
+		}
+		size := uint64(1)
+		if s := sys.Sizes[name]; s > 0 {
+			size = uint64(s)
+		}
+		regions = append(regions, region{name: name, start: start, size: size})
+	}
+	sort.SliceStable(regions, func(i, j int) bool {
+		return regions[i].start < regions[j].start
+	})
+	for i, a := range regions {
+		for _, b := range regions[i+1:] {
+			if b.start >= a.end() {
+				// the regions are sorted by their start
+				break
+			}
+			findings = append(findings, Finding{
+				Check:   "overlap",
+				Vars:    []string{a.name, b.name},
+				Message: fmt.Sprintf("%s overlaps %s", a, b),
+			})
+		}
+	}
+	return findings
+}
@@ -0,0 +1,145 @@
+package envcheck
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type envcheckTestSuite struct {
+	sys *System
+	env *uenv.Env
+}
+
+var _ = Suite(&envcheckTestSuite{})
+
+func touch(c *C, path string) {
+	c.Assert(os.MkdirAll(filepath.Dir(path), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(path, nil, 0644), IsNil)
+}
+
+func (s *envcheckTestSuite) SetUpTest(c *C) {
+	dir := c.MkDir()
+	s.sys = &System{
+		BootDir: filepath.Join(dir, "boot"),
+		DevDir:  filepath.Join(dir, "dev"),
+	}
+	touch(c, filepath.Join(dir, "boot", "dtbs", "rockchip", "rk3399-rock-pi-4b.dtb"))
+	touch(c, filepath.Join(dir, "dev", "mmcblk0p2"))
+	touch(c, filepath.Join(dir, "dev", "disk", "by-uuid", "1234-abcd"))
+	touch(c, filepath.Join(dir, "dev", "disk", "by-partuuid", "5e8a0c9f-02"))
+
+	s.env = uenv.New(uenv.Config{Size: 4096})
+	s.set(c, map[string]string{
+		"fdtfile":        "rockchip/rk3399-rock-pi-4b.dtb",
+		"bootargs":       "console=ttyS2,1500000 root=/dev/mmcblk0p2 rw",
+		"kernel_addr_r":  "0x02080000",
+		"ramdisk_addr_r": "0x06000000",
+		"fdt_addr_r":     "01f00000",
+	})
+}
+
+func (s *envcheckTestSuite) set(c *C, vars map[string]string) {
+	for k, v := range vars {
+		c.Assert(s.env.Set(k, v), IsNil)
+	}
+}
+
+func (s *envcheckTestSuite) TestConsistent(c *C) {
+	c.Check(s.sys.Check(s.env), IsNil)
+
+	s.sys.Sizes = map[string]int64{"kernel_addr_r": 0x2000000, "fdt_addr_r": 0x10000}
+	c.Check(s.sys.Check(s.env), IsNil)
+}
+
+func (s *envcheckTestSuite) TestFdtfile(c *C) {
+	s.set(c, map[string]string{"fdtfile": "rockchip/missing.dtb"})
+	c.Check(s.sys.Check(s.env), DeepEquals, []Finding{{
+		Check:   "fdtfile",
+		Vars:    []string{"fdtfile"},
+		Message: "device tree rockchip/missing.dtb not found in " + s.sys.BootDir,
+	}})
+
+	// fdtdir replaces the default directories
+	s.set(c, map[string]string{"fdtfile": "rockchip/rk3399-rock-pi-4b.dtb", "fdtdir": "/dtb"})
+	c.Check(s.sys.Check(s.env), HasLen, 1)
+	s.set(c, map[string]string{"fdtdir": "/dtbs"})
+	c.Check(s.sys.Check(s.env), IsNil)
+
+	// without a boot dir there is nothing to check
+	s.set(c, map[string]string{"fdtfile": "rockchip/missing.dtb"})
+	s.sys.BootDir = ""
+	c.Check(s.sys.Check(s.env), IsNil)
+}
+
+func (s *envcheckTestSuite) TestRoot(c *C) {
+	for _, t := range []struct {
+		root string
+		ok   bool
+	}{
+		{"/dev/mmcblk0p2", true},
+		{"/dev/mmcblk1p2", false},
+		{"UUID=1234-ABCD", true},
+		{"UUID=0000-0000", false},
+		{"PARTUUID=5e8a0c9f-02", true},
+		{"LABEL=writable", false},
+		// forms that can not be checked
+		{"/dev/nfs", true},
+		{"ubi0:rootfs", true},
+		{"PARTUUID=5e8a0c9f-01/PARTNROFF=1", true},
+		{"b302", true},
+	} {
+		s.set(c, map[string]string{"bootargs": "quiet root=" + t.root})
+		findings := s.sys.Check(s.env)
+		if t.ok {
+			c.Check(findings, IsNil, Commentf(t.root))
+			continue
+		}
+		c.Check(findings, DeepEquals, []Finding{{
+			Check:   "root",
+			Vars:    []string{"bootargs"},
+			Message: "root device " + t.root + " does not exist",
+		}}, Commentf(t.root))
+	}
+}
+
+func (s *envcheckTestSuite) TestBadBootargs(c *C) {
+	s.set(c, map[string]string{"bootargs": `root=/dev/mmcblk0p2 "unterminated`})
+	findings := s.sys.Check(s.env)
+	c.Assert(findings, HasLen, 1)
+	c.Check(findings[0].Check, Equals, "bootargs")
+	c.Check(findings[0].String(), Matches, "bootargs: cannot parse bootargs: .*")
+}
+
+func (s *envcheckTestSuite) TestOverlap(c *C) {
+	s.sys.Sizes = map[string]int64{"kernel_addr_r": 0x4000000}
+	c.Check(s.sys.Check(s.env), DeepEquals, []Finding{{
+		Check:   "overlap",
+		Vars:    []string{"kernel_addr_r", "ramdisk_addr_r"},
+		Message: "kernel_addr_r (0x2080000-0x6080000) overlaps ramdisk_addr_r (0x6000000-0x6000001)",
+	}})
+
+	// the same address overlaps without sizes
+	s.sys.Sizes = nil
+	s.set(c, map[string]string{"scriptaddr": "0x6000000"})
+	findings := s.sys.Check(s.env)
+	c.Assert(findings, HasLen, 1)
+	c.Check(findings[0].Vars, DeepEquals, []string{"ramdisk_addr_r", "scriptaddr"})
+}
+
+func (s *envcheckTestSuite) TestBadAddress(c *C) {
+	s.set(c, map[string]string{"ramdisk_addr_r": "${loadaddr}"})
+	c.Check(s.sys.Check(s.env), DeepEquals, []Finding{{
+		Check:   "address",
+		Vars:    []string{"ramdisk_addr_r"},
+		Message: `ramdisk_addr_r is not an address: "${loadaddr}"`,
+	}})
+}
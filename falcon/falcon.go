@@ -0,0 +1,251 @@
+// Package falcon reads and writes the args image that SPL passes to
+// the kernel in falcon mode. The image is prepared with "spl export
+// fdt" (or "spl export atags") and stored raw at a fixed offset, e.g.
+// CONFIG_SYS_MMCSD_RAW_MODE_ARGS_SECTOR on MMC or CONFIG_CMD_SPL_NAND_OFS
+// on NAND, in an area of CONFIG_CMD_SPL_WRITE_SIZE bytes. Unlike the
+// env the args image has no CRC, so Read checks the structure of the
+// FDT or the ATAGS and Write reads the image back.
+package falcon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/mvo5/uboot-go/fit"
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Format is the format of an args image
+type Format int
+
+// The formats of args images
+const (
+	FormatFDT Format = iota + 1
+	FormatATAGS
+)
+
+// String returns the name of the format
+func (f Format) String() string {
+	switch f {
+	case FormatFDT:
+		return "fdt"
+	case FormatATAGS:
+		return "atags"
+	}
+	return fmt.Sprintf("format %d", int(f))
+}
+
+// fdtMagic is the big endian magic number at the start of a DTB
+const fdtMagic = 0xd00dfeed
+
+// The ATAGS used by uboot, see arch/arm/include/asm/setup.h
+const (
+	atagNone    = 0x00000000
+	atagCore    = 0x54410001
+	atagMem     = 0x54410002
+	atagCmdline = 0x54410009
+	atagInitrd2 = 0x54420005
+)
+
+// Args is an args image
+type Args struct {
+	Format Format
+	// Data is the image without the padding of the args area
+	Data []byte
+}
+
+// Parse parses an args image, data after the image is ignored
+func Parse(content []byte) (*Args, error) {
+	if len(content) < 8 {
+		return nil, fmt.Errorf("args image too small: %v bytes", len(content))
+	}
+	if binary.BigEndian.Uint32(content) == fdtMagic {
+		if _, err := fit.ParseDeviceTree(content); err != nil {
+			return nil, err
+		}
+		size := binary.BigEndian.Uint32(content[4:])
+		return &Args{Format: FormatFDT, Data: content[:size]}, nil
+	}
+	if binary.LittleEndian.Uint32(content[4:]) == atagCore {
+		size, err := atagsSize(content)
+		if err != nil {
+			return nil, err
+		}
+		return &Args{Format: FormatATAGS, Data: content[:size]}, nil
+	}
+	return nil, fmt.Errorf("cannot find FDT or ATAGS in args image")
+}
+
+// atagsSize returns the size of the ATAGS list up to and including the
+// ATAG_NONE tag
+func atagsSize(content []byte) (int, error) {
+	off := 0
+	for {
+		if off+8 > len(content) {
+			return 0, fmt.Errorf("cannot find end of ATAGS")
+		}
+		words := binary.LittleEndian.Uint32(content[off:])
+		tag := binary.LittleEndian.Uint32(content[off+4:])
+		if tag == atagNone {
+			return off + 8, nil
+		}
+		if words < 2 || uint64(off)+uint64(words)*4 > uint64(len(content)) {
+			return 0, fmt.Errorf("invalid ATAG %#x at offset %v", tag, off)
+		}
+		off += int(words) * 4
+	}
+}
+
+// Read reads the args image from an args area of the given size
+func Read(s uenv.Storage, size int) (*Args, error) {
+	unlock, err := s.Lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	content, err := s.ReadAll(size)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(content)
+}
+
+// Write writes the args image to an args area of the given size, the
+// rest of the area is padded with 0xff. The area is read back to make
+// sure the image was written correctly.
+func Write(s uenv.Storage, args *Args, size int) error {
+	if len(args.Data) > size {
+		return fmt.Errorf("cannot write args image of %v bytes to an area of %v bytes", len(args.Data), size)
+	}
+	if _, err := Parse(args.Data); err != nil {
+		return err
+	}
+	content := bytes.Repeat([]byte{0xff}, size)
+	copy(content, args.Data)
+
+	unlock, err := s.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.Erase(size); err != nil {
+		return err
+	}
+	if err := s.WriteInPlace(content); err != nil {
+		return err
+	}
+	written, err := s.ReadAll(size)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(written, content) {
+		return fmt.Errorf("cannot verify args image: read back data differs")
+	}
+	return nil
+}
+
+// Bootargs returns the kernel command line of the args image, from
+// /chosen/bootargs of an FDT or the ATAG_CMDLINE tag
+func (args *Args) Bootargs() (string, error) {
+	switch args.Format {
+	case FormatFDT:
+		root, err := fit.ParseDeviceTree(args.Data)
+		if err != nil {
+			return "", err
+		}
+		if chosen := root.Child("chosen"); chosen != nil {
+			return chosen.StringProp("bootargs"), nil
+		}
+		return "", nil
+	case FormatATAGS:
+		tags, err := args.ATAGS()
+		if err != nil {
+			return "", err
+		}
+		return tags.Cmdline, nil
+	}
+	return "", fmt.Errorf("cannot get bootargs of %s args image", args.Format)
+}
+
+// MemoryBank is a bank of RAM described by an ATAG_MEM tag
+type MemoryBank struct {
+	Start uint32
+	Size  uint32
+}
+
+// ATAGS are the boot parameters passed in an ATAGS list
+type ATAGS struct {
+	Memory      []MemoryBank
+	InitrdStart uint32
+	InitrdSize  uint32
+	Cmdline     string
+}
+
+// ATAGS returns the boot parameters of an ATAGS args image, unknown
+// tags are skipped
+func (args *Args) ATAGS() (*ATAGS, error) {
+	if args.Format != FormatATAGS {
+		return nil, fmt.Errorf("cannot get ATAGS of %s args image", args.Format)
+	}
+	size, err := atagsSize(args.Data)
+	if err != nil {
+		return nil, err
+	}
+	tags := &ATAGS{}
+	for off := 0; off+8 < size; {
+		words := int(binary.LittleEndian.Uint32(args.Data[off:]))
+		tag := binary.LittleEndian.Uint32(args.Data[off+4:])
+		data := args.Data[off+8 : off+words*4]
+		switch {
+		case tag == atagMem && len(data) >= 8:
+			tags.Memory = append(tags.Memory, MemoryBank{
+				Size:  binary.LittleEndian.Uint32(data),
+				Start: binary.LittleEndian.Uint32(data[4:]),
+			})
+		case tag == atagInitrd2 && len(data) >= 8:
+			tags.InitrdStart = binary.LittleEndian.Uint32(data)
+			tags.InitrdSize = binary.LittleEndian.Uint32(data[4:])
+		case tag == atagCmdline:
+			if end := bytes.IndexByte(data, 0); end >= 0 {
+				data = data[:end]
+			}
+			tags.Cmdline = string(data)
+		}
+		off += words * 4
+	}
+	return tags, nil
+}
+
+// NewATAGS returns an ATAGS args image with the given boot parameters,
+// laid out like setup_start_tag() and friends in uboot do
+func NewATAGS(tags *ATAGS) *Args {
+	buf := bytes.NewBuffer(nil)
+	put := func(tag uint32, data ...uint32) {
+		binary.Write(buf, binary.LittleEndian, uint32(2+len(data)))
+		binary.Write(buf, binary.LittleEndian, tag)
+		binary.Write(buf, binary.LittleEndian, data)
+	}
+	// flags, page size and root device
+	put(atagCore, 0, 0, 0)
+	for _, bank := range tags.Memory {
+		put(atagMem, bank.Size, bank.Start)
+	}
+	if tags.InitrdSize != 0 {
+		put(atagInitrd2, tags.InitrdStart, tags.InitrdSize)
+	}
+	if tags.Cmdline != "" {
+		cmdline := append([]byte(tags.Cmdline), 0)
+		for len(cmdline)%4 != 0 {
+			cmdline = append(cmdline, 0)
+		}
+		binary.Write(buf, binary.LittleEndian, uint32(2+len(cmdline)/4))
+		binary.Write(buf, binary.LittleEndian, uint32(atagCmdline))
+		buf.Write(cmdline)
+	}
+	// ATAG_NONE ends the list
+	binary.Write(buf, binary.LittleEndian, []uint32{0, atagNone})
+	return &Args{Format: FormatATAGS, Data: buf.Bytes()}
+}
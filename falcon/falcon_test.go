@@ -0,0 +1,134 @@
+package falcon
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type falconTestSuite struct {
+	dtb []byte
+}
+
+var _ = Suite(&falconTestSuite{})
+
+func (s *falconTestSuite) SetUpSuite(c *C) {
+	var err error
+	s.dtb, err = ioutil.ReadFile(filepath.Join("testdata", "args.dtb"))
+	c.Assert(err, IsNil)
+}
+
+func (s *falconTestSuite) TestParseFDT(c *C) {
+	// the args area is padded
+	args, err := Parse(append(append([]byte(nil), s.dtb...), 0xff, 0xff, 0xff))
+	c.Assert(err, IsNil)
+	c.Check(args.Format, Equals, FormatFDT)
+	c.Check(args.Data, DeepEquals, s.dtb)
+
+	bootargs, err := args.Bootargs()
+	c.Assert(err, IsNil)
+	c.Check(bootargs, Equals, "console=ttyS0,115200 root=/dev/mmcblk0p2")
+
+	_, err = args.ATAGS()
+	c.Check(err, ErrorMatches, "cannot get ATAGS of fdt args image")
+}
+
+func (s *falconTestSuite) TestATAGS(c *C) {
+	tags := &ATAGS{
+		Memory:      []MemoryBank{{Start: 0x80000000, Size: 0x10000000}},
+		InitrdStart: 0x88000000,
+		InitrdSize:  0x200000,
+		Cmdline:     "console=ttyO0 root=/dev/mmcblk0p2",
+	}
+	args := NewATAGS(tags)
+	c.Check(args.Format, Equals, FormatATAGS)
+	// core, mem, initrd2, cmdline and none
+	c.Check(args.Data, HasLen, 20+16+16+8+36+8)
+
+	parsed, err := Parse(append(append([]byte(nil), args.Data...), make([]byte, 16)...))
+	c.Assert(err, IsNil)
+	c.Check(parsed.Data, DeepEquals, args.Data)
+	back, err := parsed.ATAGS()
+	c.Assert(err, IsNil)
+	c.Check(back, DeepEquals, tags)
+	bootargs, err := parsed.Bootargs()
+	c.Assert(err, IsNil)
+	c.Check(bootargs, Equals, "console=ttyO0 root=/dev/mmcblk0p2")
+}
+
+func (s *falconTestSuite) TestParseErrors(c *C) {
+	_, err := Parse([]byte{1, 2})
+	c.Check(err, ErrorMatches, "args image too small: 2 bytes")
+	_, err = Parse(bytes.Repeat([]byte{0xff}, 64))
+	c.Check(err, ErrorMatches, "cannot find FDT or ATAGS in args image")
+	_, err = Parse(s.dtb[:len(s.dtb)-1])
+	c.Check(err, ErrorMatches, "device tree truncated: .*")
+
+	atags := NewATAGS(&ATAGS{Cmdline: "quiet"}).Data
+	_, err = Parse(atags[:len(atags)-8])
+	c.Check(err, ErrorMatches, "cannot find end of ATAGS")
+	broken := append([]byte(nil), atags...)
+	broken[20] = 1
+	_, err = Parse(broken)
+	c.Check(err, ErrorMatches, "invalid ATAG 0x54410009 at offset 20")
+}
+
+func (s *falconTestSuite) TestReadWrite(c *C) {
+	path := filepath.Join(c.MkDir(), "mmcblk0")
+	c.Assert(ioutil.WriteFile(path, make([]byte, 8192), 0644), IsNil)
+	storage := &uenv.FileStorage{Path: path, Offset: 2048}
+
+	_, err := Read(storage, 1024)
+	c.Check(err, ErrorMatches, "cannot find FDT or ATAGS in args image")
+
+	c.Assert(Write(storage, &Args{Format: FormatFDT, Data: s.dtb}, 1024), IsNil)
+	content, err := ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+	c.Check(content[2048:2048+len(s.dtb)], DeepEquals, s.dtb)
+	c.Check(content[2048+len(s.dtb):3072], DeepEquals, bytes.Repeat([]byte{0xff}, 1024-len(s.dtb)))
+	c.Check(content[3072:], DeepEquals, make([]byte, 8192-3072))
+
+	args, err := Read(storage, 1024)
+	c.Assert(err, IsNil)
+	c.Check(args.Format, Equals, FormatFDT)
+	c.Check(args.Data, DeepEquals, s.dtb)
+}
+
+func (s *falconTestSuite) TestWriteErrors(c *C) {
+	storage := uenv.NewMemoryStorage(nil)
+	err := Write(storage, &Args{Format: FormatFDT, Data: s.dtb}, 128)
+	c.Check(err, ErrorMatches, "cannot write args image of 218 bytes to an area of 128 bytes")
+	err = Write(storage, &Args{Format: FormatATAGS, Data: make([]byte, 64)}, 128)
+	c.Check(err, ErrorMatches, "cannot find FDT or ATAGS in args image")
+	c.Check(storage.Bytes(), HasLen, 0)
+}
+
+func FuzzParse(f *testing.F) {
+	dtb, err := ioutil.ReadFile(filepath.Join("testdata", "args.dtb"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(dtb)
+	f.Add(NewATAGS(&ATAGS{
+		Memory:      []MemoryBank{{Start: 0x80000000, Size: 0x10000000}},
+		InitrdStart: 0x88000000,
+		InitrdSize:  0x200000,
+		Cmdline:     "console=ttyO0",
+	}).Data)
+	f.Fuzz(func(t *testing.T, content []byte) {
+		args, err := Parse(content)
+		if err != nil {
+			return
+		}
+		args.Bootargs()
+		args.ATAGS()
+	})
+}
@@ -0,0 +1,225 @@
+package fit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// fdtMagic is the magic number at the start of a flattened device tree
+const fdtMagic = 0xd00dfeed
+
+// The tokens of the structure block
+const (
+	fdtBeginNode = 1
+	fdtEndNode   = 2
+	fdtProp      = 3
+	fdtNop       = 4
+	fdtEnd       = 9
+)
+
+// fdtHeaderSize is the size of the header up to size_dt_struct
+const fdtHeaderSize = 40
+
+// Node is a node of a flattened device tree
+type Node struct {
+	// Name is the name of the node including the unit address
+	Name string
+	// Children are the sub nodes in the order of the tree
+	Children []*Node
+
+	props map[string][]byte
+}
+
+// Child returns the sub node with the given name or nil
+func (n *Node) Child(name string) *Node {
+	for _, child := range n.Children {
+		if child.Name == name {
+			return child
+		}
+	}
+	return nil
+}
+
+// Prop returns the value of the given property
+func (n *Node) Prop(name string) (value []byte, ok bool) {
+	value, ok = n.props[name]
+	return value, ok
+}
+
+// StringList returns the value of a string list property like
+// "compatible"
+func (n *Node) StringList(name string) []string {
+	value, ok := n.props[name]
+	if !ok {
+		return nil
+	}
+	return splitStrings(value)
+}
+
+// StringProp returns the value of a string property
+func (n *Node) StringProp(name string) string {
+	if l := n.StringList(name); len(l) > 0 {
+		return l[0]
+	}
+	return ""
+}
+
+// Uint32 returns the value of a single cell property
+func (n *Node) Uint32(name string) (uint32, bool) {
+	value, ok := n.props[name]
+	if !ok || len(value) != 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(value), true
+}
+
+// compatible returns true if the "compatible" property of the node
+// contains compat, like fdt_node_check_compatible()
+func (n *Node) compatible(compat string) bool {
+	for _, c := range n.StringList("compatible") {
+		if c == compat {
+			return true
+		}
+	}
+	return false
+}
+
+// splitStrings splits a list of \0 terminated strings
+func splitStrings(value []byte) []string {
+	value = bytes.TrimRight(value, "\x00")
+	if len(value) == 0 {
+		return nil
+	}
+	return strings.Split(string(value), "\x00")
+}
+
+// ParseDeviceTree parses a flattened device tree (a DTB) and returns
+// its root node
+func ParseDeviceTree(content []byte) (*Node, error) {
+	root, _, err := parseFDT(content)
+	return root, err
+}
+
+// parseFDT parses a flattened device tree and returns the root node
+// and the total size of the tree
+func parseFDT(content []byte) (*Node, int, error) {
+	if len(content) < fdtHeaderSize {
+		return nil, 0, fmt.Errorf("device tree too small: %v bytes", len(content))
+	}
+	// the sizes are checked as uint64, as int they may overflow on
+	// 32 bit systems
+	hdr := func(i int) uint64 { return uint64(binary.BigEndian.Uint32(content[i*4:])) }
+	if magic := hdr(0); magic != fdtMagic {
+		return nil, 0, fmt.Errorf("bad device tree magic: %#x", magic)
+	}
+	totalSize, offStruct, offStrings := hdr(1), hdr(2), hdr(3)
+	sizeStrings, sizeStruct := hdr(8), hdr(9)
+	if totalSize > uint64(len(content)) || totalSize < fdtHeaderSize {
+		return nil, 0, fmt.Errorf("device tree truncated: %v bytes expected but only %v bytes found", totalSize, len(content))
+	}
+	if offStruct+sizeStruct > totalSize || offStrings+sizeStrings > totalSize {
+		return nil, 0, fmt.Errorf("invalid device tree layout")
+	}
+	p := &fdtParser{
+		data:    content[offStruct : offStruct+sizeStruct],
+		strings: content[offStrings : offStrings+sizeStrings],
+	}
+	root, err := p.parse()
+	if err != nil {
+		return nil, 0, err
+	}
+	return root, int(totalSize), nil
+}
+
+type fdtParser struct {
+	data    []byte
+	strings []byte
+	off     int
+}
+
+func (p *fdtParser) u32() (uint32, error) {
+	if p.off+4 > len(p.data) {
+		return 0, fmt.Errorf("unexpected end of device tree")
+	}
+	v := binary.BigEndian.Uint32(p.data[p.off:])
+	p.off += 4
+	return v, nil
+}
+
+// align moves the offset to the next 4 byte boundary
+func (p *fdtParser) align() {
+	p.off = (p.off + 3) &^ 3
+	if p.off > len(p.data) {
+		p.off = len(p.data)
+	}
+}
+
+func (p *fdtParser) parse() (*Node, error) {
+	var stack []*Node
+	var root *Node
+	for {
+		token, err := p.u32()
+		if err != nil {
+			return nil, err
+		}
+		switch token {
+		case fdtBeginNode:
+			end := bytes.IndexByte(p.data[p.off:], 0)
+			if end < 0 {
+				return nil, fmt.Errorf("cannot find end of node name")
+			}
+			node := &Node{Name: string(p.data[p.off : p.off+end]), props: make(map[string][]byte)}
+			p.off += end + 1
+			p.align()
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, node)
+			} else if root != nil {
+				return nil, fmt.Errorf("more than one root node")
+			} else {
+				root = node
+			}
+			stack = append(stack, node)
+		case fdtEndNode:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("unexpected end of node")
+			}
+			stack = stack[:len(stack)-1]
+		case fdtProp:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("property outside of node")
+			}
+			size, err := p.u32()
+			if err != nil {
+				return nil, err
+			}
+			nameOff, err := p.u32()
+			if err != nil {
+				return nil, err
+			}
+			if uint64(p.off)+uint64(size) > uint64(len(p.data)) {
+				return nil, fmt.Errorf("property value truncated")
+			}
+			if uint64(nameOff) >= uint64(len(p.strings)) {
+				return nil, fmt.Errorf("invalid property name offset %v", nameOff)
+			}
+			name := p.strings[nameOff:]
+			if end := bytes.IndexByte(name, 0); end >= 0 {
+				name = name[:end]
+			}
+			stack[len(stack)-1].props[string(name)] = p.data[p.off : p.off+int(size)]
+			p.off += int(size)
+			p.align()
+		case fdtNop:
+		case fdtEnd:
+			if root == nil || len(stack) != 0 {
+				return nil, fmt.Errorf("unexpected end of device tree")
+			}
+			return root, nil
+		default:
+			return nil, fmt.Errorf("invalid device tree token %v", token)
+		}
+	}
+}
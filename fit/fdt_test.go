@@ -0,0 +1,160 @@
+package fit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type fdtTestSuite struct{}
+
+var _ = Suite(&fdtTestSuite{})
+
+// tnode is a device tree node that can be flattened with buildFDT
+type tnode struct {
+	name     string
+	props    []tprop
+	children []*tnode
+}
+
+type tprop struct {
+	name  string
+	value []byte
+}
+
+func strs(l ...string) []byte {
+	buf := bytes.NewBuffer(nil)
+	for _, s := range l {
+		buf.WriteString(s)
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
+
+func cell(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+// buildFDT flattens the given tree like dtc
+func buildFDT(root *tnode) []byte {
+	structs := bytes.NewBuffer(nil)
+	strings := bytes.NewBuffer(nil)
+	nameOffs := make(map[string]int)
+	u32 := func(v uint32) { binary.Write(structs, binary.BigEndian, v) }
+	pad := func() {
+		for structs.Len()%4 != 0 {
+			structs.WriteByte(0)
+		}
+	}
+	var walk func(n *tnode)
+	walk = func(n *tnode) {
+		u32(fdtBeginNode)
+		structs.WriteString(n.name)
+		structs.WriteByte(0)
+		pad()
+		for _, p := range n.props {
+			off, ok := nameOffs[p.name]
+			if !ok {
+				off = strings.Len()
+				nameOffs[p.name] = off
+				strings.WriteString(p.name)
+				strings.WriteByte(0)
+			}
+			u32(fdtProp)
+			u32(uint32(len(p.value)))
+			u32(uint32(off))
+			structs.Write(p.value)
+			pad()
+		}
+		for _, child := range n.children {
+			walk(child)
+		}
+		u32(fdtEndNode)
+	}
+	walk(root)
+	u32(fdtEnd)
+
+	// header and an empty memory reservation block
+	offStruct := fdtHeaderSize + 16
+	offStrings := offStruct + structs.Len()
+	total := offStrings + strings.Len()
+	out := bytes.NewBuffer(nil)
+	header := []uint32{fdtMagic, uint32(total), uint32(offStruct), uint32(offStrings), fdtHeaderSize, 17, 16, 0, uint32(strings.Len()), uint32(structs.Len())}
+	binary.Write(out, binary.BigEndian, header)
+	out.Write(make([]byte, 16))
+	out.Write(structs.Bytes())
+	out.Write(strings.Bytes())
+	return out.Bytes()
+}
+
+func (s *fdtTestSuite) TestParseFDT(c *C) {
+	content := buildFDT(&tnode{
+		props: []tprop{
+			{"compatible", strs("acme,board-rev2", "acme,board")},
+			{"model", strs("Acme Board")},
+		},
+		children: []*tnode{
+			{name: "chosen", props: []tprop{{"bootargs", strs("console=ttyS0")}}},
+			{name: "memory@80000000", props: []tprop{{"#size-cells", cell(1)}}},
+		},
+	})
+	root, size, err := parseFDT(append(content, 0xff, 0xff))
+	c.Assert(err, IsNil)
+	c.Check(size, Equals, len(content))
+	c.Check(root.Name, Equals, "")
+	c.Check(root.StringList("compatible"), DeepEquals, []string{"acme,board-rev2", "acme,board"})
+	c.Check(root.StringProp("model"), Equals, "Acme Board")
+	c.Check(root.compatible("acme,board"), Equals, true)
+	c.Check(root.compatible("acme"), Equals, false)
+	c.Assert(root.Children, HasLen, 2)
+	c.Check(root.Child("chosen").StringProp("bootargs"), Equals, "console=ttyS0")
+	cells, ok := root.Child("memory@80000000").Uint32("#size-cells")
+	c.Check(ok, Equals, true)
+	c.Check(cells, Equals, uint32(1))
+	c.Check(root.Child("memory"), IsNil)
+	_, ok = root.Prop("missing")
+	c.Check(ok, Equals, false)
+
+	root, err = ParseDeviceTree(content)
+	c.Assert(err, IsNil)
+	c.Check(root.StringProp("model"), Equals, "Acme Board")
+}
+
+func (s *fdtTestSuite) TestParseFDTErrors(c *C) {
+	good := buildFDT(&tnode{props: []tprop{{"model", strs("x")}}})
+
+	_, _, err := parseFDT(good[:20])
+	c.Check(err, ErrorMatches, "device tree too small: 20 bytes")
+	_, _, err = parseFDT(make([]byte, 64))
+	c.Check(err, ErrorMatches, "bad device tree magic: 0x0")
+	_, _, err = parseFDT(good[:len(good)-1])
+	c.Check(err, ErrorMatches, "device tree truncated: .*")
+
+	// the end token is missing
+	broken := append([]byte(nil), good...)
+	copy(broken[len(broken)-len("model\x00")-4:], cell(fdtNop))
+	_, _, err = parseFDT(broken)
+	c.Check(err, ErrorMatches, "unexpected end of device tree")
+
+	broken = append([]byte(nil), good...)
+	copy(broken[fdtHeaderSize+16:], cell(7))
+	_, _, err = parseFDT(broken)
+	c.Check(err, ErrorMatches, "invalid device tree token 7")
+}
+
+func (s *fdtTestSuite) TestParseFDTLayoutOverflow(c *C) {
+	// offset plus size overflows a 32 bit int
+	out := bytes.NewBuffer(nil)
+	header := []uint32{fdtMagic, 64, 0x7fffff00, fdtHeaderSize, fdtHeaderSize, 17, 16, 0, 0, 0x7fffff00}
+	binary.Write(out, binary.BigEndian, header)
+	out.Write(make([]byte, 24))
+	_, _, err := parseFDT(out.Bytes())
+	c.Check(err, ErrorMatches, "invalid device tree layout")
+}
@@ -0,0 +1,160 @@
+// Package fit reads FIT (flattened image tree) images as created by
+// "mkimage -f image.its". A FIT image is a device tree with the images
+// (kernels, DTBs, ramdisks) below /images and the bootable combinations
+// of them below /configurations.
+package fit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/mvo5/uboot-go/decompress"
+)
+
+// Image is a FIT image
+type Image struct {
+	// Root is the root node of the image tree
+	Root *Node
+
+	content []byte
+	// size is the size of the device tree, external data follows it
+	size int
+}
+
+// Parse parses a FIT image
+func Parse(content []byte) (*Image, error) {
+	root, size, err := parseFDT(content)
+	if err != nil {
+		return nil, err
+	}
+	if root.Child("images") == nil {
+		return nil, fmt.Errorf("not a FIT image: no /images node")
+	}
+	return &Image{Root: root, content: content, size: size}, nil
+}
+
+// Images returns the nodes below /images
+func (img *Image) Images() []*Node {
+	return img.Root.Child("images").Children
+}
+
+// Configurations returns the nodes below /configurations in the order
+// of the image
+func (img *Image) Configurations() []*Node {
+	if confs := img.Root.Child("configurations"); confs != nil {
+		return confs.Children
+	}
+	return nil
+}
+
+// DefaultConfiguration returns the name of the default configuration
+func (img *Image) DefaultConfiguration() string {
+	if confs := img.Root.Child("configurations"); confs != nil {
+		return confs.StringProp("default")
+	}
+	return ""
+}
+
+// ImageData returns the data of the image with the given name below
+// /images. The data may be embedded or external (mkimage -E).
+func (img *Image) ImageData(name string) ([]byte, error) {
+	node := img.Root.Child("images").Child(name)
+	if node == nil {
+		return nil, fmt.Errorf("cannot find image %q", name)
+	}
+	if data, ok := node.Prop("data"); ok {
+		return data, nil
+	}
+
+	size, ok := node.Uint32("data-size")
+	if !ok {
+		return nil, fmt.Errorf("image %q has no data", name)
+	}
+	var start uint64
+	if pos, ok := node.Uint32("data-position"); ok {
+		start = uint64(pos)
+	} else if off, ok := node.Uint32("data-offset"); ok {
+		// relative to the end of the device tree
+		start = uint64((img.size+3)&^3) + uint64(off)
+	} else {
+		return nil, fmt.Errorf("image %q has no data", name)
+	}
+	if start+uint64(size) > uint64(len(img.content)) {
+		return nil, fmt.Errorf("external data of image %q truncated", name)
+	}
+	return img.content[start : start+uint64(size)], nil
+}
+
+// ImageReader returns a reader over the data of the image with the
+// given name decompressed as given by its "compression" property
+func (img *Image) ImageReader(name string) (io.Reader, error) {
+	data, err := img.ImageData(name)
+	if err != nil {
+		return nil, err
+	}
+	comp := img.Root.Child("images").Child(name).StringProp("compression")
+	if comp == "" {
+		comp = "none"
+	}
+	return decompress.NewReader(comp, bytes.NewReader(data))
+}
+
+// ParseCompatible splits a "compatible" property as found in
+// /proc/device-tree/compatible
+func ParseCompatible(value []byte) []string {
+	return splitStrings(value)
+}
+
+// FindCompatible returns the name of the configuration that uboot
+// picks for a board with the given compatible strings, most specific
+// first, like fit_conf_find_compat(). A configuration matches with the
+// "compatible" property of the configuration node or, if there is
+// none, with the one of the root node of its DTB. The configuration
+// that matches the earliest compatible string wins, on ties the first
+// configuration.
+func (img *Image) FindCompatible(compatible []string) (string, error) {
+	if len(compatible) == 0 {
+		return "", fmt.Errorf("cannot find configuration without compatible strings")
+	}
+	best := ""
+	bestPos := len(compatible)
+	for _, conf := range img.Configurations() {
+		node := img.compatNode(conf)
+		if node == nil {
+			continue
+		}
+		for i := 0; i < bestPos; i++ {
+			if node.compatible(compatible[i]) {
+				best = conf.Name
+				bestPos = i
+				break
+			}
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("cannot find configuration compatible with %q", compatible[0])
+	}
+	return best, nil
+}
+
+// compatNode returns the node whose "compatible" property is used
+// for the given configuration or nil if there is none
+func (img *Image) compatNode(conf *Node) *Node {
+	if _, ok := conf.Prop("compatible"); ok {
+		return conf
+	}
+	name := conf.StringProp("fdt")
+	if name == "" {
+		return nil
+	}
+	data, err := img.ImageData(name)
+	if err != nil {
+		return nil
+	}
+	root, _, err := parseFDT(data)
+	if err != nil {
+		return nil
+	}
+	return root
+}
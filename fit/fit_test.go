@@ -0,0 +1,199 @@
+package fit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+type fitTestSuite struct{}
+
+var _ = Suite(&fitTestSuite{})
+
+func dtb(compatible ...string) []byte {
+	return buildFDT(&tnode{props: []tprop{{"compatible", strs(compatible...)}}})
+}
+
+// makeFIT returns a FIT image with a kernel, the given DTBs and
+// configurations
+func makeFIT(fdts []*tnode, confs []*tnode) []byte {
+	images := &tnode{name: "images", children: append([]*tnode{
+		{name: "kernel", props: []tprop{{"data", []byte("kernel")}, {"type", strs("kernel")}}},
+	}, fdts...)}
+	return buildFDT(&tnode{
+		props: []tprop{{"description", strs("test image")}},
+		children: []*tnode{
+			images,
+			{name: "configurations", props: []tprop{{"default", strs("conf-1")}}, children: confs},
+		},
+	})
+}
+
+func fdtImage(name string, data []byte) *tnode {
+	return &tnode{name: name, props: []tprop{{"data", data}, {"type", strs("flat_dt")}}}
+}
+
+func conf(name, fdt string, props ...tprop) *tnode {
+	return &tnode{name: name, props: append([]tprop{{"kernel", strs("kernel")}, {"fdt", strs(fdt)}}, props...)}
+}
+
+func (s *fitTestSuite) TestParse(c *C) {
+	img, err := Parse(makeFIT(
+		[]*tnode{fdtImage("fdt-1", dtb("acme,a"))},
+		[]*tnode{conf("conf-1", "fdt-1")},
+	))
+	c.Assert(err, IsNil)
+	c.Check(img.DefaultConfiguration(), Equals, "conf-1")
+	c.Check(img.Configurations(), HasLen, 1)
+	c.Check(img.Images(), HasLen, 2)
+
+	data, err := img.ImageData("kernel")
+	c.Assert(err, IsNil)
+	c.Check(data, DeepEquals, []byte("kernel"))
+	_, err = img.ImageData("missing")
+	c.Check(err, ErrorMatches, `cannot find image "missing"`)
+
+	_, err = Parse(dtb("acme,a"))
+	c.Check(err, ErrorMatches, "not a FIT image: no /images node")
+}
+
+func (s *fitTestSuite) TestExternalData(c *C) {
+	content := buildFDT(&tnode{children: []*tnode{
+		{name: "images", children: []*tnode{
+			{name: "kernel", props: []tprop{{"data-offset", cell(4)}, {"data-size", cell(3)}}},
+			{name: "ramdisk", props: []tprop{{"data-position", cell(0)}, {"data-size", cell(4)}}},
+			{name: "nodata"},
+		}},
+	}})
+	for len(content)%4 != 0 {
+		content = append(content, 0)
+	}
+	content = append(content, []byte("skipKRN")...)
+
+	img, err := Parse(content)
+	c.Assert(err, IsNil)
+	data, err := img.ImageData("kernel")
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, "KRN")
+	data, err = img.ImageData("ramdisk")
+	c.Assert(err, IsNil)
+	c.Check(data, DeepEquals, content[:4])
+	_, err = img.ImageData("nodata")
+	c.Check(err, ErrorMatches, `image "nodata" has no data`)
+
+	img.content = img.content[:len(img.content)-1]
+	_, err = img.ImageData("kernel")
+	c.Check(err, ErrorMatches, `external data of image "kernel" truncated`)
+}
+
+func (s *fitTestSuite) TestImageReader(c *C) {
+	buf := bytes.NewBuffer(nil)
+	w := gzip.NewWriter(buf)
+	w.Write([]byte("ramdisk"))
+	c.Assert(w.Close(), IsNil)
+	img, err := Parse(buildFDT(&tnode{children: []*tnode{
+		{name: "images", children: []*tnode{
+			{name: "kernel", props: []tprop{{"data", []byte("kernel")}}},
+			{name: "ramdisk", props: []tprop{{"data", buf.Bytes()}, {"compression", strs("gzip")}}},
+			{name: "fpga", props: []tprop{{"data", []byte("x")}, {"compression", strs("lzo")}}},
+		}},
+	}}))
+	c.Assert(err, IsNil)
+
+	for name, expected := range map[string]string{"kernel": "kernel", "ramdisk": "ramdisk"} {
+		r, err := img.ImageReader(name)
+		c.Assert(err, IsNil)
+		data, err := ioutil.ReadAll(r)
+		c.Assert(err, IsNil)
+		c.Check(string(data), Equals, expected)
+	}
+	_, err = img.ImageReader("fpga")
+	c.Check(err, ErrorMatches, `unsupported compression "lzo"`)
+	_, err = img.ImageReader("missing")
+	c.Check(err, ErrorMatches, `cannot find image "missing"`)
+}
+
+func (s *fitTestSuite) TestFindCompatible(c *C) {
+	img, err := Parse(makeFIT(
+		[]*tnode{
+			fdtImage("fdt-generic", dtb("acme,board")),
+			fdtImage("fdt-rev2", dtb("acme,board-rev2", "acme,board")),
+			fdtImage("fdt-other", dtb("other,board")),
+		},
+		[]*tnode{
+			conf("conf-generic", "fdt-generic"),
+			conf("conf-rev2", "fdt-rev2"),
+			conf("conf-other", "fdt-other"),
+			conf("conf-broken", "missing"),
+		},
+	))
+	c.Assert(err, IsNil)
+
+	for _, t := range []struct {
+		compatible []string
+		conf       string
+	}{
+		{[]string{"acme,board-rev2", "acme,board"}, "conf-rev2"},
+		{[]string{"acme,board-rev3", "acme,board"}, "conf-generic"},
+		{[]string{"other,board"}, "conf-other"},
+	} {
+		conf, err := img.FindCompatible(t.compatible)
+		c.Check(err, IsNil)
+		c.Check(conf, Equals, t.conf, Commentf("%q", t.compatible))
+	}
+
+	_, err = img.FindCompatible([]string{"unknown,board"})
+	c.Check(err, ErrorMatches, `cannot find configuration compatible with "unknown,board"`)
+	_, err = img.FindCompatible(nil)
+	c.Check(err, ErrorMatches, "cannot find configuration without compatible strings")
+}
+
+func (s *fitTestSuite) TestFindCompatibleConfigNode(c *C) {
+	// the compatible of the configuration node is used instead of the
+	// one of the DTB
+	img, err := Parse(makeFIT(
+		[]*tnode{fdtImage("fdt-1", dtb("acme,board"))},
+		[]*tnode{
+			conf("conf-1", "fdt-1", tprop{"compatible", strs("acme,other")}),
+			conf("conf-2", "fdt-1"),
+		},
+	))
+	c.Assert(err, IsNil)
+	conf, err := img.FindCompatible([]string{"acme,board"})
+	c.Assert(err, IsNil)
+	c.Check(conf, Equals, "conf-2")
+	conf, err = img.FindCompatible([]string{"acme,other", "acme,board"})
+	c.Assert(err, IsNil)
+	c.Check(conf, Equals, "conf-1")
+}
+
+func (s *fitTestSuite) TestParseCompatible(c *C) {
+	c.Check(ParseCompatible([]byte("acme,board-rev2\x00acme,board\x00")), DeepEquals, []string{"acme,board-rev2", "acme,board"})
+	c.Check(ParseCompatible(nil), HasLen, 0)
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add(makeFIT(
+		[]*tnode{fdtImage("fdt-1", dtb("acme,a"))},
+		[]*tnode{conf("conf-1", "fdt-1"), conf("conf-2", "missing")},
+	))
+	f.Add(buildFDT(&tnode{children: []*tnode{
+		{name: "images", children: []*tnode{
+			{name: "kernel", props: []tprop{{"data-offset", cell(4)}, {"data-size", cell(3)}}},
+			{name: "ramdisk", props: []tprop{{"data-position", cell(0)}, {"data-size", cell(4)}}},
+		}},
+	}}))
+	f.Fuzz(func(t *testing.T, content []byte) {
+		img, err := Parse(content)
+		if err != nil {
+			return
+		}
+		for _, node := range img.Images() {
+			img.ImageData(node.Name)
+		}
+		img.FindCompatible([]string{"acme,a"})
+	})
+}
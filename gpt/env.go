@@ -0,0 +1,96 @@
+package gpt
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// EnvConfig returns the config of the env that is stored in the
+// partitions with the given type GUID on the disk or disk image at
+// path. A single partition holds the env, a second one holds the
+// redundant copy. If cfg has no size the env fills the partition.
+func EnvConfig(path string, typ GUID, cfg uenv.Config) (uenv.Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return cfg, err
+	}
+	defer f.Close()
+	t, err := Read(f)
+	if err != nil {
+		return cfg, fmt.Errorf("cannot read partition table of %s: %v", path, err)
+	}
+
+	parts := t.FindType(typ)
+	switch len(parts) {
+	case 0:
+		return cfg, fmt.Errorf("cannot find env partition with type %v in %s", typ, path)
+	case 1, 2:
+	default:
+		return cfg, fmt.Errorf("cannot use %v env partitions with type %v in %s", len(parts), typ, path)
+	}
+	partSize := t.Size(parts[0])
+	for _, p := range parts[1:] {
+		if size := t.Size(p); size < partSize {
+			partSize = size
+		}
+	}
+	if cfg.Size == 0 {
+		cfg.Size = int(partSize)
+	}
+	if int64(cfg.Size) > partSize {
+		return cfg, fmt.Errorf("cannot fit env of %v bytes into partition of %v bytes", cfg.Size, partSize)
+	}
+	cfg.Offset = t.Offset(parts[0])
+	if len(parts) == 2 {
+		cfg.Redundant = true
+		cfg.RedundantFile = ""
+		cfg.RedundantOffset = t.Offset(parts[1])
+	}
+	return cfg, nil
+}
+
+// OpenEnv opens the env in the partitions with the given type GUID,
+// see EnvConfig
+func OpenEnv(path string, typ GUID, cfg uenv.Config) (*uenv.Env, error) {
+	cfg, err := EnvConfig(path, typ, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return uenv.OpenWithConfig(path, cfg)
+}
+
+// CreateEnv creates a new empty env in the partitions with the given
+// type GUID, see EnvConfig
+func CreateEnv(path string, typ GUID, cfg uenv.Config) (*uenv.Env, error) {
+	cfg, err := EnvConfig(path, typ, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return uenv.CreateWithConfig(path, cfg)
+}
+
+// AddPartition adds a partition with the given type GUID, name and
+// size to the partition table of the disk image at path, e.g. to
+// create the env partition when an image is built. Calling it twice
+// creates the partition for the redundant copy.
+func AddPartition(path string, typ GUID, name string, size int64) (*Partition, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	t, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read partition table of %s: %v", path, err)
+	}
+	p, err := t.Add(typ, name, size)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Write(f); err != nil {
+		return nil, err
+	}
+	return p, f.Sync()
+}
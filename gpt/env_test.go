@@ -0,0 +1,55 @@
+package gpt
+
+import (
+	"github.com/mvo5/uboot-go/uenv"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *gptTestSuite) TestEnvConfig(c *C) {
+	cfg, err := EnvConfig(s.disk, UBootEnvType, uenv.Config{})
+	c.Assert(err, IsNil)
+	c.Check(cfg, DeepEquals, uenv.Config{Size: 4096, Offset: 32768})
+
+	cfg, err = EnvConfig(s.disk, UBootEnvType, uenv.Config{Size: 1024})
+	c.Assert(err, IsNil)
+	c.Check(cfg.Size, Equals, 1024)
+
+	_, err = EnvConfig(s.disk, UBootEnvType, uenv.Config{Size: 8192})
+	c.Check(err, ErrorMatches, "cannot fit env of 8192 bytes into partition of 4096 bytes")
+	_, err = EnvConfig(s.disk, MustParseGUID("01234567-89ab-cdef-0123-456789abcdef"), uenv.Config{})
+	c.Check(err, ErrorMatches, "cannot find env partition with type 01234567-89ab-cdef-0123-456789abcdef in .*")
+}
+
+func (s *gptTestSuite) TestCreateAndOpenEnv(c *C) {
+	env, err := CreateEnv(s.disk, UBootEnvType, uenv.Config{})
+	c.Assert(err, IsNil)
+	c.Assert(env.Set("bootcmd", "run distro_bootcmd"), IsNil)
+	c.Assert(env.Save(), IsNil)
+
+	env, err = OpenEnv(s.disk, UBootEnvType, uenv.Config{})
+	c.Assert(err, IsNil)
+	c.Check(env.Get("bootcmd"), Equals, "run distro_bootcmd")
+	// the partition table is untouched
+	c.Check(s.readTable(c).Partitions, HasLen, 2)
+}
+
+func (s *gptTestSuite) TestRedundantEnv(c *C) {
+	_, err := AddPartition(s.disk, UBootEnvType, "uboot-env-redund", 4096)
+	c.Assert(err, IsNil)
+	cfg, err := EnvConfig(s.disk, UBootEnvType, uenv.Config{})
+	c.Assert(err, IsNil)
+	c.Check(cfg.Redundant, Equals, true)
+	c.Check(cfg.RedundantOffset, Equals, int64(72*512))
+
+	env, err := CreateEnv(s.disk, UBootEnvType, uenv.Config{})
+	c.Assert(err, IsNil)
+	c.Assert(env.Set("a", "1"), IsNil)
+	c.Assert(env.Save(), IsNil)
+	env, err = OpenEnv(s.disk, UBootEnvType, uenv.Config{})
+	c.Assert(err, IsNil)
+	c.Check(env.Get("a"), Equals, "1")
+	report, err := uenv.Verify(s.disk, cfg)
+	c.Assert(err, IsNil)
+	c.Check(report.Problems, HasLen, 0)
+}
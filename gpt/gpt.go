@@ -0,0 +1,357 @@
+// Package gpt reads GUID partition tables and finds the partition that
+// holds the uboot env by its type GUID. Image builders that place the
+// env in a small dedicated partition can also add the partition entry
+// to the table of a disk image, the primary and the backup table are
+// both updated.
+package gpt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// GUID is a GUID in the mixed endian encoding used on disk
+type GUID [16]byte
+
+// ParseGUID parses a GUID like "3de21764-95bd-54bd-a5c3-4abe786f38a8"
+func ParseGUID(s string) (GUID, error) {
+	var g GUID
+	b, err := hex.DecodeString(strings.Replace(s, "-", "", -1))
+	if err != nil || len(b) != 16 || len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return g, fmt.Errorf("cannot parse GUID %q", s)
+	}
+	// the first three fields are little endian
+	g[0], g[1], g[2], g[3] = b[3], b[2], b[1], b[0]
+	g[4], g[5] = b[5], b[4]
+	g[6], g[7] = b[7], b[6]
+	copy(g[8:], b[8:])
+	return g, nil
+}
+
+// MustParseGUID is like ParseGUID but panics if s is not a GUID
+func MustParseGUID(s string) GUID {
+	g, err := ParseGUID(s)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// String returns the GUID in its text form
+func (g GUID) String() string {
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(g[0:]), binary.LittleEndian.Uint16(g[4:]),
+		binary.LittleEndian.Uint16(g[6:]), g[8:10], g[10:])
+}
+
+// UBootEnvType is the partition type GUID that uboot uses for env
+// partitions (PARTITION_U_BOOT_ENVIRONMENT)
+var UBootEnvType = MustParseGUID("3de21764-95bd-54bd-a5c3-4abe786f38a8")
+
+const (
+	signature  = "EFI PART"
+	headerSize = 92
+	nameSize   = 72
+)
+
+// sectorSizes are the logical sector sizes that are tried when a table
+// is read
+var sectorSizes = []int{512, 4096}
+
+// Partition is a used entry of the partition table
+type Partition struct {
+	// Index is the index of the entry in the table, starting at 0
+	Index      int
+	Type       GUID
+	GUID       GUID
+	FirstLBA   uint64
+	LastLBA    uint64
+	Attributes uint64
+	Name       string
+}
+
+// header is a GPT header, only the fields that are not implied by
+// its location are kept
+type header struct {
+	currentLBA  uint64
+	backupLBA   uint64
+	firstUsable uint64
+	lastUsable  uint64
+	diskGUID    GUID
+	entriesLBA  uint64
+	numEntries  uint32
+	entrySize   uint32
+}
+
+// Table is a GUID partition table
+type Table struct {
+	// SectorSize is the logical sector size of the disk
+	SectorSize int
+	// DiskGUID is the GUID of the disk
+	DiskGUID GUID
+	// FirstUsableLBA and LastUsableLBA limit where partitions can
+	// be placed
+	FirstUsableLBA uint64
+	LastUsableLBA  uint64
+	// Partitions are the used entries in the order of the table
+	Partitions []Partition
+
+	primary, backup header
+	// entries are the raw partition entries
+	entries []byte
+}
+
+// Read reads the partition table from r, the sector size is detected.
+// The CRCs of the primary header and its entries are checked.
+func Read(r io.ReaderAt) (*Table, error) {
+	var firstErr error
+	for _, sectorSize := range sectorSizes {
+		t, err := readTable(r, sectorSize)
+		if err == nil {
+			return t, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+func readTable(r io.ReaderAt, sectorSize int) (*Table, error) {
+	primary, entries, err := readHeader(r, 1, sectorSize)
+	if err != nil {
+		return nil, err
+	}
+	t := &Table{
+		SectorSize:     sectorSize,
+		DiskGUID:       primary.diskGUID,
+		FirstUsableLBA: primary.firstUsable,
+		LastUsableLBA:  primary.lastUsable,
+		primary:        *primary,
+		entries:        entries,
+	}
+	for i := 0; i < int(primary.numEntries); i++ {
+		e := entries[i*int(primary.entrySize):]
+		p := Partition{
+			Index:      i,
+			FirstLBA:   binary.LittleEndian.Uint64(e[32:]),
+			LastLBA:    binary.LittleEndian.Uint64(e[40:]),
+			Attributes: binary.LittleEndian.Uint64(e[48:]),
+			Name:       decodeName(e[56 : 56+nameSize]),
+		}
+		copy(p.Type[:], e[0:16])
+		copy(p.GUID[:], e[16:32])
+		if p.Type == (GUID{}) {
+			continue
+		}
+		t.Partitions = append(t.Partitions, p)
+	}
+	// the backup is only needed to update the table
+	if backup, _, err := readHeader(r, primary.backupLBA, sectorSize); err == nil {
+		t.backup = *backup
+	}
+	return t, nil
+}
+
+// readHeader reads and checks the header at the given LBA and returns
+// it with its partition entries
+func readHeader(r io.ReaderAt, lba uint64, sectorSize int) (*header, []byte, error) {
+	buf := make([]byte, sectorSize)
+	if _, err := r.ReadAt(buf, int64(lba)*int64(sectorSize)); err != nil {
+		return nil, nil, fmt.Errorf("cannot read GPT header: %v", err)
+	}
+	if string(buf[:8]) != signature {
+		return nil, nil, fmt.Errorf("cannot find GPT header at LBA %v", lba)
+	}
+	size := binary.LittleEndian.Uint32(buf[12:])
+	if size < headerSize || int(size) > sectorSize {
+		return nil, nil, fmt.Errorf("invalid GPT header size %v", size)
+	}
+	crc := binary.LittleEndian.Uint32(buf[16:])
+	binary.LittleEndian.PutUint32(buf[16:], 0)
+	if actualCRC := crc32.ChecksumIEEE(buf[:size]); crc != actualCRC {
+		return nil, nil, fmt.Errorf("bad GPT header CRC: %v != %v", crc, actualCRC)
+	}
+
+	hdr := &header{
+		currentLBA:  binary.LittleEndian.Uint64(buf[24:]),
+		backupLBA:   binary.LittleEndian.Uint64(buf[32:]),
+		firstUsable: binary.LittleEndian.Uint64(buf[40:]),
+		lastUsable:  binary.LittleEndian.Uint64(buf[48:]),
+		entriesLBA:  binary.LittleEndian.Uint64(buf[72:]),
+		numEntries:  binary.LittleEndian.Uint32(buf[80:]),
+		entrySize:   binary.LittleEndian.Uint32(buf[84:]),
+	}
+	copy(hdr.diskGUID[:], buf[56:72])
+	if hdr.entrySize < 128 || hdr.entrySize%8 != 0 || uint64(hdr.numEntries)*uint64(hdr.entrySize) > 1<<20 {
+		return nil, nil, fmt.Errorf("invalid GPT partition entries: %v entries of %v bytes", hdr.numEntries, hdr.entrySize)
+	}
+
+	entries := make([]byte, int(hdr.numEntries)*int(hdr.entrySize))
+	if _, err := r.ReadAt(entries, int64(hdr.entriesLBA)*int64(sectorSize)); err != nil {
+		return nil, nil, fmt.Errorf("cannot read GPT partition entries: %v", err)
+	}
+	entriesCRC := binary.LittleEndian.Uint32(buf[88:])
+	if actualCRC := crc32.ChecksumIEEE(entries); entriesCRC != actualCRC {
+		return nil, nil, fmt.Errorf("bad GPT partition entries CRC: %v != %v", entriesCRC, actualCRC)
+	}
+	return hdr, entries, nil
+}
+
+func decodeName(b []byte) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u))
+}
+
+func encodeName(name string) ([]byte, error) {
+	u := utf16.Encode([]rune(name))
+	if len(u)*2 > nameSize {
+		return nil, fmt.Errorf("partition name %q too long", name)
+	}
+	b := make([]byte, nameSize)
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(b[i*2:], c)
+	}
+	return b, nil
+}
+
+// Offset returns the offset of the partition in bytes
+func (t *Table) Offset(p *Partition) int64 {
+	return int64(p.FirstLBA) * int64(t.SectorSize)
+}
+
+// Size returns the size of the partition in bytes
+func (t *Table) Size(p *Partition) int64 {
+	return int64(p.LastLBA-p.FirstLBA+1) * int64(t.SectorSize)
+}
+
+// FindType returns the partitions with the given type GUID in the
+// order of the table
+func (t *Table) FindType(typ GUID) []*Partition {
+	var found []*Partition
+	for i := range t.Partitions {
+		if t.Partitions[i].Type == typ {
+			found = append(found, &t.Partitions[i])
+		}
+	}
+	return found
+}
+
+// Add adds a partition of the given type, name and size in bytes to
+// the table. It is placed in the first free entry, after the last
+// partition and aligned to 1 MiB if the disk has room for that. The
+// table is only changed in memory, see Write.
+func (t *Table) Add(typ GUID, name string, size int64) (*Partition, error) {
+	if typ == (GUID{}) {
+		return nil, fmt.Errorf("cannot add partition without type")
+	}
+	if size <= 0 || size%int64(t.SectorSize) != 0 {
+		return nil, fmt.Errorf("cannot add partition of %v bytes, the size must be a multiple of %v", size, t.SectorSize)
+	}
+	encodedName, err := encodeName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	index := -1
+	for i := 0; i < int(t.primary.numEntries); i++ {
+		if bytes.Equal(t.entry(i)[:16], make([]byte, 16)) {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("cannot add partition: no free partition entry")
+	}
+
+	sectors := uint64(size / int64(t.SectorSize))
+	first := t.FirstUsableLBA
+	for _, p := range t.Partitions {
+		if p.LastLBA >= first {
+			first = p.LastLBA + 1
+		}
+	}
+	align := uint64(1<<20) / uint64(t.SectorSize)
+	if aligned := (first + align - 1) / align * align; aligned+sectors-1 <= t.LastUsableLBA {
+		first = aligned
+	}
+	if first+sectors-1 > t.LastUsableLBA {
+		return nil, fmt.Errorf("cannot add partition of %v bytes: not enough free space", size)
+	}
+
+	p := Partition{Index: index, Type: typ, FirstLBA: first, LastLBA: first + sectors - 1, Name: name}
+	if _, err := rand.Read(p.GUID[:]); err != nil {
+		return nil, err
+	}
+	// a random (version 4) GUID
+	p.GUID[7] = p.GUID[7]&0x0f | 0x40
+	p.GUID[8] = p.GUID[8]&0x3f | 0x80
+
+	e := t.entry(index)
+	copy(e[0:16], p.Type[:])
+	copy(e[16:32], p.GUID[:])
+	binary.LittleEndian.PutUint64(e[32:], p.FirstLBA)
+	binary.LittleEndian.PutUint64(e[40:], p.LastLBA)
+	binary.LittleEndian.PutUint64(e[48:], p.Attributes)
+	copy(e[56:], encodedName)
+
+	t.Partitions = append(t.Partitions, p)
+	return &t.Partitions[len(t.Partitions)-1], nil
+}
+
+func (t *Table) entry(i int) []byte {
+	size := int(t.primary.entrySize)
+	return t.entries[i*size : (i+1)*size]
+}
+
+// Write writes the partition entries and the primary and backup
+// headers with updated CRCs to w
+func (t *Table) Write(w io.WriterAt) error {
+	if t.backup.currentLBA == 0 {
+		return fmt.Errorf("cannot write GPT without a valid backup header")
+	}
+	entriesCRC := crc32.ChecksumIEEE(t.entries)
+	for _, hdr := range []*header{&t.primary, &t.backup} {
+		if _, err := w.WriteAt(t.entries, int64(hdr.entriesLBA)*int64(t.SectorSize)); err != nil {
+			return err
+		}
+		if _, err := w.WriteAt(hdr.marshal(entriesCRC), int64(hdr.currentLBA)*int64(t.SectorSize)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// marshal returns the header with the given entries CRC and its own
+// CRC filled in
+func (hdr *header) marshal(entriesCRC uint32) []byte {
+	buf := make([]byte, headerSize)
+	copy(buf, signature)
+	binary.LittleEndian.PutUint32(buf[8:], 0x00010000)
+	binary.LittleEndian.PutUint32(buf[12:], headerSize)
+	binary.LittleEndian.PutUint64(buf[24:], hdr.currentLBA)
+	binary.LittleEndian.PutUint64(buf[32:], hdr.backupLBA)
+	binary.LittleEndian.PutUint64(buf[40:], hdr.firstUsable)
+	binary.LittleEndian.PutUint64(buf[48:], hdr.lastUsable)
+	copy(buf[56:], hdr.diskGUID[:])
+	binary.LittleEndian.PutUint64(buf[72:], hdr.entriesLBA)
+	binary.LittleEndian.PutUint32(buf[80:], hdr.numEntries)
+	binary.LittleEndian.PutUint32(buf[84:], hdr.entrySize)
+	binary.LittleEndian.PutUint32(buf[88:], entriesCRC)
+	binary.LittleEndian.PutUint32(buf[16:], crc32.ChecksumIEEE(buf))
+	return buf
+}
@@ -0,0 +1,141 @@
+package gpt
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type gptTestSuite struct {
+	disk string
+}
+
+var _ = Suite(&gptTestSuite{})
+
+var linuxType = MustParseGUID("0fc63daf-8483-4772-8e79-3d69d8477de4")
+
+// SetUpTest copies the test disk, a 64 KiB disk with a rootfs and an
+// env partition
+func (s *gptTestSuite) SetUpTest(c *C) {
+	content, err := ioutil.ReadFile(filepath.Join("testdata", "disk.img"))
+	c.Assert(err, IsNil)
+	s.disk = filepath.Join(c.MkDir(), "disk.img")
+	c.Assert(ioutil.WriteFile(s.disk, content, 0644), IsNil)
+}
+
+func (s *gptTestSuite) readTable(c *C) *Table {
+	f, err := os.Open(s.disk)
+	c.Assert(err, IsNil)
+	defer f.Close()
+	t, err := Read(f)
+	c.Assert(err, IsNil)
+	return t
+}
+
+func (s *gptTestSuite) TestGUID(c *C) {
+	c.Check(UBootEnvType.String(), Equals, "3de21764-95bd-54bd-a5c3-4abe786f38a8")
+	c.Check(UBootEnvType[:4], DeepEquals, []byte{0x64, 0x17, 0xe2, 0x3d})
+
+	for _, s := range []string{"", "3de21764-95bd-54bd-a5c3-4abe786f38", "3de2176495bd54bda5c34abe786f38a8", "3de21764-95bd-54bd-a5c3-4abe786f38ax"} {
+		_, err := ParseGUID(s)
+		c.Check(err, ErrorMatches, "cannot parse GUID .*")
+	}
+}
+
+func (s *gptTestSuite) TestRead(c *C) {
+	t := s.readTable(c)
+	c.Check(t.SectorSize, Equals, 512)
+	c.Check(t.DiskGUID.String(), Equals, "01234567-89ab-cdef-0123-456789abcdef")
+	c.Check(t.FirstUsableLBA, Equals, uint64(34))
+	c.Check(t.LastUsableLBA, Equals, uint64(94))
+	c.Assert(t.Partitions, HasLen, 2)
+
+	root := t.Partitions[0]
+	c.Check(root.Type, Equals, linuxType)
+	c.Check(root.Name, Equals, "rootfs")
+	env := t.FindType(UBootEnvType)
+	c.Assert(env, HasLen, 1)
+	c.Check(env[0].Index, Equals, 1)
+	c.Check(env[0].Name, Equals, "uboot-env")
+	c.Check(env[0].GUID.String(), Equals, "66666666-7777-8888-9999-aaaaaaaaaaaa")
+	c.Check(t.Offset(env[0]), Equals, int64(32768))
+	c.Check(t.Size(env[0]), Equals, int64(4096))
+}
+
+func (s *gptTestSuite) TestReadErrors(c *C) {
+	empty := filepath.Join(c.MkDir(), "empty.img")
+	c.Assert(ioutil.WriteFile(empty, make([]byte, 65536), 0644), IsNil)
+	f, err := os.Open(empty)
+	c.Assert(err, IsNil)
+	defer f.Close()
+	_, err = Read(f)
+	c.Check(err, ErrorMatches, "cannot find GPT header at LBA 1")
+
+	content, err := ioutil.ReadFile(s.disk)
+	c.Assert(err, IsNil)
+	for _, t := range []struct {
+		offset int
+		err    string
+	}{
+		{512 + 40, "bad GPT header CRC: .*"},
+		{1024, "bad GPT partition entries CRC: .*"},
+	} {
+		broken := append([]byte(nil), content...)
+		broken[t.offset] ^= 0xff
+		c.Assert(ioutil.WriteFile(empty, broken, 0644), IsNil)
+		f, err := os.Open(empty)
+		c.Assert(err, IsNil)
+		_, err = Read(f)
+		f.Close()
+		c.Check(err, ErrorMatches, t.err)
+	}
+}
+
+func (s *gptTestSuite) TestAdd(c *C) {
+	p, err := AddPartition(s.disk, UBootEnvType, "uboot-env-redund", 4096)
+	c.Assert(err, IsNil)
+	c.Check(p.Index, Equals, 2)
+	c.Check(p.FirstLBA, Equals, uint64(72))
+	c.Check(p.LastLBA, Equals, uint64(79))
+
+	t := s.readTable(c)
+	c.Assert(t.Partitions, HasLen, 3)
+	c.Check(t.Partitions[2], DeepEquals, *p)
+	c.Check(t.FindType(UBootEnvType), HasLen, 2)
+	// the GUID is random
+	c.Check(p.GUID, Not(Equals), GUID{})
+	c.Check(p.GUID.String()[14], Equals, byte('4'))
+
+	// the backup got updated too
+	f, err := os.Open(s.disk)
+	c.Assert(err, IsNil)
+	defer f.Close()
+	backup, entries, err := readHeader(f, t.primary.backupLBA, 512)
+	c.Assert(err, IsNil)
+	c.Check(backup.entriesLBA, Equals, uint64(95))
+	c.Check(entries, DeepEquals, t.entries)
+}
+
+func (s *gptTestSuite) TestAddErrors(c *C) {
+	t := s.readTable(c)
+	for _, tc := range []struct {
+		typ  GUID
+		name string
+		size int64
+		err  string
+	}{
+		{GUID{}, "env", 4096, "cannot add partition without type"},
+		{UBootEnvType, "env", 1000, "cannot add partition of 1000 bytes, the size must be a multiple of 512"},
+		{UBootEnvType, "env", 1 << 20, "cannot add partition of 1048576 bytes: not enough free space"},
+		{UBootEnvType, "a name that is much longer than thirty six characters", 4096, `partition name ".*" too long`},
+	} {
+		_, err := t.Add(tc.typ, tc.name, tc.size)
+		c.Check(err, ErrorMatches, tc.err)
+	}
+}
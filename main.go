@@ -1,32 +1,252 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"os/exec"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/mvo5/uboot-go/envcheck"
+	"github.com/mvo5/uboot-go/gpt"
 	"github.com/mvo5/uboot-go/uenv"
 )
 
+// exitProblems is the exit code of verify if the env has problems
+const exitProblems = 2
+
+var (
+	board      = flag.String("board", "", "use the env layout of the given board profile")
+	size       = flag.Int("size", 0, "size of the env, needed for <env-file>@<offset>")
+	expectSize = flag.Int("expect-size", 0, "fail if the env does not have the given size, e.g. 0x20000")
+	jsonOutput = flag.Bool("json", false, "print, diff, apply, verify, check and watch output JSON")
+	interval   = flag.Duration("interval", time.Second, "poll interval of watch")
+	aesKey     = flag.String("aes-key", "", "hex encoded key of an AES encrypted env (CONFIG_ENV_AES)")
+	printenv   = flag.Bool("printenv", false, "import reads printenv output copied from the uboot console")
+	saveenv    = flag.Bool("saveenv", false, "end the setenv script of export script with saveenv")
+	gptType    = flag.String("gpt-type", "", "find the env in the GPT partitions with the given type GUID, \"uboot\" for the uboot env type")
+)
+
+// printJSON writes v as indented JSON to stdout
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Fatalf("cannot encode JSON: %s", err)
+	}
+}
+
+// envJSON is the JSON output of print
+type envJSON struct {
+	File      string            `json:"file"`
+	Source    string            `json:"source"`
+	Size      int               `json:"size"`
+	Used      int               `json:"used"`
+	Free      int               `json:"free"`
+	CRC       uint32            `json:"crc"`
+	Variables map[string]string `json:"variables"`
+}
+
+// watchJSON is a JSON line of the output of watch
+type watchJSON struct {
+	Time    time.Time     `json:"time"`
+	Changes []uenv.Change `json:"changes"`
+}
+
+// copyJSON and reportJSON are the JSON output of verify
+type copyJSON struct {
+	Source     string   `json:"source"`
+	Size       int      `json:"size"`
+	Flags      byte     `json:"flags"`
+	Vars       int      `json:"variables"`
+	Duplicates []string `json:"duplicates,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+type reportJSON struct {
+	OK       bool       `json:"ok"`
+	Copies   []copyJSON `json:"copies"`
+	Problems []string   `json:"problems"`
+	Warnings []string   `json:"warnings,omitempty"`
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [options] <env-file>[@<offset>] print|create|set|import|export|apply|verify|check|repair|edit|diff|watch|add-partition [args]\n", os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+// splitOffset splits "path@offset" into the path and the offset, the
+// offset can be given in decimal or as hex with a 0x prefix
+func splitOffset(envFile string) (string, int64) {
+	i := strings.LastIndex(envFile, "@")
+	if i < 0 {
+		return envFile, 0
+	}
+	if _, err := os.Stat(envFile); err == nil {
+		// a file with a "@" in its name
+		return envFile, 0
+	}
+	offset, err := strconv.ParseInt(envFile[i+1:], 0, 64)
+	if err != nil {
+		log.Fatalf("cannot parse offset of %s: %s", envFile, err)
+	}
+	return envFile[:i], offset
+}
+
+// config returns the file name and config for the env file given on
+// the cmdline
+func config(envFile string) (string, uenv.Config) {
+	fname, cfg := layout(envFile)
+	cfg.ExpectedSize = *expectSize
+	if *aesKey != "" {
+		key, err := hex.DecodeString(*aesKey)
+		if err != nil {
+			log.Fatalf("cannot decode -aes-key: %s", err)
+		}
+		cfg.Transform, err = uenv.NewAESCBC(key)
+		if err != nil {
+			log.Fatalf("cannot use -aes-key: %s", err)
+		}
+	}
+	return fname, cfg
+}
+
+// layout returns the file name and the size and location of the env
+// given on the cmdline
+func layout(envFile string) (string, uenv.Config) {
+	envFile, offset := splitOffset(envFile)
+	if *gptType != "" {
+		if *board != "" || offset != 0 {
+			log.Fatalf("cannot use -gpt-type together with -board or an offset")
+		}
+		cfg, err := gpt.EnvConfig(envFile, partitionType(), uenv.Config{Size: *size})
+		if err != nil {
+			log.Fatalf("%s", err)
+		}
+		return envFile, cfg
+	}
+	if *board == "" {
+		if offset != 0 && *size == 0 {
+			log.Fatalf("size of the env at offset %#x of %s is unknown, use -size", offset, envFile)
+		}
+		return envFile, uenv.Config{Offset: offset, Size: *size}
+	}
+	if offset != 0 {
+		log.Fatalf("cannot use an offset together with -board")
+	}
+	p, err := uenv.LookupProfile(*board)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	fname, cfg, err := p.ConfigFor(envFile)
+	if err != nil {
+		log.Fatalf("cannot use board %s for %s: %s", *board, envFile, err)
+	}
+	return fname, cfg
+}
+
+// partitionType returns the GUID given with -gpt-type
+func partitionType() gpt.GUID {
+	if *gptType == "uboot" {
+		return gpt.UBootEnvType
+	}
+	typ, err := gpt.ParseGUID(*gptType)
+	if err != nil {
+		log.Fatalf("cannot use -gpt-type: %s", err)
+	}
+	return typ
+}
+
+// addPartition adds an env partition of the given size to the
+// partition table of a disk image
+func addPartition(disk string, args []string) {
+	if *gptType == "" || len(args) < 1 {
+		log.Fatalf("add-partition needs -gpt-type and a size")
+	}
+	size, err := strconv.ParseInt(args[0], 0, 64)
+	if err != nil {
+		log.Fatalf("cannot parse partition size %s: %s", args[0], err)
+	}
+	name := "uboot-env"
+	if len(args) > 1 {
+		name = args[1]
+	}
+	p, err := gpt.AddPartition(disk, partitionType(), name, size)
+	if err != nil {
+		log.Fatalf("cannot add partition to %s: %s", disk, err)
+	}
+	fmt.Printf("added partition %v (%s) at LBA %v-%v\n", p.Index+1, p.Name, p.FirstLBA, p.LastLBA)
+}
+
 func main() {
-	// FIXME: argsparse ftw!
-	envFile := os.Args[1]
-	cmd := os.Args[2]
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 2 {
+		usage()
+	}
+	if args[1] == "add-partition" {
+		// the partition does not exist yet, so there is no env config
+		addPartition(args[0], args[2:])
+		return
+	}
+	envFile, cfg := config(args[0])
+	cmd := args[1]
+	switch cmd {
+	case "import", "apply", "diff":
+		// these need a file as argument
+		if len(args) < 3 {
+			usage()
+		}
+	}
 
 	switch cmd {
 	case "print":
-		env, err := uenv.Open(envFile)
+		env, err := uenv.OpenWithConfig(envFile, cfg)
 		if err != nil {
 			log.Fatalf("uenv.Open failed for %s: %s", envFile, err)
 		}
+		if *jsonOutput {
+			stats := env.Stats()
+			printJSON(envJSON{
+				File:      envFile,
+				Source:    env.Source().String(),
+				Size:      stats.Size,
+				Used:      stats.Used,
+				Free:      stats.Free,
+				CRC:       env.CRC(),
+				Variables: env.Map(),
+			})
+			return
+		}
 		fmt.Print(env)
 	case "create":
-		size, err := strconv.Atoi(os.Args[3])
-		if err != nil {
-			log.Fatalf("Atoi failed for %s: %s", envFile, err)
+		if len(args) > 2 {
+			size, err := strconv.Atoi(args[2])
+			if err != nil {
+				log.Fatalf("Atoi failed for %s: %s", envFile, err)
+			}
+			cfg.Size = size
+		}
+		if *board == "" && cfg.Offset == 0 {
+			// a plain env file is replaced like uenv.Create does
+			f, err := os.Create(envFile)
+			if err != nil {
+				log.Fatalf("Create failed for %s: %s", envFile, err)
+			}
+			f.Close()
 		}
-		env, err := uenv.Create(envFile, size)
+		env, err := uenv.CreateWithConfig(envFile, cfg)
 		if err != nil {
 			log.Fatalf("uenv.Create failed for %s: %s", envFile, err)
 		}
@@ -35,34 +255,409 @@ func main() {
 		}
 
 	case "set":
-		env, err := uenv.Open(envFile)
+		setVars(envFile, cfg, args[2:])
+	case "import":
+		fname := args[2]
+		r, err := os.Open(fname)
+		if err != nil {
+			log.Fatalf("Open failed for %s: %s", fname, err)
+		}
+		defer r.Close()
+		err = uenv.Update(envFile, cfg, func(env *uenv.Env) error {
+			importEnv := env.Import
+			switch {
+			case *printenv:
+				importEnv = env.ImportPrintenv
+			case strings.HasSuffix(fname, ".json"):
+				importEnv = env.ImportJSON
+			}
+			return importEnv(r)
+		})
+		if err != nil {
+			log.Fatalf("cannot import %s into %s: %s", fname, envFile, err)
+		}
+	case "export":
+		env, err := uenv.OpenWithConfig(envFile, cfg)
 		if err != nil {
 			log.Fatalf("uenv.Open failed for %s: %s", envFile, err)
 		}
-		name := os.Args[3]
-		value := os.Args[4]
-		env.Set(name, value)
-		if err := env.Save(); err != nil {
-			log.Fatalf("env.Save failed for %s: %s", envFile, err)
+		if len(args) > 2 && args[2] == "script" {
+			if err := env.ExportScript(os.Stdout, *saveenv); err != nil {
+				log.Fatalf("env.ExportScript failed for %s: %s", envFile, err)
+			}
+			return
 		}
-	case "import":
-		env, err := uenv.Open(envFile)
+		if err := env.ExportJSON(os.Stdout); err != nil {
+			log.Fatalf("env.ExportJSON failed for %s: %s", envFile, err)
+		}
+	case "apply":
+		apply(envFile, cfg, args[2])
+	case "verify":
+		report, err := uenv.Verify(envFile, cfg)
+		if err != nil {
+			log.Fatalf("uenv.Verify failed for %s: %s", envFile, err)
+		}
+		if *jsonOutput {
+			printVerifyJSON(report)
+		} else {
+			printVerify(report)
+		}
+		if !report.OK() {
+			os.Exit(exitProblems)
+		}
+	case "check":
+		checkSystem(envFile, cfg, args[2:])
+	case "repair":
+		if len(args) > 2 {
+			repairFromExport(envFile, cfg, args[2])
+			return
+		}
+		repair(envFile, cfg)
+	case "edit":
+		edit(envFile, cfg)
+	case "diff":
+		env, err := uenv.OpenWithConfig(envFile, cfg)
 		if err != nil {
 			log.Fatalf("uenv.Open failed for %s: %s", envFile, err)
 		}
-		fname := os.Args[3]
-		r, err := os.Open(fname)
+		otherFile, otherCfg := config(args[2])
+		other, err := uenv.OpenWithConfig(otherFile, otherCfg)
 		if err != nil {
-			log.Fatalf("Open failed for %s: %s", fname, err)
+			log.Fatalf("uenv.Open failed for %s: %s", otherFile, err)
 		}
-		if err := env.Import(r); err != nil {
-			log.Fatalf("env.Import failed for %s: %s", envFile, err)
+		changes := uenv.Diff(env, other)
+		if *jsonOutput {
+			if changes == nil {
+				changes = []uenv.Change{}
+			}
+			printJSON(changes)
+			return
 		}
-		if err := env.Save(); err != nil {
-			log.Fatalf("env.Save failed for %s: %s", envFile, err)
+		for _, change := range changes {
+			fmt.Println(change)
+		}
+	case "watch":
+		enc := json.NewEncoder(os.Stdout)
+		err := uenv.Watch(context.Background(), envFile, cfg, *interval, func(changes []uenv.Change) {
+			now := time.Now()
+			if *jsonOutput {
+				enc.Encode(watchJSON{Time: now, Changes: changes})
+				return
+			}
+			fmt.Printf("# %s\n", now.Format(time.RFC3339))
+			for _, change := range changes {
+				fmt.Println(change)
+			}
+		})
+		if err != nil {
+			log.Fatalf("uenv.Watch failed for %s: %s", envFile, err)
 		}
 	default:
 		log.Fatalf("unknown command %s", cmd)
 	}
 
 }
+
+// setVars sets the variables like fw_setenv: pairs of name and value,
+// a name without value removes the variable and "-s <file>" reads the
+// pairs from a script, "-" is stdin. All changes are saved at once.
+func setVars(envFile string, cfg uenv.Config, args []string) {
+	if len(args) == 0 {
+		log.Fatalf("set needs a variable name or -s <script>")
+	}
+	var state *uenv.DesiredState
+	var err error
+	if args[0] == "-s" {
+		if len(args) != 2 {
+			log.Fatalf("set -s needs a script file or - for stdin")
+		}
+		r := os.Stdin
+		if args[1] != "-" {
+			r, err = os.Open(args[1])
+			if err != nil {
+				log.Fatalf("Open failed for %s: %s", args[1], err)
+			}
+			defer r.Close()
+		}
+		state, err = uenv.ReadFwSetenvScript(r)
+	} else {
+		state, err = uenv.FwSetenvArgs(args)
+	}
+	if err != nil {
+		log.Fatalf("cannot set variables: %s", err)
+	}
+	err = uenv.Update(envFile, cfg, func(env *uenv.Env) error {
+		_, err := env.Apply(state)
+		return err
+	})
+	if err != nil {
+		log.Fatalf("cannot set variables in %s: %s", envFile, err)
+	}
+}
+
+// apply changes the env to match the desired state in fname, the env
+// is only written if something changed
+func apply(envFile string, cfg uenv.Config, fname string) {
+	f, err := os.Open(fname)
+	if err != nil {
+		log.Fatalf("Open failed for %s: %s", fname, err)
+	}
+	defer f.Close()
+	state, err := uenv.ReadDesiredState(f)
+	if err != nil {
+		log.Fatalf("cannot read desired state from %s: %s", fname, err)
+	}
+	var changes []uenv.Change
+	err = uenv.Update(envFile, cfg, func(env *uenv.Env) error {
+		changes, err = env.Apply(state)
+		if err == nil && len(changes) == 0 {
+			return uenv.ErrNoChange
+		}
+		return err
+	})
+	if err != nil {
+		log.Fatalf("cannot apply %s to %s: %s", fname, envFile, err)
+	}
+	if *jsonOutput {
+		if changes == nil {
+			changes = []uenv.Change{}
+		}
+		printJSON(changes)
+		return
+	}
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+}
+
+// checkSystem checks the env against the running system, the device
+// tree is looked up on the boot partition if its mount point is given
+func checkSystem(envFile string, cfg uenv.Config, args []string) {
+	env, err := uenv.OpenWithConfig(envFile, cfg)
+	if err != nil {
+		log.Fatalf("uenv.Open failed for %s: %s", envFile, err)
+	}
+	sys := &envcheck.System{}
+	if len(args) > 0 {
+		sys.BootDir = args[0]
+	}
+	findings := sys.Check(env)
+	if *jsonOutput {
+		if findings == nil {
+			findings = []envcheck.Finding{}
+		}
+		printJSON(findings)
+	} else {
+		for _, finding := range findings {
+			fmt.Println(finding)
+		}
+	}
+	if len(findings) > 0 {
+		os.Exit(exitProblems)
+	}
+}
+
+// repair rewrites the broken copies of the env from the valid copy
+func repair(envFile string, cfg uenv.Config) {
+	report, err := uenv.Verify(envFile, cfg)
+	if err != nil {
+		log.Fatalf("uenv.Verify failed for %s: %s", envFile, err)
+	}
+	if report.OK() {
+		fmt.Println("nothing to repair")
+		return
+	}
+	env, err := uenv.OpenWithConfig(envFile, cfg)
+	if err != nil {
+		log.Fatalf("cannot repair %s without a valid copy: %s", envFile, err)
+	}
+	// rewrite every copy, this also gets rid of duplicated
+	// variables
+	if err := env.SaveAll(); err != nil {
+		log.Fatalf("env.SaveAll failed for %s: %s", envFile, err)
+	}
+	fmt.Printf("repaired %s from the %s copy\n", envFile, env.Source())
+}
+
+// repairFromExport rebuilds the env from a text export
+func repairFromExport(envFile string, cfg uenv.Config, exportFile string) {
+	if cfg.Size == 0 && !cfg.RedundantImage {
+		st, err := os.Stat(envFile)
+		if err != nil {
+			log.Fatalf("Stat failed for %s: %s", envFile, err)
+		}
+		cfg.Size = int(st.Size())
+	}
+	env, err := uenv.CreateWithConfig(envFile, cfg)
+	if err != nil {
+		log.Fatalf("uenv.CreateWithConfig failed for %s: %s", envFile, err)
+	}
+	r, err := os.Open(exportFile)
+	if err != nil {
+		log.Fatalf("Open failed for %s: %s", exportFile, err)
+	}
+	defer r.Close()
+	if err := env.Import(r); err != nil {
+		log.Fatalf("env.Import failed for %s: %s", exportFile, err)
+	}
+	if err := env.Save(); err != nil {
+		log.Fatalf("env.Save failed for %s: %s", envFile, err)
+	}
+	fmt.Printf("rebuilt %s from %s\n", envFile, exportFile)
+}
+
+// confirm asks the user the given yes/no question
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// edit lets the user edit the env with $EDITOR, the result is shown as
+// a diff and only written after confirmation
+func edit(envFile string, cfg uenv.Config) {
+	env, err := uenv.OpenWithConfig(envFile, cfg)
+	if err != nil {
+		log.Fatalf("uenv.Open failed for %s: %s", envFile, err)
+	}
+	text, err := env.MarshalText()
+	if err != nil {
+		log.Fatalf("env.MarshalText failed for %s: %s", envFile, err)
+	}
+	orig := uenv.New(uenv.Config{})
+	if err := orig.UnmarshalText(text); err != nil {
+		log.Fatalf("cannot copy env: %s", err)
+	}
+
+	f, err := ioutil.TempFile("", "uboot-env-")
+	if err != nil {
+		log.Fatalf("TempFile failed: %s", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(text)
+	f.Close()
+	if err != nil {
+		log.Fatalf("Write failed for %s: %s", f.Name(), err)
+	}
+
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	for {
+		cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", f.Name())
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			log.Fatalf("editor failed: %s", err)
+		}
+		edited, err := ioutil.ReadFile(f.Name())
+		if err != nil {
+			log.Fatalf("ReadFile failed for %s: %s", f.Name(), err)
+		}
+		if bytes.Equal(edited, text) {
+			fmt.Println("no changes")
+			return
+		}
+		err = env.UnmarshalText(edited)
+		if err == nil {
+			// catches values that do not fit into the env
+			_, err = env.MarshalBinary()
+		}
+		if err == nil {
+			err = checkSchema(orig, env)
+		}
+		if err == nil {
+			break
+		}
+		fmt.Printf("invalid env: %s\n", err)
+		if !confirm("edit again?") {
+			os.Exit(1)
+		}
+	}
+
+	changes := uenv.Diff(orig, env)
+	if len(changes) == 0 {
+		fmt.Println("no changes")
+		return
+	}
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	if !confirm(fmt.Sprintf("write changes to %s?", envFile)) {
+		fmt.Println("nothing written")
+		return
+	}
+	// the env is not locked while the editor runs, so it is read
+	// again and only written if nobody changed it in between
+	edited, err := env.MarshalText()
+	if err != nil {
+		log.Fatalf("env.MarshalText failed for %s: %s", envFile, err)
+	}
+	err = uenv.Update(envFile, cfg, func(current *uenv.Env) error {
+		if changes := uenv.Diff(orig, current); len(changes) > 0 {
+			return fmt.Errorf("env changed while editing: %s", changes[0])
+		}
+		return current.UnmarshalText(edited)
+	})
+	if err != nil {
+		log.Fatalf("cannot write changes to %s: %s", envFile, err)
+	}
+}
+
+// checkSchema checks the changed values of the well known variables
+// against their schema
+func checkSchema(orig, env *uenv.Env) error {
+	for _, change := range uenv.Diff(orig, env) {
+		v, ok := uenv.LookupVar(change.Key)
+		if !ok || change.New == "" {
+			continue
+		}
+		if err := v.Check(change.New); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printVerify(report *uenv.Report) {
+	for _, c := range report.Copies {
+		if c.Err != nil {
+			fmt.Printf("%s: invalid\n", c.Source)
+			continue
+		}
+		fmt.Printf("%s: ok, %d bytes, %d variables, flags %d\n", c.Source, c.Size, c.Vars, c.Flags)
+	}
+	for _, problem := range report.Problems {
+		fmt.Printf("problem: %s\n", problem)
+	}
+	for _, warning := range report.Warnings {
+		fmt.Printf("warning: %s\n", warning)
+	}
+}
+
+func printVerifyJSON(report *uenv.Report) {
+	out := reportJSON{
+		OK:       report.OK(),
+		Copies:   []copyJSON{},
+		Problems: report.Problems,
+		Warnings: report.Warnings,
+	}
+	if out.Problems == nil {
+		out.Problems = []string{}
+	}
+	for _, c := range report.Copies {
+		cj := copyJSON{
+			Source:     c.Source.String(),
+			Size:       c.Size,
+			Flags:      c.Flags,
+			Vars:       c.Vars,
+			Duplicates: c.Duplicates,
+		}
+		if c.Err != nil {
+			cj.Error = c.Err.Error()
+		}
+		out.Copies = append(out.Copies, cj)
+	}
+	printJSON(out)
+}
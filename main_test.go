@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type mainTestSuite struct {
+	envFile string
+}
+
+var _ = Suite(&mainTestSuite{})
+
+func (s *mainTestSuite) SetUpTest(c *C) {
+	s.envFile = filepath.Join(c.MkDir(), "uboot.env")
+}
+
+func (s *mainTestSuite) TearDownTest(c *C) {
+	// the flags are global, reset the ones the tests use
+	*aesKey = ""
+}
+
+// runMain runs main with the given args and returns what it printed
+func runMain(c *C, args ...string) string {
+	out, err := ioutil.TempFile(c.MkDir(), "stdout")
+	c.Assert(err, IsNil)
+	defer out.Close()
+
+	oldArgs, oldStdout := os.Args, os.Stdout
+	defer func() {
+		os.Args, os.Stdout = oldArgs, oldStdout
+	}()
+	os.Args = append([]string{"uboot-go"}, args...)
+	os.Stdout = out
+	flag.CommandLine.Parse(os.Args[1:])
+	main()
+
+	content, err := ioutil.ReadFile(out.Name())
+	c.Assert(err, IsNil)
+	return string(content)
+}
+
+func (s *mainTestSuite) TestCreatePrintAES(c *C) {
+	key := "000102030405060708090a0b0c0d0e0f"
+	runMain(c, "-aes-key", key, s.envFile, "create", "4096")
+	runMain(c, "-aes-key", key, s.envFile, "set", "foo", "bar")
+	c.Check(runMain(c, "-aes-key", key, s.envFile, "print"), Equals, "foo=bar\n")
+
+	// the env on disk is encrypted
+	content, err := ioutil.ReadFile(s.envFile)
+	c.Assert(err, IsNil)
+	c.Check(content, HasLen, 4096)
+	c.Check(string(content), Not(Matches), "(?s).*foo=bar.*")
+}
+
+func (s *mainTestSuite) TestImportApply(c *C) {
+	dir := filepath.Dir(s.envFile)
+	importFile := filepath.Join(dir, "import.txt")
+	c.Assert(ioutil.WriteFile(importFile, []byte("bootdelay=2\nbootcount=1\n"), 0644), IsNil)
+	desiredFile := filepath.Join(dir, "desired.yaml")
+	c.Assert(ioutil.WriteFile(desiredFile, []byte("set:\n  bootdelay: 0\nunset:\n  - bootcount\n"), 0644), IsNil)
+
+	runMain(c, s.envFile, "create", "4096")
+	runMain(c, s.envFile, "import", importFile)
+	c.Check(runMain(c, s.envFile, "apply", desiredFile), Equals, "-bootcount=1\n-bootdelay=2\n+bootdelay=0\n")
+	st, err := os.Stat(s.envFile)
+	c.Assert(err, IsNil)
+
+	// nothing changed, the env is not written
+	c.Check(runMain(c, s.envFile, "apply", desiredFile), Equals, "")
+	st2, err := os.Stat(s.envFile)
+	c.Assert(err, IsNil)
+	c.Check(st2.ModTime(), Equals, st.ModTime())
+	c.Check(runMain(c, s.envFile, "print"), Equals, "bootdelay=0\n")
+}
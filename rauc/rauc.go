@@ -0,0 +1,194 @@
+// Package rauc manages the uboot env variables used by the RAUC
+// bootchooser. BOOT_ORDER contains the space separated list of slots
+// in the order they are tried and BOOT_<slot>_LEFT the number of boot
+// attempts left for each slot. The boot script boots the first slot
+// from BOOT_ORDER that has attempts left and decrements its counter.
+// Like the reference boot script unset variables default to "A B" and
+// 3 attempts.
+package rauc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// OrderVar is the name of the env variable with the boot order
+const OrderVar = "BOOT_ORDER"
+
+// DefaultAttempts is the number of boot attempts a slot gets when it
+// is marked good or made primary, this matches RAUC.
+const DefaultAttempts = 3
+
+// DefaultBootOrder is the boot order the RAUC boot script uses if
+// BOOT_ORDER is unset
+var DefaultBootOrder = []string{"A", "B"}
+
+// LeftVar returns the name of the env variable with the boot attempts
+// left for the given slot
+func LeftVar(slot string) string {
+	return fmt.Sprintf("BOOT_%s_LEFT", slot)
+}
+
+// Slots manages the RAUC variables of an env. Marking a slot, making
+// it primary and counting a boot attempt save the env, reading the
+// state does not.
+type Slots struct {
+	env *uenv.Env
+	// Attempts is the number of boot attempts used when a slot is
+	// reset or its variable is unset, it defaults to DefaultAttempts
+	Attempts int
+	// DefaultOrder is the boot order used when BOOT_ORDER is unset,
+	// it defaults to DefaultBootOrder
+	DefaultOrder []string
+}
+
+// New returns Slots that operate on the given env
+func New(env *uenv.Env) *Slots {
+	return &Slots{env: env, Attempts: DefaultAttempts, DefaultOrder: DefaultBootOrder}
+}
+
+func validateSlot(slot string) error {
+	if slot == "" || strings.ContainsAny(slot, " \t=") {
+		return fmt.Errorf("invalid slot name %q", slot)
+	}
+	return nil
+}
+
+// Order returns the slots in boot order, DefaultOrder if BOOT_ORDER
+// is unset
+func (s *Slots) Order() []string {
+	if s.env.Get(OrderVar) == "" {
+		return append([]string(nil), s.DefaultOrder...)
+	}
+	return strings.Fields(s.env.Get(OrderVar))
+}
+
+// AttemptsLeft returns the boot attempts left for the given slot, a
+// missing variable means s.Attempts like in the boot script.
+func (s *Slots) AttemptsLeft(slot string) (int, error) {
+	if err := validateSlot(slot); err != nil {
+		return 0, err
+	}
+	v := s.env.Get(LeftVar(slot))
+	if v == "" {
+		return s.Attempts, nil
+	}
+	left, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse %s: %s", LeftVar(slot), err)
+	}
+	return left, nil
+}
+
+// Primary returns the slot that the bootloader will boot next, that
+// is the first slot in the boot order with attempts left.
+func (s *Slots) Primary() (string, error) {
+	slot, err := s.primary()
+	if err == nil && slot == "" {
+		err = s.noBootableSlot()
+	}
+	return slot, err
+}
+
+// primary returns the slot that the bootloader will boot next or ""
+// if no slot has attempts left
+func (s *Slots) primary() (string, error) {
+	for _, slot := range s.Order() {
+		left, err := s.AttemptsLeft(slot)
+		if err != nil {
+			return "", err
+		}
+		if left > 0 {
+			return slot, nil
+		}
+	}
+	return "", nil
+}
+
+func (s *Slots) noBootableSlot() error {
+	return fmt.Errorf("no bootable slot in %s=%q", OrderVar, strings.Join(s.Order(), " "))
+}
+
+// IsGood returns true if the given slot has boot attempts left
+func (s *Slots) IsGood(slot string) (bool, error) {
+	left, err := s.AttemptsLeft(slot)
+	return left > 0, err
+}
+
+// MarkGood resets the boot attempts of the given slot
+func (s *Slots) MarkGood(slot string) error {
+	if err := validateSlot(slot); err != nil {
+		return err
+	}
+	if err := s.env.Set(LeftVar(slot), strconv.Itoa(s.Attempts)); err != nil {
+		return err
+	}
+	return s.env.Save()
+}
+
+// MarkBad sets the boot attempts of the given slot to zero so that
+// the bootloader will skip it
+func (s *Slots) MarkBad(slot string) error {
+	if err := validateSlot(slot); err != nil {
+		return err
+	}
+	if err := s.env.Set(LeftVar(slot), "0"); err != nil {
+		return err
+	}
+	return s.env.Save()
+}
+
+// SetPrimary moves the given slot to the front of the boot order and
+// resets its boot attempts
+func (s *Slots) SetPrimary(slot string) error {
+	if err := validateSlot(slot); err != nil {
+		return err
+	}
+	order := []string{slot}
+	for _, other := range s.Order() {
+		if other != slot {
+			order = append(order, other)
+		}
+	}
+	if err := s.env.Set(OrderVar, strings.Join(order, " ")); err != nil {
+		return err
+	}
+	if err := s.env.Set(LeftVar(slot), strconv.Itoa(s.Attempts)); err != nil {
+		return err
+	}
+	return s.env.Save()
+}
+
+// BootAttempt does what the RAUC boot script does: it picks the
+// primary slot and decrements its boot attempts. If no slot has
+// attempts left all slots are reset, like the reference boot script
+// does, and an error is returned. Variables that can not be parsed
+// are returned as error without changing the env.
+func (s *Slots) BootAttempt() (string, error) {
+	slot, err := s.primary()
+	if err != nil {
+		return "", err
+	}
+	if slot == "" {
+		for _, other := range s.Order() {
+			if err := s.env.Set(LeftVar(other), strconv.Itoa(s.Attempts)); err != nil {
+				return "", err
+			}
+		}
+		if err := s.env.Save(); err != nil {
+			return "", err
+		}
+		return "", s.noBootableSlot()
+	}
+	left, err := s.AttemptsLeft(slot)
+	if err != nil {
+		return "", err
+	}
+	if err := s.env.Set(LeftVar(slot), strconv.Itoa(left-1)); err != nil {
+		return "", err
+	}
+	return slot, s.env.Save()
+}
@@ -0,0 +1,157 @@
+package rauc
+
+import (
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type raucTestSuite struct {
+	envFile string
+	slots   *Slots
+}
+
+var _ = Suite(&raucTestSuite{})
+
+func (s *raucTestSuite) SetUpTest(c *C) {
+	s.envFile = filepath.Join(c.MkDir(), "uboot.env")
+	env, err := uenv.Create(s.envFile, 4096)
+	c.Assert(err, IsNil)
+	env.Set(OrderVar, "A B")
+	env.Set(LeftVar("A"), "3")
+	env.Set(LeftVar("B"), "3")
+	c.Assert(env.Save(), IsNil)
+	s.slots = New(env)
+}
+
+func (s *raucTestSuite) reopen(c *C) *Slots {
+	env, err := uenv.Open(s.envFile)
+	c.Assert(err, IsNil)
+	return New(env)
+}
+
+func (s *raucTestSuite) TestPrimary(c *C) {
+	slot, err := s.slots.Primary()
+	c.Assert(err, IsNil)
+	c.Check(slot, Equals, "A")
+
+	c.Assert(s.slots.MarkBad("A"), IsNil)
+	slot, err = s.reopen(c).Primary()
+	c.Assert(err, IsNil)
+	c.Check(slot, Equals, "B")
+}
+
+func (s *raucTestSuite) TestSetPrimary(c *C) {
+	c.Assert(s.slots.MarkBad("B"), IsNil)
+	c.Assert(s.slots.SetPrimary("B"), IsNil)
+
+	slots := s.reopen(c)
+	c.Check(slots.Order(), DeepEquals, []string{"B", "A"})
+	left, err := slots.AttemptsLeft("B")
+	c.Assert(err, IsNil)
+	c.Check(left, Equals, 3)
+	slot, err := slots.Primary()
+	c.Assert(err, IsNil)
+	c.Check(slot, Equals, "B")
+}
+
+func (s *raucTestSuite) TestSetPrimaryNewSlot(c *C) {
+	c.Assert(s.slots.SetPrimary("C"), IsNil)
+	c.Check(s.slots.Order(), DeepEquals, []string{"C", "A", "B"})
+}
+
+func (s *raucTestSuite) TestBootAttemptDecrementsAndFallsBack(c *C) {
+	s.slots.Attempts = 2
+	c.Assert(s.slots.SetPrimary("B"), IsNil)
+
+	for _, expected := range []string{"B", "B", "A", "A", "A"} {
+		slot, err := s.slots.BootAttempt()
+		c.Assert(err, IsNil)
+		c.Check(slot, Equals, expected)
+	}
+	left, err := s.reopen(c).AttemptsLeft("A")
+	c.Assert(err, IsNil)
+	c.Check(left, Equals, 0)
+
+	// nothing is left, all slots get reset
+	_, err = s.slots.BootAttempt()
+	c.Assert(err, ErrorMatches, `no bootable slot in BOOT_ORDER="B A"`)
+	good, err := s.reopen(c).IsGood("A")
+	c.Assert(err, IsNil)
+	c.Check(good, Equals, true)
+}
+
+func (s *raucTestSuite) TestBootAttemptBadVariable(c *C) {
+	c.Assert(s.slots.MarkBad("B"), IsNil)
+	c.Assert(s.slots.env.Set(LeftVar("A"), "junk"), IsNil)
+	c.Assert(s.slots.env.Save(), IsNil)
+
+	// the error is returned and no slot is re-armed
+	_, err := s.slots.BootAttempt()
+	c.Assert(err, ErrorMatches, `cannot parse BOOT_A_LEFT: .*`)
+	env := s.reopen(c).env
+	c.Check(env.Get(LeftVar("A")), Equals, "junk")
+	c.Check(env.Get(LeftVar("B")), Equals, "0")
+}
+
+func (s *raucTestSuite) TestMarkGoodResets(c *C) {
+	_, err := s.slots.BootAttempt()
+	c.Assert(err, IsNil)
+	left, err := s.slots.AttemptsLeft("A")
+	c.Assert(err, IsNil)
+	c.Check(left, Equals, 2)
+
+	c.Assert(s.slots.MarkGood("A"), IsNil)
+	left, err = s.reopen(c).AttemptsLeft("A")
+	c.Assert(err, IsNil)
+	c.Check(left, Equals, 3)
+}
+
+func (s *raucTestSuite) TestUnsetDefaults(c *C) {
+	// a slot without a variable has the default attempts, like in
+	// the boot script
+	good, err := s.slots.IsGood("C")
+	c.Assert(err, IsNil)
+	c.Check(good, Equals, true)
+
+	env, err := uenv.Create(s.envFile, 4096)
+	c.Assert(err, IsNil)
+	slots := New(env)
+	c.Check(slots.Order(), DeepEquals, []string{"A", "B"})
+	left, err := slots.AttemptsLeft("B")
+	c.Assert(err, IsNil)
+	c.Check(left, Equals, 3)
+	slot, err := slots.Primary()
+	c.Assert(err, IsNil)
+	c.Check(slot, Equals, "A")
+
+	slot, err = slots.BootAttempt()
+	c.Assert(err, IsNil)
+	c.Check(slot, Equals, "A")
+	left, err = s.reopen(c).AttemptsLeft("A")
+	c.Assert(err, IsNil)
+	c.Check(left, Equals, 2)
+
+	slots.DefaultOrder = []string{"system0", "system1"}
+	slots.Attempts = 1
+	c.Check(slots.Order(), DeepEquals, []string{"system0", "system1"})
+	left, err = slots.AttemptsLeft("system1")
+	c.Assert(err, IsNil)
+	c.Check(left, Equals, 1)
+}
+
+func (s *raucTestSuite) TestInvalid(c *C) {
+	c.Check(s.slots.MarkGood("A B"), ErrorMatches, `invalid slot name "A B"`)
+	c.Check(s.slots.SetPrimary(""), ErrorMatches, `invalid slot name ""`)
+
+	c.Assert(s.slots.MarkGood("A"), IsNil)
+	s.slots.env.Set(LeftVar("A"), "x")
+	_, err := s.slots.Primary()
+	c.Check(err, ErrorMatches, `cannot parse BOOT_A_LEFT: .*`)
+}
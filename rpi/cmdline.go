@@ -0,0 +1,25 @@
+package rpi
+
+import (
+	"io/ioutil"
+
+	"github.com/mvo5/uboot-go/bootargs"
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// ReadCmdline reads the kernel command line from the cmdline.txt at
+// the given path
+func ReadCmdline(path string) (*bootargs.Cmdline, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return bootargs.Parse(string(content))
+}
+
+// WriteCmdline atomically replaces the cmdline.txt at the given path,
+// the firmware expects the command line on a single line
+func WriteCmdline(path string, cmdline *bootargs.Cmdline) error {
+	fs := &uenv.FileStorage{Path: path}
+	return fs.WriteAtomic([]byte(cmdline.String() + "\n"))
+}
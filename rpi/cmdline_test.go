@@ -0,0 +1,31 @@
+package rpi
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+type cmdlineTestSuite struct{}
+
+var _ = Suite(&cmdlineTestSuite{})
+
+func (s *cmdlineTestSuite) TestReadWriteCmdline(c *C) {
+	path := filepath.Join(c.MkDir(), "cmdline.txt")
+	c.Assert(ioutil.WriteFile(path, []byte("console=serial0,115200 console=tty1 root=PARTUUID=1234-02 rootwait\n"), 0644), IsNil)
+
+	cmdline, err := ReadCmdline(path)
+	c.Assert(err, IsNil)
+	c.Check(cmdline.Values("console"), DeepEquals, []string{"serial0,115200", "tty1"})
+	cmdline.Set("root", "/dev/mmcblk0p3")
+	cmdline.SetFlag("quiet")
+	c.Assert(WriteCmdline(path, cmdline), IsNil)
+
+	content, err := ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "console=serial0,115200 console=tty1 root=/dev/mmcblk0p3 rootwait quiet\n")
+
+	_, err = ReadCmdline(filepath.Join(c.MkDir(), "missing"))
+	c.Check(err, NotNil)
+}
@@ -0,0 +1,209 @@
+// Package rpi edits the config.txt and cmdline.txt files that the
+// Raspberry Pi firmware reads from the boot partition before it starts
+// uboot (kernel=u-boot.bin) or the kernel.
+package rpi
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Config is a config.txt file. The file is made of sections that
+// start with a conditional filter like [pi4] or [all], settings before
+// the first filter are in the section "". Comments, empty lines and
+// lines the editor does not know, like "include extra.txt", are kept
+// as they are.
+type Config struct {
+	lines []configLine
+}
+
+type configLine struct {
+	// raw is the line as read or written
+	raw string
+	// section is the filter of the section of the line
+	section string
+	// header is true if the line is the filter of a section
+	header bool
+	// setting is true for key=value lines
+	setting    bool
+	key, value string
+}
+
+// ParseConfig parses the content of a config.txt
+func ParseConfig(content []byte) *Config {
+	cfg := &Config{}
+	section := ""
+	text := strings.TrimSuffix(strings.Replace(string(content), "\r\n", "\n", -1), "\n")
+	if text == "" {
+		return cfg
+	}
+	for _, raw := range strings.Split(text, "\n") {
+		l := configLine{raw: raw, section: section}
+		line := strings.TrimSpace(raw)
+		switch {
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			section = line[1 : len(line)-1]
+			l.section = section
+			l.header = true
+		case line == "" || strings.HasPrefix(line, "#"):
+		default:
+			if i := strings.IndexByte(line, '='); i > 0 {
+				l.setting = true
+				l.key = strings.TrimSpace(line[:i])
+				l.value = strings.TrimSpace(line[i+1:])
+			}
+		}
+		cfg.lines = append(cfg.lines, l)
+	}
+	return cfg
+}
+
+// ReadConfig reads the config.txt at the given path
+func ReadConfig(path string) (*Config, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(content), nil
+}
+
+// Bytes returns the content of the config.txt
+func (cfg *Config) Bytes() []byte {
+	buf := bytes.NewBuffer(nil)
+	for _, l := range cfg.lines {
+		buf.WriteString(l.raw)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// Write atomically replaces the config.txt at the given path
+func (cfg *Config) Write(path string) error {
+	fs := &uenv.FileStorage{Path: path}
+	return fs.WriteAtomic(cfg.Bytes())
+}
+
+// Sections returns the filters of the sections in the order of the
+// file, every filter is returned once
+func (cfg *Config) Sections() []string {
+	var sections []string
+	seen := make(map[string]bool)
+	for _, l := range cfg.lines {
+		if (l.header || l.setting) && !seen[l.section] {
+			seen[l.section] = true
+			sections = append(sections, l.section)
+		}
+	}
+	return sections
+}
+
+// Get returns the value of the given key in the given section, if the
+// key is set more than once the last value wins like in the firmware
+func (cfg *Config) Get(section, key string) (value string, ok bool) {
+	values := cfg.Values(section, key)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+// Values returns all values of the given key in the given section, e.g.
+// of all dtoverlay lines
+func (cfg *Config) Values(section, key string) []string {
+	var values []string
+	for _, l := range cfg.lines {
+		if l.setting && l.section == section && l.key == key {
+			values = append(values, l.value)
+		}
+	}
+	return values
+}
+
+// Lookup returns the value of the given key that the firmware uses,
+// match reports if the filter of a section applies to the board.
+// Settings before the first filter and below [all] always apply.
+func (cfg *Config) Lookup(key string, match func(filter string) bool) (value string, ok bool) {
+	active := map[string]bool{"": true, "all": true}
+	for _, l := range cfg.lines {
+		if !l.setting || l.key != key {
+			continue
+		}
+		applies, known := active[l.section]
+		if !known {
+			applies = match(l.section)
+			active[l.section] = applies
+		}
+		if applies {
+			value, ok = l.value, true
+		}
+	}
+	return value, ok
+}
+
+// Set sets the given key in the given section. The first setting of
+// the key in the section is replaced and others are removed, new keys
+// are added at the end of the section. A missing section is added at
+// the end of the file.
+func (cfg *Config) Set(section, key, value string) {
+	newLine := configLine{raw: key + "=" + value, section: section, setting: true, key: key, value: value}
+	for i, l := range cfg.lines {
+		if l.setting && l.section == section && l.key == key {
+			cfg.lines[i] = newLine
+			cfg.removeAfter(i+1, section, key)
+			return
+		}
+	}
+	cfg.insert(newLine)
+}
+
+// Add adds the given key at the end of the given section even if it is
+// already set, e.g. for another dtoverlay
+func (cfg *Config) Add(section, key, value string) {
+	cfg.insert(configLine{raw: key + "=" + value, section: section, setting: true, key: key, value: value})
+}
+
+// Remove removes all settings of the given key in the given section
+func (cfg *Config) Remove(section, key string) {
+	cfg.removeAfter(0, section, key)
+}
+
+func (cfg *Config) removeAfter(start int, section, key string) {
+	lines := cfg.lines[:start]
+	for _, l := range cfg.lines[start:] {
+		if !(l.setting && l.section == section && l.key == key) {
+			lines = append(lines, l)
+		}
+	}
+	cfg.lines = lines
+}
+
+// insert inserts a setting after the last line of its section that is
+// not a comment or empty
+func (cfg *Config) insert(l configLine) {
+	pos := -1
+	for i, other := range cfg.lines {
+		line := strings.TrimSpace(other.raw)
+		if other.section == l.section && line != "" && !strings.HasPrefix(line, "#") {
+			pos = i + 1
+		}
+	}
+	switch {
+	case pos >= 0:
+	case l.section == "":
+		// after the comments at the top of the file
+		pos = 0
+		for pos < len(cfg.lines) && strings.HasPrefix(strings.TrimSpace(cfg.lines[pos].raw), "#") {
+			pos++
+		}
+	default:
+		if n := len(cfg.lines); n > 0 && strings.TrimSpace(cfg.lines[n-1].raw) != "" {
+			cfg.lines = append(cfg.lines, configLine{section: cfg.lines[n-1].section})
+		}
+		cfg.lines = append(cfg.lines, configLine{raw: "[" + l.section + "]", section: l.section, header: true})
+		pos = len(cfg.lines)
+	}
+	cfg.lines = append(cfg.lines[:pos], append([]configLine{l}, cfg.lines[pos:]...)...)
+}
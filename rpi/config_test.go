@@ -0,0 +1,131 @@
+package rpi
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type configTestSuite struct{}
+
+var _ = Suite(&configTestSuite{})
+
+const configTxt = `# For more options see https://rpf.io/configtxt
+arm_64bit=1
+kernel=u-boot.bin
+include extra.txt
+
+[pi4]
+dtoverlay=vc4-kms-v3d
+dtoverlay=disable-bt
+arm_boost=1
+
+[cm4]
+otg_mode=1
+
+[all]
+enable_uart=1
+`
+
+func (s *configTestSuite) TestParseConfig(c *C) {
+	cfg := ParseConfig([]byte(configTxt))
+	c.Check(string(cfg.Bytes()), Equals, configTxt)
+	c.Check(cfg.Sections(), DeepEquals, []string{"", "pi4", "cm4", "all"})
+
+	value, ok := cfg.Get("", "kernel")
+	c.Check(ok, Equals, true)
+	c.Check(value, Equals, "u-boot.bin")
+	c.Check(cfg.Values("pi4", "dtoverlay"), DeepEquals, []string{"vc4-kms-v3d", "disable-bt"})
+	_, ok = cfg.Get("pi4", "kernel")
+	c.Check(ok, Equals, false)
+	_, ok = cfg.Get("", "include extra.txt")
+	c.Check(ok, Equals, false)
+
+	c.Check(ParseConfig([]byte("a=1\r\n")).Bytes(), DeepEquals, []byte("a=1\n"))
+	c.Check(ParseConfig(nil).Bytes(), HasLen, 0)
+}
+
+func (s *configTestSuite) TestLookup(c *C) {
+	cfg := ParseConfig([]byte(configTxt + "[pi4]\narm_64bit=0\n[pi3]\nkernel=other.bin\n"))
+	pi4 := func(filter string) bool { return filter == "pi4" }
+
+	value, ok := cfg.Lookup("arm_64bit", pi4)
+	c.Check(ok, Equals, true)
+	c.Check(value, Equals, "0")
+	value, _ = cfg.Lookup("kernel", pi4)
+	c.Check(value, Equals, "u-boot.bin")
+	value, _ = cfg.Lookup("enable_uart", pi4)
+	c.Check(value, Equals, "1")
+	_, ok = cfg.Lookup("otg_mode", pi4)
+	c.Check(ok, Equals, false)
+
+	value, _ = cfg.Lookup("kernel", func(filter string) bool { return filter == "pi3" })
+	c.Check(value, Equals, "other.bin")
+}
+
+func (s *configTestSuite) TestEdit(c *C) {
+	cfg := ParseConfig([]byte(configTxt))
+	cfg.Set("", "kernel", "u-boot-new.bin")
+	cfg.Set("", "disable_splash", "1")
+	cfg.Set("pi4", "arm_boost", "0")
+	cfg.Add("pi4", "dtoverlay", "i2c-rtc,ds3231")
+	cfg.Remove("cm4", "otg_mode")
+	cfg.Set("tryboot", "kernel", "u-boot-try.bin")
+	c.Check(string(cfg.Bytes()), Equals, `# For more options see https://rpf.io/configtxt
+arm_64bit=1
+kernel=u-boot-new.bin
+include extra.txt
+disable_splash=1
+
+[pi4]
+dtoverlay=vc4-kms-v3d
+dtoverlay=disable-bt
+arm_boost=0
+dtoverlay=i2c-rtc,ds3231
+
+[cm4]
+
+[all]
+enable_uart=1
+
+[tryboot]
+kernel=u-boot-try.bin
+`)
+
+	// setting a repeated key leaves a single setting
+	cfg.Set("pi4", "dtoverlay", "vc4-fkms-v3d")
+	c.Check(cfg.Values("pi4", "dtoverlay"), DeepEquals, []string{"vc4-fkms-v3d"})
+}
+
+func (s *configTestSuite) TestEditEmpty(c *C) {
+	cfg := ParseConfig([]byte("# header\n[pi4]\narm_boost=1\n"))
+	cfg.Set("", "kernel", "u-boot.bin")
+	c.Check(string(cfg.Bytes()), Equals, "# header\nkernel=u-boot.bin\n[pi4]\narm_boost=1\n")
+
+	cfg = ParseConfig(nil)
+	cfg.Set("pi4", "arm_boost", "1")
+	c.Check(string(cfg.Bytes()), Equals, "[pi4]\narm_boost=1\n")
+}
+
+func (s *configTestSuite) TestReadWriteConfig(c *C) {
+	path := filepath.Join(c.MkDir(), "config.txt")
+	c.Assert(ioutil.WriteFile(path, []byte(configTxt), 0644), IsNil)
+
+	cfg, err := ReadConfig(path)
+	c.Assert(err, IsNil)
+	cfg.Set("all", "enable_uart", "0")
+	c.Assert(cfg.Write(path), IsNil)
+
+	cfg, err = ReadConfig(path)
+	c.Assert(err, IsNil)
+	value, _ := cfg.Get("all", "enable_uart")
+	c.Check(value, Equals, "0")
+
+	_, err = ReadConfig(filepath.Join(c.MkDir(), "missing"))
+	c.Check(err, NotNil)
+}
@@ -0,0 +1,128 @@
+// Package snapboot implements the snapd boot protocol on top of a
+// uboot env. The bootloader boots snap_kernel and snap_core unless
+// snap_mode is "try", in which case it sets snap_mode to "trying" and
+// boots snap_try_kernel and snap_try_core instead. If the system does
+// not confirm the boot the next boot finds snap_mode still set to
+// "trying", resets it and boots the previous kernel and core again.
+package snapboot
+
+import (
+	"fmt"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// The names of the env variables used by the protocol
+const (
+	ModeVar      = "snap_mode"
+	KernelVar    = "snap_kernel"
+	CoreVar      = "snap_core"
+	TryKernelVar = "snap_try_kernel"
+	TryCoreVar   = "snap_try_core"
+)
+
+// The values of snap_mode
+const (
+	// ModeDefault boots the current kernel and core
+	ModeDefault = ""
+	// ModeTry makes the bootloader try the new kernel and core on
+	// the next boot
+	ModeTry = "try"
+	// ModeTrying is set by the bootloader when it boots the new
+	// kernel and core
+	ModeTrying = "trying"
+)
+
+// Boot manages the snapd boot variables of an env. SetTry,
+// MarkBootSuccessful and Revert save the env, like snapd does after
+// every change of the mode.
+type Boot struct {
+	env *uenv.Env
+}
+
+// New returns a Boot that operates on the given env
+func New(env *uenv.Env) *Boot {
+	return &Boot{env: env}
+}
+
+// Mode returns the current snap_mode
+func (b *Boot) Mode() string {
+	return b.env.Get(ModeVar)
+}
+
+// Kernel returns the kernel snap that is known to be good
+func (b *Boot) Kernel() string {
+	return b.env.Get(KernelVar)
+}
+
+// Core returns the core snap that is known to be good
+func (b *Boot) Core() string {
+	return b.env.Get(CoreVar)
+}
+
+// TryKernel returns the kernel snap that is tried, if any
+func (b *Boot) TryKernel() string {
+	return b.env.Get(TryKernelVar)
+}
+
+// TryCore returns the core snap that is tried, if any
+func (b *Boot) TryCore() string {
+	return b.env.Get(TryCoreVar)
+}
+
+// SetTry makes the bootloader try the given kernel and core snaps on
+// the next boot. An empty kernel or core keeps the current one.
+func (b *Boot) SetTry(kernel, core string) error {
+	if b.Mode() == ModeTrying {
+		return fmt.Errorf("cannot set try snaps: boot of %q/%q not confirmed yet", b.TryKernel(), b.TryCore())
+	}
+	if kernel == "" && core == "" {
+		return fmt.Errorf("cannot set try snaps: no kernel or core given")
+	}
+	if err := b.env.SetAll(TryKernelVar, kernel, TryCoreVar, core, ModeVar, ModeTry); err != nil {
+		return err
+	}
+
+	return b.env.Save()
+}
+
+// MarkBootSuccessful confirms that the tried kernel and core booted
+// fine, they become the new known good snaps. It does nothing if no
+// snaps are being tried.
+func (b *Boot) MarkBootSuccessful() error {
+	if b.Mode() != ModeTrying {
+		return nil
+	}
+	if kernel := b.TryKernel(); kernel != "" {
+		if err := b.env.Set(KernelVar, kernel); err != nil {
+			return err
+		}
+	}
+	if core := b.TryCore(); core != "" {
+		if err := b.env.Set(CoreVar, core); err != nil {
+			return err
+		}
+	}
+
+	return b.clearTry()
+}
+
+// Revert abandons the tried kernel and core, the next boot will use
+// the known good snaps again.
+func (b *Boot) Revert() error {
+	return b.clearTry()
+}
+
+// RolledBack returns true if the bootloader gave up on the tried
+// snaps because their boot was never confirmed.
+func (b *Boot) RolledBack() bool {
+	return b.Mode() == ModeDefault && (b.TryKernel() != "" || b.TryCore() != "")
+}
+
+func (b *Boot) clearTry() error {
+	if err := b.env.SetAll(ModeVar, ModeDefault, TryKernelVar, "", TryCoreVar, ""); err != nil {
+		return err
+	}
+
+	return b.env.Save()
+}
@@ -0,0 +1,126 @@
+package snapboot
+
+import (
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type snapbootTestSuite struct {
+	envFile string
+	env     *uenv.Env
+}
+
+var _ = Suite(&snapbootTestSuite{})
+
+func (s *snapbootTestSuite) SetUpTest(c *C) {
+	s.envFile = filepath.Join(c.MkDir(), "uboot.env")
+	env, err := uenv.Create(s.envFile, 4096)
+	c.Assert(err, IsNil)
+	env.Set(KernelVar, "pc-kernel_1.snap")
+	env.Set(CoreVar, "core_1.snap")
+	c.Assert(env.Save(), IsNil)
+	s.env = env
+}
+
+// bootloaderBoot does what the snappy boot script does
+func (s *snapbootTestSuite) bootloaderBoot(c *C) (kernel, core string) {
+	env, err := uenv.Open(s.envFile)
+	c.Assert(err, IsNil)
+	kernel, core = env.Get(KernelVar), env.Get(CoreVar)
+	switch env.Get(ModeVar) {
+	case ModeTry:
+		env.Set(ModeVar, ModeTrying)
+		if v := env.Get(TryKernelVar); v != "" {
+			kernel = v
+		}
+		if v := env.Get(TryCoreVar); v != "" {
+			core = v
+		}
+	case ModeTrying:
+		env.Set(ModeVar, ModeDefault)
+	}
+	c.Assert(env.Save(), IsNil)
+
+	s.env, err = uenv.Open(s.envFile)
+	c.Assert(err, IsNil)
+	return kernel, core
+}
+
+func (s *snapbootTestSuite) TestTryAndConfirm(c *C) {
+	b := New(s.env)
+	c.Assert(b.SetTry("pc-kernel_2.snap", ""), IsNil)
+	c.Check(b.Mode(), Equals, ModeTry)
+
+	kernel, core := s.bootloaderBoot(c)
+	c.Check(kernel, Equals, "pc-kernel_2.snap")
+	c.Check(core, Equals, "core_1.snap")
+
+	b = New(s.env)
+	c.Check(b.Mode(), Equals, ModeTrying)
+	c.Assert(b.MarkBootSuccessful(), IsNil)
+	c.Check(b.Mode(), Equals, ModeDefault)
+	c.Check(b.Kernel(), Equals, "pc-kernel_2.snap")
+	c.Check(b.Core(), Equals, "core_1.snap")
+	c.Check(b.TryKernel(), Equals, "")
+	c.Check(b.RolledBack(), Equals, false)
+
+	kernel, core = s.bootloaderBoot(c)
+	c.Check(kernel, Equals, "pc-kernel_2.snap")
+	c.Check(core, Equals, "core_1.snap")
+}
+
+func (s *snapbootTestSuite) TestTryUnconfirmedRollsBack(c *C) {
+	b := New(s.env)
+	c.Assert(b.SetTry("", "core_2.snap"), IsNil)
+
+	_, core := s.bootloaderBoot(c)
+	c.Check(core, Equals, "core_2.snap")
+	// the system crashed before it confirmed the boot
+	_, core = s.bootloaderBoot(c)
+	c.Check(core, Equals, "core_1.snap")
+
+	b = New(s.env)
+	c.Check(b.RolledBack(), Equals, true)
+	c.Assert(b.Revert(), IsNil)
+	c.Check(b.RolledBack(), Equals, false)
+	c.Check(b.Core(), Equals, "core_1.snap")
+	c.Check(b.TryCore(), Equals, "")
+}
+
+func (s *snapbootTestSuite) TestRevertBeforeBoot(c *C) {
+	b := New(s.env)
+	c.Assert(b.SetTry("pc-kernel_2.snap", "core_2.snap"), IsNil)
+	c.Assert(b.Revert(), IsNil)
+
+	kernel, core := s.bootloaderBoot(c)
+	c.Check(kernel, Equals, "pc-kernel_1.snap")
+	c.Check(core, Equals, "core_1.snap")
+}
+
+func (s *snapbootTestSuite) TestMarkBootSuccessfulNoTry(c *C) {
+	b := New(s.env)
+	c.Assert(b.MarkBootSuccessful(), IsNil)
+	c.Check(b.Kernel(), Equals, "pc-kernel_1.snap")
+}
+
+func (s *snapbootTestSuite) TestSetTryWhileTrying(c *C) {
+	b := New(s.env)
+	c.Assert(b.SetTry("pc-kernel_2.snap", ""), IsNil)
+	s.bootloaderBoot(c)
+
+	b = New(s.env)
+	err := b.SetTry("pc-kernel_3.snap", "")
+	c.Assert(err, ErrorMatches, `cannot set try snaps: boot of "pc-kernel_2.snap"/"" not confirmed yet`)
+}
+
+func (s *snapbootTestSuite) TestSetTryNothing(c *C) {
+	err := New(s.env).SetTry("", "")
+	c.Assert(err, ErrorMatches, "cannot set try snaps: no kernel or core given")
+}
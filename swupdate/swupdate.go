@@ -0,0 +1,146 @@
+// Package swupdate implements the uboot env conventions used by
+// swupdate. The update agent sets recovery_status to "in_progress"
+// while an update is installed and clears it once the update was
+// written, ustate tracks the state of the update across the reboot
+// into the new system and upgrade_available arms the bootcount limit
+// of uboot until the new system is confirmed.
+package swupdate
+
+import (
+	"fmt"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// The names of the env variables used by swupdate
+const (
+	StateVar            = "ustate"
+	RecoveryStatusVar   = "recovery_status"
+	UpgradeAvailableVar = "upgrade_available"
+	BootcountVar        = "bootcount"
+)
+
+// RecoveryInProgress and RecoveryFailed are the values of
+// recovery_status
+const (
+	RecoveryInProgress = "in_progress"
+	RecoveryFailed     = "failed"
+)
+
+// State is the value of ustate, see include/state.h in swupdate
+type State string
+
+// The states known to swupdate
+const (
+	StateOK           State = "0"
+	StateInstalled    State = "1"
+	StateTesting      State = "2"
+	StateFailed       State = "3"
+	StateNotAvailable State = "4"
+	StateError        State = "5"
+	StateWait         State = "6"
+	StateInProgress   State = "7"
+)
+
+var stateNames = map[State]string{
+	StateOK:           "ok",
+	StateInstalled:    "installed",
+	StateTesting:      "testing",
+	StateFailed:       "failed",
+	StateNotAvailable: "not-available",
+	StateError:        "error",
+	StateWait:         "wait",
+	StateInProgress:   "in-progress",
+}
+
+func (s State) String() string {
+	if name, ok := stateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%q)", string(s))
+}
+
+// Tracker manages the swupdate variables of an env. Every transition
+// of the update state is saved right away so that the bootloader sees
+// it after a reset.
+type Tracker struct {
+	env *uenv.Env
+}
+
+// New returns a Tracker that operates on the given env
+func New(env *uenv.Env) *Tracker {
+	return &Tracker{env: env}
+}
+
+// State returns the current ustate, a missing ustate is StateOK
+func (t *Tracker) State() State {
+	s := State(t.env.Get(StateVar))
+	if s == "" {
+		return StateOK
+	}
+	return s
+}
+
+// RecoveryStatus returns the current recovery_status
+func (t *Tracker) RecoveryStatus() string {
+	return t.env.Get(RecoveryStatusVar)
+}
+
+// InProgress returns true if an update was started but never
+// finished, e.g. because of a power cut during the install
+func (t *Tracker) InProgress() bool {
+	return t.RecoveryStatus() == RecoveryInProgress
+}
+
+// BeginUpdate marks the start of an update
+func (t *Tracker) BeginUpdate() error {
+	if t.InProgress() {
+		return fmt.Errorf("cannot begin update: another update is in progress")
+	}
+	if err := t.env.SetAll(RecoveryStatusVar, RecoveryInProgress, StateVar, string(StateInProgress)); err != nil {
+		return err
+	}
+	return t.env.Save()
+}
+
+// FinishUpdate marks the end of an update. On success the new system
+// gets installed and needs to be confirmed after the reboot, on
+// failure the update is marked as failed.
+func (t *Tracker) FinishUpdate(success bool) error {
+	if !t.InProgress() {
+		return fmt.Errorf("cannot finish update: no update in progress")
+	}
+	if !success {
+		if err := t.env.SetAll(RecoveryStatusVar, RecoveryFailed, StateVar, string(StateFailed)); err != nil {
+			return err
+		}
+		return t.env.Save()
+	}
+	if err := t.env.SetAll(RecoveryStatusVar, "", StateVar, string(StateInstalled), UpgradeAvailableVar, "1", BootcountVar, "0"); err != nil {
+		return err
+	}
+	return t.env.Save()
+}
+
+// Confirm marks the installed update as good, it is called from the
+// new system once it booted successfully.
+func (t *Tracker) Confirm() error {
+	switch t.State() {
+	case StateInstalled, StateTesting:
+	default:
+		return fmt.Errorf("cannot confirm update in state %s", t.State())
+	}
+	if err := t.env.SetAll(StateVar, string(StateOK), UpgradeAvailableVar, "", BootcountVar, "0"); err != nil {
+		return err
+	}
+	return t.env.Save()
+}
+
+// MarkFailed marks the installed update as failed, e.g. because the
+// health checks of the new system failed.
+func (t *Tracker) MarkFailed() error {
+	if err := t.env.SetAll(StateVar, string(StateFailed), UpgradeAvailableVar, ""); err != nil {
+		return err
+	}
+	return t.env.Save()
+}
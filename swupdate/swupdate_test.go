@@ -0,0 +1,83 @@
+package swupdate
+
+import (
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type swupdateTestSuite struct {
+	envFile string
+	tracker *Tracker
+}
+
+var _ = Suite(&swupdateTestSuite{})
+
+func (s *swupdateTestSuite) SetUpTest(c *C) {
+	s.envFile = filepath.Join(c.MkDir(), "uboot.env")
+	env, err := uenv.Create(s.envFile, 4096)
+	c.Assert(err, IsNil)
+	c.Assert(env.Save(), IsNil)
+	s.tracker = New(env)
+}
+
+func (s *swupdateTestSuite) reopen(c *C) *uenv.Env {
+	env, err := uenv.Open(s.envFile)
+	c.Assert(err, IsNil)
+	return env
+}
+
+func (s *swupdateTestSuite) TestSuccessfulUpdate(c *C) {
+	c.Check(s.tracker.State(), Equals, StateOK)
+
+	c.Assert(s.tracker.BeginUpdate(), IsNil)
+	env := s.reopen(c)
+	c.Check(env.Get(RecoveryStatusVar), Equals, "in_progress")
+	c.Check(env.Get(StateVar), Equals, "7")
+	c.Check(New(env).InProgress(), Equals, true)
+
+	c.Assert(s.tracker.FinishUpdate(true), IsNil)
+	env = s.reopen(c)
+	c.Check(env.String(), Equals, "bootcount=0\nupgrade_available=1\nustate=1\n")
+
+	// the bootloader moves to testing
+	env.Set(StateVar, string(StateTesting))
+	t := New(env)
+	c.Assert(t.Confirm(), IsNil)
+	c.Check(s.reopen(c).String(), Equals, "bootcount=0\nustate=0\n")
+}
+
+func (s *swupdateTestSuite) TestFailedUpdate(c *C) {
+	c.Assert(s.tracker.BeginUpdate(), IsNil)
+	c.Assert(s.tracker.FinishUpdate(false), IsNil)
+
+	t := New(s.reopen(c))
+	c.Check(t.RecoveryStatus(), Equals, RecoveryFailed)
+	c.Check(t.State(), Equals, StateFailed)
+	c.Check(t.InProgress(), Equals, false)
+	c.Check(t.Confirm(), ErrorMatches, "cannot confirm update in state failed")
+}
+
+func (s *swupdateTestSuite) TestMarkFailed(c *C) {
+	c.Assert(s.tracker.BeginUpdate(), IsNil)
+	c.Assert(s.tracker.FinishUpdate(true), IsNil)
+	c.Assert(s.tracker.MarkFailed(), IsNil)
+	c.Check(s.reopen(c).String(), Equals, "bootcount=0\nustate=3\n")
+}
+
+func (s *swupdateTestSuite) TestErrors(c *C) {
+	c.Check(s.tracker.FinishUpdate(true), ErrorMatches, "cannot finish update: no update in progress")
+	c.Assert(s.tracker.BeginUpdate(), IsNil)
+	c.Check(s.tracker.BeginUpdate(), ErrorMatches, "cannot begin update: another update is in progress")
+}
+
+func (s *swupdateTestSuite) TestStateString(c *C) {
+	c.Check(StateInProgress.String(), Equals, "in-progress")
+	c.Check(State("x").String(), Equals, `unknown("x")`)
+}
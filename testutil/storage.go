@@ -0,0 +1,193 @@
+// Package testutil provides a fake uenv.Storage for testing the
+// recovery logic of applications that use the uenv package without
+// real hardware.
+package testutil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"syscall"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+var _ uenv.Storage = (*FakeStorage)(nil)
+
+// ErrPowerCut is returned by a FakeStorage after a simulated power cut
+// until PowerOn is called
+var ErrPowerCut = errors.New("power cut")
+
+type faultKind int
+
+const (
+	faultEIO faultKind = iota
+	faultShortWrite
+	faultPowerCut
+)
+
+type fault struct {
+	kind   faultKind
+	length int
+}
+
+// FakeStorage is an in-memory storage that can inject the faults of
+// real devices: CRC corruption, EIO, short writes and power cuts.
+// Faults are scheduled for the Nth write, counting from 1 since the
+// storage was created.
+type FakeStorage struct {
+	mu     sync.Mutex
+	lockMu sync.Mutex
+	data   []byte
+	writes int
+	faults map[int]fault
+	off    bool
+}
+
+// NewFakeStorage returns a fake storage with the given content
+func NewFakeStorage(content []byte) *FakeStorage {
+	return &FakeStorage{
+		data:   append([]byte(nil), content...),
+		faults: make(map[int]fault),
+	}
+}
+
+// FailWrite makes the nth write fail with EIO without changing the
+// content
+func (fs *FakeStorage) FailWrite(n int) {
+	fs.schedule(n, fault{kind: faultEIO})
+}
+
+// ShortWrite makes the nth write only write the first length bytes and
+// fail with io.ErrShortWrite, the rest keeps the old content
+func (fs *FakeStorage) ShortWrite(n, length int) {
+	fs.schedule(n, fault{kind: faultShortWrite, length: length})
+}
+
+// PowerCut makes the nth write stop after length bytes and truncates
+// the storage there, like a file that was cut short. All further calls
+// fail with ErrPowerCut until PowerOn is called.
+func (fs *FakeStorage) PowerCut(n, length int) {
+	fs.schedule(n, fault{kind: faultPowerCut, length: length})
+}
+
+func (fs *FakeStorage) schedule(n int, f fault) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.faults[n] = f
+}
+
+// PowerOn makes the storage usable again after a power cut
+func (fs *FakeStorage) PowerOn() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.off = false
+}
+
+// CorruptCRC flips the bits of the first byte, which is part of the
+// CRC in every header layout, so the stored env no longer validates
+func (fs *FakeStorage) CorruptCRC() error {
+	return fs.Corrupt(0)
+}
+
+// Corrupt flips the bits of the byte at offset off
+func (fs *FakeStorage) Corrupt(off int) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if off < 0 || off >= len(fs.data) {
+		return fmt.Errorf("cannot corrupt offset %v of %v bytes", off, len(fs.data))
+	}
+	fs.data[off] ^= 0xff
+	return nil
+}
+
+// Writes returns the number of writes so far, including failed ones
+func (fs *FakeStorage) Writes() int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.writes
+}
+
+// Bytes returns a copy of the content of the storage
+func (fs *FakeStorage) Bytes() []byte {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return append([]byte(nil), fs.data...)
+}
+
+// ReadAll reads size bytes of the env, if size is zero the whole
+// content is read
+func (fs *FakeStorage) ReadAll(size int) ([]byte, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.off {
+		return nil, ErrPowerCut
+	}
+	if size == 0 {
+		return append([]byte(nil), fs.data...), nil
+	}
+	if size > len(fs.data) {
+		return nil, fmt.Errorf("cannot read %v bytes of env from %v bytes", size, len(fs.data))
+	}
+	return append([]byte(nil), fs.data[:size]...), nil
+}
+
+// WriteInPlace overwrites the env with content or injects the fault
+// scheduled for this write
+func (fs *FakeStorage) WriteInPlace(content []byte) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.off {
+		return ErrPowerCut
+	}
+	fs.writes++
+	f, ok := fs.faults[fs.writes]
+	if !ok {
+		fs.write(content)
+		return nil
+	}
+	delete(fs.faults, fs.writes)
+	length := f.length
+	if length > len(content) {
+		length = len(content)
+	}
+	switch f.kind {
+	case faultShortWrite:
+		fs.write(content[:length])
+		return io.ErrShortWrite
+	case faultPowerCut:
+		fs.write(content[:length])
+		fs.data = fs.data[:length]
+		fs.off = true
+		return ErrPowerCut
+	default:
+		return syscall.EIO
+	}
+}
+
+func (fs *FakeStorage) write(content []byte) {
+	if len(content) > len(fs.data) {
+		fs.data = append(fs.data, make([]byte, len(content)-len(fs.data))...)
+	}
+	copy(fs.data, content)
+}
+
+// Erase does nothing unless the power is cut
+func (fs *FakeStorage) Erase(size int) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.off {
+		return ErrPowerCut
+	}
+	return nil
+}
+
+// Lock locks the storage
+func (fs *FakeStorage) Lock() (unlock func() error, err error) {
+	fs.lockMu.Lock()
+	return func() error {
+		fs.lockMu.Unlock()
+		return nil
+	}, nil
+}
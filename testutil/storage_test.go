@@ -0,0 +1,111 @@
+package testutil
+
+import (
+	"io"
+	"syscall"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type storageTestSuite struct{}
+
+var _ = Suite(&storageTestSuite{})
+
+func (s *storageTestSuite) TestWrite(c *C) {
+	fs := NewFakeStorage([]byte("abcd"))
+	c.Assert(fs.WriteInPlace([]byte("xy")), IsNil)
+	c.Check(fs.Bytes(), DeepEquals, []byte("xycd"))
+	c.Assert(fs.WriteInPlace([]byte("123456")), IsNil)
+	c.Check(fs.Bytes(), DeepEquals, []byte("123456"))
+	c.Check(fs.Writes(), Equals, 2)
+
+	content, err := fs.ReadAll(3)
+	c.Assert(err, IsNil)
+	c.Check(content, DeepEquals, []byte("123"))
+	_, err = fs.ReadAll(7)
+	c.Check(err, ErrorMatches, "cannot read 7 bytes of env from 6 bytes")
+}
+
+func (s *storageTestSuite) TestFailWrite(c *C) {
+	fs := NewFakeStorage([]byte("abcd"))
+	fs.FailWrite(2)
+	c.Assert(fs.WriteInPlace([]byte("1")), IsNil)
+	c.Check(fs.WriteInPlace([]byte("2")), Equals, syscall.EIO)
+	c.Assert(fs.WriteInPlace([]byte("3")), IsNil)
+	c.Check(fs.Bytes(), DeepEquals, []byte("3bcd"))
+}
+
+func (s *storageTestSuite) TestShortWrite(c *C) {
+	fs := NewFakeStorage([]byte("abcd"))
+	fs.ShortWrite(1, 2)
+	c.Check(fs.WriteInPlace([]byte("1234")), Equals, io.ErrShortWrite)
+	c.Check(fs.Bytes(), DeepEquals, []byte("12cd"))
+}
+
+func (s *storageTestSuite) TestPowerCut(c *C) {
+	fs := NewFakeStorage([]byte("abcd"))
+	fs.PowerCut(1, 3)
+	c.Check(fs.WriteInPlace([]byte("1234")), Equals, ErrPowerCut)
+	_, err := fs.ReadAll(0)
+	c.Check(err, Equals, ErrPowerCut)
+	c.Check(fs.WriteInPlace([]byte("1234")), Equals, ErrPowerCut)
+	c.Check(fs.Erase(4), Equals, ErrPowerCut)
+	c.Check(fs.Writes(), Equals, 1)
+
+	fs.PowerOn()
+	content, err := fs.ReadAll(0)
+	c.Assert(err, IsNil)
+	c.Check(content, DeepEquals, []byte("123"))
+}
+
+func (s *storageTestSuite) TestCorrupt(c *C) {
+	fs := NewFakeStorage([]byte{0, 1})
+	c.Assert(fs.CorruptCRC(), IsNil)
+	c.Assert(fs.Corrupt(1), IsNil)
+	c.Check(fs.Bytes(), DeepEquals, []byte{0xff, 0xfe})
+	c.Check(fs.Corrupt(2), ErrorMatches, "cannot corrupt offset 2 of 2 bytes")
+}
+
+func (s *storageTestSuite) TestEnvCorruptCRC(c *C) {
+	fs := NewFakeStorage(nil)
+	env, err := uenv.CreateStorage(fs, uenv.Config{Size: 64})
+	c.Assert(err, IsNil)
+	c.Assert(env.Set("a", "1"), IsNil)
+	c.Assert(env.Save(), IsNil)
+
+	c.Assert(fs.CorruptCRC(), IsNil)
+	_, err = uenv.OpenStorage(fs, uenv.Config{Size: 64})
+	c.Check(err, ErrorMatches, "bad CRC: .*")
+}
+
+func (s *storageTestSuite) TestEnvRecoversFromPowerCut(c *C) {
+	primary := NewFakeStorage(nil)
+	redundant := NewFakeStorage(nil)
+	cfg := uenv.Config{Size: 64, Redundant: true, RedundantStorage: redundant}
+	env, err := uenv.CreateStorage(primary, cfg)
+	c.Assert(err, IsNil)
+	c.Assert(env.Set("bootcount", "1"), IsNil)
+	c.Assert(env.Save(), IsNil)
+	env, err = uenv.OpenStorage(primary, cfg)
+	c.Assert(err, IsNil)
+
+	// the next save goes to the other copy and is cut short
+	next := primary
+	if env.Source() == uenv.SourcePrimary {
+		next = redundant
+	}
+	next.PowerCut(next.Writes()+1, 10)
+	c.Assert(env.Set("bootcount", "2"), IsNil)
+	c.Check(env.Save(), NotNil)
+	next.PowerOn()
+
+	env, err = uenv.OpenStorage(primary, cfg)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("bootcount"), Equals, "1")
+}
@@ -0,0 +1,182 @@
+//go:build tinyenv
+
+// Package tinyenv is a minimal read-only API for uboot envs, meant for
+// static initramfs binaries where binary size and allocations matter.
+// It only depends on os, io, errors and hash/crc32 and reads the env
+// through a fixed scratch buffer, looking up a variable does not
+// allocate. The package is only built with "-tags tinyenv", use the
+// uenv package for everything else.
+package tinyenv
+
+import (
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// ScratchSize is the size of the buffer the env is read with
+const ScratchSize = 4096
+
+// MaxValueSize is the size of the largest value that can be looked up
+const MaxValueSize = 4096
+
+// The errors returned by Lookup, they are static to keep the package
+// free of fmt
+var (
+	ErrNotFound      = errors.New("variable not found")
+	ErrBadCRC        = errors.New("bad CRC")
+	ErrTooSmall      = errors.New("env too small")
+	ErrNoEnd         = errors.New("cannot find end of env")
+	ErrValueTooLarge = errors.New("value too large")
+	ErrShortRead     = errors.New("env truncated")
+	ErrHeaderSize    = errors.New("unsupported header size")
+)
+
+// Reader looks up variables of an env. The zero value reads envs with
+// a crc32 and flags header that span the whole file.
+type Reader struct {
+	// HeaderSize is 4 for a crc32 only header or 5 for crc32 plus
+	// flags byte, if zero 5 is used.
+	HeaderSize int
+	// Offset is the offset of the env in the file.
+	Offset int64
+	// Size is the size of the env, if zero everything up to the end
+	// of the file is used.
+	Size int64
+
+	scratch [ScratchSize]byte
+	value   [MaxValueSize]byte
+}
+
+// LookupFile returns the value of the variable in the env file at
+// path, see Reader.Lookup
+func (r *Reader) LookupFile(path, name string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return r.Lookup(f, name)
+}
+
+// Lookup returns the value of the variable in the env read from f. The
+// returned slice points into the Reader and is only valid until the
+// next lookup. The CRC of the whole env is verified before the value
+// is returned.
+func (r *Reader) Lookup(f io.ReadSeeker, name string) ([]byte, error) {
+	if name == "" {
+		return nil, ErrNotFound
+	}
+	hdrSize := int64(r.HeaderSize)
+	if hdrSize == 0 {
+		hdrSize = 5
+	}
+	if hdrSize != 4 && hdrSize != 5 {
+		return nil, ErrHeaderSize
+	}
+	size := r.Size
+	if size == 0 {
+		end, err := f.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		size = end - r.Offset
+	}
+	if size <= hdrSize {
+		return nil, ErrTooSmall
+	}
+	if _, err := f.Seek(r.Offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	hdr := r.scratch[:hdrSize]
+	if _, err := io.ReadFull(f, hdr); err != nil {
+		return nil, ErrShortRead
+	}
+	expectedCRC := uint32(hdr[0]) | uint32(hdr[1])<<8 | uint32(hdr[2])<<16 | uint32(hdr[3])<<24
+
+	sc := scanner{name: name, value: r.value[:0], atStart: true}
+	crc := uint32(0)
+	for left := size - hdrSize; left > 0; {
+		chunk := r.scratch[:]
+		if left < int64(len(chunk)) {
+			chunk = chunk[:left]
+		}
+		n, err := io.ReadFull(f, chunk)
+		if err != nil {
+			return nil, ErrShortRead
+		}
+		crc = crc32.Update(crc, crc32.IEEETable, chunk[:n])
+		sc.scan(chunk[:n])
+		left -= int64(n)
+	}
+	switch {
+	case crc != expectedCRC:
+		return nil, ErrBadCRC
+	case sc.tooLarge:
+		return nil, ErrValueTooLarge
+	case !sc.done:
+		return nil, ErrNoEnd
+	case !sc.found:
+		return nil, ErrNotFound
+	}
+	return r.value[:sc.n], nil
+}
+
+// scanner finds the last entry of a variable in the payload of an env
+type scanner struct {
+	name string
+	// matched is the number of bytes of the current entry that
+	// match name and "=", it is -1 if the entry does not match
+	matched  int
+	atStart  bool
+	value    []byte
+	n        int
+	found    bool
+	tooLarge bool
+	done     bool
+}
+
+func (sc *scanner) scan(p []byte) {
+	prefix := len(sc.name) + 1
+	for _, b := range p {
+		if sc.done {
+			return
+		}
+		if b == 0 {
+			if sc.atStart {
+				sc.done = true
+				return
+			}
+			if sc.matched == prefix {
+				sc.found = true
+				sc.n = len(sc.value)
+			}
+			sc.matched = 0
+			sc.atStart = true
+			continue
+		}
+		sc.atStart = false
+		switch {
+		case sc.matched == prefix:
+			if len(sc.value) == cap(sc.value) {
+				sc.tooLarge = true
+				sc.matched = -1
+				continue
+			}
+			sc.value = append(sc.value, b)
+		case sc.matched < 0:
+		case sc.matched < len(sc.name) && sc.name[sc.matched] == b:
+			sc.matched++
+		case sc.matched == len(sc.name) && b == '=':
+			// a new entry of the name, the last one wins even if
+			// an earlier one was too large
+			sc.matched++
+			sc.value = sc.value[:0]
+			sc.tooLarge = false
+		default:
+			sc.matched = -1
+		}
+	}
+}
@@ -0,0 +1,135 @@
+//go:build tinyenv
+
+package tinyenv
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type tinyenvTestSuite struct {
+	envFile string
+}
+
+var _ = Suite(&tinyenvTestSuite{})
+
+func (s *tinyenvTestSuite) SetUpTest(c *C) {
+	s.envFile = filepath.Join(c.MkDir(), "uboot.env")
+	env, err := uenv.Create(s.envFile, 16384)
+	c.Assert(err, IsNil)
+	c.Assert(env.Set("snap_mode", "try"), IsNil)
+	c.Assert(env.Set("snap", "short"), IsNil)
+	c.Assert(env.Set("bootcount", "2"), IsNil)
+	// spans several scratch buffers
+	c.Assert(env.Set("big", strings.Repeat("y", 4000)), IsNil)
+	c.Assert(env.Set("huge", strings.Repeat("z", 5000)), IsNil)
+	c.Assert(env.Save(), IsNil)
+}
+
+func (s *tinyenvTestSuite) TestLookupFile(c *C) {
+	r := &Reader{}
+	for name, expected := range map[string]string{
+		"snap_mode": "try",
+		"snap":      "short",
+		"bootcount": "2",
+		"big":       strings.Repeat("y", 4000),
+	} {
+		value, err := r.LookupFile(s.envFile, name)
+		c.Assert(err, IsNil)
+		c.Check(string(value), Equals, expected)
+	}
+
+	_, err := r.LookupFile(s.envFile, "snap_m")
+	c.Check(err, Equals, ErrNotFound)
+	_, err = r.LookupFile(s.envFile, "")
+	c.Check(err, Equals, ErrNotFound)
+	_, err = r.LookupFile(s.envFile, "huge")
+	c.Check(err, Equals, ErrValueTooLarge)
+	_, err = r.LookupFile(filepath.Join(c.MkDir(), "missing"), "snap_mode")
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func (s *tinyenvTestSuite) TestLookupNoAllocs(c *C) {
+	f, err := os.Open(s.envFile)
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	r := &Reader{}
+	allocs := testing.AllocsPerRun(10, func() {
+		if _, err := r.Lookup(f, "bootcount"); err != nil {
+			c.Fatal(err)
+		}
+	})
+	c.Check(allocs, Equals, float64(0))
+}
+
+func (s *tinyenvTestSuite) TestLookupLayout(c *C) {
+	fname := filepath.Join(c.MkDir(), "disk.img")
+	c.Assert(ioutil.WriteFile(fname, make([]byte, 1024), 0644), IsNil)
+	env, err := uenv.CreateWithConfig(fname, uenv.Config{Size: 256, Offset: 512, HeaderSize: 4})
+	c.Assert(err, IsNil)
+	c.Assert(env.Set("bootcount", "7"), IsNil)
+	c.Assert(env.Save(), IsNil)
+
+	r := &Reader{Size: 256, Offset: 512, HeaderSize: 4}
+	value, err := r.LookupFile(fname, "bootcount")
+	c.Assert(err, IsNil)
+	c.Check(string(value), Equals, "7")
+
+	r = &Reader{Size: 1024, Offset: 512, HeaderSize: 4}
+	_, err = r.LookupFile(fname, "bootcount")
+	c.Check(err, Equals, ErrShortRead)
+	r = &Reader{Offset: 1020}
+	_, err = r.LookupFile(fname, "bootcount")
+	c.Check(err, Equals, ErrTooSmall)
+	r = &Reader{HeaderSize: 3}
+	_, err = r.LookupFile(fname, "bootcount")
+	c.Check(err, Equals, ErrHeaderSize)
+}
+
+func (s *tinyenvTestSuite) TestLookupBadCRC(c *C) {
+	content, err := ioutil.ReadFile(s.envFile)
+	c.Assert(err, IsNil)
+	content[len(content)-1] = 0
+	c.Assert(ioutil.WriteFile(s.envFile, content, 0644), IsNil)
+
+	_, err = (&Reader{}).LookupFile(s.envFile, "snap_mode")
+	c.Check(err, Equals, ErrBadCRC)
+}
+
+// writeRaw writes an env with the given payload, e.g. with duplicated
+// entries that uenv never writes
+func writeRaw(c *C, fname, payload string, size int) {
+	content := make([]byte, size)
+	copy(content[5:], payload)
+	binary.LittleEndian.PutUint32(content, crc32.ChecksumIEEE(content[5:]))
+	c.Assert(ioutil.WriteFile(fname, content, 0644), IsNil)
+}
+
+func (s *tinyenvTestSuite) TestLookupDuplicateTooLarge(c *C) {
+	huge := strings.Repeat("z", MaxValueSize+1)
+	r := &Reader{}
+
+	// the last entry wins, an earlier one that is too large does not
+	// matter
+	writeRaw(c, s.envFile, "dup="+huge+"\x00dup=ok\x00\x00", 8192)
+	value, err := r.LookupFile(s.envFile, "dup")
+	c.Assert(err, IsNil)
+	c.Check(string(value), Equals, "ok")
+
+	writeRaw(c, s.envFile, "dup=ok\x00dup="+huge+"\x00\x00", 8192)
+	_, err = r.LookupFile(s.envFile, "dup")
+	c.Check(err, Equals, ErrValueTooLarge)
+}
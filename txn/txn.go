@@ -0,0 +1,277 @@
+// Package txn commits the env together with the boot files it refers
+// to, like boot.scr and extlinux.conf. The files are written first and
+// the env last, so the env never points at a kernel or script that was
+// not written. Rollback copies of the old files and the old variables
+// are kept in a journal directory until the commit is done and
+// Recover undoes an interrupted commit after a reboot.
+package txn
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// journalName is the name of the journal in the journal directory
+const journalName = "journal.json"
+
+// journal records what a commit changes so that it can be rolled back
+type journal struct {
+	Files []journalFile `json:"files"`
+	Env   *journalEnv   `json:"env,omitempty"`
+	// Written is set once the files and the env were written
+	Written bool `json:"written,omitempty"`
+}
+
+type journalFile struct {
+	Path string `json:"path"`
+	// Rollback is the copy of the old file, empty if the file did
+	// not exist
+	Rollback string `json:"rollback,omitempty"`
+}
+
+type journalEnv struct {
+	File string            `json:"file"`
+	Size int               `json:"size"`
+	Old  map[string]string `json:"old"`
+	New  map[string]string `json:"new"`
+}
+
+type stagedFile struct {
+	path    string
+	content []byte
+}
+
+// Transaction stages the new content of boot files and changes of the
+// env and commits them together
+type Transaction struct {
+	dir     string
+	files   []stagedFile
+	envFile string
+	envCfg  uenv.Config
+	state   *uenv.DesiredState
+}
+
+// New returns a transaction that keeps its journal and the rollback
+// files in dir. The directory should be on the same filesystem as the
+// boot files, e.g. the boot partition.
+func New(dir string) *Transaction {
+	return &Transaction{dir: dir}
+}
+
+// WriteFile stages the new content of the file at path, the files are
+// written in the order they were staged
+func (t *Transaction) WriteFile(path string, content []byte) {
+	t.files = append(t.files, stagedFile{path: path, content: content})
+}
+
+// UpdateEnv stages the changes of the env in fname, they are applied
+// after all files were written
+func (t *Transaction) UpdateEnv(fname string, cfg uenv.Config, state *uenv.DesiredState) {
+	t.envFile = fname
+	t.envCfg = cfg
+	t.state = state
+}
+
+// Commit writes the staged files and then the env. If a step fails the
+// files and the env are rolled back. If the commit is interrupted,
+// e.g. by a power cut, Recover must be called before the next commit.
+func (t *Transaction) Commit() error {
+	if _, err := os.Stat(filepath.Join(t.dir, journalName)); err == nil {
+		return fmt.Errorf("cannot commit: unfinished transaction in %s", t.dir)
+	}
+	if err := os.MkdirAll(t.dir, 0755); err != nil {
+		return err
+	}
+
+	j := &journal{}
+	var env *uenv.Env
+	if t.state != nil {
+		var err error
+		env, err = uenv.OpenWithConfig(t.envFile, t.envCfg)
+		if err != nil {
+			return fmt.Errorf("cannot commit: %v", err)
+		}
+		old := env.Map()
+		if _, err := env.Apply(t.state); err != nil {
+			return fmt.Errorf("cannot commit: %v", err)
+		}
+		j.Env = &journalEnv{File: t.envFile, Size: env.Size(), Old: old, New: env.Map()}
+	}
+	for i, f := range t.files {
+		jf, err := t.keepRollback(i, f.path)
+		if err != nil {
+			cleanup(t.dir, j)
+			return fmt.Errorf("cannot commit: %v", err)
+		}
+		j.Files = append(j.Files, jf)
+	}
+	if err := writeJournal(t.dir, j); err != nil {
+		cleanup(t.dir, j)
+		return fmt.Errorf("cannot commit: %v", err)
+	}
+
+	err := t.apply(env)
+	if err != nil {
+		if rollbackErr := rollback(t.dir, j, t.envCfg); rollbackErr != nil {
+			return fmt.Errorf("cannot commit: %v (rollback failed: %v)", err, rollbackErr)
+		}
+		return fmt.Errorf("cannot commit: %v", err)
+	}
+	// the marker only matters if the cleanup gets interrupted, the
+	// commit is complete even if it can not be written
+	j.Written = true
+	writeJournal(t.dir, j)
+	return cleanup(t.dir, j)
+}
+
+// apply writes the files and then the env
+func (t *Transaction) apply(env *uenv.Env) error {
+	for _, f := range t.files {
+		fs := &uenv.FileStorage{Path: f.path}
+		if err := fs.WriteAtomic(f.content); err != nil {
+			return err
+		}
+	}
+	if env == nil {
+		return nil
+	}
+	return env.Save()
+}
+
+// keepRollback copies the file at path to the journal directory
+func (t *Transaction) keepRollback(i int, path string) (journalFile, error) {
+	jf := journalFile{Path: path}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return jf, nil
+	}
+	if err != nil {
+		return jf, err
+	}
+	jf.Rollback = filepath.Join(t.dir, strconv.Itoa(i)+".rollback")
+	fs := &uenv.FileStorage{Path: jf.Rollback}
+	return jf, fs.WriteAtomic(content)
+}
+
+// cleanup removes the journal and the rollback files, removing the
+// journal finishes the transaction
+func cleanup(dir string, j *journal) error {
+	if err := os.Remove(filepath.Join(dir, journalName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, f := range j.Files {
+		if f.Rollback != "" {
+			os.Remove(f.Rollback)
+		}
+	}
+	return nil
+}
+
+func writeJournal(dir string, j *journal) error {
+	content, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	fs := &uenv.FileStorage{Path: filepath.Join(dir, journalName)}
+	return fs.WriteAtomic(append(content, '\n'))
+}
+
+// Recover finishes or undoes a commit that was interrupted, cfg is the
+// config of the env of the transaction. If everything was written the
+// commit is complete and only the rollback files are removed,
+// otherwise the files and the env are rolled back. Without an
+// unfinished transaction in dir it does nothing.
+func Recover(dir string, cfg uenv.Config) error {
+	content, err := ioutil.ReadFile(filepath.Join(dir, journalName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var j journal
+	if err := json.Unmarshal(content, &j); err != nil {
+		return fmt.Errorf("cannot recover transaction in %s: %v", dir, err)
+	}
+	if j.Written {
+		return cleanup(dir, &j)
+	}
+	// the env is written last, if it has the new variables only the
+	// marker is missing. That can not be told from an env that the
+	// commit did not change.
+	if j.Env != nil && !reflect.DeepEqual(j.Env.Old, j.Env.New) {
+		env, err := uenv.OpenWithConfig(j.Env.File, cfg)
+		if err == nil && reflect.DeepEqual(env.Map(), j.Env.New) {
+			return cleanup(dir, &j)
+		}
+	}
+	if err := rollback(dir, &j, cfg); err != nil {
+		return fmt.Errorf("cannot recover transaction in %s: %v", dir, err)
+	}
+	return nil
+}
+
+// rollback restores the old files and the old env and finishes the
+// transaction
+func rollback(dir string, j *journal, cfg uenv.Config) error {
+	for _, f := range j.Files {
+		if f.Rollback == "" {
+			if err := os.Remove(f.Path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		content, err := ioutil.ReadFile(f.Rollback)
+		if err != nil {
+			return err
+		}
+		fs := &uenv.FileStorage{Path: f.Path}
+		if err := fs.WriteAtomic(content); err != nil {
+			return err
+		}
+	}
+	if j.Env != nil {
+		if err := restoreEnv(j.Env, cfg); err != nil {
+			return err
+		}
+	}
+	return cleanup(dir, j)
+}
+
+// restoreEnv writes the old variables to the env unless it still has
+// them, an env that can not be read any more is created again
+func restoreEnv(je *journalEnv, cfg uenv.Config) error {
+	env, err := uenv.OpenWithConfig(je.File, cfg)
+	if err == nil && reflect.DeepEqual(env.Map(), je.Old) {
+		return nil
+	}
+	if err != nil {
+		cfg.Size = je.Size
+		env, err = uenv.CreateWithConfig(je.File, cfg)
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := env.Apply(&uenv.DesiredState{Set: je.Old, Unset: unsetVars(env.Map(), je.Old)}); err != nil {
+		return err
+	}
+	return env.Save()
+}
+
+// unsetVars returns the variables of current that are not in old
+func unsetVars(current, old map[string]string) []string {
+	var unset []string
+	for k := range current {
+		if _, ok := old[k]; !ok {
+			unset = append(unset, k)
+		}
+	}
+	return unset
+}
@@ -0,0 +1,204 @@
+package txn
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/mvo5/uboot-go/testutil"
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type txnTestSuite struct {
+	bootDir    string
+	journalDir string
+	bootScr    string
+	extlinux   string
+	storage    *testutil.FakeStorage
+	cfg        uenv.Config
+}
+
+var _ = Suite(&txnTestSuite{})
+
+func (s *txnTestSuite) SetUpTest(c *C) {
+	s.bootDir = c.MkDir()
+	s.journalDir = filepath.Join(s.bootDir, "txn")
+	s.bootScr = filepath.Join(s.bootDir, "boot.scr")
+	s.extlinux = filepath.Join(s.bootDir, "extlinux", "extlinux.conf")
+	c.Assert(os.MkdirAll(filepath.Dir(s.extlinux), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(s.bootScr, []byte("old script"), 0644), IsNil)
+
+	s.storage = testutil.NewFakeStorage(nil)
+	s.cfg = uenv.Config{Size: 256, Storage: s.storage}
+	env, err := uenv.CreateStorage(s.storage, s.cfg)
+	c.Assert(err, IsNil)
+	c.Assert(env.Set("kernel", "vmlinuz-1"), IsNil)
+	c.Assert(env.Save(), IsNil)
+}
+
+func (s *txnTestSuite) stage() *Transaction {
+	t := New(s.journalDir)
+	t.WriteFile(s.bootScr, []byte("new script"))
+	t.WriteFile(s.extlinux, []byte("new extlinux"))
+	t.UpdateEnv("", s.cfg, &uenv.DesiredState{Set: map[string]string{"kernel": "vmlinuz-2"}})
+	return t
+}
+
+func (s *txnTestSuite) checkOld(c *C) {
+	content, err := ioutil.ReadFile(s.bootScr)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "old script")
+	_, err = os.Stat(s.extlinux)
+	c.Check(os.IsNotExist(err), Equals, true)
+	env, err := uenv.OpenStorage(s.storage, s.cfg)
+	c.Assert(err, IsNil)
+	c.Check(env.Map(), DeepEquals, map[string]string{"kernel": "vmlinuz-1"})
+	s.checkFinished(c)
+}
+
+func (s *txnTestSuite) checkNew(c *C) {
+	content, err := ioutil.ReadFile(s.bootScr)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "new script")
+	content, err = ioutil.ReadFile(s.extlinux)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "new extlinux")
+	env, err := uenv.OpenStorage(s.storage, s.cfg)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("kernel"), Equals, "vmlinuz-2")
+	s.checkFinished(c)
+}
+
+// checkFinished checks that neither the journal nor rollback files are
+// left
+func (s *txnTestSuite) checkFinished(c *C) {
+	names, err := filepath.Glob(filepath.Join(s.journalDir, "*"))
+	c.Assert(err, IsNil)
+	c.Check(names, HasLen, 0)
+}
+
+func (s *txnTestSuite) TestCommit(c *C) {
+	c.Assert(s.stage().Commit(), IsNil)
+	s.checkNew(c)
+	c.Check(Recover(s.journalDir, s.cfg), IsNil)
+	s.checkNew(c)
+}
+
+func (s *txnTestSuite) TestCommitFileFails(c *C) {
+	t := s.stage()
+	t.WriteFile(filepath.Join(s.bootDir, "missing", "file"), []byte("x"))
+	c.Check(t.Commit(), ErrorMatches, "cannot commit: .*missing.*")
+	s.checkOld(c)
+}
+
+func (s *txnTestSuite) TestCommitEnvFails(c *C) {
+	s.storage.FailWrite(s.storage.Writes() + 1)
+	c.Check(s.stage().Commit(), ErrorMatches, "cannot commit: input/output error")
+	s.checkOld(c)
+}
+
+func (s *txnTestSuite) TestCommitInvalidState(c *C) {
+	t := New(s.journalDir)
+	t.WriteFile(s.bootScr, []byte("new script"))
+	t.UpdateEnv("", s.cfg, &uenv.DesiredState{Set: map[string]string{"a": "1"}, Unset: []string{"a"}})
+	c.Check(t.Commit(), ErrorMatches, `cannot commit: cannot apply desired state: "a" is both set and unset`)
+	s.checkOld(c)
+}
+
+func (s *txnTestSuite) TestRecoverPowerCut(c *C) {
+	// the env write is cut short and the rollback can not reach the
+	// storage either
+	s.storage.PowerCut(s.storage.Writes()+1, 10)
+	err := s.stage().Commit()
+	c.Check(err, ErrorMatches, "cannot commit: power cut \\(rollback failed: power cut\\)")
+	c.Check(New(s.journalDir).Commit(), ErrorMatches, "cannot commit: unfinished transaction in .*")
+
+	// after the reboot
+	s.storage.PowerOn()
+	c.Assert(Recover(s.journalDir, s.cfg), IsNil)
+	s.checkOld(c)
+}
+
+func (s *txnTestSuite) TestRecoverAfterEnvWritten(c *C) {
+	// interrupted after the env was written but before the journal
+	// was removed
+	t := s.stage()
+	j := &journal{
+		Files: []journalFile{{Path: s.bootScr, Rollback: filepath.Join(s.journalDir, "0.rollback")}, {Path: s.extlinux}},
+		Env:   &journalEnv{Size: 256, Old: map[string]string{"kernel": "vmlinuz-1"}, New: map[string]string{"kernel": "vmlinuz-2"}},
+	}
+	c.Assert(os.MkdirAll(s.journalDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(j.Files[0].Rollback, []byte("old script"), 0644), IsNil)
+	c.Assert(writeJournal(s.journalDir, j), IsNil)
+	env, err := uenv.OpenStorage(s.storage, s.cfg)
+	c.Assert(err, IsNil)
+	_, err = env.Apply(t.state)
+	c.Assert(err, IsNil)
+	c.Assert(t.apply(env), IsNil)
+
+	c.Assert(Recover(s.journalDir, s.cfg), IsNil)
+	s.checkNew(c)
+}
+
+func (s *txnTestSuite) TestRecoverUnchangedEnv(c *C) {
+	// the staged state does not change the env and only the first
+	// file got written
+	j := &journal{
+		Files: []journalFile{{Path: s.bootScr, Rollback: filepath.Join(s.journalDir, "0.rollback")}, {Path: s.extlinux}},
+		Env:   &journalEnv{Size: 256, Old: map[string]string{"kernel": "vmlinuz-1"}, New: map[string]string{"kernel": "vmlinuz-1"}},
+	}
+	c.Assert(os.MkdirAll(s.journalDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(j.Files[0].Rollback, []byte("old script"), 0644), IsNil)
+	c.Assert(writeJournal(s.journalDir, j), IsNil)
+	c.Assert(ioutil.WriteFile(s.bootScr, []byte("new script"), 0644), IsNil)
+
+	c.Assert(Recover(s.journalDir, s.cfg), IsNil)
+	s.checkOld(c)
+}
+
+func (s *txnTestSuite) TestRecoverWritten(c *C) {
+	// interrupted after everything was written, the env does not
+	// tell as it is unchanged
+	t := New(s.journalDir)
+	t.WriteFile(s.bootScr, []byte("new script"))
+	t.WriteFile(s.extlinux, []byte("new extlinux"))
+	t.UpdateEnv("", s.cfg, &uenv.DesiredState{Set: map[string]string{"kernel": "vmlinuz-1"}})
+	j := &journal{
+		Files:   []journalFile{{Path: s.bootScr, Rollback: filepath.Join(s.journalDir, "0.rollback")}, {Path: s.extlinux}},
+		Env:     &journalEnv{Size: 256, Old: map[string]string{"kernel": "vmlinuz-1"}, New: map[string]string{"kernel": "vmlinuz-1"}},
+		Written: true,
+	}
+	c.Assert(os.MkdirAll(s.journalDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(j.Files[0].Rollback, []byte("old script"), 0644), IsNil)
+	c.Assert(writeJournal(s.journalDir, j), IsNil)
+	env, err := uenv.OpenStorage(s.storage, s.cfg)
+	c.Assert(err, IsNil)
+	c.Assert(t.apply(env), IsNil)
+
+	c.Assert(Recover(s.journalDir, s.cfg), IsNil)
+	content, err := ioutil.ReadFile(s.extlinux)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "new extlinux")
+	s.checkFinished(c)
+}
+
+func (s *txnTestSuite) TestFilesOnly(c *C) {
+	t := New(s.journalDir)
+	t.WriteFile(s.extlinux, []byte("new extlinux"))
+	c.Assert(t.Commit(), IsNil)
+	content, err := ioutil.ReadFile(s.extlinux)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "new extlinux")
+	s.checkFinished(c)
+}
+
+func (s *txnTestSuite) TestRecoverNothing(c *C) {
+	c.Check(Recover(s.journalDir, s.cfg), IsNil)
+	c.Check(Recover(c.MkDir(), s.cfg), IsNil)
+}
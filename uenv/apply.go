@@ -0,0 +1,157 @@
+package uenv
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DesiredState is a declarative description of variables that should
+// be set and variables that should not exist in an env
+type DesiredState struct {
+	Set   map[string]string `json:"set"`
+	Unset []string          `json:"unset"`
+}
+
+// Apply changes the env to match the desired state and returns the
+// changes that were made, applying the same state again returns no
+// changes. The env is not saved.
+func (env *Env) Apply(state *DesiredState) ([]Change, error) {
+	for _, name := range state.Unset {
+		if _, ok := state.Set[name]; ok {
+			return nil, fmt.Errorf("cannot apply desired state: %q is both set and unset", name)
+		}
+	}
+	for name, value := range state.Set {
+		if name == "" {
+			return nil, fmt.Errorf("cannot apply desired state: empty variable name")
+		}
+		if value == "" {
+			return nil, fmt.Errorf("cannot apply desired state: %q is set to an empty value", name)
+		}
+	}
+
+	old := &Env{data: env.Map()}
+	for _, name := range state.Unset {
+		delete(env.data, name)
+	}
+	for name, value := range state.Set {
+		if err := env.Set(name, value); err != nil {
+			// leave the env as it was
+			env.data = old.data
+			return nil, err
+		}
+	}
+	return Diff(old, env), nil
+}
+
+// ReadDesiredState reads a desired state in JSON or in the following
+// subset of YAML:
+//
+//	set:
+//	  bootdelay: 0
+//	  bootargs: "console=ttyS0,115200 quiet"
+//	unset:
+//	  - bootcount
+func ReadDesiredState(r io.Reader) (*DesiredState, error) {
+	br := bufio.NewReader(r)
+	if first, err := br.Peek(1); err == nil && first[0] == '{' {
+		var state DesiredState
+		if err := json.NewDecoder(br).Decode(&state); err != nil {
+			return nil, fmt.Errorf("cannot decode desired state: %v", err)
+		}
+		return &state, nil
+	}
+
+	state := &DesiredState{Set: make(map[string]string)}
+	section := ""
+	scanner := bufio.NewScanner(br)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			switch trimmed {
+			case "set:", "set: {}":
+				section = "set"
+			case "unset:", "unset: []":
+				section = "unset"
+			default:
+				return nil, fmt.Errorf("line %v: unknown section %q", n, trimmed)
+			}
+			continue
+		}
+
+		switch section {
+		case "set":
+			l := strings.SplitN(trimmed, ":", 2)
+			if len(l) != 2 {
+				return nil, fmt.Errorf("line %v: cannot parse %q as name: value pair", n, trimmed)
+			}
+			name, err := yamlScalar(l[0])
+			if err != nil {
+				return nil, fmt.Errorf("line %v: %v", n, err)
+			}
+			value, err := yamlScalar(l[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %v: %v", n, err)
+			}
+			state.Set[name] = value
+		case "unset":
+			if !strings.HasPrefix(trimmed, "- ") {
+				return nil, fmt.Errorf("line %v: cannot parse %q as list item", n, trimmed)
+			}
+			name, err := yamlScalar(trimmed[2:])
+			if err != nil {
+				return nil, fmt.Errorf("line %v: %v", n, err)
+			}
+			state.Unset = append(state.Unset, name)
+		default:
+			return nil, fmt.Errorf("line %v: %q is not in a section", n, trimmed)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// yamlScalar returns the value of a plain, single or double quoted
+// YAML scalar that may be followed by a comment
+func yamlScalar(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || (s[0] != '"' && s[0] != '\'') {
+		if i := strings.Index(s, " #"); i >= 0 {
+			s = strings.TrimSpace(s[:i])
+		}
+		return s, nil
+	}
+
+	end := -1
+	for i := 1; i < len(s) && end < 0; i++ {
+		switch {
+		case s[0] == '"' && s[i] == '\\':
+			i++
+		case s[0] == '\'' && s[i] == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case s[i] == s[0]:
+			end = i
+		}
+	}
+	if rest := strings.TrimSpace(s[end+1:]); end < 0 || (rest != "" && rest[0] != '#') {
+		return "", fmt.Errorf("cannot parse quoted string %s", s)
+	}
+	if s[0] == '\'' {
+		return strings.Replace(s[1:end], "''", "'", -1), nil
+	}
+	value, err := strconv.Unquote(s[:end+1])
+	if err != nil {
+		return "", fmt.Errorf("cannot parse quoted string %s", s)
+	}
+	return value, nil
+}
@@ -0,0 +1,103 @@
+package uenv
+
+import (
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type applyTestSuite struct{}
+
+var _ = Suite(&applyTestSuite{})
+
+func (s *applyTestSuite) TestApply(c *C) {
+	env := New(Config{Size: 4096})
+	env.Set("bootdelay", "2")
+	env.Set("bootcount", "3")
+	env.Set("keep", "1")
+
+	state := &DesiredState{
+		Set:   map[string]string{"bootdelay": "0", "keep": "1", "new": "x"},
+		Unset: []string{"bootcount", "missing"},
+	}
+	changes, err := env.Apply(state)
+	c.Assert(err, IsNil)
+	c.Check(changes, DeepEquals, []Change{
+		{Key: "bootcount", Old: "3"},
+		{Key: "bootdelay", Old: "2", New: "0"},
+		{Key: "new", New: "x"},
+	})
+	c.Check(env.Map(), DeepEquals, map[string]string{"bootdelay": "0", "keep": "1", "new": "x"})
+
+	// applying again changes nothing
+	changes, err = env.Apply(state)
+	c.Assert(err, IsNil)
+	c.Check(changes, HasLen, 0)
+}
+
+func (s *applyTestSuite) TestApplyErrors(c *C) {
+	env := New(Config{Size: 4096})
+	env.Set("a", "1")
+
+	_, err := env.Apply(&DesiredState{Set: map[string]string{"a": "2"}, Unset: []string{"a"}})
+	c.Check(err, ErrorMatches, `cannot apply desired state: "a" is both set and unset`)
+	_, err = env.Apply(&DesiredState{Set: map[string]string{"a": ""}})
+	c.Check(err, ErrorMatches, `cannot apply desired state: "a" is set to an empty value`)
+	_, err = env.Apply(&DesiredState{Set: map[string]string{"b=": "2"}, Unset: []string{"a"}})
+	c.Check(err, ErrorMatches, `invalid variable "b=": name contains "="`)
+	_, err = env.Apply(&DesiredState{Set: map[string]string{"": "2"}})
+	c.Check(err, ErrorMatches, `cannot apply desired state: empty variable name`)
+
+	// nothing changed
+	c.Check(env.Map(), DeepEquals, map[string]string{"a": "1"})
+}
+
+func (s *applyTestSuite) TestReadDesiredStateYAML(c *C) {
+	state, err := ReadDesiredState(strings.NewReader(`---
+# boot settings
+set:
+  bootdelay: 0
+  bootargs: "console=ttyS0,115200 quiet\t"
+  bootcmd: 'run ''a''; run b' # comment
+  plain: run a # comment
+
+unset:
+  - bootcount
+  - "upgrade_available"
+`))
+	c.Assert(err, IsNil)
+	c.Check(state, DeepEquals, &DesiredState{
+		Set: map[string]string{
+			"bootdelay": "0",
+			"bootargs":  "console=ttyS0,115200 quiet\t",
+			"bootcmd":   "run 'a'; run b",
+			"plain":     "run a",
+		},
+		Unset: []string{"bootcount", "upgrade_available"},
+	})
+}
+
+func (s *applyTestSuite) TestReadDesiredStateJSON(c *C) {
+	state, err := ReadDesiredState(strings.NewReader(`{"set": {"a": "1"}, "unset": ["b"]}`))
+	c.Assert(err, IsNil)
+	c.Check(state, DeepEquals, &DesiredState{
+		Set:   map[string]string{"a": "1"},
+		Unset: []string{"b"},
+	})
+}
+
+func (s *applyTestSuite) TestReadDesiredStateErrors(c *C) {
+	for _, t := range []struct {
+		input, err string
+	}{
+		{"foo:\n", `line 1: unknown section "foo:"`},
+		{"  a: 1\n", `line 1: "a: 1" is not in a section`},
+		{"set:\n  a\n", `line 2: cannot parse "a" as name: value pair`},
+		{"set:\n  a: \"1\n", `line 2: cannot parse quoted string "1`},
+		{"unset:\n  a\n", `line 2: cannot parse "a" as list item`},
+		{"{", "cannot decode desired state: .*"},
+	} {
+		_, err := ReadDesiredState(strings.NewReader(t.input))
+		c.Check(err, ErrorMatches, t.err, Commentf("%q", t.input))
+	}
+}
@@ -0,0 +1,27 @@
+package uenv
+
+import (
+	"hash/crc32"
+)
+
+// Checksum computes the checksum of the env payload that is stored in
+// the first four bytes of the header. Vendor forks of uboot that do
+// not use crc32 can be handled with their own Checksum.
+type Checksum func(payload []byte) uint32
+
+// CRC32 returns a Checksum that computes a crc32 with the given
+// table, e.g. crc32.MakeTable(crc32.Castagnoli)
+func CRC32(tab *crc32.Table) Checksum {
+	return func(payload []byte) uint32 {
+		return crc32.Checksum(payload, tab)
+	}
+}
+
+// checksum returns the checksum of the config, crc32 IEEE like uboot
+// by default
+func (cfg *Config) checksum() Checksum {
+	if cfg.Checksum != nil {
+		return cfg.Checksum
+	}
+	return crc32.ChecksumIEEE
+}
@@ -0,0 +1,50 @@
+package uenv
+
+import (
+	"encoding/binary"
+	"hash/adler32"
+	"hash/crc32"
+
+	. "gopkg.in/check.v1"
+)
+
+type checksumTestSuite struct{}
+
+var _ = Suite(&checksumTestSuite{})
+
+func (s *checksumTestSuite) TestChecksum(c *C) {
+	for _, sum := range []Checksum{CRC32(crc32.MakeTable(crc32.Castagnoli)), adler32.Checksum} {
+		ms := NewMemoryStorage(nil)
+		cfg := Config{Size: 64, Checksum: sum}
+		env, err := CreateStorage(ms, cfg)
+		c.Assert(err, IsNil)
+		c.Assert(env.Set("foo", "bar"), IsNil)
+		c.Assert(env.Save(), IsNil)
+
+		content := ms.Bytes()
+		c.Check(binary.LittleEndian.Uint32(content), Equals, sum(content[5:]))
+		c.Check(env.CRC(), Equals, sum(content[5:]))
+
+		env, err = OpenStorage(ms, cfg)
+		c.Assert(err, IsNil)
+		c.Check(env.Get("foo"), Equals, "bar")
+		c.Check(env.Validate(), IsNil)
+
+		// the default crc32 does not match
+		_, err = OpenStorage(ms, Config{Size: 64})
+		c.Check(err, ErrorMatches, "bad CRC: .*")
+	}
+}
+
+func (s *checksumTestSuite) TestChecksumDefault(c *C) {
+	env := New(Config{Size: 32})
+	c.Assert(env.Set("a", "b"), IsNil)
+	content, err := env.MarshalBinary()
+	c.Assert(err, IsNil)
+	c.Check(binary.LittleEndian.Uint32(content), Equals, crc32.ChecksumIEEE(content[5:]))
+
+	// an explicit IEEE table gives the same result
+	other := New(Config{HeaderSize: 5, Checksum: CRC32(crc32.IEEETable)})
+	c.Assert(other.UnmarshalBinary(content), IsNil)
+	c.Check(other.Get("a"), Equals, "b")
+}
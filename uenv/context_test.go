@@ -0,0 +1,136 @@
+package uenv
+
+import (
+	"context"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type contextTestSuite struct {
+	envFile string
+}
+
+var _ = Suite(&contextTestSuite{})
+
+func (s *contextTestSuite) SetUpTest(c *C) {
+	s.envFile = filepath.Join(c.MkDir(), "uboot.env")
+}
+
+func (s *contextTestSuite) TestOpenSaveContext(c *C) {
+	env, err := Create(s.envFile, 32)
+	c.Assert(err, IsNil)
+	env.Set("a", "b")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	c.Assert(env.SaveContext(ctx), IsNil)
+
+	env, err = OpenContext(ctx, s.envFile, Config{})
+	c.Assert(err, IsNil)
+	c.Check(env.Get("a"), Equals, "b")
+}
+
+func (s *contextTestSuite) TestCanceled(c *C) {
+	env, err := Create(s.envFile, 32)
+	c.Assert(err, IsNil)
+	env.Set("a", "b")
+	c.Assert(env.Save(), IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	env.Set("a", "c")
+	c.Check(env.SaveContext(ctx), Equals, context.Canceled)
+	_, err = OpenContext(ctx, s.envFile, Config{})
+	c.Check(err, Equals, context.Canceled)
+
+	// nothing got written
+	env, err = Open(s.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("a"), Equals, "b")
+}
+
+// stuckStorage is a memory storage whose writes block until release is
+// closed
+type stuckStorage struct {
+	*MemoryStorage
+	release chan struct{}
+	writing int32
+	overlap int32
+}
+
+func (s *stuckStorage) WriteInPlace(content []byte) error {
+	if atomic.AddInt32(&s.writing, 1) > 1 {
+		atomic.StoreInt32(&s.overlap, 1)
+	}
+	defer atomic.AddInt32(&s.writing, -1)
+	<-s.release
+	return s.MemoryStorage.WriteInPlace(content)
+}
+
+func (s *contextTestSuite) TestSaveContextAbandonedWrite(c *C) {
+	storage := &stuckStorage{MemoryStorage: NewMemoryStorage(nil), release: make(chan struct{})}
+	env, err := CreateStorage(storage, Config{Size: 32})
+	c.Assert(err, IsNil)
+	env.Set("a", "b")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	c.Assert(env.SaveContext(ctx), Equals, context.DeadlineExceeded)
+
+	// the storage stays locked while the write is still running
+	locked := make(chan struct{})
+	go func() {
+		unlock, err := storage.Lock()
+		c.Check(err, IsNil)
+		unlock()
+		close(locked)
+	}()
+	// and the env is not written again in the meantime
+	saved := make(chan error, 1)
+	go func() {
+		saved <- env.Save()
+	}()
+	select {
+	case <-locked:
+		c.Fatal("storage unlocked while the write is running")
+	case err := <-saved:
+		c.Fatalf("env saved while the write is running: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(storage.release)
+	<-locked
+	c.Check(<-saved, IsNil)
+	c.Check(atomic.LoadInt32(&storage.overlap), Equals, int32(0))
+	env, err = OpenStorage(storage, Config{Size: 32})
+	c.Assert(err, IsNil)
+	c.Check(env.Get("a"), Equals, "b")
+}
+
+func (s *contextTestSuite) TestWithContextDeadline(c *C) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// a stuck write
+	stuck := make(chan struct{})
+	defer close(stuck)
+	err := withContext(ctx, func() error {
+		<-stuck
+		return nil
+	})
+	c.Check(err, Equals, context.DeadlineExceeded)
+}
+
+func (s *contextTestSuite) TestWithContextNoDeadline(c *C) {
+	called := false
+	err := withContext(context.Background(), func() error {
+		called = true
+		return nil
+	})
+	c.Check(err, IsNil)
+	c.Check(called, Equals, true)
+}
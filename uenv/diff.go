@@ -0,0 +1,45 @@
+package uenv
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Change is the change of a single variable between two envs
+type Change struct {
+	Key string `json:"key"`
+	// Old is the old value, empty if the variable was added
+	Old string `json:"old,omitempty"`
+	// New is the new value, empty if the variable was removed
+	New string `json:"new,omitempty"`
+}
+
+func (c Change) String() string {
+	switch {
+	case c.Old == "":
+		return fmt.Sprintf("+%s=%s", c.Key, c.New)
+	case c.New == "":
+		return fmt.Sprintf("-%s=%s", c.Key, c.Old)
+	}
+	return fmt.Sprintf("-%s=%s\n+%s=%s", c.Key, c.Old, c.Key, c.New)
+}
+
+// Diff returns the changes needed to go from the old to the new env,
+// sorted by key
+func Diff(old, new *Env) []Change {
+	var changes []Change
+	for k, v := range old.data {
+		if newV := new.data[k]; newV != v {
+			changes = append(changes, Change{Key: k, Old: v, New: newV})
+		}
+	}
+	for k, v := range new.data {
+		if _, ok := old.data[k]; !ok {
+			changes = append(changes, Change{Key: k, New: v})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
@@ -0,0 +1,32 @@
+package uenv
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type diffTestSuite struct{}
+
+var _ = Suite(&diffTestSuite{})
+
+func (s *diffTestSuite) TestDiff(c *C) {
+	old := New(Config{Size: 64})
+	old.Set("same", "1")
+	old.Set("changed", "a")
+	old.Set("removed", "x")
+	new := New(Config{Size: 64})
+	new.Set("same", "1")
+	new.Set("changed", "b")
+	new.Set("added", "y")
+
+	changes := Diff(old, new)
+	c.Check(changes, DeepEquals, []Change{
+		{Key: "added", New: "y"},
+		{Key: "changed", Old: "a", New: "b"},
+		{Key: "removed", Old: "x"},
+	})
+	c.Check(changes[0].String(), Equals, "+added=y")
+	c.Check(changes[1].String(), Equals, "-changed=a\n+changed=b")
+	c.Check(changes[2].String(), Equals, "-removed=x")
+
+	c.Check(Diff(old, old), HasLen, 0)
+}
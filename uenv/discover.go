@@ -0,0 +1,107 @@
+package uenv
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DiscoverNames are the names of env files on a boot partition in the
+// order Discover looks for them
+var DiscoverNames = []string{"uboot.env", "uboot.conf", "boot.sel"}
+
+// discoverDirs are the directories of the boot partition that are
+// searched, Ubuntu Core 20 and later keeps boot.sel in uboot/ubuntu/
+var discoverDirs = []string{".", filepath.Join("uboot", "ubuntu")}
+
+// Discover finds the env file on the boot partition mounted at dir,
+// detects its layout and opens it. Files with a layout that can not be
+// detected, like empty marker files, are skipped.
+func Discover(dir string) (*Env, error) {
+	fname, cfg, err := DiscoverConfig(dir)
+	if err != nil {
+		return nil, err
+	}
+	return OpenWithConfig(fname, cfg)
+}
+
+// DiscoverConfig returns the file name and the detected config of the
+// env file on the boot partition mounted at dir, see Discover
+func DiscoverConfig(dir string) (string, Config, error) {
+	var firstErr error
+	for _, sub := range discoverDirs {
+		for _, name := range DiscoverNames {
+			fname := filepath.Join(dir, sub, name)
+			if _, err := os.Stat(fname); err != nil {
+				continue
+			}
+			cfg, err := DetectLayout(fname)
+			if err == nil {
+				return fname, cfg, nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if firstErr != nil {
+		return "", Config{}, firstErr
+	}
+	return "", Config{}, fmt.Errorf("cannot find env file in %s", dir)
+}
+
+// redundantFileName returns the name of the redundant env file next to
+// fname like CONFIG_ENV_FAT_FILE_REDUND, e.g. uboot-redund.env
+func redundantFileName(fname string) string {
+	ext := filepath.Ext(fname)
+	return strings.TrimSuffix(fname, ext) + "-redund" + ext
+}
+
+// validCRC returns true if content is an env copy with a valid CRC for
+// the given header size
+func validCRC(content []byte, hdrSize int) bool {
+	if len(content) <= hdrSize {
+		return false
+	}
+	return readUint32(content) == crc32.ChecksumIEEE(content[hdrSize:])
+}
+
+// DetectLayout returns the config of the env file at fname by looking
+// for a valid CRC. It detects the header size, redundant images that
+// contain both copies and redundant copies in a "-redund" file next to
+// fname.
+func DetectLayout(fname string) (Config, error) {
+	content, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return Config{}, err
+	}
+
+	redundantFile := redundantFileName(fname)
+	if redundant, err := ioutil.ReadFile(redundantFile); err == nil {
+		// one of the copies may be missing or broken
+		size := 0
+		switch {
+		case validCRC(content, 5):
+			size = len(content)
+		case validCRC(redundant, 5):
+			size = len(redundant)
+		}
+		if size > 0 {
+			return Config{Size: size, HeaderSize: 5, Redundant: true, RedundantFile: redundantFile}, nil
+		}
+	}
+	for _, hdrSize := range []int{4, 5} {
+		if validCRC(content, hdrSize) {
+			return Config{Size: len(content), HeaderSize: hdrSize}, nil
+		}
+	}
+	if half := len(content) / 2; len(content)%2 == 0 {
+		if validCRC(content[:half], 5) || validCRC(content[half:], 5) {
+			return Config{Size: half, HeaderSize: 5, RedundantImage: true}, nil
+		}
+	}
+	return Config{}, fmt.Errorf("cannot detect env layout of %s: no valid CRC found", fname)
+}
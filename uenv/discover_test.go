@@ -0,0 +1,81 @@
+package uenv
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+type discoverTestSuite struct{}
+
+var _ = Suite(&discoverTestSuite{})
+
+func createEnv(c *C, fname string, cfg Config) {
+	env, err := CreateWithConfig(fname, cfg)
+	c.Assert(err, IsNil)
+	c.Assert(env.Set("foo", "bar"), IsNil)
+	c.Assert(env.Save(), IsNil)
+}
+
+func (s *discoverTestSuite) TestDetectLayout(c *C) {
+	dir := c.MkDir()
+	for _, t := range []struct {
+		name     string
+		cfg      Config
+		expected Config
+	}{
+		{"crc.env", Config{Size: 1024, HeaderSize: 4}, Config{Size: 1024, HeaderSize: 4}},
+		{"flags.env", Config{Size: 1024}, Config{Size: 1024, HeaderSize: 5}},
+		{"image.env", Config{Size: 512, RedundantImage: true}, Config{Size: 512, HeaderSize: 5, RedundantImage: true}},
+		{"redundant.env", Config{Size: 1024, Redundant: true, RedundantFile: filepath.Join(dir, "redundant-redund.env")},
+			Config{Size: 1024, HeaderSize: 5, Redundant: true, RedundantFile: filepath.Join(dir, "redundant-redund.env")}},
+	} {
+		fname := filepath.Join(dir, t.name)
+		createEnv(c, fname, t.cfg)
+		cfg, err := DetectLayout(fname)
+		c.Assert(err, IsNil, Commentf("%s", t.name))
+		c.Check(cfg, DeepEquals, t.expected, Commentf("%s", t.name))
+
+		env, err := OpenWithConfig(fname, cfg)
+		c.Assert(err, IsNil, Commentf("%s", t.name))
+		c.Check(env.Get("foo"), Equals, "bar")
+	}
+
+	fname := filepath.Join(dir, "garbage.env")
+	c.Assert(ioutil.WriteFile(fname, []byte("garbage!"), 0644), IsNil)
+	_, err := DetectLayout(fname)
+	c.Check(err, ErrorMatches, "cannot detect env layout of .*/garbage.env: no valid CRC found")
+}
+
+func (s *discoverTestSuite) TestDiscover(c *C) {
+	bootDir := c.MkDir()
+	_, err := Discover(bootDir)
+	c.Check(err, ErrorMatches, "cannot find env file in .*")
+
+	// Ubuntu Core 20 keeps boot.sel in uboot/ubuntu/, the empty
+	// uboot.conf marker is skipped
+	c.Assert(ioutil.WriteFile(filepath.Join(bootDir, "uboot.conf"), nil, 0644), IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(bootDir, "uboot", "ubuntu"), 0755), IsNil)
+	createEnv(c, filepath.Join(bootDir, "uboot", "ubuntu", "boot.sel"), Config{Size: 4096})
+
+	fname, cfg, err := DiscoverConfig(bootDir)
+	c.Assert(err, IsNil)
+	c.Check(fname, Equals, filepath.Join(bootDir, "uboot", "ubuntu", "boot.sel"))
+	c.Check(cfg, DeepEquals, Config{Size: 4096, HeaderSize: 5})
+
+	// uboot.env is preferred
+	createEnv(c, filepath.Join(bootDir, "uboot.env"), Config{Size: 1024, HeaderSize: 4})
+	env, err := Discover(bootDir)
+	c.Assert(err, IsNil)
+	c.Check(env.Size(), Equals, 1024)
+	c.Check(env.Get("foo"), Equals, "bar")
+}
+
+func (s *discoverTestSuite) TestDiscoverOnlyBroken(c *C) {
+	bootDir := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(bootDir, "uboot.env"), make([]byte, 64), 0644), IsNil)
+	_, err := Discover(bootDir)
+	c.Check(err, ErrorMatches, "cannot detect env layout of .*/uboot.env: no valid CRC found")
+}
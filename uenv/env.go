@@ -3,25 +3,237 @@ package uenv
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
-	"hash/crc32"
 	"io"
-	"io/ioutil"
 	"os"
 	"sort"
 	"strings"
 )
 
-// FIXME: add config option for that so that the user can select if
-//        he/she wants env with or without flags
+// headerSize is the default header size (crc32 + flags byte), used
+// when the Config does not specify one
 var headerSize = 5
 
+// MaxSize is the size of the largest env that is read or created. Real
+// envs are a few KiB, larger sizes come from corrupt configs or images
+// and are rejected instead of being read into memory.
+const MaxSize = 64 << 20
+
+// checkHeaderSize returns an error unless hdrSize is 4 or 5
+func checkHeaderSize(hdrSize int) error {
+	if hdrSize != 4 && hdrSize != 5 {
+		return fmt.Errorf("unsupported env header size %v", hdrSize)
+	}
+	return nil
+}
+
 // Env contains the data of the uboot environment
 type Env struct {
-	fname string
-	size  int
-	data  map[string]string
+	fname  string
+	size   int
+	data   map[string]string
+	config Config
+	// storages are the primary and the optional redundant copy
+	storages []Storage
+	// mirror is the optional copy on another device
+	mirror Storage
+
+	// active is the copy (0 primary, 1 redundant) that was read, -1
+	// if no copy was read yet
+	active int
+	// flags is the flags byte of the active copy
+	flags byte
+	// corrupt is set if the env was read despite a bad CRC
+	corrupt bool
+	// source is the copy the data was read from
+	source Source
+	// primaryErr is the reason why the primary copy was not used
+	primaryErr error
+	// mirrorErr is the reason why the mirror was not valid
+	mirrorErr error
+	// writes is the number of copies written since the env was
+	// opened
+	writes uint64
+	// saved are the variables as they were read or last saved, they
+	// are only kept if the config has a MetadataFile
+	saved map[string]string
+	// preSave and postSave are the hooks that run around Save
+	preSave  []PreSaveHook
+	postSave []PostSaveHook
+	// pending is closed once a write that was given up on because its
+	// context was done has finished, nil if there is none
+	pending chan struct{}
+}
+
+// Source identifies the copy an env was read from
+type Source int
+
+const (
+	// SourcePrimary is the primary copy
+	SourcePrimary Source = iota
+	// SourceRedundant is the redundant copy
+	SourceRedundant
+	// SourceBackup is the backup file
+	SourceBackup
+	// SourceMirror is the mirror on another device
+	SourceMirror
+)
+
+func (s Source) String() string {
+	switch s {
+	case SourcePrimary:
+		return "primary"
+	case SourceRedundant:
+		return "redundant"
+	case SourceBackup:
+		return "backup"
+	case SourceMirror:
+		return "mirror"
+	}
+	return fmt.Sprintf("Source(%d)", int(s))
+}
+
+// Config describes where and how a uboot env is stored, it is the
+// equivalent of a /etc/fw_env.config line.
+type Config struct {
+	// Size is the size of a single env copy (CONFIG_ENV_SIZE), if
+	// zero the size of the file is used.
+	Size int
+	// ExpectedSize makes open fail if the env does not have this
+	// size, e.g. Size128K, so that a wrong device or offset is caught
+	// immediately. Zero accepts any size.
+	ExpectedSize int
+	// Offset is the offset of the env inside the file or device
+	// (CONFIG_ENV_OFFSET).
+	Offset int64
+	// HeaderSize is 4 for a crc32 only header or 5 for crc32 plus
+	// flags byte, if zero the default of 5 is used.
+	HeaderSize int
+
+	// Redundant enables the redundant env copy
+	// (CONFIG_SYS_REDUNDAND_ENVIRONMENT).
+	Redundant bool
+	// RedundantFile is the file with the redundant copy, if empty
+	// the redundant copy is stored in the same file.
+	RedundantFile string
+	// RedundantOffset is the offset of the redundant copy
+	// (CONFIG_ENV_OFFSET_REDUND).
+	RedundantOffset int64
+	// RedundantImage treats the file as a single image that contains
+	// both copies back to back, the redundant copy directly follows
+	// the primary copy. If Size is zero each copy is half of the file.
+	RedundantImage bool
+
+	// BackupFile is a plain env file with the same size and header
+	// that is used if neither the primary nor the redundant copy is
+	// valid. It is never written.
+	BackupFile string
+
+	// MirrorFile is a file or device, e.g. SPI NOR next to an env on
+	// eMMC, that gets the same image as the primary copy on every
+	// Save. It is used if the primary copy is not valid. Mirrors can
+	// not be combined with redundant envs.
+	MirrorFile string
+	// MirrorOffset is the offset of the env in MirrorFile.
+	MirrorOffset int64
+	// MirrorStorage is the storage of the mirror, if set it is used
+	// instead of MirrorFile and MirrorOffset.
+	MirrorStorage Storage
+
+	// Storage is the storage of the primary copy, if set it is used
+	// instead of the file name and Offset.
+	Storage Storage
+	// RedundantStorage is the storage of the redundant copy, if set
+	// it is used instead of RedundantFile and RedundantOffset.
+	RedundantStorage Storage
+	// AtomicWrite makes Save replace the env atomically if the
+	// storage supports it instead of overwriting it in place.
+	AtomicWrite bool
+	// DifferentialWrite makes Save skip the erase if the new image
+	// only clears bits of the current content, which NOR flash can
+	// program directly. It must only be used with storage that
+	// allows programming already programmed bits, not with NAND or
+	// NOR with ECC.
+	DifferentialWrite bool
+	// Retry makes Save retry failed writes, it may be nil.
+	Retry *RetryPolicy
+	// WriteCounterFile is a file outside of the env that counts how
+	// often the env got written over its lifetime, e.g. to estimate
+	// the wear of the media. If empty the writes are only counted in
+	// memory.
+	WriteCounterFile string
+	// MetadataFile is a JSON file outside of the env that records
+	// when each variable was last changed by Save, see LastModified.
+	// If empty no metadata is recorded.
+	MetadataFile string
+
+	// Transform encodes the payload on Save and decodes it on open,
+	// e.g. to encrypt the env. It may be nil.
+	Transform Transform
+	// Checksum replaces the crc32 of the header, it may be nil.
+	Checksum Checksum
+	// Order sorts the variables in the image written by Save and in
+	// String and the exports, e.g. to write the critical boot
+	// variables first so that they are more likely to survive a
+	// partial write. It returns true if a comes before b, variables
+	// it does not order are sorted by name. If nil all variables are
+	// sorted by name, see also PrefixOrder.
+	Order func(a, b string) bool
+
+	// Signer makes Save write a detached signature of the env to
+	// SignatureFile and open verify it, it may be nil. If
+	// SignatureFile is empty the file name of the env with a ".sig"
+	// suffix is used.
+	Signer        Signer
+	SignatureFile string
+
+	// Logger receives debug events, it may be nil.
+	Logger Logger
+
+	// Flags alter the behavior of open.
+	Flags OpenFlags
+
+	// MaxVarSize limits the size of a single "name=value" entry that
+	// is accepted by Set, zero means no limit.
+	MaxVarSize int
+	// Reserve is the number of bytes that Set always keeps free so
+	// that a single large value can not block later updates.
+	Reserve int
+	// RejectControl makes Set reject names with control characters
+	// and values with control characters other than tab and newline.
+	RejectControl bool
+	// GuardSave makes Save and RepairPrimary run RoundTripCheck on the
+	// image and refuse to write it if it does not decode to the
+	// variables of the env.
+	GuardSave bool
+}
+
+// storages returns the storage of the primary and, for redundant
+// envs, of the redundant copy. It returns nil if the env is neither
+// backed by a file nor by a storage.
+func (cfg *Config) storages(fname string) []Storage {
+	primary := cfg.Storage
+	if primary == nil {
+		if fname == "" {
+			return nil
+		}
+		primary = &FileStorage{Path: fname, Offset: cfg.Offset}
+	}
+	storages := []Storage{primary}
+	if cfg.Redundant {
+		redundant := cfg.RedundantStorage
+		if redundant == nil {
+			redundantFname := cfg.RedundantFile
+			if redundantFname == "" {
+				redundantFname = fname
+			}
+			redundant = &FileStorage{Path: redundantFname, Offset: cfg.RedundantOffset}
+		}
+		storages = append(storages, redundant)
+	}
+	return storages
 }
 
 // little endian helpers
@@ -46,21 +258,119 @@ func Create(fname string, size int) (*Env, error) {
 	}
 	defer f.Close()
 
-	env := &Env{
-		fname: fname,
-		size:  size,
-		data:  make(map[string]string),
+	return newEnv(fname, Config{Size: size}), nil
+}
+
+// CreateWithConfig creates a new empty uboot env with the given
+// config. Unlike Create existing files are not truncated so that the
+// env can live at an offset inside a larger file or device.
+func CreateWithConfig(fname string, cfg Config) (*Env, error) {
+	if cfg.Size <= 0 || cfg.Size > MaxSize {
+		return nil, fmt.Errorf("cannot create env with size %v", cfg.Size)
+	}
+	if cfg.RedundantImage {
+		if err := cfg.splitImage(fname); err != nil {
+			return nil, err
+		}
+	}
+	env := newEnv(fname, cfg)
+	if env.mirror != nil && cfg.Redundant {
+		return nil, fmt.Errorf("cannot mirror a redundant env")
+	}
+	for _, s := range append(env.storages, env.mirror) {
+		fs, ok := s.(*FileStorage)
+		if !ok {
+			continue
+		}
+		f, err := os.OpenFile(fs.Path, os.O_WRONLY|os.O_CREATE, 0666)
+		if err != nil {
+			return nil, err
+		}
+		f.Close()
 	}
 
 	return env, nil
 }
 
+// CreateStorage creates a new empty uboot env on the given storage
+func CreateStorage(s Storage, cfg Config) (*Env, error) {
+	cfg.Storage = s
+	return CreateWithConfig("", cfg)
+}
+
+// OpenStorage opens a existing uboot env from the given storage
+func OpenStorage(s Storage, cfg Config) (*Env, error) {
+	cfg.Storage = s
+	return OpenWithConfig("", cfg)
+}
+
+// splitImage sets up the redundant copy for a RedundantImage config
+func (cfg *Config) splitImage(fname string) error {
+	if cfg.Storage != nil {
+		return fmt.Errorf("cannot split storage into two env copies")
+	}
+	if cfg.Size == 0 {
+		st, err := os.Stat(fname)
+		if err != nil {
+			return err
+		}
+		imageSize := st.Size() - cfg.Offset
+		if imageSize <= 0 || imageSize%2 != 0 {
+			return fmt.Errorf("cannot split %v byte image into two env copies", imageSize)
+		}
+		cfg.Size = int(imageSize / 2)
+	}
+	cfg.Redundant = true
+	cfg.RedundantFile = ""
+	cfg.RedundantOffset = cfg.Offset + int64(cfg.Size)
+
+	return nil
+}
+
+// CreateRedundantImage creates a new empty uboot env image of the
+// given total size that contains both redundant copies.
+func CreateRedundantImage(fname string, size int) (*Env, error) {
+	if size%2 != 0 {
+		return nil, fmt.Errorf("cannot split %v byte image into two env copies", size)
+	}
+	return CreateWithConfig(fname, Config{Size: size / 2, RedundantImage: true})
+}
+
+// OpenRedundantImage opens a existing uboot env image that contains
+// both redundant copies.
+func OpenRedundantImage(fname string) (*Env, error) {
+	return OpenWithConfig(fname, Config{RedundantImage: true})
+}
+
+// New returns a new empty uboot env that is not backed by a file, it
+// can be serialized with MarshalBinary or WriteTo.
+func New(cfg Config) *Env {
+	return newEnv("", cfg)
+}
+
+func newEnv(fname string, cfg Config) *Env {
+	if cfg.HeaderSize == 0 {
+		cfg.HeaderSize = headerSize
+	}
+	return &Env{
+		fname:    fname,
+		size:     cfg.Size,
+		data:     make(map[string]string),
+		config:   cfg,
+		storages: cfg.storages(fname),
+		mirror:   cfg.mirror(),
+		active:   -1,
+	}
+}
+
 // OpenFlags instructs open how to alter its behavior.
 type OpenFlags int
 
 const (
 	// OpenBestEffort instructs OpenWithFlags to skip malformed data without returning an error.
 	OpenBestEffort OpenFlags = 1 << iota
+	// OpenIgnoreCRC instructs OpenWithFlags to read the env even if the CRC is wrong.
+	OpenIgnoreCRC
 )
 
 // Open opens a existing uboot env file
@@ -68,39 +378,245 @@ func Open(fname string) (*Env, error) {
 	return OpenWithFlags(fname, OpenFlags(0))
 }
 
+// OpenCorrupt opens a existing uboot env file with a bad CRC and
+// salvages all key=value pairs that can be parsed. The env is marked
+// as corrupt until it is saved again.
+func OpenCorrupt(fname string) (*Env, error) {
+	return OpenWithFlags(fname, OpenBestEffort|OpenIgnoreCRC)
+}
+
 // OpenWithFlags opens a existing uboot env file, passing additional flags.
 func OpenWithFlags(fname string, flags OpenFlags) (*Env, error) {
-	f, err := os.Open(fname)
+	return OpenWithConfig(fname, Config{Flags: flags})
+}
+
+// OpenWithConfig opens a existing uboot env using the given config. For
+// redundant envs the valid copy with the most recent flags is used.
+func OpenWithConfig(fname string, cfg Config) (*Env, error) {
+	return OpenContext(context.Background(), fname, cfg)
+}
+
+// OpenContext is like OpenWithConfig but gives up once ctx is done.
+func OpenContext(ctx context.Context, fname string, cfg Config) (*Env, error) {
+	env, err := prepareOpen(fname, cfg)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-
-	contentWithHeader, err := ioutil.ReadAll(f)
+	unlock, err := env.lock(ctx, false)
 	if err != nil {
 		return nil, err
 	}
+	defer unlock()
+
+	if err := env.read(ctx); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// read reads the env from the copy to use, the storage must be locked
+func (env *Env) read(ctx context.Context) error {
+	cfg := env.config
+	env.debug("opening env", "file", env.fname, "storage", fmt.Sprintf("%T", env.storages[0]), "redundant", cfg.Redundant)
+
+	var copies []*envCopy
+	for i, s := range env.storages {
+		copies = append(copies, env.readCopy(ctx, s, Source(i)))
+	}
+	var backup *envCopy
+	if cfg.BackupFile != "" {
+		backup = env.readCopy(ctx, &FileStorage{Path: cfg.BackupFile}, SourceBackup)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	chosen, source := pickSource(copies, backup)
+	if env.mirror != nil {
+		chosen, source = env.readMirror(ctx, chosen, source)
+	}
+	if chosen == nil {
+		env.debug("no usable env copy", "error", copies[0].err)
+		return copies[0].err
+	}
+	env.debug("using env copy", "source", source, "size", chosen.size, "flags", chosen.flags, "corrupt", chosen.corrupt)
+	if err := cfg.checkExpectedSize(chosen.size); err != nil {
+		return err
+	}
+	if !PlausibleSize(chosen.size) {
+		env.debug("implausible env size", "size", chosen.size)
+	}
+	if cfg.Signer != nil {
+		var err error
+		if chosen, source, err = env.verifiedCopy(chosen, source, copies); err != nil {
+			return err
+		}
+	}
+
+	env.data = chosen.data
+	env.snapshot()
+	env.size = chosen.size
+	env.config.Size = env.size
+	env.flags = chosen.flags
+	env.corrupt = chosen.corrupt
+	env.source = source
+	if int(source) < len(env.storages) {
+		env.active = int(source)
+	}
+	switch {
+	case copies[0].err != nil:
+		env.primaryErr = copies[0].err
+	case copies[0].corrupt:
+		env.primaryErr = fmt.Errorf("bad CRC")
+	}
+
+	return nil
+}
+
+// prepareOpen returns the empty env for opening fname with cfg
+func prepareOpen(fname string, cfg Config) (*Env, error) {
+	if cfg.Size < 0 || cfg.Size > MaxSize {
+		return nil, fmt.Errorf("cannot open env with size %v", cfg.Size)
+	}
+	if cfg.RedundantImage {
+		if err := cfg.splitImage(fname); err != nil {
+			return nil, err
+		}
+	}
+	env := newEnv(fname, cfg)
+	if err := checkHeaderSize(env.config.HeaderSize); err != nil {
+		return nil, err
+	}
+	if env.config.Redundant && (env.config.Size == 0 || env.config.HeaderSize < 5) {
+		return nil, fmt.Errorf("redundant env needs a size and a header with flags")
+	}
+	if env.mirror != nil && env.config.Redundant {
+		return nil, fmt.Errorf("cannot mirror a redundant env")
+	}
+	if len(env.storages) == 0 {
+		return nil, fmt.Errorf("cannot open env without file or storage")
+	}
+	return env, nil
+}
+
+// envCopy is the result of reading a single env copy
+type envCopy struct {
+	data    map[string]string
+	content []byte
+	size    int
+	flags   byte
+	corrupt bool
+	err     error
+}
+
+func (env *Env) readCopy(ctx context.Context, s Storage, source Source) *envCopy {
+	hdrSize := env.config.HeaderSize
+	var content []byte
+	err := withContext(ctx, func() (err error) {
+		content, err = s.ReadAll(env.config.Size)
+		return err
+	})
+	if err != nil {
+		env.debug("cannot read env copy", "source", source, "error", err)
+		return &envCopy{err: err}
+	}
+	data, corrupt, err := parseImage(content, hdrSize, env.config.Flags, env.config.Transform, env.config.checksum())
+	env.debug("read env copy", "source", source, "size", len(content), "crc-ok", err == nil && !corrupt, "error", err)
+	if err != nil {
+		return &envCopy{err: err}
+	}
+	c := &envCopy{data: data, content: content, size: len(content), corrupt: corrupt}
+	if hdrSize > 4 {
+		c.flags = content[4]
+	}
+	return c
+}
+
+// parseImage parses the env including the header, it returns if the
+// env was read despite a bad CRC. The CRC is computed with sum and the
+// payload is decoded with t if it is not nil.
+func parseImage(contentWithHeader []byte, hdrSize int, flags OpenFlags, t Transform, sum Checksum) (data map[string]string, corrupt bool, err error) {
+	if err := checkHeaderSize(hdrSize); err != nil {
+		return nil, false, err
+	}
+	if len(contentWithHeader) < hdrSize {
+		return nil, false, fmt.Errorf("env too small: %v bytes", len(contentWithHeader))
+	}
 	crc := readUint32(contentWithHeader)
 
-	payload := contentWithHeader[headerSize:]
-	actualCRC := crc32.ChecksumIEEE(payload)
+	payload := contentWithHeader[hdrSize:]
+	actualCRC := sum(payload)
 	if crc != actualCRC {
-		return nil, fmt.Errorf("bad CRC: %v != %v", crc, actualCRC)
+		if flags&OpenIgnoreCRC == 0 {
+			return nil, false, fmt.Errorf("bad CRC: %v != %v", crc, actualCRC)
+		}
+		corrupt = true
+	}
+	if t != nil {
+		payload = t.Decode(payload)
 	}
 	eof := bytes.Index(payload, []byte{0, 0})
+	if eof < 0 {
+		if !corrupt && flags&OpenBestEffort == 0 {
+			return nil, false, fmt.Errorf("cannot find end of env")
+		}
+		// the end marker got lost, salvage what is there
+		eof = len(payload)
+	}
 
-	data, err := parseData(payload[:eof], flags)
-	if err != nil {
-		return nil, err
+	data, err = parseData(payload[:eof], flags)
+	return data, corrupt, err
+}
+
+// pickSource returns the copy that should be used and where it comes
+// from. Valid copies are preferred over the backup and the backup is
+// preferred over corrupt copies. It returns nil if nothing could be
+// read.
+func pickSource(copies []*envCopy, backup *envCopy) (*envCopy, Source) {
+	var valid []*envCopy
+	for _, c := range copies {
+		if c.err == nil && c.corrupt {
+			c = &envCopy{err: fmt.Errorf("corrupt")}
+		}
+		valid = append(valid, c)
+	}
+	if active := pickValid(valid); active >= 0 {
+		return copies[active], Source(active)
 	}
+	if backup != nil && backup.err == nil && !backup.corrupt {
+		return backup, SourceBackup
+	}
+	if active := pickValid(copies); active >= 0 {
+		return copies[active], Source(active)
+	}
+	if backup != nil && backup.err == nil {
+		return backup, SourceBackup
+	}
+	return nil, SourcePrimary
+}
 
-	env := &Env{
-		fname: fname,
-		size:  len(contentWithHeader),
-		data:  data,
+// pickValid follows the logic of env_import_redund() in uboot
+func pickValid(copies []*envCopy) int {
+	if len(copies) == 1 || copies[1].err != nil {
+		if copies[0].err != nil {
+			return -1
+		}
+		return 0
+	}
+	if copies[0].err != nil {
+		return 1
 	}
 
-	return env, nil
+	flags0, flags1 := copies[0].flags, copies[1].flags
+	switch {
+	case flags0 == 0xff && flags1 == 0:
+		return 1
+	case flags1 == 0xff && flags0 == 0:
+		return 0
+	case flags1 > flags0:
+		return 1
+	default:
+		return 0
+	}
 }
 
 func parseData(data []byte, flags OpenFlags) (map[string]string, error) {
@@ -119,6 +635,12 @@ func parseData(data []byte, flags OpenFlags) (map[string]string, error) {
 		}
 		key := l[0]
 		value := l[1]
+		if key == "" {
+			if flags&OpenBestEffort == OpenBestEffort {
+				continue
+			}
+			return nil, fmt.Errorf("cannot parse line %q: empty variable name", envStr)
+		}
 		out[key] = value
 	}
 
@@ -135,48 +657,197 @@ func (env *Env) String() string {
 	return out
 }
 
+// Map returns a copy of all variables
+func (env *Env) Map() map[string]string {
+	m := make(map[string]string, len(env.data))
+	for k, v := range env.data {
+		m[k] = v
+	}
+	return m
+}
+
+// Size returns the size of the env including the header
+func (env *Env) Size() int {
+	return env.size
+}
+
+// Free returns the number of bytes that are still available for
+// variables, it is negative if the variables do not fit.
+func (env *Env) Free() int {
+	return env.size - env.headerSize() - env.dataSize()
+}
+
+// CRC returns the CRC of the env as it is written by Save
+func (env *Env) CRC() uint32 {
+	return readUint32(env.image(0))
+}
+
 // Get the value of the environment variable
 func (env *Env) Get(name string) string {
 	return env.data[name]
 }
 
+// InvalidVarError is returned by Set for names or values that can not
+// be stored in the env
+type InvalidVarError struct {
+	Name   string
+	Reason string
+}
+
+func (e *InvalidVarError) Error() string {
+	return fmt.Sprintf("invalid variable %q: %s", e.Name, e.Reason)
+}
+
+// checkVar returns an *InvalidVarError if name or value can not be
+// read back by uboot. With rejectControl control characters are
+// rejected as well.
+func checkVar(name, value string, rejectControl bool) error {
+	switch {
+	case strings.Contains(name, "="):
+		return &InvalidVarError{Name: name, Reason: `name contains "="`}
+	case strings.HasPrefix(name, "\xff"):
+		return &InvalidVarError{Name: name, Reason: "name starts with 0xff"}
+	case strings.ContainsRune(name, 0):
+		return &InvalidVarError{Name: name, Reason: "name contains a NUL byte"}
+	case strings.ContainsRune(value, 0):
+		return &InvalidVarError{Name: name, Reason: "value contains a NUL byte"}
+	}
+	if !rejectControl {
+		return nil
+	}
+	if strings.IndexFunc(name, isControl) >= 0 {
+		return &InvalidVarError{Name: name, Reason: "name contains a control character"}
+	}
+	if strings.IndexFunc(value, func(r rune) bool { return isControl(r) && r != '\t' && r != '\n' }) >= 0 {
+		return &InvalidVarError{Name: name, Reason: "value contains a control character"}
+	}
+	return nil
+}
+
+func isControl(r rune) bool {
+	return r < 0x20 || r == 0x7f
+}
+
 // Set an environment name to the given value, if the value is empty
-// the variable will be removed from the environment
-func (env *Env) Set(name, value string) {
+// the variable will be removed from the environment. An
+// *InvalidVarError is returned for names or values that can not be
+// stored, e.g. because they contain a NUL byte. An error is also
+// returned if the value exceeds the MaxVarSize or Reserve of the
+// config, removing or shrinking a variable always succeeds.
+func (env *Env) Set(name, value string) error {
 	if name == "" {
 		panic(fmt.Sprintf("Set() can not be called with empty key for value: %q", value))
 	}
 	if value == "" {
 		delete(env.data, name)
-		return
+		return nil
+	}
+	if err := checkVar(name, value, env.config.RejectControl); err != nil {
+		return err
+	}
+	if err := env.checkQuota(name, value); err != nil {
+		return err
 	}
 	env.data[name] = value
+	return nil
+}
+
+// SetAll sets the given name, value pairs like Set does, e.g. all the
+// variables of a state transition. It stops at the first error, the
+// pairs before it stay set.
+func (env *Env) SetAll(pairs ...string) error {
+	if len(pairs)%2 != 0 {
+		panic(fmt.Sprintf("SetAll() can not be called with an odd number of arguments: %q", pairs))
+	}
+	for i := 0; i < len(pairs); i += 2 {
+		if err := env.Set(pairs[i], pairs[i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkQuota checks that setting name to value stays within the
+// MaxVarSize and Reserve of the config
+func (env *Env) checkQuota(name, value string) error {
+	entry := len(name) + len(value) + 1
+	if max := env.config.MaxVarSize; max > 0 && entry > max {
+		return fmt.Errorf("cannot set %q: %v bytes exceed the limit of %v bytes per variable", name, entry, max)
+	}
+	old, ok := env.data[name]
+	grow := len(value) - len(old)
+	if !ok {
+		grow = entry + 1
+		if len(env.data) == 0 {
+			// the extra end marker of an empty env is reused
+			grow--
+		}
+	}
+	if reserve := env.config.Reserve; reserve > 0 && grow > 0 && env.Free()-grow < reserve {
+		return fmt.Errorf("cannot set %q: only %v bytes would be left but %v bytes are reserved", name, env.Free()-grow, reserve)
+	}
+	return nil
 }
 
 // iterEnv calls the passed function f with key, value for environment
-// vars. The order is guaranteed (unlike just iterating over the map)
+// vars. The order is guaranteed (unlike just iterating over the map),
+// it is the Order of the config
 func (env *Env) iterEnv(f func(key, value string)) {
+	for _, k := range env.keys() {
+		if k == "" {
+			panic("iterEnv iterating over a empty key")
+		}
+
+		f(k, env.data[k])
+	}
+}
+
+// keys returns the names of the variables in the Order of the config
+func (env *Env) keys() []string {
 	keys := make([]string, 0, len(env.data))
 	for k := range env.data {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
+	if order := env.config.Order; order != nil {
+		sort.SliceStable(keys, func(i, j int) bool {
+			return order(keys[i], keys[j])
+		})
+	}
+	return keys
+}
 
-	for _, k := range keys {
-		if k == "" {
-			panic("iterEnv iterating over a empty key")
-		}
+// dataSize returns the size of the serialized variables including the
+// end marker, without the header
+func (env *Env) dataSize() int {
+	size := 1
+	for k, v := range env.data {
+		size += len(k) + len(v) + 2
+	}
+	if len(env.data) == 0 {
+		size++
+	}
+	return size
+}
 
-		f(k, env.data[k])
+// checkSize returns an error if the variables do not fit into the env
+func (env *Env) checkSize() error {
+	if needed := env.headerSize() + env.dataSize(); needed > env.size {
+		return fmt.Errorf("env too large: %v bytes needed but env size is %v", needed, env.size)
 	}
+	return nil
 }
 
-// Save will write out the environment data
-func (env *Env) Save() error {
+// image returns the serialized env including the header
+func (env *Env) image(flags byte) []byte {
+	hdrSize := env.headerSize()
 	w := bytes.NewBuffer(nil)
 	// will panic if the buffer can't grow, all writes to
 	// the buffer will be ok because we sized it correctly
-	w.Grow(env.size - headerSize)
+	w.Grow(env.size)
+
+	// header, crc is filled in once the payload is known
+	w.Write(make([]byte, hdrSize))
 
 	// write the payload
 	env.iterEnv(func(key, value string) {
@@ -194,44 +865,384 @@ func (env *Env) Save() error {
 
 	// write ff into the remaining parts
 	writtenSoFar := w.Len()
-	for i := 0; i < env.size-headerSize-writtenSoFar; i++ {
+	for i := 0; i < env.size-writtenSoFar; i++ {
 		w.Write([]byte{0xff})
 	}
 
+	content := w.Bytes()
+	if t := env.config.Transform; t != nil {
+		copy(content[hdrSize:], t.Encode(content[hdrSize:]))
+	}
 	// checksum
-	crc := crc32.ChecksumIEEE(w.Bytes())
-
-	// Note that we overwrite the existing file and do not do
-	// the usual write-rename. The rationale is that we want to
-	// minimize the amount of writes happening on a potential
-	// FAT partition where the env is loaded from. The file will
-	// always be of a fixed size so we know the writes will not
-	// fail because of ENOSPC.
-	//
-	// The size of the env file never changes so we do not
-	// truncate it.
-	//
-	// We also do not O_TRUNC to avoid reallocations on the FS
-	// to minimize risk of fs corruption.
-	f, err := os.OpenFile(env.fname, os.O_WRONLY, 0666)
+	crc := env.config.checksum()(content[hdrSize:])
+	copy(content, writeUint32(crc))
+	// flags byte (e.g. for redundant header)
+	if hdrSize > 4 {
+		content[4] = flags
+	}
+
+	return content
+}
+
+// Corrupt returns true if the env was opened with a bad CRC, see
+// OpenCorrupt. Saving the env repairs it.
+func (env *Env) Corrupt() bool {
+	return env.corrupt
+}
+
+// Source returns the copy the env was read from
+func (env *Env) Source() Source {
+	return env.source
+}
+
+// PrimaryErr returns why the primary copy could not be used when the
+// env was opened, it is nil if the primary copy was valid.
+func (env *Env) PrimaryErr() error {
+	return env.primaryErr
+}
+
+// RepairPrimary rewrites the primary copy with the current data if it
+// was not valid when the env was opened. For redundant envs the
+// repaired copy becomes the active one.
+func (env *Env) RepairPrimary() error {
+	if env.primaryErr == nil {
+		return nil
+	}
+	if err := env.checkSize(); err != nil {
+		return err
+	}
+	flags := env.flags
+	if env.config.Redundant {
+		flags++
+	}
+	ctx := context.Background()
+	unlock, err := env.lock(ctx, true)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	defer unlock()
+	content := env.image(flags)
+	if err := env.guard(content); err != nil {
+		return err
+	}
+	sig, err := env.signature(content)
+	if err != nil {
+		return err
+	}
+	if err := env.writeCopy(ctx, SourcePrimary, content); err != nil {
+		return err
+	}
+	env.active = 0
+	env.flags = flags
+	env.source = SourcePrimary
+	env.primaryErr = nil
+	if !env.config.Redundant {
+		env.corrupt = false
+	}
+
+	return env.writeSignature(sig)
+}
+
+// Save will write out the environment data. For redundant envs the
+// copy that was not read is written so that the previous env stays
+// intact if the write gets interrupted.
+func (env *Env) Save() error {
+	return env.SaveContext(context.Background())
+}
 
-	if _, err := f.Write(writeUint32(crc)); err != nil {
+// SaveContext is like Save but gives up once ctx is done. A redundant
+// copy is never written after ctx is done, but a write that is already
+// in progress cannot be interrupted and may still complete. The
+// storage stays locked until it does and the env is not saved again
+// before that.
+func (env *Env) SaveContext(ctx context.Context) error {
+	return env.runHooks(func() error {
+		if err := env.save(ctx); err != nil {
+			return err
+		}
+		env.recordModified()
+		return nil
+	})
+}
+
+// SaveAll is like Save but rewrites every copy of the env in a single
+// pass, e.g. to repair copies that are not valid or that contain
+// duplicated variables. Both copies of a redundant env get the same
+// flags.
+func (env *Env) SaveAll() error {
+	// without an active copy both redundant copies are written
+	env.active = -1
+	return env.Save()
+}
+
+func (env *Env) save(ctx context.Context) error {
+	if len(env.storages) == 0 {
+		return fmt.Errorf("cannot save env that is not backed by a file")
+	}
+	if err := env.checkSize(); err != nil {
 		return err
 	}
-	// padding bytes (e.g. for redundant header)
-	pad := make([]byte, headerSize-binary.Size(crc))
-	if _, err := f.Write(pad); err != nil {
+	unlock, err := env.lock(ctx, true)
+	if err != nil {
 		return err
 	}
-	if _, err := f.Write(w.Bytes()); err != nil {
+	defer unlock()
+
+	return env.write(ctx)
+}
+
+// write writes the env, the storage must be locked
+func (env *Env) write(ctx context.Context) error {
+	if !env.config.Redundant {
+		content := env.image(0)
+		if err := env.guard(content); err != nil {
+			return err
+		}
+		sig, err := env.signature(content)
+		if err != nil {
+			return err
+		}
+		err = env.writeCopy(ctx, SourcePrimary, content)
+		if env.mirror != nil {
+			// the mirror is written even if the primary device
+			// failed, that is what it is for
+			err = env.writeMirror(ctx, content, err)
+		}
+		if err != nil {
+			return err
+		}
+		env.corrupt = false
+		env.primaryErr = nil
+		return env.writeSignature(sig)
+	}
+
+	flags := env.flags + 1
+	content := env.image(flags)
+	if err := env.guard(content); err != nil {
+		return err
+	}
+	sig, err := env.signature(content)
+	if err != nil {
 		return err
 	}
+	targets := []int{1 - env.active}
+	if env.active < 0 {
+		// new env or read from the backup, write both copies
+		targets = []int{0, 1}
+	}
+	for _, target := range targets {
+		env.debug("saving redundant env copy", "target", Source(target), "flags", flags)
+		if err := env.writeCopy(ctx, Source(target), content); err != nil {
+			return err
+		}
+		env.active = target
+		if target == 0 {
+			env.primaryErr = nil
+		}
+	}
+	env.flags = flags
+	env.corrupt = false
 
-	return f.Sync()
+	return env.writeSignature(sig)
+}
+
+// writeImage writes content to the given storage
+func (env *Env) writeImage(ctx context.Context, s Storage, content []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	env.countWrite()
+	return env.writeContext(ctx, func() error {
+		if aw, ok := s.(AtomicWriter); ok && env.config.AtomicWrite {
+			env.debug("writing env atomically", "storage", fmt.Sprintf("%T", s), "size", len(content))
+			return aw.WriteAtomic(content)
+		}
+		env.debug("writing env in place", "storage", fmt.Sprintf("%T", s), "size", len(content))
+		if env.config.DifferentialWrite && onlyClearsBits(s, content) {
+			env.debug("skipping erase, the env only clears bits")
+			return s.WriteInPlace(content)
+		}
+		if err := s.Erase(len(content)); err != nil {
+			return err
+		}
+		return s.WriteInPlace(content)
+	})
+}
+
+// onlyClearsBits returns true if writing content to the storage only
+// changes bits from 1 to 0, so that flash can be programmed without an
+// erase. If the current content can not be read the erase is needed.
+func onlyClearsBits(s Storage, content []byte) bool {
+	old, err := s.ReadAll(len(content))
+	if err != nil || len(old) != len(content) {
+		return false
+	}
+	for i := range content {
+		if content[i]&^old[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// lock locks the storage of all copies and returns the function to
+// unlock them. A pending write of an earlier save is waited for
+// first. If a write is pending when unlock is called the storage is
+// only unlocked once it finished. Only a lock for a write may create
+// lock files.
+func (env *Env) lock(ctx context.Context, write bool) (unlock func() error, err error) {
+	if env.pending != nil {
+		select {
+		case <-env.pending:
+			env.pending = nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	unlockAll, err := env.lockStorages(ctx, write)
+	if err != nil {
+		return nil, err
+	}
+	return func() error {
+		if pending := env.pending; pending != nil {
+			go func() {
+				<-pending
+				unlockAll()
+			}()
+			return nil
+		}
+		return unlockAll()
+	}, nil
+}
+
+// lockStorages locks the storage of every copy and the mirror, storage
+// that is shared by several copies is only locked once. Copies other
+// than the primary one that do not exist are not locked, they are
+// reported when they are read.
+func (env *Env) lockStorages(ctx context.Context, write bool) (unlock func() error, err error) {
+	storages := env.storages
+	if env.mirror != nil {
+		storages = append(storages[:len(storages):len(storages)], env.mirror)
+	}
+	var locked []Storage
+	var unlocks []func() error
+	unlockAll := func() error {
+		var err error
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			if unlockErr := unlocks[i](); unlockErr != nil {
+				err = unlockErr
+			}
+		}
+		return err
+	}
+next:
+	for i, s := range storages {
+		for _, other := range locked {
+			if sameStorage(s, other) {
+				continue next
+			}
+		}
+		lockStorage := s.Lock
+		if al, ok := s.(AtomicLocker); ok && env.config.AtomicWrite {
+			lockStorage = func() (func() error, error) {
+				return al.LockAtomic(write)
+			}
+		}
+		unlock, err := lock(ctx, lockStorage)
+		if i > 0 && os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			unlockAll()
+			return nil, err
+		}
+		locked = append(locked, s)
+		unlocks = append(unlocks, unlock)
+	}
+	return unlockAll, nil
+}
+
+// lock calls the lock function of a storage and returns the function
+// to unlock it
+func lock(ctx context.Context, lockStorage func() (func() error, error)) (unlock func() error, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if ctx.Done() == nil {
+		return lockStorage()
+	}
+
+	type result struct {
+		unlock func() error
+		err    error
+	}
+	resCh := make(chan result, 1)
+	go func() {
+		unlock, err := lockStorage()
+		resCh <- result{unlock, err}
+	}()
+	select {
+	case res := <-resCh:
+		return res.unlock, res.err
+	case <-ctx.Done():
+		// release the lock once it is eventually taken
+		go func() {
+			if res := <-resCh; res.err == nil {
+				res.unlock()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// withContext runs f and returns early with the error of ctx if ctx is
+// done before f returns. There is no way to interrupt a blocked read
+// or write so f keeps running in the background in that case.
+func withContext(ctx context.Context, f func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ctx.Done() == nil {
+		return f()
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- f()
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// writeContext runs the write f like withContext. If ctx is done
+// before f returns the write is pending until f returns, the storage
+// lock is only released after that.
+func (env *Env) writeContext(ctx context.Context, f func() error) error {
+	if ctx.Done() == nil {
+		return f()
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- f()
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		pending := make(chan struct{})
+		env.pending = pending
+		go func() {
+			err := <-errCh
+			env.debug("abandoned env write finished", "error", err)
+			close(pending)
+		}()
+		return ctx.Err()
+	}
 }
 
 // Import is a helper that imports a given text file that contains
@@ -248,8 +1259,13 @@ func (env *Env) Import(r io.Reader) error {
 		if len(l) == 1 {
 			return fmt.Errorf("Invalid line: %q", line)
 		}
+		if l[0] == "" {
+			return fmt.Errorf("cannot import variable with empty name")
+		}
+		if err := checkVar(l[0], l[1], env.config.RejectControl); err != nil {
+			return err
+		}
 		env.data[l[0]] = l[1]
-
 	}
 
 	return scanner.Err()
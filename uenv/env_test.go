@@ -3,6 +3,7 @@ package uenv
 import (
 	"bytes"
 	"hash/crc32"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -77,6 +78,12 @@ func (u *uenvTestSuite) TestImportHasError(c *C) {
 	r := strings.NewReader("foxy")
 	err = env.Import(r)
 	c.Assert(err, ErrorMatches, "Invalid line: \"foxy\"")
+
+	err = env.Import(strings.NewReader("=foxy"))
+	c.Assert(err, ErrorMatches, "cannot import variable with empty name")
+	err = env.Import(strings.NewReader("fox=\x00"))
+	c.Assert(err, ErrorMatches, `invalid variable "fox": value contains a NUL byte`)
+	c.Assert(env.Map(), HasLen, 0)
 }
 
 func (u *uenvTestSuite) TestSetEmptyUnsets(c *C) {
@@ -89,6 +96,91 @@ func (u *uenvTestSuite) TestSetEmptyUnsets(c *C) {
 	c.Assert(env.String(), Equals, "")
 }
 
+func (u *uenvTestSuite) TestSetAll(c *C) {
+	env := New(Config{Size: 4096})
+	env.Set("c", "1")
+
+	c.Assert(env.SetAll("a", "1", "b", "2", "c", ""), IsNil)
+	c.Check(env.String(), Equals, "a=1\nb=2\n")
+	// the pairs before an invalid one stay set
+	c.Check(env.SetAll("d", "1", "e=", "2", "f", "3"), ErrorMatches, `invalid variable "e=": .*`)
+	c.Check(env.String(), Equals, "a=1\nb=2\nd=1\n")
+	c.Check(func() { env.SetAll("a") }, PanicMatches, `SetAll\(\) can not be called with an odd number of arguments: .*`)
+}
+
+func (u *uenvTestSuite) TestValueWithEqualsRoundTrip(c *C) {
+	env, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	env.Set("bootargs", "root=/dev/mmcblk0p2 console=ttyS0,115200")
+	env.Set("eq", "==")
+	c.Assert(env.Save(), IsNil)
+
+	env, err = Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("bootargs"), Equals, "root=/dev/mmcblk0p2 console=ttyS0,115200")
+	c.Check(env.Get("eq"), Equals, "==")
+}
+
+func (u *uenvTestSuite) TestSetInvalid(c *C) {
+	env := New(Config{Size: 4096})
+
+	for _, t := range []struct {
+		name, value, err string
+	}{
+		{"a=b", "1", `invalid variable "a=b": name contains "="`},
+		{"\xffa", "1", `invalid variable "\\xffa": name starts with 0xff`},
+		{"a\x00b", "1", `invalid variable "a\\x00b": name contains a NUL byte`},
+		{"a", "1\x002", `invalid variable "a": value contains a NUL byte`},
+	} {
+		err := env.Set(t.name, t.value)
+		c.Check(err, ErrorMatches, t.err)
+		c.Check(err, FitsTypeOf, &InvalidVarError{})
+		c.Check(err.(*InvalidVarError).Name, Equals, t.name)
+	}
+	c.Check(env.Map(), HasLen, 0)
+
+	// control characters are fine unless they are rejected
+	c.Check(env.Set("bootcmd", "echo\x1b[0m\r\n"), IsNil)
+}
+
+func (u *uenvTestSuite) TestSetRejectControl(c *C) {
+	env := New(Config{Size: 4096, RejectControl: true})
+
+	c.Check(env.Set("script", "echo a\n\techo b"), IsNil)
+	err := env.Set("bootcmd", "echo\r")
+	c.Check(err, ErrorMatches, `invalid variable "bootcmd": value contains a control character`)
+	err = env.Set("boot\tcmd", "1")
+	c.Check(err, ErrorMatches, `invalid variable "boot\\tcmd": name contains a control character`)
+	err = env.Set("bootcmd", "\x7f")
+	c.Check(err, ErrorMatches, `invalid variable "bootcmd": value contains a control character`)
+}
+
+func (u *uenvTestSuite) TestSetMaxVarSize(c *C) {
+	env := New(Config{Size: 4096, MaxVarSize: 8})
+
+	c.Assert(env.Set("foo", "1234"), IsNil)
+	err := env.Set("foo", "12345")
+	c.Assert(err, ErrorMatches, `cannot set "foo": 9 bytes exceed the limit of 8 bytes per variable`)
+	c.Assert(env.Get("foo"), Equals, "1234")
+}
+
+func (u *uenvTestSuite) TestSetReserve(c *C) {
+	env := New(Config{Size: 64, Reserve: 32})
+	// 57 bytes are free in the empty env, 25 of them can be used
+	c.Assert(env.Set("a", strings.Repeat("x", 23)), IsNil)
+	c.Assert(env.Free(), Equals, 32)
+
+	err := env.Set("b", "1")
+	c.Assert(err, ErrorMatches, `cannot set "b": only 28 bytes would be left but 32 bytes are reserved`)
+	err = env.Set("a", strings.Repeat("x", 24))
+	c.Assert(err, ErrorMatches, `cannot set "a": only 31 bytes would be left but 32 bytes are reserved`)
+
+	// shrinking and removing is always possible
+	c.Assert(env.Set("a", "x"), IsNil)
+	c.Assert(env.Set("a", ""), IsNil)
+	c.Assert(env.Free(), Equals, 57)
+}
+
 func (u *uenvTestSuite) makeUbootEnvFromData(c *C, mockData []byte) {
 	w := bytes.NewBuffer(nil)
 	crc := crc32.ChecksumIEEE(mockData)
@@ -138,6 +230,16 @@ func (u *uenvTestSuite) TestErrorOnMalformedData(c *C) {
 	c.Assert(env, IsNil)
 }
 
+func (u *uenvTestSuite) TestErrorOnEmptyName(c *C) {
+	u.makeUbootEnvFromData(c, []byte("=foo\x00a=b\x00\x00"))
+
+	_, err := Open(u.envFile)
+	c.Assert(err, ErrorMatches, `cannot parse line "=foo": empty variable name`)
+	env, err := OpenWithFlags(u.envFile, OpenBestEffort)
+	c.Assert(err, IsNil)
+	c.Assert(env.String(), Equals, "a=b\n")
+}
+
 // ensure that the malformed data is not causing us to panic.
 func (u *uenvTestSuite) TestOpenBestEffort(c *C) {
 	mockData := []byte{
@@ -235,3 +337,443 @@ func (u *uenvTestSuite) TestWritesContentCorrectly(c *C) {
 	c.Assert(env.String(), Equals, "a=b\nc=d\n")
 	c.Assert(env.size, Equals, totalSize)
 }
+
+func (u *uenvTestSuite) TestConfigOffsetAndHeaderSize(c *C) {
+	// the env lives in the middle of a larger image
+	err := ioutil.WriteFile(u.envFile, bytes.Repeat([]byte{0xaa}, 64), 0644)
+	c.Assert(err, IsNil)
+
+	cfg := Config{Size: 16, Offset: 32, HeaderSize: 4}
+	env, err := CreateWithConfig(u.envFile, cfg)
+	c.Assert(err, IsNil)
+	env.Set("a", "b")
+	err = env.Save()
+	c.Assert(err, IsNil)
+
+	content, err := ioutil.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(content, HasLen, 64)
+	// data outside of the env is untouched
+	c.Assert(content[:32], DeepEquals, bytes.Repeat([]byte{0xaa}, 32))
+	c.Assert(content[48:], DeepEquals, bytes.Repeat([]byte{0xaa}, 16))
+	// no flags byte
+	c.Assert(content[36:41], DeepEquals, []byte("a=b\x00\x00"))
+
+	env, err = OpenWithConfig(u.envFile, cfg)
+	c.Assert(err, IsNil)
+	c.Assert(env.String(), Equals, "a=b\n")
+}
+
+func (u *uenvTestSuite) TestCreateWithConfigNeedsSize(c *C) {
+	_, err := CreateWithConfig(u.envFile, Config{})
+	c.Assert(err, ErrorMatches, "cannot create env with size 0")
+	_, err = CreateWithConfig(u.envFile, Config{Size: MaxSize + 1})
+	c.Assert(err, ErrorMatches, "cannot create env with size 67108865")
+}
+
+func (u *uenvTestSuite) TestOpenWithConfigAbsurdConfig(c *C) {
+	env, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	c.Assert(env.Save(), IsNil)
+
+	_, err = OpenWithConfig(u.envFile, Config{Size: -1})
+	c.Assert(err, ErrorMatches, "cannot open env with size -1")
+	_, err = OpenWithConfig(u.envFile, Config{Size: MaxSize + 1})
+	c.Assert(err, ErrorMatches, "cannot open env with size 67108865")
+	_, err = OpenWithConfig(u.envFile, Config{HeaderSize: 2})
+	c.Assert(err, ErrorMatches, "unsupported env header size 2")
+}
+
+func (u *uenvTestSuite) TestOpenWithConfigShortRead(c *C) {
+	err := ioutil.WriteFile(u.envFile, make([]byte, 8), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = OpenWithConfig(u.envFile, Config{Size: 16})
+	c.Assert(err, ErrorMatches, "cannot read env from .* at offset 0: EOF")
+}
+
+var redundantConfig = Config{
+	Size:            16,
+	HeaderSize:      5,
+	Redundant:       true,
+	RedundantOffset: 16,
+}
+
+func (u *uenvTestSuite) TestRedundantSaveAlternatesCopies(c *C) {
+	env, err := CreateWithConfig(u.envFile, redundantConfig)
+	c.Assert(err, IsNil)
+	env.Set("a", "1")
+	// a new env writes both copies
+	c.Assert(env.Save(), IsNil)
+	content, err := ioutil.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(content, HasLen, 32)
+	c.Assert(content[4], Equals, byte(1))
+	c.Assert(content[20], Equals, byte(1))
+
+	env, err = OpenWithConfig(u.envFile, redundantConfig)
+	c.Assert(err, IsNil)
+	c.Assert(env.active, Equals, 0)
+	env.Set("a", "2")
+	c.Assert(env.Save(), IsNil)
+
+	content, err = ioutil.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+	// the primary copy is untouched, the redundant one is newer
+	c.Assert(content[4:9], DeepEquals, []byte("\x01a=1\x00"))
+	c.Assert(content[20:25], DeepEquals, []byte("\x02a=2\x00"))
+
+	env, err = OpenWithConfig(u.envFile, redundantConfig)
+	c.Assert(err, IsNil)
+	c.Assert(env.active, Equals, 1)
+	c.Assert(env.Get("a"), Equals, "2")
+}
+
+func (u *uenvTestSuite) TestRedundantUsesValidCopy(c *C) {
+	env, err := CreateWithConfig(u.envFile, redundantConfig)
+	c.Assert(err, IsNil)
+	env.Set("a", "1")
+	c.Assert(env.Save(), IsNil)
+	env.Set("a", "2")
+	c.Assert(env.Save(), IsNil)
+
+	// corrupt the newer (primary) copy
+	content, err := ioutil.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+	content[6] = 'x'
+	c.Assert(ioutil.WriteFile(u.envFile, content, 0644), IsNil)
+
+	env, err = OpenWithConfig(u.envFile, redundantConfig)
+	c.Assert(err, IsNil)
+	c.Assert(env.active, Equals, 1)
+	c.Assert(env.Get("a"), Equals, "1")
+}
+
+func (u *uenvTestSuite) TestRedundantFlagsWrapAround(c *C) {
+	c.Check(pickValid([]*envCopy{{flags: 0xff}, {flags: 0}}), Equals, 1)
+	c.Check(pickValid([]*envCopy{{flags: 0}, {flags: 0xff}}), Equals, 0)
+	c.Check(pickValid([]*envCopy{{flags: 3}, {flags: 2}}), Equals, 0)
+	c.Check(pickValid([]*envCopy{{flags: 2}, {flags: 3}}), Equals, 1)
+	c.Check(pickValid([]*envCopy{{err: io.EOF}, {err: io.EOF}}), Equals, -1)
+}
+
+func (u *uenvTestSuite) TestRedundantSeparateFile(c *C) {
+	cfg := Config{
+		Size:          16,
+		Redundant:     true,
+		RedundantFile: u.envFile + ".redund",
+	}
+	env, err := CreateWithConfig(u.envFile, cfg)
+	c.Assert(err, IsNil)
+	env.Set("a", "1")
+	c.Assert(env.Save(), IsNil)
+
+	env, err = OpenWithConfig(u.envFile, cfg)
+	c.Assert(err, IsNil)
+	env.Set("a", "2")
+	c.Assert(env.Save(), IsNil)
+
+	content, err := ioutil.ReadFile(cfg.RedundantFile)
+	c.Assert(err, IsNil)
+	c.Assert(content[4:9], DeepEquals, []byte("\x02a=2\x00"))
+}
+
+func (u *uenvTestSuite) TestRedundantNeedsFlags(c *C) {
+	_, err := OpenWithConfig(u.envFile, Config{Size: 16, HeaderSize: 4, Redundant: true})
+	c.Assert(err, ErrorMatches, "redundant env needs a size and a header with flags")
+}
+
+func (u *uenvTestSuite) TestOpenCorruptSalvages(c *C) {
+	env, err := Create(u.envFile, 32)
+	c.Assert(err, IsNil)
+	env.Set("a", "1")
+	env.Set("b", "2")
+	env.Set("c", "3")
+	c.Assert(env.Save(), IsNil)
+
+	// a bit flip in the "=" of "b=2" kills the pair and the CRC
+	content, err := ioutil.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+	content[10] = 'x'
+	c.Assert(ioutil.WriteFile(u.envFile, content, 0644), IsNil)
+
+	_, err = Open(u.envFile)
+	c.Assert(err, ErrorMatches, "bad CRC: .*")
+
+	env, err = OpenCorrupt(u.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.Corrupt(), Equals, true)
+	c.Check(env.String(), Equals, "a=1\nc=3\n")
+
+	// repair
+	env.Set("b", "2")
+	c.Assert(env.Save(), IsNil)
+	c.Check(env.Corrupt(), Equals, false)
+	env, err = Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.Corrupt(), Equals, false)
+	c.Check(env.String(), Equals, "a=1\nb=2\nc=3\n")
+}
+
+func (u *uenvTestSuite) TestOpenCorruptNoEndMarker(c *C) {
+	mockData := []byte("a=1\x00b=2")
+	u.makeUbootEnvFromData(c, mockData)
+	// break the crc
+	content, err := ioutil.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+	content[0]++
+	c.Assert(ioutil.WriteFile(u.envFile, content, 0644), IsNil)
+
+	env, err := OpenCorrupt(u.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.String(), Equals, "a=1\nb=2\n")
+}
+
+func (u *uenvTestSuite) TestOpenNoEndMarker(c *C) {
+	u.makeUbootEnvFromData(c, []byte("a=1"))
+
+	_, err := Open(u.envFile)
+	c.Assert(err, ErrorMatches, "cannot find end of env")
+}
+
+func (u *uenvTestSuite) TestRedundantPrefersValidOverCorrupt(c *C) {
+	env, err := CreateWithConfig(u.envFile, redundantConfig)
+	c.Assert(err, IsNil)
+	env.Set("a", "1")
+	c.Assert(env.Save(), IsNil)
+	env.Set("a", "2")
+	c.Assert(env.Save(), IsNil)
+
+	// corrupt the newer (primary) copy
+	content, err := ioutil.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+	content[8] = 'x'
+	c.Assert(ioutil.WriteFile(u.envFile, content, 0644), IsNil)
+
+	cfg := redundantConfig
+	cfg.Flags = OpenBestEffort | OpenIgnoreCRC
+	env, err = OpenWithConfig(u.envFile, cfg)
+	c.Assert(err, IsNil)
+	c.Check(env.Corrupt(), Equals, false)
+	c.Check(env.Get("a"), Equals, "1")
+}
+
+func (u *uenvTestSuite) corruptByte(c *C, fname string, offset int) {
+	content, err := ioutil.ReadFile(fname)
+	c.Assert(err, IsNil)
+	content[offset] ^= 0xff
+	c.Assert(ioutil.WriteFile(fname, content, 0644), IsNil)
+}
+
+func (u *uenvTestSuite) TestRedundantFallbackAndRepairPrimary(c *C) {
+	env, err := CreateWithConfig(u.envFile, redundantConfig)
+	c.Assert(err, IsNil)
+	env.Set("a", "1")
+	c.Assert(env.Save(), IsNil)
+
+	env, err = OpenWithConfig(u.envFile, redundantConfig)
+	c.Assert(err, IsNil)
+	c.Check(env.Source(), Equals, SourcePrimary)
+	c.Check(env.PrimaryErr(), IsNil)
+	// nothing to repair
+	c.Assert(env.RepairPrimary(), IsNil)
+
+	u.corruptByte(c, u.envFile, 6)
+	env, err = OpenWithConfig(u.envFile, redundantConfig)
+	c.Assert(err, IsNil)
+	c.Check(env.Source(), Equals, SourceRedundant)
+	c.Check(env.PrimaryErr(), ErrorMatches, "bad CRC: .*")
+	c.Check(env.Get("a"), Equals, "1")
+
+	c.Assert(env.RepairPrimary(), IsNil)
+	c.Check(env.PrimaryErr(), IsNil)
+	env, err = OpenWithConfig(u.envFile, redundantConfig)
+	c.Assert(err, IsNil)
+	c.Check(env.Source(), Equals, SourcePrimary)
+	c.Check(env.Get("a"), Equals, "1")
+}
+
+func (u *uenvTestSuite) TestRedundantSaveAll(c *C) {
+	env, err := CreateWithConfig(u.envFile, redundantConfig)
+	c.Assert(err, IsNil)
+	env.Set("a", "1")
+	c.Assert(env.Save(), IsNil)
+
+	u.corruptByte(c, u.envFile, 6)
+	env, err = OpenWithConfig(u.envFile, redundantConfig)
+	c.Assert(err, IsNil)
+	c.Assert(env.SaveAll(), IsNil)
+
+	// both copies are written once with the next flags
+	report, err := Verify(u.envFile, redundantConfig)
+	c.Assert(err, IsNil)
+	c.Check(report.OK(), Equals, true)
+	c.Assert(report.Copies, HasLen, 2)
+	c.Check(report.Copies[0].Flags, Equals, byte(2))
+	c.Check(report.Copies[1].Flags, Equals, byte(2))
+}
+
+func (u *uenvTestSuite) TestBackupFallback(c *C) {
+	backupFile := u.envFile + ".bak"
+	env, err := Create(backupFile, 32)
+	c.Assert(err, IsNil)
+	env.Set("a", "backup")
+	c.Assert(env.Save(), IsNil)
+
+	env, err = Create(u.envFile, 32)
+	c.Assert(err, IsNil)
+	env.Set("a", "primary")
+	c.Assert(env.Save(), IsNil)
+
+	cfg := Config{BackupFile: backupFile}
+	env, err = OpenWithConfig(u.envFile, cfg)
+	c.Assert(err, IsNil)
+	c.Check(env.Source(), Equals, SourcePrimary)
+	c.Check(env.Get("a"), Equals, "primary")
+
+	u.corruptByte(c, u.envFile, 6)
+	env, err = OpenWithConfig(u.envFile, cfg)
+	c.Assert(err, IsNil)
+	c.Check(env.Source(), Equals, SourceBackup)
+	c.Check(env.Get("a"), Equals, "backup")
+
+	c.Assert(env.RepairPrimary(), IsNil)
+	env, err = Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("a"), Equals, "backup")
+}
+
+func (u *uenvTestSuite) TestBackupPreferredOverCorrupt(c *C) {
+	backupFile := u.envFile + ".bak"
+	env, err := Create(backupFile, 32)
+	c.Assert(err, IsNil)
+	env.Set("a", "backup")
+	c.Assert(env.Save(), IsNil)
+
+	env, err = Create(u.envFile, 32)
+	c.Assert(err, IsNil)
+	env.Set("a", "primary")
+	c.Assert(env.Save(), IsNil)
+	u.corruptByte(c, u.envFile, 0)
+
+	env, err = OpenWithConfig(u.envFile, Config{BackupFile: backupFile, Flags: OpenIgnoreCRC})
+	c.Assert(err, IsNil)
+	c.Check(env.Source(), Equals, SourceBackup)
+	c.Check(env.Corrupt(), Equals, false)
+	c.Check(env.PrimaryErr(), ErrorMatches, "bad CRC")
+}
+
+func (u *uenvTestSuite) TestBackupAllBroken(c *C) {
+	_, err := OpenWithConfig(u.envFile, Config{BackupFile: u.envFile + ".bak"})
+	c.Assert(err, ErrorMatches, ".*/uboot.env: no such file or directory")
+}
+
+func (u *uenvTestSuite) TestSourceString(c *C) {
+	c.Check(SourcePrimary.String(), Equals, "primary")
+	c.Check(SourceRedundant.String(), Equals, "redundant")
+	c.Check(SourceBackup.String(), Equals, "backup")
+	c.Check(Source(42).String(), Equals, "Source(42)")
+}
+
+func (u *uenvTestSuite) TestRedundantImage(c *C) {
+	env, err := CreateRedundantImage(u.envFile, 32)
+	c.Assert(err, IsNil)
+	env.Set("a", "1")
+	c.Assert(env.Save(), IsNil)
+
+	content, err := ioutil.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(content, HasLen, 32)
+	c.Check(content[:16], DeepEquals, content[16:])
+
+	env, err = OpenRedundantImage(u.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.size, Equals, 16)
+	env.Set("a", "2")
+	c.Assert(env.Save(), IsNil)
+
+	content, err = ioutil.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+	c.Check(content[4:9], DeepEquals, []byte("\x01a=1\x00"))
+	c.Check(content[20:25], DeepEquals, []byte("\x02a=2\x00"))
+
+	// the newer copy B gets corrupted, copy A is used
+	u.corruptByte(c, u.envFile, 22)
+	env, err = OpenRedundantImage(u.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.Source(), Equals, SourcePrimary)
+	c.Check(env.Get("a"), Equals, "1")
+}
+
+func (u *uenvTestSuite) TestRedundantImageWithOffset(c *C) {
+	c.Assert(ioutil.WriteFile(u.envFile, make([]byte, 40), 0644), IsNil)
+
+	cfg := Config{Offset: 8, RedundantImage: true}
+	_, err := OpenWithConfig(u.envFile, cfg)
+	c.Assert(err, ErrorMatches, "bad CRC: .*")
+
+	cfg.Size = 16
+	env, err := CreateWithConfig(u.envFile, cfg)
+	c.Assert(err, IsNil)
+	env.Set("a", "1")
+	c.Assert(env.Save(), IsNil)
+
+	content, err := ioutil.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+	c.Check(content[:8], DeepEquals, make([]byte, 8))
+	c.Check(content[8:24], DeepEquals, content[24:40])
+
+	env, err = OpenWithConfig(u.envFile, Config{Offset: 8, RedundantImage: true})
+	c.Assert(err, IsNil)
+	c.Check(env.Get("a"), Equals, "1")
+}
+
+func (u *uenvTestSuite) TestRedundantImageOddSize(c *C) {
+	c.Assert(ioutil.WriteFile(u.envFile, make([]byte, 33), 0644), IsNil)
+	_, err := OpenRedundantImage(u.envFile)
+	c.Assert(err, ErrorMatches, "cannot split 33 byte image into two env copies")
+
+	_, err = CreateRedundantImage(u.envFile, 33)
+	c.Assert(err, ErrorMatches, "cannot split 33 byte image into two env copies")
+}
+
+func (u *uenvTestSuite) TestSaveTooLarge(c *C) {
+	env, err := Create(u.envFile, 16)
+	c.Assert(err, IsNil)
+	// 5 bytes header, 10 bytes "a=1234567\0", 1 byte end marker
+	env.Set("a", "1234567")
+	c.Assert(env.Save(), IsNil)
+	env.Set("a", "12345678")
+	c.Assert(env.Save(), ErrorMatches, "env too large: 17 bytes needed but env size is 16")
+
+	_, err = env.MarshalBinary()
+	c.Assert(err, ErrorMatches, "env too large: .*")
+
+	// the previous env is still there
+	env, err = Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("a"), Equals, "1234567")
+}
+
+func (u *uenvTestSuite) TestSizeFreeCRC(c *C) {
+	env, err := Create(u.envFile, 16)
+	c.Assert(err, IsNil)
+	c.Check(env.Size(), Equals, 16)
+	c.Check(env.Free(), Equals, 9)
+	env.Set("a", "b")
+	env.Set("c", "d")
+	c.Check(env.Free(), Equals, 2)
+	c.Check(env.CRC(), Equals, uint32(0xc56bd9c7))
+	env.Set("c", "dddd")
+	c.Check(env.Free(), Equals, -1)
+}
+
+func (u *uenvTestSuite) TestMap(c *C) {
+	env, err := Create(u.envFile, 16)
+	c.Assert(err, IsNil)
+	env.Set("a", "b")
+	m := env.Map()
+	c.Check(m, DeepEquals, map[string]string{"a": "b"})
+	m["a"] = "c"
+	c.Check(env.Get("a"), Equals, "b")
+}
@@ -0,0 +1,122 @@
+package uenv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// The fuzz targets are run with "go test -fuzz=FuzzName ./uenv", the
+// seeds also run as part of the normal tests.
+
+func fuzzImage(hdrSize int, vars map[string]string) []byte {
+	env := New(Config{Size: 256, HeaderSize: hdrSize})
+	for k, v := range vars {
+		env.Set(k, v)
+	}
+	content, err := env.MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	return content
+}
+
+func FuzzParseData(f *testing.F) {
+	f.Add([]byte("foo=bar\x00baz=\x00"), false)
+	f.Add([]byte("foo\x00=\x00\xff\xff"), true)
+	f.Add([]byte("a=b=c\x00\x00\x00"), false)
+	f.Fuzz(func(t *testing.T, data []byte, bestEffort bool) {
+		flags := OpenFlags(0)
+		if bestEffort {
+			flags = OpenBestEffort
+		}
+		out, err := parseData(data, flags)
+		if err != nil {
+			return
+		}
+		for k := range out {
+			if strings.ContainsAny(k, "=\x00") {
+				t.Fatalf("invalid name %q", k)
+			}
+		}
+	})
+}
+
+func FuzzImport(f *testing.F) {
+	f.Add("foo=bar\n#comment\n\nbaz=baz")
+	f.Add("foxy")
+	f.Add("=empty\n")
+	f.Add("a=\x00\n")
+	f.Fuzz(func(t *testing.T, text string) {
+		env := New(Config{Size: 4096})
+		if err := env.Import(strings.NewReader(text)); err != nil {
+			return
+		}
+		checkRoundTrip(t, env)
+	})
+}
+
+func FuzzUnmarshalBinary(f *testing.F) {
+	f.Add(fuzzImage(5, map[string]string{"foo": "bar", "bootcmd": "run a; run b"}), 5)
+	f.Add(fuzzImage(4, map[string]string{"a": "1"}), 4)
+	f.Add([]byte{0, 0, 0, 0}, 5)
+	f.Add([]byte{}, 1)
+	f.Fuzz(func(t *testing.T, content []byte, hdrSize int) {
+		for _, flags := range []OpenFlags{0, OpenBestEffort, OpenIgnoreCRC} {
+			env := New(Config{HeaderSize: hdrSize, Flags: flags})
+			if err := env.UnmarshalBinary(content); err != nil {
+				continue
+			}
+			checkRoundTrip(t, env)
+		}
+	})
+}
+
+func FuzzImportJSON(f *testing.F) {
+	f.Add(`{"foo": "bar", "n": 1, "b": true, "gone": null}`)
+	f.Add(`{"": "x"}`)
+	f.Add(`{"a": [1]}`)
+	f.Fuzz(func(t *testing.T, text string) {
+		env := New(Config{Size: 4096})
+		if err := env.ImportJSON(strings.NewReader(text)); err != nil {
+			return
+		}
+		checkRoundTrip(t, env)
+	})
+}
+
+func FuzzReadDesiredState(f *testing.F) {
+	f.Add("set:\n  bootdelay: 0\n  bootargs: \"console=ttyS0 quiet\"\nunset:\n  - bootcount\n")
+	f.Add(`{"set": {"a": "1"}, "unset": ["b"]}`)
+	f.Add("set:\n  '': 'x'\n")
+	f.Fuzz(func(t *testing.T, text string) {
+		state, err := ReadDesiredState(strings.NewReader(text))
+		if err != nil {
+			return
+		}
+		env := New(Config{Size: 4096})
+		if _, err := env.Apply(state); err != nil {
+			return
+		}
+		checkRoundTrip(t, env)
+	})
+}
+
+// checkRoundTrip checks that an env that was accepted can be written
+// and read back unchanged, if it fits
+func checkRoundTrip(t *testing.T, env *Env) {
+	if env.size == 0 {
+		env.size = 4096
+	}
+	content, err := env.MarshalBinary()
+	if err != nil {
+		return
+	}
+	again := New(Config{HeaderSize: env.config.HeaderSize})
+	if err := again.UnmarshalBinary(content); err != nil {
+		t.Fatalf("cannot read back env: %v", err)
+	}
+	if !bytes.Equal([]byte(again.String()), []byte(env.String())) {
+		t.Fatalf("env changed on round trip: %q != %q", again.String(), env.String())
+	}
+}
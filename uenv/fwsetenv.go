@@ -0,0 +1,75 @@
+package uenv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// FwSetenvArgs returns the desired state of the arguments of fw_setenv
+// from libubootenv: pairs of name and value, a name without a value
+// removes the variable. Later arguments win over earlier ones.
+func FwSetenvArgs(args []string) (*DesiredState, error) {
+	state := &DesiredState{Set: make(map[string]string)}
+	for i := 0; i < len(args); i += 2 {
+		value := ""
+		if i+1 < len(args) {
+			value = args[i+1]
+		}
+		if err := state.add(args[i], value); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// ReadFwSetenvScript reads the script of "fw_setenv -s": every line is
+// a name and a value separated by spaces or tabs, a name without a
+// value removes the variable. Empty lines and lines starting with "#"
+// are skipped. Later lines win over earlier ones.
+func ReadFwSetenvScript(r io.Reader) (*DesiredState, error) {
+	state := &DesiredState{Set: make(map[string]string)}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, MaxSize)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimLeft(scanner.Text(), " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value := line, ""
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			name, value = line[:i], strings.TrimLeft(line[i:], " \t")
+		}
+		if err := state.add(name, value); err != nil {
+			return nil, fmt.Errorf("cannot parse line %v: %v", n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// add sets name to value in the state or unsets it if value is empty
+func (state *DesiredState) add(name, value string) error {
+	if name == "" {
+		return fmt.Errorf("empty variable name")
+	}
+	if strings.Contains(name, "=") {
+		return fmt.Errorf("invalid variable name %q", name)
+	}
+	delete(state.Set, name)
+	for i, unset := range state.Unset {
+		if unset == name {
+			state.Unset = append(state.Unset[:i], state.Unset[i+1:]...)
+			break
+		}
+	}
+	if value == "" {
+		state.Unset = append(state.Unset, name)
+		return nil
+	}
+	state.Set[name] = value
+	return nil
+}
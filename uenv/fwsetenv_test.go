@@ -0,0 +1,60 @@
+package uenv
+
+import (
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type fwSetenvTestSuite struct{}
+
+var _ = Suite(&fwSetenvTestSuite{})
+
+func (s *fwSetenvTestSuite) TestFwSetenvArgs(c *C) {
+	state, err := FwSetenvArgs([]string{"bootdelay", "0", "bootargs", "console=ttyS0 quiet", "bootcount"})
+	c.Assert(err, IsNil)
+	c.Check(state, DeepEquals, &DesiredState{
+		Set:   map[string]string{"bootdelay": "0", "bootargs": "console=ttyS0 quiet"},
+		Unset: []string{"bootcount"},
+	})
+
+	state, err = FwSetenvArgs([]string{"a", "1", "a", "", "b", "", "b", "2"})
+	c.Assert(err, IsNil)
+	c.Check(state, DeepEquals, &DesiredState{Set: map[string]string{"b": "2"}, Unset: []string{"a"}})
+
+	_, err = FwSetenvArgs([]string{"a=b", "1"})
+	c.Check(err, ErrorMatches, `invalid variable name "a=b"`)
+}
+
+func (s *fwSetenvTestSuite) TestReadFwSetenvScript(c *C) {
+	script := `# comment
+bootdelay 0
+bootargs	console=ttyS0,115200   quiet
+
+  bootcount
+bootcmd   run distro_bootcmd
+`
+	state, err := ReadFwSetenvScript(strings.NewReader(script))
+	c.Assert(err, IsNil)
+	c.Check(state, DeepEquals, &DesiredState{
+		Set: map[string]string{
+			"bootdelay": "0",
+			"bootargs":  "console=ttyS0,115200   quiet",
+			"bootcmd":   "run distro_bootcmd",
+		},
+		Unset: []string{"bootcount"},
+	})
+
+	_, err = ReadFwSetenvScript(strings.NewReader("a 1\nb=c 2\n"))
+	c.Check(err, ErrorMatches, `cannot parse line 2: invalid variable name "b=c"`)
+}
+
+func (s *fwSetenvTestSuite) TestApplyFwSetenvScript(c *C) {
+	env := New(Config{Size: 4096})
+	c.Assert(env.Set("bootcount", "3"), IsNil)
+	state, err := ReadFwSetenvScript(strings.NewReader("bootcount\nbootdelay 0\n"))
+	c.Assert(err, IsNil)
+	_, err = env.Apply(state)
+	c.Assert(err, IsNil)
+	c.Check(env.Map(), DeepEquals, map[string]string{"bootdelay": "0"})
+}
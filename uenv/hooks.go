@@ -0,0 +1,51 @@
+package uenv
+
+import (
+	"fmt"
+)
+
+// PreSaveHook is called before the env is written, e.g. to take a lock
+// in another subsystem. If it returns an error the env is not written.
+type PreSaveHook func(env *Env) error
+
+// PostSaveHook is called after the env was written or the save failed,
+// err is the error of the save or nil, e.g. to release a lock, sync
+// the filesystem or notify an update agent.
+type PostSaveHook func(env *Env, err error) error
+
+// AddPreSaveHook adds a hook that runs before every Save, hooks run in
+// the order they were added.
+func (env *Env) AddPreSaveHook(hook PreSaveHook) {
+	env.preSave = append(env.preSave, hook)
+}
+
+// AddPostSaveHook adds a hook that runs after every Save, also after a
+// failed Save or a failed pre-save hook so that what the pre-save hooks
+// did can be undone. Hooks run in the order they were added, if the
+// save succeeded Save returns the first error of a hook.
+func (env *Env) AddPostSaveHook(hook PostSaveHook) {
+	env.postSave = append(env.postSave, hook)
+}
+
+// runHooks runs save between the pre-save and the post-save hooks
+func (env *Env) runHooks(save func() error) error {
+	err := env.runPreSave()
+	if err == nil {
+		err = save()
+	}
+	for _, hook := range env.postSave {
+		if hookErr := hook(env, err); hookErr != nil && err == nil {
+			err = fmt.Errorf("post-save hook failed: %v", hookErr)
+		}
+	}
+	return err
+}
+
+func (env *Env) runPreSave() error {
+	for _, hook := range env.preSave {
+		if err := hook(env); err != nil {
+			return fmt.Errorf("cannot save env: pre-save hook failed: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package uenv
+
+import (
+	"fmt"
+
+	. "gopkg.in/check.v1"
+)
+
+type hooksTestSuite struct {
+	storage *MemoryStorage
+	env     *Env
+}
+
+var _ = Suite(&hooksTestSuite{})
+
+func (s *hooksTestSuite) SetUpTest(c *C) {
+	s.storage = NewMemoryStorage(nil)
+	env, err := CreateStorage(s.storage, Config{Size: 64})
+	c.Assert(err, IsNil)
+	c.Assert(env.Save(), IsNil)
+	s.env = env
+}
+
+func (s *hooksTestSuite) TestHooksOrder(c *C) {
+	var calls []string
+	s.env.AddPreSaveHook(func(env *Env) error {
+		calls = append(calls, "pre1")
+		return nil
+	})
+	s.env.AddPreSaveHook(func(env *Env) error {
+		// the env is not written yet
+		saved, err := OpenStorage(s.storage, Config{Size: 64})
+		c.Assert(err, IsNil)
+		calls = append(calls, "pre2 "+saved.Get("a"))
+		return nil
+	})
+	s.env.AddPostSaveHook(func(env *Env, err error) error {
+		saved, openErr := OpenStorage(s.storage, Config{Size: 64})
+		c.Assert(openErr, IsNil)
+		calls = append(calls, fmt.Sprintf("post %s %v", saved.Get("a"), err))
+		return nil
+	})
+	c.Assert(s.env.Set("a", "1"), IsNil)
+	c.Assert(s.env.Save(), IsNil)
+	c.Check(calls, DeepEquals, []string{"pre1", "pre2 ", "post 1 <nil>"})
+}
+
+func (s *hooksTestSuite) TestPreSaveHookAborts(c *C) {
+	var postErr error
+	s.env.AddPreSaveHook(func(env *Env) error {
+		return fmt.Errorf("locked")
+	})
+	s.env.AddPostSaveHook(func(env *Env, err error) error {
+		postErr = err
+		return nil
+	})
+	c.Assert(s.env.Set("a", "1"), IsNil)
+	c.Check(s.env.Save(), ErrorMatches, "cannot save env: pre-save hook failed: locked")
+	c.Check(postErr, ErrorMatches, "cannot save env: pre-save hook failed: locked")
+
+	saved, err := OpenStorage(s.storage, Config{Size: 64})
+	c.Assert(err, IsNil)
+	c.Check(saved.Get("a"), Equals, "")
+}
+
+func (s *hooksTestSuite) TestPostSaveHookError(c *C) {
+	s.env.AddPostSaveHook(func(env *Env, err error) error {
+		return fmt.Errorf("cannot notify")
+	})
+	c.Assert(s.env.Set("a", "1"), IsNil)
+	c.Check(s.env.Save(), ErrorMatches, "post-save hook failed: cannot notify")
+
+	// the env was written anyway
+	saved, err := OpenStorage(s.storage, Config{Size: 64})
+	c.Assert(err, IsNil)
+	c.Check(saved.Get("a"), Equals, "1")
+}
+
+func (s *hooksTestSuite) TestPostSaveHookGetsSaveError(c *C) {
+	env := New(Config{Size: 64})
+	var postErr error
+	env.AddPostSaveHook(func(env *Env, err error) error {
+		postErr = err
+		return fmt.Errorf("ignored")
+	})
+	c.Check(env.Save(), ErrorMatches, "cannot save env that is not backed by a file")
+	c.Check(postErr, ErrorMatches, "cannot save env that is not backed by a file")
+}
@@ -0,0 +1,93 @@
+package uenv
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// ImportJSON imports the variables of a JSON object like the one
+// written by ExportJSON or fw_printenv --json into the uboot env.
+// Numbers and booleans are imported as they are written, a null
+// value removes the variable.
+func (env *Env) ImportJSON(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	var vars map[string]interface{}
+	if err := dec.Decode(&vars); err != nil {
+		return fmt.Errorf("cannot decode JSON env: %v", err)
+	}
+	data := make(map[string]string, len(vars))
+	for k, v := range vars {
+		if k == "" {
+			return fmt.Errorf("cannot import variable with empty name")
+		}
+		switch v := v.(type) {
+		case nil:
+			continue
+		case string:
+			data[k] = v
+		case json.Number:
+			data[k] = v.String()
+		case bool:
+			data[k] = fmt.Sprint(v)
+		default:
+			return fmt.Errorf("cannot import variable %q: unsupported JSON value %v", k, v)
+		}
+		if err := checkVar(k, data[k], env.config.RejectControl); err != nil {
+			return err
+		}
+	}
+
+	for k, v := range vars {
+		if v == nil {
+			delete(env.data, k)
+		}
+	}
+	for k, v := range data {
+		env.data[k] = v
+	}
+	return nil
+}
+
+// ExportJSON writes all variables as a JSON object with sorted keys
+// that can be read back with ImportJSON. The keys are in the Order of
+// the config if it has one.
+func (env *Env) ExportJSON(w io.Writer) error {
+	if env.config.Order != nil {
+		return env.exportOrderedJSON(w)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(env.data)
+}
+
+// exportOrderedJSON writes the JSON object of ExportJSON with the keys
+// in the Order of the config, encoding a map always sorts the keys
+func (env *Env) exportOrderedJSON(w io.Writer) error {
+	compact := bytes.NewBuffer(nil)
+	enc := json.NewEncoder(compact)
+	enc.SetEscapeHTML(false)
+	compact.WriteByte('{')
+	for i, k := range env.keys() {
+		if i > 0 {
+			compact.WriteByte(',')
+		}
+		// Encode cannot fail for strings
+		enc.Encode(k)
+		compact.WriteByte(':')
+		enc.Encode(env.data[k])
+	}
+	compact.WriteByte('}')
+
+	out := bytes.NewBuffer(nil)
+	if err := json.Indent(out, compact.Bytes(), "", "  "); err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+	_, err := out.WriteTo(w)
+	return err
+}
@@ -0,0 +1,66 @@
+package uenv
+
+import (
+	"bytes"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type jsonTestSuite struct{}
+
+var _ = Suite(&jsonTestSuite{})
+
+func (s *jsonTestSuite) TestExportImportRoundTrip(c *C) {
+	env := New(Config{Size: 4096})
+	env.Set("bootcmd", "run a && run b")
+	env.Set("bootargs", "console=ttyS0,115200 root=/dev/mmcblk0p2")
+	env.Set("quoted", "say \"hi\"\tnow")
+
+	buf := bytes.NewBuffer(nil)
+	err := env.ExportJSON(buf)
+	c.Assert(err, IsNil)
+	c.Check(buf.String(), Equals, `{
+  "bootargs": "console=ttyS0,115200 root=/dev/mmcblk0p2",
+  "bootcmd": "run a && run b",
+  "quoted": "say \"hi\"\tnow"
+}
+`)
+
+	env2 := New(Config{Size: 4096})
+	err = env2.ImportJSON(buf)
+	c.Assert(err, IsNil)
+	c.Check(env2.Map(), DeepEquals, env.Map())
+}
+
+func (s *jsonTestSuite) TestImportJSONMerges(c *C) {
+	env := New(Config{Size: 4096})
+	env.Set("keep", "1")
+	env.Set("gone", "1")
+
+	err := env.ImportJSON(strings.NewReader(`{"gone": null, "bootdelay": 3, "silent": true, "new": "x"}`))
+	c.Assert(err, IsNil)
+	c.Check(env.Map(), DeepEquals, map[string]string{
+		"keep":      "1",
+		"bootdelay": "3",
+		"silent":    "true",
+		"new":       "x",
+	})
+}
+
+func (s *jsonTestSuite) TestImportJSONErrors(c *C) {
+	env := New(Config{Size: 4096})
+	env.Set("foo", "bar")
+
+	err := env.ImportJSON(strings.NewReader(`["foo"]`))
+	c.Check(err, ErrorMatches, "cannot decode JSON env: .*")
+	err = env.ImportJSON(strings.NewReader(`{"a": "1", "b": {"c": "d"}}`))
+	c.Check(err, ErrorMatches, `cannot import variable "b": unsupported JSON value .*`)
+	err = env.ImportJSON(strings.NewReader(`{"": "1"}`))
+	c.Check(err, ErrorMatches, "cannot import variable with empty name")
+	err = env.ImportJSON(strings.NewReader(`{"a": "1\u00002"}`))
+	c.Check(err, ErrorMatches, `invalid variable "a": value contains a NUL byte`)
+
+	// nothing is imported on error
+	c.Check(env.Map(), DeepEquals, map[string]string{"foo": "bar"})
+}
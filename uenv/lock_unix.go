@@ -0,0 +1,37 @@
+//go:build !windows
+
+package uenv
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive flock on f, unlock releases it by
+// closing f
+func lockFile(f *os.File) (unlock func() error, err error) {
+	if err := lockFd(f); err != nil {
+		return nil, err
+	}
+	return f.Close, nil
+}
+
+// lockFd takes an exclusive flock on f
+func lockFd(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
+
+// unlockFd releases the flock on f
+func unlockFd(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
+
+// syncDir makes changes to the entries of dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,61 @@
+package uenv
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+// lockfileExclusiveLock is LOCKFILE_EXCLUSIVE_LOCK
+const lockfileExclusiveLock = 2
+
+// Locks on Windows are mandatory and block reads and writes of other
+// handles, so like sqlite a single byte far beyond the end of the file
+// is locked instead of the env itself.
+const (
+	lockOffsetLow  = 0xfffffffe
+	lockOffsetHigh = 0x7fffffff
+)
+
+// lockFile takes an exclusive LockFileEx lock on f, unlock releases
+// it and closes f
+func lockFile(f *os.File) (unlock func() error, err error) {
+	if err := lockFd(f); err != nil {
+		return nil, err
+	}
+	return func() error {
+		unlockFd(f)
+		return f.Close()
+	}, nil
+}
+
+// lockFd takes an exclusive LockFileEx lock on f
+func lockFd(f *os.File) error {
+	ol := &syscall.Overlapped{Offset: lockOffsetLow, OffsetHigh: lockOffsetHigh}
+	r, _, err := procLockFileEx.Call(f.Fd(), lockfileExclusiveLock, 0, 1, 0, uintptr(unsafe.Pointer(ol)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// unlockFd releases the LockFileEx lock on f
+func unlockFd(f *os.File) error {
+	ol := &syscall.Overlapped{Offset: lockOffsetLow, OffsetHigh: lockOffsetHigh}
+	r, _, err := procUnlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(ol)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// syncDir does nothing, directories can not be synced on Windows
+func syncDir(dir string) error {
+	return nil
+}
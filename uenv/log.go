@@ -0,0 +1,16 @@
+package uenv
+
+// Logger receives debug events about what the library does, e.g. which
+// copy was read and how many bytes got written. The arguments are
+// alternating keys and values, this matches the Debug method of
+// *slog.Logger so it can be used directly.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+}
+
+func (env *Env) debug(msg string, args ...interface{}) {
+	if env.config.Logger == nil {
+		return
+	}
+	env.config.Logger.Debug(msg, args...)
+}
@@ -0,0 +1,58 @@
+package uenv
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type logTestSuite struct{}
+
+var _ = Suite(&logTestSuite{})
+
+type testLogger struct {
+	events []string
+}
+
+func (l *testLogger) Debug(msg string, args ...interface{}) {
+	var kv []string
+	for i := 0; i+1 < len(args); i += 2 {
+		kv = append(kv, fmt.Sprintf("%v=%v", args[i], args[i+1]))
+	}
+	l.events = append(l.events, strings.TrimSpace(msg+" "+strings.Join(kv, " ")))
+}
+
+func (s *logTestSuite) TestLogger(c *C) {
+	logger := &testLogger{}
+	envFile := filepath.Join(c.MkDir(), "uboot.env")
+	c.Assert(ioutil.WriteFile(envFile, make([]byte, 32), 0644), IsNil)
+	cfg := Config{Size: 16, Redundant: true, RedundantOffset: 16, Logger: logger}
+
+	_, err := OpenWithConfig(envFile, cfg)
+	c.Assert(err, ErrorMatches, "bad CRC: .*")
+
+	env, err := CreateWithConfig(envFile, cfg)
+	c.Assert(err, IsNil)
+	c.Assert(env.Save(), IsNil)
+	_, err = OpenWithConfig(envFile, cfg)
+	c.Assert(err, IsNil)
+
+	c.Check(logger.events, DeepEquals, []string{
+		"opening env file=" + envFile + " storage=*uenv.FileStorage redundant=true",
+		"read env copy source=primary size=16 crc-ok=false error=bad CRC: 0 != 1804055020",
+		"read env copy source=redundant size=16 crc-ok=false error=bad CRC: 0 != 1804055020",
+		"no usable env copy error=bad CRC: 0 != 1804055020",
+		"saving redundant env copy target=primary flags=1",
+		"writing env in place storage=*uenv.FileStorage size=16",
+		"saving redundant env copy target=redundant flags=1",
+		"writing env in place storage=*uenv.FileStorage size=16",
+		"opening env file=" + envFile + " storage=*uenv.FileStorage redundant=true",
+		"read env copy source=primary size=16 crc-ok=true error=<nil>",
+		"read env copy source=redundant size=16 crc-ok=true error=<nil>",
+		"using env copy source=primary size=16 flags=1 corrupt=false",
+		"implausible env size size=16",
+	})
+}
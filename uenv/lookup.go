@@ -0,0 +1,162 @@
+package uenv
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+)
+
+// Lookup returns the value of a single variable of the env file
+// without parsing the whole env. The env is streamed once and its CRC
+// is verified along the way, the value is only returned if the CRC is
+// valid. This is meant for tools that need a single variable early in
+// the boot, like snap_mode or bootcount.
+func Lookup(fname, name string) (value string, ok bool, err error) {
+	return LookupWithConfig(fname, name, Config{})
+}
+
+// LookupWithConfig is like Lookup for an env with the given config.
+// Redundant envs and envs with a Transform, a Signer or a Checksum can
+// not be streamed, they are opened and parsed as a whole.
+func LookupWithConfig(fname, name string, cfg Config) (value string, ok bool, err error) {
+	if name == "" || strings.Contains(name, "=") {
+		return "", false, fmt.Errorf("cannot look up invalid variable name %q", name)
+	}
+	if cfg.Redundant || cfg.RedundantImage || cfg.Transform != nil || cfg.Signer != nil || cfg.Checksum != nil || cfg.Storage != nil {
+		env, err := OpenWithConfig(fname, cfg)
+		if err != nil {
+			return "", false, err
+		}
+		value, ok = env.data[name]
+		return value, ok, nil
+	}
+
+	hdrSize := cfg.HeaderSize
+	if hdrSize == 0 {
+		hdrSize = headerSize
+	}
+	if err := checkHeaderSize(hdrSize); err != nil {
+		return "", false, err
+	}
+	f, err := os.Open(fname)
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+	size := int64(cfg.Size)
+	if size == 0 {
+		st, err := f.Stat()
+		if err != nil {
+			return "", false, err
+		}
+		size = st.Size() - cfg.Offset
+	}
+	if size < int64(hdrSize) {
+		return "", false, fmt.Errorf("env too small: %v bytes", size)
+	}
+
+	r := io.NewSectionReader(f, cfg.Offset, size)
+	hdr := make([]byte, hdrSize)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return "", false, fmt.Errorf("cannot read env header: %v", err)
+	}
+	h := crc32.NewIEEE()
+	sc := &lookupScanner{prefix: []byte(name + "="), atStart: true}
+	n, err := io.Copy(io.MultiWriter(h, sc), r)
+	if err != nil {
+		return "", false, err
+	}
+	if n != size-int64(hdrSize) {
+		return "", false, fmt.Errorf("cannot read env: %v bytes expected but only %v bytes found", size-int64(hdrSize), n)
+	}
+	if crc, actualCRC := readUint32(hdr), h.Sum32(); crc != actualCRC {
+		return "", false, fmt.Errorf("bad CRC: %v != %v", crc, actualCRC)
+	}
+	if sc.err != nil {
+		return "", false, sc.err
+	}
+	if !sc.done {
+		return "", false, fmt.Errorf("cannot find end of env")
+	}
+	return sc.value, sc.found, nil
+}
+
+// lookupScanner scans the payload of an env for a single variable, it
+// only keeps the value of that variable in memory
+type lookupScanner struct {
+	prefix []byte
+	// matched is the number of bytes of the current entry that
+	// match prefix, it is -1 if the entry does not match
+	matched int
+	atStart bool
+	sawEq   bool
+	// emptyName is set if the entry starts with "="
+	emptyName bool
+	current   []byte
+
+	value string
+	found bool
+	done  bool
+	err   error
+}
+
+func (sc *lookupScanner) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if sc.done {
+			break
+		}
+		if b == 0 {
+			sc.endEntry()
+			continue
+		}
+		if sc.atStart && b == 0xff {
+			// skipped like parseData does
+			sc.matched = -1
+			sc.sawEq = true
+		}
+		if sc.atStart && b == '=' {
+			sc.emptyName = true
+		}
+		sc.atStart = false
+		if b == '=' {
+			sc.sawEq = true
+		}
+		switch {
+		case sc.matched == len(sc.prefix):
+			sc.current = append(sc.current, b)
+		case sc.matched >= 0 && sc.prefix[sc.matched] == b:
+			sc.matched++
+		default:
+			sc.matched = -1
+		}
+	}
+	return len(p), nil
+}
+
+// endEntry handles the \0 at the end of an entry, an empty entry ends
+// the env
+func (sc *lookupScanner) endEntry() {
+	if sc.atStart {
+		sc.done = true
+		return
+	}
+	if !sc.sawEq && sc.err == nil {
+		sc.err = fmt.Errorf("cannot parse env: entry without \"=\"")
+	}
+	if sc.emptyName && sc.err == nil {
+		// rejected like parseData does
+		sc.err = fmt.Errorf("cannot parse env: entry with empty variable name")
+	}
+	if sc.matched == len(sc.prefix) {
+		// like in the map of a parsed env the last entry wins
+		sc.value = string(sc.current)
+		sc.found = true
+	}
+	sc.current = sc.current[:0]
+	sc.matched = 0
+	sc.atStart = true
+	sc.emptyName = false
+	sc.sawEq = false
+}
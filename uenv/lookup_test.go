@@ -0,0 +1,123 @@
+package uenv
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type lookupTestSuite struct {
+	envFile string
+}
+
+var _ = Suite(&lookupTestSuite{})
+
+func (s *lookupTestSuite) SetUpTest(c *C) {
+	s.envFile = filepath.Join(c.MkDir(), "uboot.env")
+	env, err := Create(s.envFile, 4096)
+	c.Assert(err, IsNil)
+	c.Assert(env.Set("snap_mode", "try"), IsNil)
+	c.Assert(env.Set("snap_core", "core_42.snap"), IsNil)
+	c.Assert(env.Set("snap", "prefix of the others"), IsNil)
+	c.Assert(env.Set("long", strings.Repeat("x", 3000)), IsNil)
+	c.Assert(env.Save(), IsNil)
+}
+
+func (s *lookupTestSuite) TestLookup(c *C) {
+	for name, expected := range map[string]string{
+		"snap_mode": "try",
+		"snap_core": "core_42.snap",
+		"snap":      "prefix of the others",
+		"long":      strings.Repeat("x", 3000),
+	} {
+		value, ok, err := Lookup(s.envFile, name)
+		c.Assert(err, IsNil)
+		c.Check(ok, Equals, true)
+		c.Check(value, Equals, expected)
+	}
+
+	_, ok, err := Lookup(s.envFile, "snap_m")
+	c.Assert(err, IsNil)
+	c.Check(ok, Equals, false)
+	_, _, err = Lookup(s.envFile, "a=b")
+	c.Check(err, ErrorMatches, `cannot look up invalid variable name "a=b"`)
+}
+
+func (s *lookupTestSuite) TestLookupBadCRC(c *C) {
+	content, err := ioutil.ReadFile(s.envFile)
+	c.Assert(err, IsNil)
+	content[100] ^= 0xff
+	c.Assert(ioutil.WriteFile(s.envFile, content, 0644), IsNil)
+
+	_, _, err = Lookup(s.envFile, "snap_mode")
+	c.Check(err, ErrorMatches, "bad CRC: .*")
+}
+
+func (s *lookupTestSuite) TestLookupWithConfig(c *C) {
+	fname := filepath.Join(c.MkDir(), "disk.img")
+	c.Assert(ioutil.WriteFile(fname, make([]byte, 256), 0644), IsNil)
+	cfg := Config{Size: 64, Offset: 128, HeaderSize: 4}
+	env, err := CreateWithConfig(fname, cfg)
+	c.Assert(err, IsNil)
+	c.Assert(env.Set("bootcount", "3"), IsNil)
+	c.Assert(env.Save(), IsNil)
+
+	value, ok, err := LookupWithConfig(fname, "bootcount", cfg)
+	c.Assert(err, IsNil)
+	c.Check(ok, Equals, true)
+	c.Check(value, Equals, "3")
+
+	// redundant envs are parsed as a whole
+	fname = filepath.Join(c.MkDir(), "redundant.img")
+	env, err = CreateRedundantImage(fname, 128)
+	c.Assert(err, IsNil)
+	c.Assert(env.Set("bootcount", "4"), IsNil)
+	c.Assert(env.Save(), IsNil)
+	value, ok, err = LookupWithConfig(fname, "bootcount", Config{RedundantImage: true})
+	c.Assert(err, IsNil)
+	c.Check(ok, Equals, true)
+	c.Check(value, Equals, "4")
+}
+
+func (s *lookupTestSuite) TestLookupErrors(c *C) {
+	dir := c.MkDir()
+	_, _, err := Lookup(filepath.Join(dir, "missing"), "a")
+	c.Check(err, NotNil)
+
+	fname := filepath.Join(dir, "small")
+	c.Assert(ioutil.WriteFile(fname, []byte{1, 2}, 0644), IsNil)
+	_, _, err = Lookup(fname, "a")
+	c.Check(err, ErrorMatches, "env too small: 2 bytes")
+
+	_, _, err = LookupWithConfig(s.envFile, "a", Config{HeaderSize: 3})
+	c.Check(err, ErrorMatches, "unsupported env header size 3")
+	_, _, err = LookupWithConfig(s.envFile, "a", Config{Size: 8192})
+	c.Check(err, ErrorMatches, "cannot read env: 8187 bytes expected but only 4091 bytes found")
+
+	// a valid CRC over garbage
+	env := New(Config{Size: 16})
+	content, err := env.MarshalBinary()
+	c.Assert(err, IsNil)
+	copy(content[5:], "garbage\x00\x00")
+	copy(content, writeUint32(env.config.checksum()(content[5:])))
+	c.Assert(ioutil.WriteFile(fname, content, 0644), IsNil)
+	_, _, err = Lookup(fname, "a")
+	c.Check(err, ErrorMatches, `cannot parse env: entry without "="`)
+
+	// an empty name is rejected like by Open
+	copy(content[5:], "=x\x00a=1\x00\x00")
+	copy(content, writeUint32(env.config.checksum()(content[5:])))
+	c.Assert(ioutil.WriteFile(fname, content, 0644), IsNil)
+	_, _, err = Lookup(fname, "a")
+	c.Check(err, ErrorMatches, "cannot parse env: entry with empty variable name")
+	_, err = Open(fname)
+	c.Check(err, ErrorMatches, `cannot parse line "=x": empty variable name`)
+
+	copy(content[5:], "a=1\x00"+strings.Repeat("\xff", 7))
+	copy(content, writeUint32(env.config.checksum()(content[5:])))
+	c.Assert(ioutil.WriteFile(fname, content, 0644), IsNil)
+	_, _, err = Lookup(fname, "a")
+	c.Check(err, ErrorMatches, "cannot find end of env")
+}
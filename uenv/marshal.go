@@ -0,0 +1,98 @@
+package uenv
+
+import (
+	"bytes"
+	"encoding"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+var (
+	_ io.WriterTo                = (*Env)(nil)
+	_ io.ReaderFrom              = (*Env)(nil)
+	_ encoding.BinaryMarshaler   = (*Env)(nil)
+	_ encoding.BinaryUnmarshaler = (*Env)(nil)
+	_ encoding.TextMarshaler     = (*Env)(nil)
+	_ encoding.TextUnmarshaler   = (*Env)(nil)
+)
+
+// MarshalBinary returns the env image as it is written by Save. For
+// redundant envs this is a single copy.
+func (env *Env) MarshalBinary() ([]byte, error) {
+	if env.size < env.headerSize()+2 || env.size > MaxSize {
+		return nil, fmt.Errorf("cannot marshal env with size %v", env.size)
+	}
+	if err := env.checkSize(); err != nil {
+		return nil, err
+	}
+	flags := env.flags
+	if env.config.Redundant {
+		flags++
+	}
+	return env.image(flags), nil
+}
+
+// UnmarshalBinary replaces the env data with the data from the given
+// env image. The size of the env becomes the size of the image.
+func (env *Env) UnmarshalBinary(content []byte) error {
+	data, corrupt, err := parseImage(content, env.headerSize(), env.config.Flags, env.config.Transform, env.config.checksum())
+	if err != nil {
+		return err
+	}
+	env.data = data
+	env.size = len(content)
+	env.config.Size = env.size
+	env.corrupt = corrupt
+	if env.headerSize() > 4 {
+		env.flags = content[4]
+	}
+	return nil
+}
+
+// WriteTo writes the env image to w.
+func (env *Env) WriteTo(w io.Writer) (int64, error) {
+	content, err := env.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(content)
+	return int64(n), err
+}
+
+// ReadFrom reads a env image from r until EOF. Images larger than
+// MaxSize are rejected.
+func (env *Env) ReadFrom(r io.Reader) (int64, error) {
+	content, err := ioutil.ReadAll(io.LimitReader(r, MaxSize+1))
+	if err != nil {
+		return int64(len(content)), err
+	}
+	if len(content) > MaxSize {
+		return int64(len(content)), fmt.Errorf("cannot read env image larger than %v bytes", MaxSize)
+	}
+	return int64(len(content)), env.UnmarshalBinary(content)
+}
+
+// MarshalText returns the env as "key=value" lines, like String.
+func (env *Env) MarshalText() ([]byte, error) {
+	return []byte(env.String()), nil
+}
+
+// UnmarshalText replaces the env data with the "key=value" lines
+// from text, see Import.
+func (env *Env) UnmarshalText(text []byte) error {
+	old := env.data
+	env.data = make(map[string]string)
+	if err := env.Import(bytes.NewReader(text)); err != nil {
+		env.data = old
+		return err
+	}
+	return nil
+}
+
+func (env *Env) headerSize() int {
+	if env.config.HeaderSize == 0 {
+		return headerSize
+	}
+	return env.config.HeaderSize
+}
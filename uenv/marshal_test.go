@@ -0,0 +1,124 @@
+package uenv
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+type marshalTestSuite struct{}
+
+var _ = Suite(&marshalTestSuite{})
+
+func (s *marshalTestSuite) TestMarshalBinaryMatchesSave(c *C) {
+	envFile := filepath.Join(c.MkDir(), "uboot.env")
+	env, err := Create(envFile, 64)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	content, err := env.MarshalBinary()
+	c.Assert(err, IsNil)
+	onDisk, err := ioutil.ReadFile(envFile)
+	c.Assert(err, IsNil)
+	c.Check(content, DeepEquals, onDisk)
+}
+
+func (s *marshalTestSuite) TestBinaryRoundTrip(c *C) {
+	env := New(Config{Size: 32, HeaderSize: 4})
+	env.Set("a", "b")
+	env.Set("c", "d=e")
+	content, err := env.MarshalBinary()
+	c.Assert(err, IsNil)
+	c.Assert(content, HasLen, 32)
+
+	env2 := New(Config{HeaderSize: 4})
+	c.Assert(env2.UnmarshalBinary(content), IsNil)
+	c.Check(env2.String(), Equals, "a=b\nc=d=e\n")
+	c.Check(env2.size, Equals, 32)
+}
+
+func (s *marshalTestSuite) TestUnmarshalBinaryZeroEnv(c *C) {
+	env := New(Config{Size: 16})
+	env.Set("a", "b")
+	content, err := env.MarshalBinary()
+	c.Assert(err, IsNil)
+
+	var env2 Env
+	c.Assert(env2.UnmarshalBinary(content), IsNil)
+	c.Check(env2.Get("a"), Equals, "b")
+}
+
+func (s *marshalTestSuite) TestUnmarshalBinaryBadCRC(c *C) {
+	env := New(Config{Size: 16})
+	content, err := env.MarshalBinary()
+	c.Assert(err, IsNil)
+	content[0]++
+
+	c.Check(env.UnmarshalBinary(content), ErrorMatches, "bad CRC: .*")
+}
+
+func (s *marshalTestSuite) TestUnmarshalBinaryBadHeaderSize(c *C) {
+	for _, hdrSize := range []int{-1, 1, 3, 6} {
+		err := New(Config{HeaderSize: hdrSize}).UnmarshalBinary([]byte{0, 0})
+		c.Check(err, ErrorMatches, "unsupported env header size .*")
+	}
+}
+
+func (s *marshalTestSuite) TestMarshalBinaryTooSmall(c *C) {
+	_, err := New(Config{Size: 3}).MarshalBinary()
+	c.Check(err, ErrorMatches, "cannot marshal env with size 3")
+}
+
+func (s *marshalTestSuite) TestWriteToReadFrom(c *C) {
+	env := New(Config{Size: 32})
+	env.Set("foo", "bar")
+
+	// e.g. embedded in a larger image
+	buf := bytes.NewBufferString("prefix")
+	n, err := env.WriteTo(buf)
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, int64(32))
+	c.Check(buf.Len(), Equals, 6+32)
+
+	env2 := New(Config{})
+	n, err = env2.ReadFrom(bytes.NewReader(buf.Bytes()[6:]))
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, int64(32))
+	c.Check(env2.String(), Equals, "foo=bar\n")
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func (s *marshalTestSuite) TestReadFromTooLarge(c *C) {
+	// e.g. a whole block device instead of the env
+	_, err := New(Config{}).ReadFrom(zeroReader{})
+	c.Check(err, ErrorMatches, "cannot read env image larger than 67108864 bytes")
+}
+
+func (s *marshalTestSuite) TestText(c *C) {
+	env := New(Config{Size: 32})
+	env.Set("old", "value")
+	c.Assert(env.UnmarshalText([]byte("foo=bar\nbaz=1\n")), IsNil)
+
+	text, err := env.MarshalText()
+	c.Assert(err, IsNil)
+	c.Check(string(text), Equals, "baz=1\nfoo=bar\n")
+
+	c.Check(env.UnmarshalText([]byte("junk")), ErrorMatches, `Invalid line: "junk"`)
+	c.Check(env.String(), Equals, "baz=1\nfoo=bar\n")
+}
+
+func (s *marshalTestSuite) TestSaveWithoutFile(c *C) {
+	err := New(Config{Size: 32}).Save()
+	c.Check(err, ErrorMatches, "cannot save env that is not backed by a file")
+}
@@ -0,0 +1,110 @@
+package uenv
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// metadata is the content of the MetadataFile
+type metadata struct {
+	Variables map[string]varMetadata `json:"variables"`
+}
+
+// varMetadata is the metadata of a single variable
+type varMetadata struct {
+	Modified time.Time `json:"modified"`
+}
+
+// snapshot remembers the variables so that the next Save can find the
+// changed ones
+func (env *Env) snapshot() {
+	if env.config.MetadataFile == "" {
+		return
+	}
+	env.saved = env.Map()
+}
+
+// recordModified records the variables that changed since the env was
+// read or last saved in the metadata file of the config. Like the
+// write counter the metadata is for debugging only, failing to update
+// it does not fail the save.
+func (env *Env) recordModified() {
+	fname := env.config.MetadataFile
+	if fname == "" {
+		return
+	}
+	var changed []string
+	for k, v := range env.data {
+		if old, ok := env.saved[k]; !ok || old != v {
+			changed = append(changed, k)
+		}
+	}
+	for k := range env.saved {
+		if _, ok := env.data[k]; !ok {
+			changed = append(changed, k)
+		}
+	}
+	env.snapshot()
+	if len(changed) == 0 {
+		return
+	}
+	if err := updateMetadata(fname, changed, timeNow().UTC()); err != nil {
+		env.debug("cannot update metadata", "file", fname, "error", err)
+	}
+}
+
+// readMetadata reads the given metadata file, a missing file has no
+// metadata
+func readMetadata(fname string) (*metadata, error) {
+	md := &metadata{Variables: make(map[string]varMetadata)}
+	content, err := ioutil.ReadFile(fname)
+	if os.IsNotExist(err) {
+		return md, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, md); err != nil {
+		return nil, fmt.Errorf("cannot parse metadata %s: %v", fname, err)
+	}
+	if md.Variables == nil {
+		md.Variables = make(map[string]varMetadata)
+	}
+	return md, nil
+}
+
+// updateMetadata records that the given variables were modified at t
+func updateMetadata(fname string, names []string, t time.Time) error {
+	md, err := readMetadata(fname)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		md.Variables[name] = varMetadata{Modified: t}
+	}
+	content, err := json.MarshalIndent(md, "", "  ")
+	if err != nil {
+		return err
+	}
+	fs := &FileStorage{Path: fname}
+	return fs.WriteAtomic(append(content, '\n'))
+}
+
+// LastModified returns when the variable was last changed by a Save of
+// this package according to the MetadataFile of the config, also if it
+// was removed since. The time is zero if no change was recorded. This
+// does not see changes made by uboot or other tools.
+func (env *Env) LastModified(name string) (time.Time, error) {
+	fname := env.config.MetadataFile
+	if fname == "" {
+		return time.Time{}, fmt.Errorf("cannot get modification time of %q: no metadata file configured", name)
+	}
+	md, err := readMetadata(fname)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return md.Variables[name].Modified, nil
+}
@@ -0,0 +1,110 @@
+package uenv
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type metadataTestSuite struct {
+	cfg Config
+	now time.Time
+}
+
+var _ = Suite(&metadataTestSuite{})
+
+func (s *metadataTestSuite) SetUpTest(c *C) {
+	s.cfg = Config{
+		Size:         64,
+		Storage:      NewMemoryStorage(nil),
+		MetadataFile: filepath.Join(c.MkDir(), "uboot.env.meta"),
+	}
+	s.now = time.Date(2016, 4, 1, 10, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return s.now }
+}
+
+func (s *metadataTestSuite) TearDownTest(c *C) {
+	timeNow = time.Now
+}
+
+func (s *metadataTestSuite) TestLastModified(c *C) {
+	env, err := CreateStorage(s.cfg.Storage, s.cfg)
+	c.Assert(err, IsNil)
+	c.Assert(env.Set("bootargs", "console=ttyS0"), IsNil)
+	c.Assert(env.Set("bootcount", "0"), IsNil)
+	c.Assert(env.Save(), IsNil)
+	first := s.now
+
+	s.now = s.now.Add(time.Hour)
+	env, err = OpenStorage(s.cfg.Storage, s.cfg)
+	c.Assert(err, IsNil)
+	c.Assert(env.Set("bootcount", "1"), IsNil)
+	c.Assert(env.Set("bootargs", "console=ttyS0"), IsNil)
+	c.Assert(env.Save(), IsNil)
+
+	t, err := env.LastModified("bootargs")
+	c.Assert(err, IsNil)
+	c.Check(t.Equal(first), Equals, true)
+	t, err = env.LastModified("bootcount")
+	c.Assert(err, IsNil)
+	c.Check(t.Equal(s.now), Equals, true)
+	t, err = env.LastModified("unknown")
+	c.Assert(err, IsNil)
+	c.Check(t.IsZero(), Equals, true)
+}
+
+func (s *metadataTestSuite) TestLastModifiedRemoved(c *C) {
+	env, err := CreateStorage(s.cfg.Storage, s.cfg)
+	c.Assert(err, IsNil)
+	c.Assert(env.Set("a", "1"), IsNil)
+	c.Assert(env.Save(), IsNil)
+
+	s.now = s.now.Add(time.Minute)
+	c.Assert(env.Set("a", ""), IsNil)
+	c.Assert(env.Save(), IsNil)
+	t, err := env.LastModified("a")
+	c.Assert(err, IsNil)
+	c.Check(t.Equal(s.now), Equals, true)
+}
+
+func (s *metadataTestSuite) TestUnchangedSaveKeepsMetadata(c *C) {
+	env, err := CreateStorage(s.cfg.Storage, s.cfg)
+	c.Assert(err, IsNil)
+	c.Assert(env.Set("a", "1"), IsNil)
+	c.Assert(env.Save(), IsNil)
+	content, err := ioutil.ReadFile(s.cfg.MetadataFile)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, `{
+  "variables": {
+    "a": {
+      "modified": "2016-04-01T10:00:00Z"
+    }
+  }
+}
+`)
+
+	s.now = s.now.Add(time.Minute)
+	c.Assert(env.Save(), IsNil)
+	again, err := ioutil.ReadFile(s.cfg.MetadataFile)
+	c.Assert(err, IsNil)
+	c.Check(again, DeepEquals, content)
+}
+
+func (s *metadataTestSuite) TestBrokenMetadataDoesNotFailSave(c *C) {
+	c.Assert(ioutil.WriteFile(s.cfg.MetadataFile, []byte("garbage"), 0644), IsNil)
+	env, err := CreateStorage(s.cfg.Storage, s.cfg)
+	c.Assert(err, IsNil)
+	c.Assert(env.Set("a", "1"), IsNil)
+	c.Assert(env.Save(), IsNil)
+
+	_, err = env.LastModified("a")
+	c.Check(err, ErrorMatches, "cannot parse metadata .*uboot.env.meta: invalid character .*")
+}
+
+func (s *metadataTestSuite) TestNoMetadataFile(c *C) {
+	env := New(Config{Size: 64})
+	_, err := env.LastModified("a")
+	c.Check(err, ErrorMatches, `cannot get modification time of "a": no metadata file configured`)
+}
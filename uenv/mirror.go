@@ -0,0 +1,67 @@
+package uenv
+
+import (
+	"context"
+	"fmt"
+)
+
+// mirror returns the storage of the mirror or nil if there is none
+func (cfg *Config) mirror() Storage {
+	if cfg.MirrorStorage != nil {
+		return cfg.MirrorStorage
+	}
+	if cfg.MirrorFile != "" {
+		return &FileStorage{Path: cfg.MirrorFile, Offset: cfg.MirrorOffset}
+	}
+	return nil
+}
+
+// storage returns the storage of the given copy
+func (env *Env) storage(source Source) Storage {
+	if source == SourceMirror {
+		return env.mirror
+	}
+	return env.storages[source]
+}
+
+// readMirror reads the mirror and returns it instead of the chosen
+// copy if the chosen copy is not valid but the mirror is. The backup
+// file is only used if the mirror is not valid either.
+func (env *Env) readMirror(ctx context.Context, chosen *envCopy, source Source) (*envCopy, Source) {
+	mirror := env.readCopy(ctx, env.mirror, SourceMirror)
+	switch {
+	case mirror.err != nil:
+		env.mirrorErr = mirror.err
+	case mirror.corrupt:
+		env.mirrorErr = fmt.Errorf("bad CRC")
+	}
+	if env.mirrorErr != nil {
+		return chosen, source
+	}
+	if chosen == nil || chosen.corrupt || source == SourceBackup {
+		return mirror, SourceMirror
+	}
+	return chosen, source
+}
+
+// writeMirror writes content to the mirror and returns the error of
+// the primary copy or, if that was written, of the mirror
+func (env *Env) writeMirror(ctx context.Context, content []byte, primaryErr error) error {
+	env.debug("saving env mirror", "storage", fmt.Sprintf("%T", env.mirror))
+	if err := env.writeCopy(ctx, SourceMirror, content); err != nil {
+		env.mirrorErr = err
+		if primaryErr == nil {
+			return fmt.Errorf("cannot save env mirror: %v", err)
+		}
+		return primaryErr
+	}
+	env.mirrorErr = nil
+	return primaryErr
+}
+
+// MirrorErr returns why the mirror could not be used when the env was
+// opened or why it could not be written by the last Save, it is nil if
+// the mirror is valid or the env has no mirror.
+func (env *Env) MirrorErr() error {
+	return env.mirrorErr
+}
@@ -0,0 +1,150 @@
+package uenv
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+type mirrorTestSuite struct {
+	primary *MemoryStorage
+	mirror  *MemoryStorage
+	cfg     Config
+}
+
+var _ = Suite(&mirrorTestSuite{})
+
+func (s *mirrorTestSuite) SetUpTest(c *C) {
+	s.primary = NewMemoryStorage(nil)
+	s.mirror = NewMemoryStorage(nil)
+	s.cfg = Config{Size: 64, Storage: s.primary, MirrorStorage: s.mirror}
+
+	env, err := CreateWithConfig("", s.cfg)
+	c.Assert(err, IsNil)
+	c.Assert(env.Set("bootcount", "1"), IsNil)
+	c.Assert(env.Save(), IsNil)
+}
+
+func (s *mirrorTestSuite) TestSaveWritesBoth(c *C) {
+	c.Check(s.mirror.Bytes(), DeepEquals, s.primary.Bytes())
+
+	env, err := OpenWithConfig("", s.cfg)
+	c.Assert(err, IsNil)
+	c.Check(env.Source(), Equals, SourcePrimary)
+	c.Check(env.MirrorErr(), IsNil)
+	c.Assert(env.Set("bootcount", "2"), IsNil)
+	c.Assert(env.Save(), IsNil)
+	c.Check(s.mirror.Bytes(), DeepEquals, s.primary.Bytes())
+
+	report, err := Verify("", s.cfg)
+	c.Assert(err, IsNil)
+	c.Check(report.Copies, HasLen, 2)
+	c.Check(report.Copies[1].Source, Equals, SourceMirror)
+	c.Check(report.OK(), Equals, true)
+}
+
+func (s *mirrorTestSuite) TestOpenFallsBackToMirror(c *C) {
+	c.Assert(s.primary.WriteInPlace([]byte("broken")), IsNil)
+
+	env, err := OpenWithConfig("", s.cfg)
+	c.Assert(err, IsNil)
+	c.Check(env.Source(), Equals, SourceMirror)
+	c.Check(env.Get("bootcount"), Equals, "1")
+	c.Check(env.PrimaryErr(), ErrorMatches, "bad CRC.*")
+
+	c.Assert(env.RepairPrimary(), IsNil)
+	c.Check(s.primary.Bytes(), DeepEquals, s.mirror.Bytes())
+}
+
+func (s *mirrorTestSuite) TestMirrorPreferredOverBackup(c *C) {
+	backup := filepath.Join(c.MkDir(), "backup.env")
+	env := New(Config{Size: 64})
+	c.Assert(env.Set("bootcount", "0"), IsNil)
+	content, err := env.MarshalBinary()
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(backup, content, 0644), IsNil)
+	c.Assert(s.primary.WriteInPlace([]byte("broken")), IsNil)
+
+	cfg := s.cfg
+	cfg.BackupFile = backup
+	env, err = OpenWithConfig("", cfg)
+	c.Assert(err, IsNil)
+	c.Check(env.Source(), Equals, SourceMirror)
+
+	c.Assert(s.mirror.WriteInPlace([]byte("broken")), IsNil)
+	env, err = OpenWithConfig("", cfg)
+	c.Assert(err, IsNil)
+	c.Check(env.Source(), Equals, SourceBackup)
+	c.Check(env.Get("bootcount"), Equals, "0")
+}
+
+func (s *mirrorTestSuite) TestBrokenMirror(c *C) {
+	c.Assert(s.mirror.WriteInPlace([]byte("broken")), IsNil)
+
+	env, err := OpenWithConfig("", s.cfg)
+	c.Assert(err, IsNil)
+	c.Check(env.Source(), Equals, SourcePrimary)
+	c.Check(env.MirrorErr(), ErrorMatches, "bad CRC.*")
+
+	report, err := Verify("", s.cfg)
+	c.Assert(err, IsNil)
+	c.Assert(report.Problems, HasLen, 1)
+	c.Check(report.Problems[0], Matches, "mirror copy: bad CRC: .*")
+
+	// saving repairs the mirror
+	c.Assert(env.Save(), IsNil)
+	c.Check(env.MirrorErr(), IsNil)
+	c.Check(s.mirror.Bytes(), DeepEquals, s.primary.Bytes())
+}
+
+func (s *mirrorTestSuite) TestMirrorDiffers(c *C) {
+	env := New(Config{Size: 64})
+	c.Assert(env.Set("bootcount", "7"), IsNil)
+	content, err := env.MarshalBinary()
+	c.Assert(err, IsNil)
+	c.Assert(s.mirror.WriteInPlace(content), IsNil)
+
+	report, err := Verify("", s.cfg)
+	c.Assert(err, IsNil)
+	c.Check(report.Problems, DeepEquals, []string{"mirror differs from the primary copy"})
+}
+
+func (s *mirrorTestSuite) TestMirrorWriteFails(c *C) {
+	cfg := s.cfg
+	failing := &flakyStorage{MemoryStorage: NewMemoryStorage(nil), failures: 1}
+	cfg.MirrorStorage = failing
+	env, err := CreateWithConfig("", cfg)
+	c.Assert(err, IsNil)
+	c.Assert(env.Set("bootcount", "3"), IsNil)
+	c.Check(env.Save(), ErrorMatches, "cannot save env mirror: input/output error")
+	c.Check(env.MirrorErr(), NotNil)
+
+	// the primary copy was written
+	env, err = OpenWithConfig("", s.cfg)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("bootcount"), Equals, "3")
+}
+
+func (s *mirrorTestSuite) TestMirrorFile(c *C) {
+	dir := c.MkDir()
+	cfg := Config{Size: 64, MirrorFile: filepath.Join(dir, "mirror.env")}
+	env, err := CreateWithConfig(filepath.Join(dir, "uboot.env"), cfg)
+	c.Assert(err, IsNil)
+	c.Assert(env.Set("a", "1"), IsNil)
+	c.Assert(env.Save(), IsNil)
+
+	mirror, err := Open(filepath.Join(dir, "mirror.env"))
+	c.Assert(err, IsNil)
+	c.Check(mirror.Get("a"), Equals, "1")
+}
+
+func (s *mirrorTestSuite) TestMirrorRedundant(c *C) {
+	cfg := s.cfg
+	cfg.Redundant = true
+	cfg.RedundantStorage = NewMemoryStorage(nil)
+	_, err := CreateWithConfig("", cfg)
+	c.Check(err, ErrorMatches, "cannot mirror a redundant env")
+	_, err = OpenWithConfig("", cfg)
+	c.Check(err, ErrorMatches, "cannot mirror a redundant env")
+}
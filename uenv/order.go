@@ -0,0 +1,35 @@
+package uenv
+
+import (
+	"strings"
+)
+
+// PrefixOrder returns an Order that puts the variables in the order of
+// the first prefix they start with, e.g. to write the critical boot
+// variables first and to group vendor variables at the end:
+//
+//	PrefixOrder("bootcmd", "bootargs", "", "vendor_")
+//
+// Variables that start with none of the prefixes go where the empty
+// prefix is or last if there is none. Within a prefix the variables
+// are sorted by name.
+func PrefixOrder(prefixes ...string) func(a, b string) bool {
+	rest := len(prefixes)
+	for i, prefix := range prefixes {
+		if prefix == "" {
+			rest = i
+			break
+		}
+	}
+	group := func(name string) int {
+		for i, prefix := range prefixes {
+			if prefix != "" && strings.HasPrefix(name, prefix) {
+				return i
+			}
+		}
+		return rest
+	}
+	return func(a, b string) bool {
+		return group(a) < group(b)
+	}
+}
@@ -0,0 +1,87 @@
+package uenv
+
+import (
+	"bytes"
+	"sort"
+
+	. "gopkg.in/check.v1"
+)
+
+type orderTestSuite struct{}
+
+var _ = Suite(&orderTestSuite{})
+
+var bootFirst = PrefixOrder("bootcmd", "bootargs", "", "vendor_")
+
+func (s *orderTestSuite) newEnv(c *C, order func(a, b string) bool) *Env {
+	env := New(Config{Size: 4096, Order: order})
+	for _, k := range []string{"vendor_serial", "arch", "bootargs", "bootcmd", "bootcmd_mmc", "vendor_a", "zebra"} {
+		c.Assert(env.Set(k, "1"), IsNil)
+	}
+	return env
+}
+
+func (s *orderTestSuite) TestPrefixOrder(c *C) {
+	names := []string{"vendor_serial", "arch", "bootargs", "bootcmd_mmc", "bootcmd", "zebra", "vendor_a"}
+	sort.Strings(names)
+	sort.SliceStable(names, func(i, j int) bool { return bootFirst(names[i], names[j]) })
+	c.Check(names, DeepEquals, []string{"bootcmd", "bootcmd_mmc", "bootargs", "arch", "zebra", "vendor_a", "vendor_serial"})
+
+	// without the empty prefix the rest goes last
+	order := PrefixOrder("z")
+	c.Check(order("zebra", "arch"), Equals, true)
+	c.Check(order("arch", "zebra"), Equals, false)
+	c.Check(order("arch", "bootcmd"), Equals, false)
+}
+
+func (s *orderTestSuite) TestOrderSave(c *C) {
+	env := s.newEnv(c, bootFirst)
+	payload := env.image(0)[env.headerSize():]
+	payload = payload[:bytes.Index(payload, []byte{0, 0})]
+	var names []string
+	for _, entry := range bytes.Split(payload, []byte{0}) {
+		names = append(names, string(entry[:bytes.IndexByte(entry, '=')]))
+	}
+	c.Check(names, DeepEquals, []string{"bootcmd", "bootcmd_mmc", "bootargs", "arch", "zebra", "vendor_a", "vendor_serial"})
+
+	// the order does not change the variables
+	data, _, err := parseImage(env.image(0), env.headerSize(), 0, nil, env.config.checksum())
+	c.Assert(err, IsNil)
+	c.Check(data, DeepEquals, env.Map())
+}
+
+func (s *orderTestSuite) TestOrderExports(c *C) {
+	env := s.newEnv(c, bootFirst)
+	c.Check(env.String(), Equals, "bootcmd=1\nbootcmd_mmc=1\nbootargs=1\narch=1\nzebra=1\nvendor_a=1\nvendor_serial=1\n")
+
+	buf := bytes.NewBuffer(nil)
+	c.Assert(env.ExportJSON(buf), IsNil)
+	c.Check(buf.String(), Equals, `{
+  "bootcmd": "1",
+  "bootcmd_mmc": "1",
+  "bootargs": "1",
+  "arch": "1",
+  "zebra": "1",
+  "vendor_a": "1",
+  "vendor_serial": "1"
+}
+`)
+
+	buf.Reset()
+	c.Assert(env.ExportScript(buf, false), IsNil)
+	c.Check(buf.String(), Matches, "setenv bootcmd 1\nsetenv bootcmd_mmc 1\n(.|\n)*setenv vendor_serial 1\n")
+}
+
+func (s *orderTestSuite) TestOrderedJSONMatchesDefault(c *C) {
+	byName := func(a, b string) bool { return a < b }
+	for _, env := range []*Env{New(Config{Size: 4096}), s.newEnv(c, nil)} {
+		env.Set("html", "<a & b>")
+		plain := bytes.NewBuffer(nil)
+		c.Assert(env.ExportJSON(plain), IsNil)
+
+		env.config.Order = byName
+		ordered := bytes.NewBuffer(nil)
+		c.Assert(env.ExportJSON(ordered), IsNil)
+		c.Check(ordered.String(), Equals, plain.String())
+	}
+}
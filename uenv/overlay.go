@@ -0,0 +1,149 @@
+package uenv
+
+import (
+	"fmt"
+)
+
+var (
+	_ Vars = Defaults(nil)
+	_ Vars = (*Overlay)(nil)
+)
+
+// Defaults are variables compiled into a tool or uboot
+// (CONFIG_EXTRA_ENV_SETTINGS), they can be read but not changed.
+type Defaults map[string]string
+
+// Get returns the default value of the variable
+func (d Defaults) Get(name string) string {
+	return d[name]
+}
+
+// Set returns an error, defaults can not be changed
+func (d Defaults) Set(name, value string) error {
+	return fmt.Errorf("cannot set %q: defaults are read-only", name)
+}
+
+// Map returns a copy of the defaults
+func (d Defaults) Map() map[string]string {
+	m := make(map[string]string, len(d))
+	for k, v := range d {
+		m[k] = v
+	}
+	return m
+}
+
+// Save does nothing
+func (d Defaults) Save() error {
+	return nil
+}
+
+// Layer is a named source of variables of an Overlay, e.g. "defaults",
+// "env" or "override"
+type Layer struct {
+	Name string
+	Vars Vars
+}
+
+// Overlay combines several sources of variables, a variable of a
+// later layer takes precedence over the same variable of an earlier
+// layer. This mirrors how the effective boot configuration of a device
+// is composed from compiled defaults, the device env and local
+// overrides.
+type Overlay struct {
+	layers []Layer
+	dirty  map[int]bool
+}
+
+// NewOverlay returns an overlay of the given layers, in increasing
+// order of precedence
+func NewOverlay(layers ...Layer) *Overlay {
+	return &Overlay{layers: layers, dirty: make(map[int]bool)}
+}
+
+// Layers returns the layers in increasing order of precedence
+func (o *Overlay) Layers() []Layer {
+	return append([]Layer(nil), o.layers...)
+}
+
+// Get returns the value of the variable in the layer with the highest
+// precedence that sets it
+func (o *Overlay) Get(name string) string {
+	value, _, _ := o.GetResolved(name)
+	return value
+}
+
+// GetResolved returns the value of the variable and the name of the
+// layer it comes from, ok is false if no layer sets it
+func (o *Overlay) GetResolved(name string) (value, source string, ok bool) {
+	for i := len(o.layers) - 1; i >= 0; i-- {
+		if value := o.layers[i].Vars.Get(name); value != "" {
+			return value, o.layers[i].Name, true
+		}
+	}
+	return "", "", false
+}
+
+// Sources returns the names of the variables of all layers with the
+// name of the layer that each of them comes from
+func (o *Overlay) Sources() map[string]string {
+	sources := make(map[string]string)
+	for _, l := range o.layers {
+		for name := range l.Vars.Map() {
+			sources[name] = l.Name
+		}
+	}
+	return sources
+}
+
+// Map returns the effective value of all variables
+func (o *Overlay) Map() map[string]string {
+	m := make(map[string]string)
+	for _, l := range o.layers {
+		for name, value := range l.Vars.Map() {
+			m[name] = value
+		}
+	}
+	return m
+}
+
+// Set sets the variable in the layer with the highest precedence, an
+// empty value only removes it from that layer so the value of a lower
+// layer may still be in effect.
+func (o *Overlay) Set(name, value string) error {
+	if len(o.layers) == 0 {
+		return fmt.Errorf("cannot set %q: overlay has no layers", name)
+	}
+	return o.setIn(len(o.layers)-1, name, value)
+}
+
+// SetIn sets the variable in the layer with the given name
+func (o *Overlay) SetIn(layer, name, value string) error {
+	for i, l := range o.layers {
+		if l.Name == layer {
+			return o.setIn(i, name, value)
+		}
+	}
+	return fmt.Errorf("cannot set %q: unknown layer %q", name, layer)
+}
+
+func (o *Overlay) setIn(i int, name, value string) error {
+	if err := o.layers[i].Vars.Set(name, value); err != nil {
+		return err
+	}
+	o.dirty[i] = true
+	return nil
+}
+
+// Save saves the layers that were changed with Set or SetIn
+func (o *Overlay) Save() error {
+	for i, l := range o.layers {
+		if !o.dirty[i] {
+			continue
+		}
+		if err := l.Vars.Save(); err != nil {
+			return fmt.Errorf("cannot save layer %q: %v", l.Name, err)
+		}
+		delete(o.dirty, i)
+	}
+	return nil
+}
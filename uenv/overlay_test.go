@@ -0,0 +1,104 @@
+package uenv
+
+import (
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+type overlayTestSuite struct {
+	envFile      string
+	overrideFile string
+	overlay      *Overlay
+}
+
+var _ = Suite(&overlayTestSuite{})
+
+func (s *overlayTestSuite) SetUpTest(c *C) {
+	dir := c.MkDir()
+	s.envFile = filepath.Join(dir, "uboot.env")
+	s.overrideFile = filepath.Join(dir, "override.env")
+
+	env, err := Create(s.envFile, 4096)
+	c.Assert(err, IsNil)
+	c.Assert(env.Set("bootcmd", "run distro_bootcmd"), IsNil)
+	c.Assert(env.Set("console", "ttyS0"), IsNil)
+	c.Assert(env.Save(), IsNil)
+	override, err := Create(s.overrideFile, 4096)
+	c.Assert(err, IsNil)
+	c.Assert(override.Set("console", "ttyS2"), IsNil)
+	c.Assert(override.Save(), IsNil)
+
+	s.overlay = NewOverlay(
+		Layer{Name: "defaults", Vars: Defaults{"bootdelay": "2", "console": "tty1", "bootcmd": "boot"}},
+		Layer{Name: "env", Vars: env},
+		Layer{Name: "override", Vars: override},
+	)
+}
+
+func (s *overlayTestSuite) TestGetResolved(c *C) {
+	for _, t := range []struct {
+		name, value, source string
+	}{
+		{"bootdelay", "2", "defaults"},
+		{"bootcmd", "run distro_bootcmd", "env"},
+		{"console", "ttyS2", "override"},
+	} {
+		value, source, ok := s.overlay.GetResolved(t.name)
+		c.Check(ok, Equals, true)
+		c.Check(value, Equals, t.value)
+		c.Check(source, Equals, t.source)
+		c.Check(s.overlay.Get(t.name), Equals, t.value)
+	}
+	_, _, ok := s.overlay.GetResolved("missing")
+	c.Check(ok, Equals, false)
+
+	c.Check(s.overlay.Map(), DeepEquals, map[string]string{
+		"bootdelay": "2",
+		"bootcmd":   "run distro_bootcmd",
+		"console":   "ttyS2",
+	})
+	c.Check(s.overlay.Sources(), DeepEquals, map[string]string{
+		"bootdelay": "defaults",
+		"bootcmd":   "env",
+		"console":   "override",
+	})
+	c.Check(s.overlay.Layers(), HasLen, 3)
+}
+
+func (s *overlayTestSuite) TestSetAndSave(c *C) {
+	c.Assert(s.overlay.Set("bootdelay", "0"), IsNil)
+	c.Assert(s.overlay.SetIn("env", "bootcmd", "run altbootcmd"), IsNil)
+	// removing the override brings back the value of the env
+	c.Assert(s.overlay.Set("console", ""), IsNil)
+	value, source, _ := s.overlay.GetResolved("console")
+	c.Check(value, Equals, "ttyS0")
+	c.Check(source, Equals, "env")
+	c.Assert(s.overlay.Save(), IsNil)
+
+	env, err := Open(s.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("bootcmd"), Equals, "run altbootcmd")
+	override, err := Open(s.overrideFile)
+	c.Assert(err, IsNil)
+	c.Check(override.Map(), DeepEquals, map[string]string{"bootdelay": "0"})
+}
+
+func (s *overlayTestSuite) TestSetErrors(c *C) {
+	err := s.overlay.SetIn("defaults", "bootdelay", "5")
+	c.Check(err, ErrorMatches, `cannot set "bootdelay": defaults are read-only`)
+	err = s.overlay.SetIn("missing", "bootdelay", "5")
+	c.Check(err, ErrorMatches, `cannot set "bootdelay": unknown layer "missing"`)
+	err = NewOverlay().Set("bootdelay", "5")
+	c.Check(err, ErrorMatches, `cannot set "bootdelay": overlay has no layers`)
+}
+
+func (s *overlayTestSuite) TestSaveOnlyChanged(c *C) {
+	broken := New(Config{Size: 4096})
+	overlay := NewOverlay(Layer{Name: "memory", Vars: broken}, Layer{Name: "defaults", Vars: Defaults{}})
+	// nothing changed, nothing to save
+	c.Check(overlay.Save(), IsNil)
+
+	c.Assert(overlay.SetIn("memory", "a", "1"), IsNil)
+	c.Check(overlay.Save(), ErrorMatches, `cannot save layer "memory": .*`)
+}
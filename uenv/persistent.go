@@ -0,0 +1,186 @@
+package uenv
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+var _ Storage = (*PersistentFileStorage)(nil)
+
+var timeNow = time.Now
+
+// PersistentFileStorage is a FileStorage that opens the file for
+// writing once and keeps the descriptor, writes are a single pwrite at
+// the offset. It is meant for daemons that update the env on a FAT
+// partition often, e.g. a bootcount on every heartbeat, where every
+// open and close of the file may update the directory entry. The
+// descriptor is checked against the path every RevalidateInterval and
+// reopened if the file got replaced or the partition remounted.
+type PersistentFileStorage struct {
+	FileStorage
+	// RevalidateInterval is how long the descriptor is trusted
+	// without checking the path, zero checks before every write.
+	RevalidateInterval time.Duration
+
+	mu        sync.Mutex
+	f         *os.File
+	validated time.Time
+
+	// lockMu is held while the storage is locked, lockF is the kept
+	// descriptor of the file that is locked
+	lockMu        sync.Mutex
+	lockF         *os.File
+	lockValidated time.Time
+}
+
+// NewPersistentFileStorage opens the file at path for writing and
+// returns a storage that keeps it open until Close
+func NewPersistentFileStorage(path string, offset int64) (*PersistentFileStorage, error) {
+	ps := &PersistentFileStorage{FileStorage: FileStorage{Path: path, Offset: offset}}
+	if err := ps.open(); err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+func (ps *PersistentFileStorage) open() error {
+	f, err := os.OpenFile(ps.Path, os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	if ps.f != nil {
+		ps.f.Close()
+	}
+	ps.f = f
+	ps.validated = timeNow()
+	return nil
+}
+
+// revalidate reopens the file if the descriptor no longer refers to
+// the file at the path
+func (ps *PersistentFileStorage) revalidate() error {
+	if ps.f == nil {
+		return fmt.Errorf("cannot write env to closed storage %s", ps.Path)
+	}
+	if timeNow().Sub(ps.validated) < ps.RevalidateInterval {
+		return nil
+	}
+	current, err := ps.f.Stat()
+	if err != nil {
+		return ps.open()
+	}
+	st, err := os.Stat(ps.Path)
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(current, st) {
+		return ps.open()
+	}
+	ps.validated = timeNow()
+	return nil
+}
+
+// WriteInPlace overwrites the env with a single pwrite on the kept
+// descriptor, if that fails the file is reopened and the write is
+// tried once more
+func (ps *PersistentFileStorage) WriteInPlace(content []byte) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if err := ps.revalidate(); err != nil {
+		return err
+	}
+	err := ps.write(content)
+	if err == nil {
+		return nil
+	}
+	if reopenErr := ps.open(); reopenErr != nil {
+		return err
+	}
+	return ps.write(content)
+}
+
+func (ps *PersistentFileStorage) write(content []byte) error {
+	if _, err := ps.f.WriteAt(content, ps.Offset); err != nil {
+		return err
+	}
+	return ps.f.Sync()
+}
+
+// Lock locks the same file as FileStorage.Lock but on a kept
+// descriptor, so that a save does not open and close it. The
+// descriptor is checked against the path like the one for writing.
+func (ps *PersistentFileStorage) Lock() (unlock func() error, err error) {
+	// a descriptor that is already locked can be locked again, so
+	// the mutex excludes other users in this process
+	ps.lockMu.Lock()
+	ps.mu.Lock()
+	closed := ps.f == nil
+	ps.mu.Unlock()
+	if closed {
+		ps.lockMu.Unlock()
+		return nil, fmt.Errorf("cannot lock closed storage %s", ps.Path)
+	}
+	if err := ps.revalidateLock(); err != nil {
+		ps.lockMu.Unlock()
+		return nil, err
+	}
+	f := ps.lockF
+	if err := lockFd(f); err != nil {
+		ps.lockMu.Unlock()
+		return nil, err
+	}
+	return func() error {
+		defer ps.lockMu.Unlock()
+		return unlockFd(f)
+	}, nil
+}
+
+// revalidateLock opens the lock descriptor if there is none or it no
+// longer refers to the file at its path
+func (ps *PersistentFileStorage) revalidateLock() error {
+	if ps.lockF != nil {
+		if timeNow().Sub(ps.lockValidated) < ps.RevalidateInterval {
+			return nil
+		}
+		current, err := ps.lockF.Stat()
+		if err == nil {
+			var st os.FileInfo
+			if st, err = os.Stat(ps.lockF.Name()); err == nil && os.SameFile(current, st) {
+				ps.lockValidated = timeNow()
+				return nil
+			}
+		}
+		ps.lockF.Close()
+		ps.lockF = nil
+	}
+	f, err := os.Open(ps.Path)
+	if err != nil {
+		return err
+	}
+	ps.lockF = f
+	ps.lockValidated = timeNow()
+	return nil
+}
+
+// Close closes the kept descriptors
+func (ps *PersistentFileStorage) Close() error {
+	ps.lockMu.Lock()
+	if ps.lockF != nil {
+		ps.lockF.Close()
+		ps.lockF = nil
+	}
+	ps.lockMu.Unlock()
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.f == nil {
+		return nil
+	}
+	err := ps.f.Close()
+	ps.f = nil
+	return err
+}
@@ -0,0 +1,136 @@
+package uenv
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type persistentTestSuite struct {
+	envFile string
+}
+
+var _ = Suite(&persistentTestSuite{})
+
+func (s *persistentTestSuite) SetUpTest(c *C) {
+	s.envFile = filepath.Join(c.MkDir(), "uboot.env")
+	c.Assert(ioutil.WriteFile(s.envFile, make([]byte, 64), 0644), IsNil)
+}
+
+// replaceFile replaces the env file like an update or a remount would
+func (s *persistentTestSuite) replaceFile(c *C) {
+	tmp := s.envFile + ".new"
+	c.Assert(ioutil.WriteFile(tmp, make([]byte, 64), 0644), IsNil)
+	c.Assert(os.Rename(tmp, s.envFile), IsNil)
+}
+
+func (s *persistentTestSuite) TestSaveAndOpen(c *C) {
+	ps, err := NewPersistentFileStorage(s.envFile, 0)
+	c.Assert(err, IsNil)
+	defer ps.Close()
+
+	env, err := CreateStorage(ps, Config{Size: 64})
+	c.Assert(err, IsNil)
+	for _, bootcount := range []string{"1", "2", "3"} {
+		c.Assert(env.Set("bootcount", bootcount), IsNil)
+		c.Assert(env.Save(), IsNil)
+	}
+
+	env, err = Open(s.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("bootcount"), Equals, "3")
+}
+
+func (s *persistentTestSuite) TestRevalidate(c *C) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	ps, err := NewPersistentFileStorage(s.envFile, 8)
+	c.Assert(err, IsNil)
+	defer ps.Close()
+	ps.RevalidateInterval = time.Minute
+
+	s.replaceFile(c)
+	// the descriptor is still trusted and writes to the old file
+	c.Assert(ps.WriteInPlace([]byte("old")), IsNil)
+	content, err := ioutil.ReadFile(s.envFile)
+	c.Assert(err, IsNil)
+	c.Check(content[8:11], DeepEquals, []byte{0, 0, 0})
+
+	now = now.Add(time.Minute)
+	c.Assert(ps.WriteInPlace([]byte("new")), IsNil)
+	content, err = ioutil.ReadFile(s.envFile)
+	c.Assert(err, IsNil)
+	c.Check(content[8:11], DeepEquals, []byte("new"))
+
+	// without an interval every write checks the path
+	ps.RevalidateInterval = 0
+	s.replaceFile(c)
+	c.Assert(ps.WriteInPlace([]byte("now")), IsNil)
+	content, err = ioutil.ReadFile(s.envFile)
+	c.Assert(err, IsNil)
+	c.Check(content[8:11], DeepEquals, []byte("now"))
+}
+
+func (s *persistentTestSuite) TestLock(c *C) {
+	ps, err := NewPersistentFileStorage(s.envFile, 0)
+	c.Assert(err, IsNil)
+	defer ps.Close()
+
+	unlock, err := ps.Lock()
+	c.Assert(err, IsNil)
+	lockF := ps.lockF
+
+	// excludes other processes, that use a FileStorage, and other
+	// users of the storage
+	locked := make(chan bool, 2)
+	for _, other := range []Storage{&FileStorage{Path: s.envFile}, ps} {
+		go func(other Storage) {
+			unlock, err := other.Lock()
+			c.Check(err, IsNil)
+			locked <- true
+			unlock()
+		}(other)
+	}
+	select {
+	case <-locked:
+		c.Fatal("lock was taken twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	c.Assert(unlock(), IsNil)
+	<-locked
+	<-locked
+
+	// the descriptor is kept
+	unlock, err = ps.Lock()
+	c.Assert(err, IsNil)
+	c.Check(ps.lockF, Equals, lockF)
+	c.Assert(unlock(), IsNil)
+
+	c.Assert(ps.Close(), IsNil)
+	_, err = ps.Lock()
+	c.Check(err, ErrorMatches, "cannot lock closed storage .*")
+}
+
+func (s *persistentTestSuite) TestErrors(c *C) {
+	_, err := NewPersistentFileStorage(filepath.Join(c.MkDir(), "missing"), 0)
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	ps, err := NewPersistentFileStorage(s.envFile, 0)
+	c.Assert(err, IsNil)
+	c.Assert(ps.Close(), IsNil)
+	c.Assert(ps.Close(), IsNil)
+	err = ps.WriteInPlace([]byte("x"))
+	c.Check(err, ErrorMatches, "cannot write env to closed storage .*")
+
+	ps, err = NewPersistentFileStorage(s.envFile, 0)
+	c.Assert(err, IsNil)
+	defer ps.Close()
+	c.Assert(os.Remove(s.envFile), IsNil)
+	err = ps.WriteInPlace([]byte("x"))
+	c.Check(os.IsNotExist(err), Equals, true)
+}
@@ -0,0 +1,100 @@
+package uenv
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var (
+	// consolePrompt matches uboot prompts like "=> ", "U-Boot> " or
+	// "STM32MP> " at the start of a line
+	consolePrompt = regexp.MustCompile(`^(=>|[\w-]+[>#])( |$)`)
+	// consoleVar matches the start of a "name=value" line
+	consoleVar = regexp.MustCompile(`^[\w.:-]+=`)
+)
+
+// ImportPrintenv imports the variables of a printenv output that was
+// copied from the uboot console. Prompt lines, the "Environment size"
+// trailer and other output before the first variable are skipped.
+// Lines that do not start with "name=" are taken as the next line of a
+// multi-line value. If the width of the terminal can be detected from
+// the wrapped lines a line of that width is always continued on the
+// next line without a newline, even if that looks like the start of a
+// variable.
+func (env *Env) ImportPrintenv(r io.Reader) error {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, MaxSize)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimRight(scanner.Text(), "\r"))
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	width := wrapWidth(lines)
+	data := make(map[string]string)
+	name := ""
+	wrapped := false
+	for _, line := range lines {
+		switch {
+		case name != "" && wrapped:
+			data[name] += line
+		case consoleVar.MatchString(line):
+			l := strings.SplitN(line, "=", 2)
+			name = l[0]
+			data[name] = l[1]
+		case consolePrompt.MatchString(line) || strings.HasPrefix(line, "Environment size:"):
+			name = ""
+		case name != "" && line != "":
+			data[name] += "\n" + line
+		}
+		wrapped = width > 0 && len(line) == width
+	}
+
+	for k, v := range data {
+		if err := checkVar(k, v, env.config.RejectControl); err != nil {
+			return err
+		}
+	}
+	for k, v := range data {
+		if v == "" {
+			delete(env.data, k)
+			continue
+		}
+		env.data[k] = v
+	}
+	return nil
+}
+
+// minWrapWidth is the narrowest terminal that is detected by
+// wrapWidth
+const minWrapWidth = 40
+
+// wrapWidth returns the width of the terminal that wrapped the lines
+// or 0 if the lines do not seem to be wrapped. The width is the
+// length of the longest lines if there are several of them and at
+// least one is followed by a line that is clearly a continuation.
+func wrapWidth(lines []string) int {
+	width, count := 0, 0
+	for _, line := range lines {
+		switch {
+		case len(line) > width:
+			width, count = len(line), 1
+		case len(line) == width:
+			count++
+		}
+	}
+	if count < 2 || width < minWrapWidth {
+		return 0
+	}
+	for i, line := range lines[:len(lines)-1] {
+		next := lines[i+1]
+		if len(line) == width && next != "" && !consoleVar.MatchString(next) && !consolePrompt.MatchString(next) {
+			return width
+		}
+	}
+	return 0
+}
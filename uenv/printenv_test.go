@@ -0,0 +1,100 @@
+package uenv
+
+import (
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type printenvTestSuite struct{}
+
+var _ = Suite(&printenvTestSuite{})
+
+func (s *printenvTestSuite) TestImportPrintenv(c *C) {
+	dump := `U-Boot 2020.01 (Jan 01 2020 - 00:00:00 +0000)
+
+Hit any key to stop autoboot:  0
+=> printenv
+arch=arm
+bootcmd=run distro_bootcmd
+bootdelay=2
+
+Environment size: 52/131068 bytes
+=> 
+`
+	env := New(Config{Size: 4096})
+	c.Assert(env.Set("old", "1"), IsNil)
+	c.Assert(env.ImportPrintenv(strings.NewReader(dump)), IsNil)
+	c.Check(env.Map(), DeepEquals, map[string]string{
+		"arch":      "arm",
+		"bootcmd":   "run distro_bootcmd",
+		"bootdelay": "2",
+		"old":       "1",
+	})
+}
+
+func (s *printenvTestSuite) TestImportPrintenvPrompts(c *C) {
+	dump := "U-Boot> printenv bootdelay\r\nbootdelay=2\r\nSTM32MP> printenv\r\nfoo=bar\r\n"
+	env := New(Config{Size: 4096})
+	c.Assert(env.ImportPrintenv(strings.NewReader(dump)), IsNil)
+	c.Check(env.Map(), DeepEquals, map[string]string{"bootdelay": "2", "foo": "bar"})
+}
+
+func (s *printenvTestSuite) TestImportPrintenvWrapped(c *C) {
+	// wrapped at 40 columns, the second line of bootargs looks like
+	// a variable but follows a line of the full width
+	dump := `=> printenv
+bootargs=console=ttyS0,115200 earlycon=u
+root=/dev/mmcblk0p2 rw
+bootcmd=run distro_bootcmd; echo booting
+ the board; echo done
+extra=value with
+ a newline
+x=1
+`
+	env := New(Config{Size: 4096})
+	c.Assert(env.ImportPrintenv(strings.NewReader(dump)), IsNil)
+	c.Check(env.Map(), DeepEquals, map[string]string{
+		"bootargs": "console=ttyS0,115200 earlycon=uroot=/dev/mmcblk0p2 rw",
+		"bootcmd":  "run distro_bootcmd; echo booting the board; echo done",
+		"extra":    "value with\n a newline",
+		"x":        "1",
+	})
+}
+
+func (s *printenvTestSuite) TestImportPrintenvMultiLine(c *C) {
+	// values with newlines and values that start like a prompt
+	dump := `=> printenv
+script=if true; then
+  echo yes
+> done
+fi
+prompt=>
+quote=> a
+x=1
+`
+	env := New(Config{Size: 4096})
+	c.Assert(env.ImportPrintenv(strings.NewReader(dump)), IsNil)
+	c.Check(env.Map(), DeepEquals, map[string]string{
+		"script": "if true; then\n  echo yes\n> done\nfi",
+		"prompt": ">",
+		"quote":  "> a",
+		"x":      "1",
+	})
+}
+
+func (s *printenvTestSuite) TestImportPrintenvInvalid(c *C) {
+	env := New(Config{Size: 4096, RejectControl: true})
+	err := env.ImportPrintenv(strings.NewReader("a=1\nb=\x01\n"))
+	c.Check(err, ErrorMatches, `invalid variable "b": value contains a control character`)
+	c.Check(env.Map(), HasLen, 0)
+}
+
+func (s *printenvTestSuite) TestWrapWidth(c *C) {
+	long := strings.Repeat("x", 38)
+	c.Check(wrapWidth(nil), Equals, 0)
+	c.Check(wrapWidth([]string{"a=1", "b=2", "continued"}), Equals, 0)
+	// equally long lines followed by variables are not wrapped
+	c.Check(wrapWidth([]string{"a=" + long, "b=1", "c=" + long, "d=2"}), Equals, 0)
+	c.Check(wrapWidth([]string{"a=" + long, "b=1", "c=" + long, "continued"}), Equals, 40)
+}
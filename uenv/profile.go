@@ -0,0 +1,167 @@
+package uenv
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Profile describes how a board stores its uboot env so that callers
+// do not need to know the magic numbers of the board config.
+type Profile struct {
+	// Name of the board, e.g. "beaglebone"
+	Name string
+	// FileName is the name of the env file if the env is stored
+	// on the boot partition (CONFIG_ENV_FAT_FILE)
+	FileName string
+	// Config contains the size, offsets and header layout of the
+	// env when stored on the raw device
+	Config Config
+	// Defaults are the values of the default env of the board that
+	// differ from the generic defaults of the Schema
+	Defaults map[string]string
+}
+
+var profiles = map[string]Profile{
+	"raspberrypi": {
+		Name:     "raspberrypi",
+		FileName: "uboot.env",
+		Config: Config{
+			Size:       0x4000,
+			HeaderSize: 4,
+		},
+		Defaults: map[string]string{
+			"kernel_addr_r":  "0x00080000",
+			"scriptaddr":     "0x02400000",
+			"pxefile_addr_r": "0x02500000",
+			"fdt_addr_r":     "0x02600000",
+			"ramdisk_addr_r": "0x02700000",
+		},
+	},
+	"beaglebone": {
+		Name:     "beaglebone",
+		FileName: "uboot.env",
+		Config: Config{
+			Size:            0x20000,
+			Offset:          0x260000,
+			HeaderSize:      5,
+			Redundant:       true,
+			RedundantOffset: 0x280000,
+		},
+		Defaults: map[string]string{
+			"loadaddr":       "0x82000000",
+			"kernel_addr_r":  "0x82000000",
+			"fdt_addr_r":     "0x88000000",
+			"ramdisk_addr_r": "0x88080000",
+			"scriptaddr":     "0x80000000",
+			"pxefile_addr_r": "0x80100000",
+		},
+	},
+	"rockpro64": {
+		Name: "rockpro64",
+		Config: Config{
+			Size:       0x8000,
+			Offset:     0x3f8000,
+			HeaderSize: 4,
+		},
+		Defaults: map[string]string{
+			"scriptaddr":         "0x00500000",
+			"pxefile_addr_r":     "0x00600000",
+			"fdt_addr_r":         "0x01f00000",
+			"kernel_addr_r":      "0x02080000",
+			"ramdisk_addr_r":     "0x06000000",
+			"kernel_comp_addr_r": "0x08000000",
+		},
+	},
+	"qemu_arm64": {
+		Name: "qemu_arm64",
+		Config: Config{
+			Size:       0x40000,
+			HeaderSize: 4,
+		},
+		Defaults: map[string]string{
+			"scriptaddr":     "0x40200000",
+			"pxefile_addr_r": "0x40300000",
+			"kernel_addr_r":  "0x40400000",
+			"ramdisk_addr_r": "0x44000000",
+		},
+	},
+}
+
+// RegisterProfile adds the given profile to the registry, an existing
+// profile with the same name is replaced.
+func RegisterProfile(p Profile) {
+	profiles[p.Name] = p
+}
+
+// LookupProfile returns the profile for the given board
+func LookupProfile(board string) (Profile, error) {
+	p, ok := profiles[board]
+	if !ok {
+		return Profile{}, fmt.Errorf("unknown board %q", board)
+	}
+	return p, nil
+}
+
+// Profiles returns the sorted names of all known boards
+func Profiles() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ConfigFor returns the file name and config to use for the env of
+// the board on devicePath. If devicePath is a directory it is
+// treated as the mounted boot partition that contains the env file,
+// otherwise it is the raw device (or an image of it).
+func (p *Profile) ConfigFor(devicePath string) (string, Config, error) {
+	st, err := os.Stat(devicePath)
+	if err != nil {
+		return "", Config{}, err
+	}
+	if !st.IsDir() {
+		return devicePath, p.Config, nil
+	}
+	if p.FileName == "" {
+		return "", Config{}, fmt.Errorf("board %q does not store its env in a file", p.Name)
+	}
+	cfg := Config{
+		Size:       p.Config.Size,
+		HeaderSize: p.Config.HeaderSize,
+		Flags:      p.Config.Flags,
+	}
+	return filepath.Join(devicePath, p.FileName), cfg, nil
+}
+
+// OpenForBoard opens the env of the given board. The devicePath is
+// either the raw device (or image) that contains the env or the
+// directory of the mounted boot partition with the env file.
+func OpenForBoard(board, devicePath string) (*Env, error) {
+	p, err := LookupProfile(board)
+	if err != nil {
+		return nil, err
+	}
+	fname, cfg, err := p.ConfigFor(devicePath)
+	if err != nil {
+		return nil, err
+	}
+	return OpenWithConfig(fname, cfg)
+}
+
+// CreateForBoard creates a new empty env for the given board, see
+// OpenForBoard for the meaning of devicePath.
+func CreateForBoard(board, devicePath string) (*Env, error) {
+	p, err := LookupProfile(board)
+	if err != nil {
+		return nil, err
+	}
+	fname, cfg, err := p.ConfigFor(devicePath)
+	if err != nil {
+		return nil, err
+	}
+	return CreateWithConfig(fname, cfg)
+}
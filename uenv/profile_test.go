@@ -0,0 +1,87 @@
+package uenv
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+type profileTestSuite struct{}
+
+var _ = Suite(&profileTestSuite{})
+
+func (s *profileTestSuite) TestLookupProfile(c *C) {
+	p, err := LookupProfile("beaglebone")
+	c.Assert(err, IsNil)
+	c.Check(p.Config.Size, Equals, 0x20000)
+	c.Check(p.Config.Offset, Equals, int64(0x260000))
+	c.Check(p.Config.Redundant, Equals, true)
+
+	_, err = LookupProfile("no-such-board")
+	c.Assert(err, ErrorMatches, `unknown board "no-such-board"`)
+}
+
+func (s *profileTestSuite) TestProfiles(c *C) {
+	c.Check(Profiles(), DeepEquals, []string{"beaglebone", "qemu_arm64", "raspberrypi", "rockpro64"})
+}
+
+func (s *profileTestSuite) TestRegisterProfile(c *C) {
+	RegisterProfile(Profile{Name: "my-board", Config: Config{Size: 16}})
+	defer delete(profiles, "my-board")
+
+	p, err := LookupProfile("my-board")
+	c.Assert(err, IsNil)
+	c.Check(p.Config.Size, Equals, 16)
+}
+
+func (s *profileTestSuite) TestCreateAndOpenForBoardInDir(c *C) {
+	bootDir := c.MkDir()
+	env, err := CreateForBoard("raspberrypi", bootDir)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	st, err := os.Stat(filepath.Join(bootDir, "uboot.env"))
+	c.Assert(err, IsNil)
+	c.Check(st.Size(), Equals, int64(0x4000))
+
+	env, err = OpenForBoard("raspberrypi", bootDir)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("foo"), Equals, "bar")
+}
+
+func (s *profileTestSuite) TestOpenForBoardRawDevice(c *C) {
+	RegisterProfile(Profile{Name: "tiny", Config: Config{
+		Size:            32,
+		Offset:          64,
+		HeaderSize:      5,
+		Redundant:       true,
+		RedundantOffset: 96,
+	}})
+	defer delete(profiles, "tiny")
+
+	device := filepath.Join(c.MkDir(), "disk.img")
+	c.Assert(ioutil.WriteFile(device, make([]byte, 128), 0644), IsNil)
+
+	env, err := CreateForBoard("tiny", device)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	content, err := ioutil.ReadFile(device)
+	c.Assert(err, IsNil)
+	c.Check(content, HasLen, 128)
+	c.Check(string(content[69:76]), Equals, "foo=bar")
+	c.Check(string(content[101:108]), Equals, "foo=bar")
+
+	env, err = OpenForBoard("tiny", device)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("foo"), Equals, "bar")
+}
+
+func (s *profileTestSuite) TestOpenForBoardNoFileName(c *C) {
+	_, err := OpenForBoard("rockpro64", c.MkDir())
+	c.Assert(err, ErrorMatches, `board "rockpro64" does not store its env in a file`)
+}
@@ -0,0 +1,101 @@
+package uenv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RetryPolicy controls how Save retries writes of an env copy that
+// failed, e.g. because the media returned a transient EIO.
+type RetryPolicy struct {
+	// Attempts is the maximum number of writes of a copy, values
+	// below 2 mean that failed writes are not retried.
+	Attempts int
+	// Backoff is the delay before the first retry, it doubles with
+	// every following retry.
+	Backoff time.Duration
+	// MaxBackoff limits the delay between retries, zero means no
+	// limit.
+	MaxBackoff time.Duration
+	// Verify reads every written copy back and retries the write if
+	// the content differs.
+	Verify bool
+	// Retryable decides if a failed write is retried, if nil every
+	// error is retried.
+	Retryable func(err error) bool
+}
+
+// SaveError is returned by Save if writing a copy failed while a
+// RetryPolicy is used. It contains the errors of all attempts.
+type SaveError struct {
+	Source   Source
+	Attempts []error
+}
+
+func (e *SaveError) Error() string {
+	msgs := make([]string, len(e.Attempts))
+	for i, err := range e.Attempts {
+		msgs[i] = fmt.Sprintf("attempt %v: %v", i+1, err)
+	}
+	return fmt.Sprintf("cannot save %s copy after %v attempts: %s", e.Source, len(e.Attempts), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the error of the last attempt
+func (e *SaveError) Unwrap() error {
+	return e.Attempts[len(e.Attempts)-1]
+}
+
+// writeCopy writes content to the storage of the given copy and
+// applies the retry policy of the config
+func (env *Env) writeCopy(ctx context.Context, source Source, content []byte) error {
+	s := env.storage(source)
+	p := env.config.Retry
+	if p == nil {
+		return env.writeImage(ctx, s, content)
+	}
+
+	saveErr := &SaveError{Source: source}
+	delay := p.Backoff
+	for {
+		err := env.writeImage(ctx, s, content)
+		if err == nil && p.Verify {
+			err = verifyWrite(s, content)
+		}
+		if err == nil {
+			return nil
+		}
+		if err == ctx.Err() {
+			return err
+		}
+		saveErr.Attempts = append(saveErr.Attempts, err)
+		if len(saveErr.Attempts) >= p.Attempts || (p.Retryable != nil && !p.Retryable(err)) {
+			return saveErr
+		}
+
+		env.debug("retrying env write", "target", source, "attempt", len(saveErr.Attempts), "error", err, "delay", delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return saveErr
+		}
+		delay *= 2
+		if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+			delay = p.MaxBackoff
+		}
+	}
+}
+
+// verifyWrite checks that the storage contains content
+func verifyWrite(s Storage, content []byte) error {
+	written, err := s.ReadAll(len(content))
+	if err != nil {
+		return fmt.Errorf("cannot read back env: %v", err)
+	}
+	if !bytes.Equal(written, content) {
+		return fmt.Errorf("read back env differs from written env")
+	}
+	return nil
+}
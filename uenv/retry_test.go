@@ -0,0 +1,95 @@
+package uenv
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type retryTestSuite struct{}
+
+var _ = Suite(&retryTestSuite{})
+
+// flakyStorage fails the first failures writes with EIO
+type flakyStorage struct {
+	*MemoryStorage
+	failures int
+	writes   int
+	// corrupt makes successful writes store a different content
+	corrupt bool
+}
+
+func (fs *flakyStorage) WriteInPlace(content []byte) error {
+	fs.writes++
+	if fs.writes <= fs.failures {
+		return syscall.EIO
+	}
+	if fs.corrupt {
+		content = append([]byte{^content[0]}, content[1:]...)
+	}
+	return fs.MemoryStorage.WriteInPlace(content)
+}
+
+func (s *retryTestSuite) TestRetryTransientError(c *C) {
+	fs := &flakyStorage{MemoryStorage: NewMemoryStorage(nil), failures: 2}
+	env, err := CreateStorage(fs, Config{Size: 32, Retry: &RetryPolicy{Attempts: 3, Backoff: time.Millisecond, Verify: true}})
+	c.Assert(err, IsNil)
+	env.Set("a", "b")
+	c.Assert(env.Save(), IsNil)
+	c.Check(fs.writes, Equals, 3)
+
+	env, err = OpenStorage(fs, Config{Size: 32})
+	c.Assert(err, IsNil)
+	c.Check(env.Get("a"), Equals, "b")
+}
+
+func (s *retryTestSuite) TestRetryGivesUp(c *C) {
+	fs := &flakyStorage{MemoryStorage: NewMemoryStorage(nil), failures: 5}
+	env, err := CreateStorage(fs, Config{Size: 32, Retry: &RetryPolicy{Attempts: 2}})
+	c.Assert(err, IsNil)
+	err = env.Save()
+	c.Check(err, ErrorMatches, "cannot save primary copy after 2 attempts: attempt 1: input/output error; attempt 2: input/output error")
+	c.Check(errors.Is(err, syscall.EIO), Equals, true)
+	saveErr, ok := err.(*SaveError)
+	c.Assert(ok, Equals, true)
+	c.Check(saveErr.Source, Equals, SourcePrimary)
+	c.Check(saveErr.Attempts, HasLen, 2)
+}
+
+func (s *retryTestSuite) TestRetryVerify(c *C) {
+	fs := &flakyStorage{MemoryStorage: NewMemoryStorage(nil), corrupt: true}
+	env, err := CreateStorage(fs, Config{Size: 32, Retry: &RetryPolicy{Attempts: 2, Verify: true}})
+	c.Assert(err, IsNil)
+	err = env.Save()
+	c.Check(err, ErrorMatches, "cannot save primary copy after 2 attempts: attempt 1: read back env differs from written env; .*")
+}
+
+func (s *retryTestSuite) TestRetryable(c *C) {
+	fs := &flakyStorage{MemoryStorage: NewMemoryStorage(nil), failures: 1}
+	retryable := func(err error) bool { return err != syscall.EIO }
+	env, err := CreateStorage(fs, Config{Size: 32, Retry: &RetryPolicy{Attempts: 3, Retryable: retryable}})
+	c.Assert(err, IsNil)
+	c.Check(env.Save(), ErrorMatches, "cannot save primary copy after 1 attempts: .*")
+	c.Check(fs.writes, Equals, 1)
+}
+
+func (s *retryTestSuite) TestRetryCanceled(c *C) {
+	fs := &flakyStorage{MemoryStorage: NewMemoryStorage(nil), failures: 5}
+	env, err := CreateStorage(fs, Config{Size: 32, Retry: &RetryPolicy{Attempts: 5, Backoff: time.Hour}})
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	c.Check(env.SaveContext(ctx), ErrorMatches, "cannot save primary copy after 1 attempts: .*")
+	c.Check(fs.writes, Equals, 1)
+}
+
+func (s *retryTestSuite) TestNoRetryPolicy(c *C) {
+	fs := &flakyStorage{MemoryStorage: NewMemoryStorage(nil), failures: 1}
+	env, err := CreateStorage(fs, Config{Size: 32})
+	c.Assert(err, IsNil)
+	c.Check(env.Save(), Equals, syscall.EIO)
+}
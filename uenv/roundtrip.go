@@ -0,0 +1,45 @@
+package uenv
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// RoundTripCheck serializes the env like Save does, parses the image
+// again and checks that it decodes to exactly the variables of the
+// env. It catches variables that cannot be stored and transforms that
+// do not decode what they encode before anything reaches the flash.
+// Unlike Validate it does not check the variables themselves.
+func RoundTripCheck(env *Env) error {
+	if err := env.checkSize(); err != nil {
+		return err
+	}
+	return env.roundTrip(env.image(env.flags))
+}
+
+// roundTrip checks that the image content decodes to the variables of
+// the env
+func (env *Env) roundTrip(content []byte) error {
+	data, _, err := parseImage(content, env.headerSize(), 0, env.config.Transform, env.config.checksum())
+	if err != nil {
+		return fmt.Errorf("cannot read back env: %v", err)
+	}
+	if !reflect.DeepEqual(data, env.data) {
+		var problems []string
+		for _, change := range Diff(&Env{data: data}, env) {
+			problems = append(problems, fmt.Sprintf("%q", change.Key))
+		}
+		return fmt.Errorf("cannot read back env: %s differ", strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// guard runs the round trip check on content before it is saved if
+// the config asks for it
+func (env *Env) guard(content []byte) error {
+	if !env.config.GuardSave {
+		return nil
+	}
+	return env.roundTrip(content)
+}
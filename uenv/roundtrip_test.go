@@ -0,0 +1,78 @@
+package uenv
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing/quick"
+
+	. "gopkg.in/check.v1"
+)
+
+type roundTripTestSuite struct{}
+
+var _ = Suite(&roundTripTestSuite{})
+
+func (s *roundTripTestSuite) TestRoundTripCheckProperty(c *C) {
+	aes, err := NewAESCBC(bytes.Repeat([]byte{0x42}, 16))
+	c.Assert(err, IsNil)
+	for _, t := range []Transform{nil, &XORTransform{Key: []byte("key")}, aes} {
+		// every env that Set accepts survives the round trip
+		property := func(vars map[string]string) bool {
+			env := New(Config{Size: 64 * 1024, Transform: t})
+			for k, v := range vars {
+				if k == "" {
+					continue
+				}
+				env.Set(k, v)
+			}
+			return RoundTripCheck(env) == nil
+		}
+		c.Check(quick.Check(property, nil), IsNil)
+	}
+}
+
+// brokenTransform does not decode what it encodes
+type brokenTransform struct{}
+
+func (brokenTransform) Encode(payload []byte) []byte {
+	return bytes.Replace(payload, []byte("a"), []byte("b"), -1)
+}
+
+func (brokenTransform) Decode(payload []byte) []byte {
+	return payload
+}
+
+func (s *roundTripTestSuite) TestRoundTripCheckFails(c *C) {
+	env := New(Config{Size: 64, Transform: brokenTransform{}})
+	c.Assert(env.Set("a", "1"), IsNil)
+	c.Assert(env.Set("c", "a"), IsNil)
+	c.Check(RoundTripCheck(env), ErrorMatches, `cannot read back env: "a", "b", "c" differ`)
+
+	// a name that cannot be stored
+	env = New(Config{Size: 64})
+	env.data["a=b"] = "c"
+	c.Check(RoundTripCheck(env), ErrorMatches, `cannot read back env: "a", "a=b" differ`)
+
+	env = New(Config{Size: 8})
+	c.Assert(env.Set("a", "12345"), IsNil)
+	c.Check(RoundTripCheck(env), ErrorMatches, "env too large: .*")
+}
+
+func (s *roundTripTestSuite) TestGuardSave(c *C) {
+	fname := filepath.Join(c.MkDir(), "uboot.env")
+	env, err := CreateWithConfig(fname, Config{Size: 64, Transform: brokenTransform{}, GuardSave: true})
+	c.Assert(err, IsNil)
+	c.Assert(env.Set("a", "1"), IsNil)
+	c.Check(env.Save(), ErrorMatches, "cannot read back env: .*")
+	// nothing was written
+	_, err = OpenWithConfig(fname, Config{Size: 64})
+	c.Check(err, ErrorMatches, "cannot read env .*")
+
+	env, err = CreateWithConfig(fname, Config{Size: 64, GuardSave: true})
+	c.Assert(err, IsNil)
+	c.Assert(env.Set("a", "1"), IsNil)
+	c.Assert(env.Save(), IsNil)
+	env, err = Open(fname)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("a"), Equals, "1")
+}
@@ -0,0 +1,185 @@
+package uenv
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VarType is the type of the value of a variable
+type VarType string
+
+// The types of the variables of the Schema
+const (
+	// TypeString is any text
+	TypeString VarType = "string"
+	// TypeCommand is a script that uboot runs, e.g. bootcmd
+	TypeCommand VarType = "command"
+	// TypeInt is a decimal number
+	TypeInt VarType = "int"
+	// TypeHex is a hex number like an address, uboot reads it with
+	// or without a 0x prefix
+	TypeHex VarType = "hex"
+	// TypeBool is a yes/no value, uboot only looks at the first
+	// character: y, Y, t, T or 1 is true
+	TypeBool VarType = "bool"
+	// TypeMAC is a MAC address like 02:00:00:00:00:01
+	TypeMAC VarType = "mac"
+	// TypeIP is an IPv4 address
+	TypeIP VarType = "ip"
+)
+
+// VarSchema describes a well known variable
+type VarSchema struct {
+	Name        string  `json:"name"`
+	Type        VarType `json:"type"`
+	Description string  `json:"description"`
+	// Default is the value of the default env, empty if the
+	// variable is not set by default
+	Default string `json:"default,omitempty"`
+}
+
+// Check returns an error if value is not valid for the type of the
+// variable
+func (v VarSchema) Check(value string) error {
+	var err error
+	switch v.Type {
+	case TypeInt:
+		_, err = strconv.ParseInt(value, 10, 64)
+	case TypeHex:
+		_, err = strconv.ParseUint(strings.TrimPrefix(strings.ToLower(value), "0x"), 16, 64)
+	case TypeBool:
+		if value == "" || !strings.ContainsRune("yYtT1nNfF0", rune(value[0])) {
+			err = fmt.Errorf("not a yes/no value")
+		}
+	case TypeMAC:
+		var hw net.HardwareAddr
+		hw, err = net.ParseMAC(value)
+		if err == nil && len(hw) != 6 {
+			err = fmt.Errorf("not an ethernet address")
+		}
+	case TypeIP:
+		if ip := net.ParseIP(value); ip == nil || ip.To4() == nil {
+			err = fmt.Errorf("not an IPv4 address")
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s value %q for %s: %v", v.Type, value, v.Name, err)
+	}
+	return nil
+}
+
+var schema = map[string]VarSchema{}
+
+func init() {
+	for _, v := range []VarSchema{
+		{"arch", TypeString, "CPU architecture of the board, e.g. arm", ""},
+		{"board", TypeString, "name of the board", ""},
+		{"board_name", TypeString, "name of the board as detected at runtime", ""},
+		{"cpu", TypeString, "CPU type of the board", ""},
+		{"soc", TypeString, "SoC family of the board", ""},
+		{"vendor", TypeString, "vendor of the board", ""},
+		{"serial#", TypeString, "serial number of the board, can only be set once", ""},
+		{"ver", TypeString, "version of uboot, set at runtime", ""},
+
+		{"bootcmd", TypeCommand, "command run when autoboot is not interrupted", ""},
+		{"altbootcmd", TypeCommand, "command run instead of bootcmd once bootcount exceeds bootlimit", ""},
+		{"preboot", TypeCommand, "command run before the autoboot countdown", ""},
+		{"distro_bootcmd", TypeCommand, "boots the first bootable device of boot_targets", ""},
+		{"boot_targets", TypeString, "devices tried by distro_bootcmd in order, e.g. \"mmc0 usb0 pxe\"", ""},
+		{"bootargs", TypeString, "kernel command line passed to the kernel", ""},
+		{"bootdelay", TypeInt, "seconds to wait for a key before autoboot, -1 disables autoboot and -2 skips the check for a key", "2"},
+		{"bootcount", TypeInt, "number of boots since the counter was last reset (CONFIG_BOOTCOUNT_ENV)", ""},
+		{"bootlimit", TypeInt, "bootcount after which altbootcmd is run instead of bootcmd", ""},
+		{"upgrade_available", TypeBool, "enables bootcount after an update until the new system confirmed it booted", ""},
+		{"silent", TypeBool, "suppresses the console output of uboot", ""},
+		{"autoload", TypeBool, "makes dhcp and bootp also load bootfile", ""},
+
+		{"baudrate", TypeInt, "baud rate of the serial console", "115200"},
+		{"stdin", TypeString, "console input devices, e.g. serial,usbkbd", ""},
+		{"stdout", TypeString, "console output devices, e.g. serial,vidconsole", ""},
+		{"stderr", TypeString, "console error output devices", ""},
+
+		{"loadaddr", TypeHex, "default address for load commands", ""},
+		{"kernel_addr_r", TypeHex, "address the kernel is loaded to", ""},
+		{"kernel_comp_addr_r", TypeHex, "address a compressed kernel is decompressed from", ""},
+		{"kernel_comp_size", TypeHex, "maximum size of a compressed kernel", ""},
+		{"ramdisk_addr_r", TypeHex, "address the initrd is loaded to", ""},
+		{"fdt_addr_r", TypeHex, "address the device tree is loaded to", ""},
+		{"fdtoverlay_addr_r", TypeHex, "address device tree overlays are loaded to", ""},
+		{"scriptaddr", TypeHex, "address boot scripts are loaded to", ""},
+		{"pxefile_addr_r", TypeHex, "address PXE config files are loaded to", ""},
+		{"fdtcontroladdr", TypeHex, "address of the device tree uboot itself uses, set at runtime", ""},
+		{"fdt_high", TypeHex, "highest address the device tree is relocated to, 0xffffffff disables the relocation", ""},
+		{"initrd_high", TypeHex, "highest address the initrd is relocated to, 0xffffffff disables the relocation", ""},
+		{"filesize", TypeHex, "size of the last loaded file, set by load commands", ""},
+		{"fdtfile", TypeString, "file name of the device tree, relative to fdtdir or the boot partition", ""},
+		{"fdtdir", TypeString, "directory of the device trees on the boot partition", ""},
+
+		{"ethaddr", TypeMAC, "MAC address of the first ethernet device, can only be set once", ""},
+		{"eth1addr", TypeMAC, "MAC address of the second ethernet device", ""},
+		{"ipaddr", TypeIP, "IP address of the board", ""},
+		{"serverip", TypeIP, "IP address of the TFTP server", ""},
+		{"gatewayip", TypeIP, "IP address of the gateway", ""},
+		{"netmask", TypeIP, "netmask of the network", ""},
+		{"bootfile", TypeString, "file loaded from the TFTP server", ""},
+		{"hostname", TypeString, "host name sent to the DHCP server", ""},
+	} {
+		RegisterVar(v)
+	}
+}
+
+// RegisterVar adds the given variable to the schema, an existing
+// variable with the same name is replaced, e.g. to describe the
+// variables of a boot script.
+func RegisterVar(v VarSchema) {
+	schema[v.Name] = v
+}
+
+// LookupVar returns the schema of the given variable, the default is
+// the generic one
+func LookupVar(name string) (VarSchema, bool) {
+	v, ok := schema[name]
+	return v, ok
+}
+
+// Schema returns all known variables sorted by name. If board is not
+// empty the defaults are those of its profile.
+func Schema(board string) ([]VarSchema, error) {
+	var defaults map[string]string
+	if board != "" {
+		p, err := LookupProfile(board)
+		if err != nil {
+			return nil, err
+		}
+		defaults = p.Defaults
+	}
+	vars := make([]VarSchema, 0, len(schema))
+	for name, v := range schema {
+		if value, ok := defaults[name]; ok {
+			v.Default = value
+		}
+		vars = append(vars, v)
+	}
+	sort.Slice(vars, func(i, j int) bool {
+		return vars[i].Name < vars[j].Name
+	})
+	return vars, nil
+}
+
+// ExportSchemaJSON writes the Schema of the board as a JSON array, e.g.
+// for documentation generators
+func ExportSchemaJSON(w io.Writer, board string) error {
+	vars, err := Schema(board)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(vars)
+}
@@ -0,0 +1,120 @@
+package uenv
+
+import (
+	"bytes"
+	"encoding/json"
+
+	. "gopkg.in/check.v1"
+)
+
+type schemaTestSuite struct{}
+
+var _ = Suite(&schemaTestSuite{})
+
+func (s *schemaTestSuite) TestLookupVar(c *C) {
+	v, ok := LookupVar("bootdelay")
+	c.Assert(ok, Equals, true)
+	c.Check(v.Type, Equals, TypeInt)
+	c.Check(v.Default, Equals, "2")
+	c.Check(v.Description, Not(Equals), "")
+
+	_, ok = LookupVar("no-such-var")
+	c.Check(ok, Equals, false)
+}
+
+func (s *schemaTestSuite) TestRegisterVar(c *C) {
+	RegisterVar(VarSchema{Name: "snap_mode", Type: TypeString, Description: "state of a snap update"})
+	defer delete(schema, "snap_mode")
+
+	v, ok := LookupVar("snap_mode")
+	c.Assert(ok, Equals, true)
+	c.Check(v.Description, Equals, "state of a snap update")
+}
+
+func (s *schemaTestSuite) TestSchema(c *C) {
+	vars, err := Schema("")
+	c.Assert(err, IsNil)
+	c.Check(vars, HasLen, len(schema))
+	for i := range vars[1:] {
+		c.Check(vars[i].Name < vars[i+1].Name, Equals, true)
+	}
+
+	byName := func(vars []VarSchema, name string) VarSchema {
+		for _, v := range vars {
+			if v.Name == name {
+				return v
+			}
+		}
+		c.Fatalf("%s not in schema", name)
+		return VarSchema{}
+	}
+	c.Check(byName(vars, "kernel_addr_r").Default, Equals, "")
+
+	vars, err = Schema("rockpro64")
+	c.Assert(err, IsNil)
+	c.Check(byName(vars, "kernel_addr_r").Default, Equals, "0x02080000")
+	c.Check(byName(vars, "bootdelay").Default, Equals, "2")
+
+	_, err = Schema("no-such-board")
+	c.Check(err, ErrorMatches, `unknown board "no-such-board"`)
+}
+
+func (s *schemaTestSuite) TestProfileDefaultsAreKnown(c *C) {
+	for _, name := range Profiles() {
+		p, err := LookupProfile(name)
+		c.Assert(err, IsNil)
+		for k, v := range p.Defaults {
+			vs, ok := LookupVar(k)
+			c.Assert(ok, Equals, true, Commentf("%s: %s", name, k))
+			c.Check(vs.Check(v), IsNil)
+		}
+	}
+}
+
+func (s *schemaTestSuite) TestExportSchemaJSON(c *C) {
+	buf := bytes.NewBuffer(nil)
+	c.Assert(ExportSchemaJSON(buf, "raspberrypi"), IsNil)
+
+	var vars []VarSchema
+	c.Assert(json.Unmarshal(buf.Bytes(), &vars), IsNil)
+	expected, err := Schema("raspberrypi")
+	c.Assert(err, IsNil)
+	c.Check(vars, DeepEquals, expected)
+	c.Check(buf.String(), Matches, `(?s).*
+  {
+    "name": "fdt_addr_r",
+    "type": "hex",
+    "description": "address the device tree is loaded to",
+    "default": "0x02600000"
+  },
+.*`)
+}
+
+func (s *schemaTestSuite) TestCheck(c *C) {
+	for _, t := range []struct {
+		typ   VarType
+		value string
+		ok    bool
+	}{
+		{TypeString, "anything", true},
+		{TypeCommand, "run a; run b", true},
+		{TypeInt, "-2", true},
+		{TypeInt, "0x10", false},
+		{TypeHex, "0x82000000", true},
+		{TypeHex, "82000000", true},
+		{TypeHex, "${loadaddr}", false},
+		{TypeBool, "yes", true},
+		{TypeBool, "0", true},
+		{TypeBool, "maybe", false},
+		{TypeBool, "", false},
+		{TypeMAC, "02:00:00:00:00:01", true},
+		{TypeMAC, "02:00:00:00:00", false},
+		{TypeIP, "192.168.0.1", true},
+		{TypeIP, "::1", false},
+	} {
+		err := VarSchema{Name: "v", Type: t.typ}.Check(t.value)
+		c.Check(err == nil, Equals, t.ok, Commentf("%s %q: %v", t.typ, t.value, err))
+	}
+	err := VarSchema{Name: "bootdelay", Type: TypeInt}.Check("soon")
+	c.Check(err, ErrorMatches, `invalid int value "soon" for bootdelay: .*`)
+}
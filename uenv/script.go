@@ -0,0 +1,53 @@
+package uenv
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ExportScript writes all variables as "setenv name 'value'" lines
+// that can be pasted into the uboot console, e.g. to apply an env that
+// was prepared offline over a serial console. With saveenv a final
+// "saveenv" line makes uboot write the env. The lines are not split,
+// values longer than the console buffer (CONFIG_SYS_CBSIZE) of the
+// board can not be pasted.
+func (env *Env) ExportScript(w io.Writer, saveenv bool) error {
+	var err error
+	env.iterEnv(func(key, value string) {
+		if err != nil {
+			return
+		}
+		if strings.IndexFunc(key+value, func(r rune) bool { return isControl(r) && r != '\t' }) >= 0 {
+			err = fmt.Errorf("cannot export %q as setenv script: control characters can not be entered on the console", key)
+			return
+		}
+		_, err = fmt.Fprintf(w, "setenv %s %s\n", hushQuote(key), hushQuote(value))
+	})
+	if err != nil {
+		return err
+	}
+	if saveenv {
+		_, err = fmt.Fprintln(w, "saveenv")
+	}
+	return err
+}
+
+// hushQuote quotes s for the uboot hush shell, single quotes keep "$",
+// ";" and the other special characters literal. A single quote
+// closes the quotes, is escaped with a backslash and opens them again
+// like in a POSIX shell.
+func hushQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, needsQuote) < 0 {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func needsQuote(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return false
+	}
+	return !strings.ContainsRune("_-.,:/+=@%", r)
+}
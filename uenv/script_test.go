@@ -0,0 +1,67 @@
+package uenv
+
+import (
+	"bytes"
+
+	. "gopkg.in/check.v1"
+)
+
+type scriptTestSuite struct{}
+
+var _ = Suite(&scriptTestSuite{})
+
+func (s *scriptTestSuite) TestExportScript(c *C) {
+	env := New(Config{Size: 4096})
+	c.Assert(env.Set("bootdelay", "0"), IsNil)
+	c.Assert(env.Set("bootcmd", "run distro_bootcmd; echo ${bootpart}"), IsNil)
+	c.Assert(env.Set("quote", "it's"), IsNil)
+	c.Assert(env.Set("console", "ttyS0,115200"), IsNil)
+
+	buf := bytes.NewBuffer(nil)
+	c.Assert(env.ExportScript(buf, false), IsNil)
+	c.Check(buf.String(), Equals, `setenv bootcmd 'run distro_bootcmd; echo ${bootpart}'
+setenv bootdelay 0
+setenv console ttyS0,115200
+setenv quote 'it'\''s'
+`)
+}
+
+func (s *scriptTestSuite) TestExportScriptSaveenv(c *C) {
+	env := New(Config{Size: 4096})
+	c.Assert(env.Set("a", "1"), IsNil)
+
+	buf := bytes.NewBuffer(nil)
+	c.Assert(env.ExportScript(buf, true), IsNil)
+	c.Check(buf.String(), Equals, "setenv a 1\nsaveenv\n")
+
+	buf.Reset()
+	c.Assert(New(Config{Size: 4096}).ExportScript(buf, true), IsNil)
+	c.Check(buf.String(), Equals, "saveenv\n")
+}
+
+func (s *scriptTestSuite) TestExportScriptControl(c *C) {
+	env := New(Config{Size: 4096})
+	c.Assert(env.Set("a", "1"), IsNil)
+	c.Assert(env.Set("b", "line1\nline2"), IsNil)
+	c.Assert(env.Set("c", "tab\there"), IsNil)
+
+	buf := bytes.NewBuffer(nil)
+	err := env.ExportScript(buf, true)
+	c.Check(err, ErrorMatches, `cannot export "b" as setenv script: control characters can not be entered on the console`)
+	c.Check(buf.String(), Equals, "setenv a 1\n")
+}
+
+func (s *scriptTestSuite) TestHushQuote(c *C) {
+	for _, t := range []struct{ in, out string }{
+		{"plain", "plain"},
+		{"", "''"},
+		{"a b", "'a b'"},
+		{"$x", "'$x'"},
+		{"a;b", "'a;b'"},
+		{`back\slash`, `'back\slash'`},
+		{"'", `''\'''`},
+		{"0x82000000", "0x82000000"},
+	} {
+		c.Check(hushQuote(t.in), Equals, t.out, Commentf("%q", t.in))
+	}
+}
@@ -0,0 +1,155 @@
+package uenv
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Signer creates and checks detached signatures of env images. Unlike
+// the CRC of the env a signature detects deliberate modifications.
+type Signer interface {
+	// Sign returns the signature of the given env image
+	Sign(image []byte) ([]byte, error)
+	// Verify returns an error if sig is not a valid signature of
+	// the given env image
+	Verify(image, sig []byte) error
+}
+
+type hmacSigner struct {
+	key []byte
+}
+
+// NewHMACSigner returns a Signer that uses HMAC-SHA256 with the given
+// key.
+func NewHMACSigner(key []byte) Signer {
+	return &hmacSigner{key: append([]byte(nil), key...)}
+}
+
+func (h *hmacSigner) Sign(image []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write(image)
+	return mac.Sum(nil), nil
+}
+
+func (h *hmacSigner) Verify(image, sig []byte) error {
+	expected, _ := h.Sign(image)
+	if !hmac.Equal(expected, sig) {
+		return fmt.Errorf("bad HMAC")
+	}
+	return nil
+}
+
+type ed25519Signer struct {
+	priv ed25519.PrivateKey
+	pub  ed25519.PublicKey
+}
+
+// NewEd25519Signer returns a Signer that signs with the given private
+// key and verifies with its public key.
+func NewEd25519Signer(priv ed25519.PrivateKey) Signer {
+	return &ed25519Signer{priv: priv, pub: priv.Public().(ed25519.PublicKey)}
+}
+
+// NewEd25519Verifier returns a Signer that can only verify signatures
+// of the given public key, e.g. on devices that never write the env.
+func NewEd25519Verifier(pub ed25519.PublicKey) Signer {
+	return &ed25519Signer{pub: pub}
+}
+
+func (e *ed25519Signer) Sign(image []byte) ([]byte, error) {
+	if e.priv == nil {
+		return nil, fmt.Errorf("cannot sign env without private key")
+	}
+	return ed25519.Sign(e.priv, image), nil
+}
+
+func (e *ed25519Signer) Verify(image, sig []byte) error {
+	if !ed25519.Verify(e.pub, image, sig) {
+		return fmt.Errorf("bad Ed25519 signature")
+	}
+	return nil
+}
+
+// signatureFile returns the file with the signature of the env
+func (env *Env) signatureFile() (string, error) {
+	if env.config.SignatureFile != "" {
+		return env.config.SignatureFile, nil
+	}
+	if env.fname == "" {
+		return "", fmt.Errorf("cannot use signer without signature file")
+	}
+	return env.fname + ".sig", nil
+}
+
+// checkSignature verifies the signature of the given env image
+func (env *Env) checkSignature(image []byte) error {
+	fname, err := env.signatureFile()
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return fmt.Errorf("cannot read env signature: %v", err)
+	}
+	sig, err := hex.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return fmt.Errorf("cannot decode env signature %s: %v", fname, err)
+	}
+	if err := env.config.Signer.Verify(image, sig); err != nil {
+		return fmt.Errorf("invalid env signature: %v", err)
+	}
+	return nil
+}
+
+// verifiedCopy checks the signature of the chosen copy. If it does not
+// match, e.g. because the power was cut after a redundant copy was
+// written but before its signature, the other valid copies are tried.
+func (env *Env) verifiedCopy(chosen *envCopy, source Source, copies []*envCopy) (*envCopy, Source, error) {
+	err := env.checkSignature(chosen.content)
+	if err == nil {
+		return chosen, source, nil
+	}
+	for i, c := range copies {
+		if c == chosen || c.err != nil || c.corrupt {
+			continue
+		}
+		if env.checkSignature(c.content) == nil {
+			env.debug("using env copy that matches the signature", "source", Source(i), "error", err)
+			return c, Source(i), nil
+		}
+	}
+	return nil, 0, err
+}
+
+// signature returns the signature of the given env image, it is nil
+// if the config has no signer. The signature is created before the env
+// is written so that a signer that fails does not leave an unsigned env
+// behind. It is only written after the env, see verifiedCopy for a
+// power cut in between.
+func (env *Env) signature(image []byte) ([]byte, error) {
+	if env.config.Signer == nil {
+		return nil, nil
+	}
+	if _, err := env.signatureFile(); err != nil {
+		return nil, err
+	}
+	return env.config.Signer.Sign(image)
+}
+
+// writeSignature writes the signature returned by signature
+func (env *Env) writeSignature(sig []byte) error {
+	if sig == nil {
+		return nil
+	}
+	fname, err := env.signatureFile()
+	if err != nil {
+		return err
+	}
+	fs := &FileStorage{Path: fname}
+	return fs.WriteAtomic([]byte(hex.EncodeToString(sig) + "\n"))
+}
@@ -0,0 +1,131 @@
+package uenv
+
+import (
+	"crypto/ed25519"
+	"io/ioutil"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+type signTestSuite struct {
+	envFile string
+}
+
+var _ = Suite(&signTestSuite{})
+
+func (s *signTestSuite) SetUpTest(c *C) {
+	s.envFile = filepath.Join(c.MkDir(), "uboot.env")
+}
+
+func (s *signTestSuite) TestHMAC(c *C) {
+	cfg := Config{Size: 64, Signer: NewHMACSigner([]byte("secret"))}
+	env, err := CreateWithConfig(s.envFile, cfg)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	sig, err := ioutil.ReadFile(s.envFile + ".sig")
+	c.Assert(err, IsNil)
+	c.Check(sig, HasLen, 65)
+
+	env, err = OpenWithConfig(s.envFile, cfg)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("foo"), Equals, "bar")
+
+	_, err = OpenWithConfig(s.envFile, Config{Signer: NewHMACSigner([]byte("other"))})
+	c.Check(err, ErrorMatches, "invalid env signature: bad HMAC")
+}
+
+func (s *signTestSuite) TestTampered(c *C) {
+	cfg := Config{Size: 64, Signer: NewHMACSigner([]byte("secret"))}
+	env, err := CreateWithConfig(s.envFile, cfg)
+	c.Assert(err, IsNil)
+	env.Set("bootdelay", "3")
+	c.Assert(env.Save(), IsNil)
+
+	// a valid env written without the key
+	env, err = Open(s.envFile)
+	c.Assert(err, IsNil)
+	env.Set("bootdelay", "0")
+	c.Assert(env.Save(), IsNil)
+
+	_, err = OpenWithConfig(s.envFile, cfg)
+	c.Check(err, ErrorMatches, "invalid env signature: bad HMAC")
+}
+
+func (s *signTestSuite) TestEd25519Redundant(c *C) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	c.Assert(err, IsNil)
+	sigFile := filepath.Join(c.MkDir(), "env.sig")
+	cfg := redundantConfig
+	cfg.Signer = NewEd25519Signer(priv)
+	cfg.SignatureFile = sigFile
+
+	env, err := CreateWithConfig(s.envFile, cfg)
+	c.Assert(err, IsNil)
+	env.Set("a", "1")
+	c.Assert(env.Save(), IsNil)
+	env.Set("a", "2")
+	c.Assert(env.Save(), IsNil)
+
+	verifyCfg := redundantConfig
+	verifyCfg.Signer = NewEd25519Verifier(pub)
+	verifyCfg.SignatureFile = sigFile
+	env, err = OpenWithConfig(s.envFile, verifyCfg)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("a"), Equals, "2")
+	env.Set("a", "3")
+	c.Check(env.Save(), ErrorMatches, "cannot sign env without private key")
+
+	// nothing got written
+	env, err = OpenWithConfig(s.envFile, verifyCfg)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("a"), Equals, "2")
+}
+
+func (s *signTestSuite) TestRedundantPowerCutBeforeSignature(c *C) {
+	cfg := redundantConfig
+	cfg.Signer = NewHMACSigner([]byte("secret"))
+	env, err := CreateWithConfig(s.envFile, cfg)
+	c.Assert(err, IsNil)
+	env.Set("a", "1")
+	c.Assert(env.Save(), IsNil)
+	env.Set("a", "2")
+	c.Assert(env.Save(), IsNil)
+
+	// the next copy got written but not its signature
+	env, err = OpenWithConfig(s.envFile, redundantConfig)
+	c.Assert(err, IsNil)
+	env.Set("a", "3")
+	c.Assert(env.Save(), IsNil)
+
+	// the other copy still matches the signature
+	env, err = OpenWithConfig(s.envFile, cfg)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("a"), Equals, "2")
+	c.Check(env.Source(), Equals, SourcePrimary)
+
+	// and the unsigned copy is overwritten by the next save
+	env.Set("a", "4")
+	c.Assert(env.Save(), IsNil)
+	env, err = OpenWithConfig(s.envFile, cfg)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("a"), Equals, "4")
+	c.Check(env.Source(), Equals, SourceRedundant)
+}
+
+func (s *signTestSuite) TestMissingSignature(c *C) {
+	env, err := Create(s.envFile, 64)
+	c.Assert(err, IsNil)
+	c.Assert(env.Save(), IsNil)
+
+	_, err = OpenWithConfig(s.envFile, Config{Signer: NewHMACSigner([]byte("secret"))})
+	c.Check(err, ErrorMatches, "cannot read env signature: .*")
+}
+
+func (s *signTestSuite) TestStorageNeedsSignatureFile(c *C) {
+	env, err := CreateStorage(NewMemoryStorage(nil), Config{Size: 64, Signer: NewHMACSigner([]byte("secret"))})
+	c.Assert(err, IsNil)
+	c.Check(env.Save(), ErrorMatches, "cannot use signer without signature file")
+}
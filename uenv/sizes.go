@@ -0,0 +1,44 @@
+package uenv
+
+import (
+	"fmt"
+)
+
+// Common values of CONFIG_ENV_SIZE
+const (
+	Size8K   = 0x2000
+	Size16K  = 0x4000
+	Size64K  = 0x10000
+	Size128K = 0x20000
+	Size256K = 0x40000
+)
+
+// CommonSizes are the common values of CONFIG_ENV_SIZE in ascending
+// order
+var CommonSizes = []int{Size8K, Size16K, Size64K, Size128K, Size256K}
+
+// sectorSize is the smallest flash sector, an env that is not one of
+// the CommonSizes is still plausible if it fills whole sectors
+const sectorSize = 0x1000
+
+// PlausibleSize returns true if size is one of the CommonSizes or a
+// multiple of a 4 KiB flash sector. Other sizes usually mean that the
+// wrong file, device or offset is used, e.g. a whole partition instead
+// of the env in it.
+func PlausibleSize(size int) bool {
+	for _, common := range CommonSizes {
+		if size == common {
+			return true
+		}
+	}
+	return size > 0 && size <= MaxSize && size%sectorSize == 0
+}
+
+// checkExpectedSize returns an error if the config expects a
+// different size
+func (cfg *Config) checkExpectedSize(size int) error {
+	if cfg.ExpectedSize != 0 && size != cfg.ExpectedSize {
+		return fmt.Errorf("env has %v bytes but %v bytes are expected", size, cfg.ExpectedSize)
+	}
+	return nil
+}
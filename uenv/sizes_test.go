@@ -0,0 +1,58 @@
+package uenv
+
+import (
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+type sizesTestSuite struct{}
+
+var _ = Suite(&sizesTestSuite{})
+
+func (s *sizesTestSuite) TestPlausibleSize(c *C) {
+	for _, size := range CommonSizes {
+		c.Check(PlausibleSize(size), Equals, true, Commentf("%#x", size))
+	}
+	c.Check(PlausibleSize(0x8000), Equals, true)
+	c.Check(PlausibleSize(0x1f000), Equals, true)
+	c.Check(PlausibleSize(0), Equals, false)
+	c.Check(PlausibleSize(16), Equals, false)
+	c.Check(PlausibleSize(10000), Equals, false)
+	c.Check(PlausibleSize(MaxSize+sectorSize), Equals, false)
+}
+
+func (s *sizesTestSuite) TestExpectedSize(c *C) {
+	envFile := filepath.Join(c.MkDir(), "uboot.env")
+	env, err := Create(envFile, Size8K)
+	c.Assert(err, IsNil)
+	c.Assert(env.Save(), IsNil)
+
+	_, err = OpenWithConfig(envFile, Config{ExpectedSize: Size8K})
+	c.Check(err, IsNil)
+	_, err = OpenWithConfig(envFile, Config{ExpectedSize: Size128K})
+	c.Check(err, ErrorMatches, "env has 8192 bytes but 131072 bytes are expected")
+
+	report, err := Verify(envFile, Config{ExpectedSize: Size128K})
+	c.Assert(err, IsNil)
+	c.Check(report.Problems, DeepEquals, []string{"primary copy: env has 8192 bytes but 131072 bytes are expected"})
+}
+
+func (s *sizesTestSuite) TestVerifyWarnsImplausibleSize(c *C) {
+	envFile := filepath.Join(c.MkDir(), "uboot.env")
+	env, err := Create(envFile, 10000)
+	c.Assert(err, IsNil)
+	c.Assert(env.Save(), IsNil)
+
+	report, err := Verify(envFile, Config{})
+	c.Assert(err, IsNil)
+	c.Check(report.OK(), Equals, true)
+	c.Check(report.Warnings, DeepEquals, []string{"primary copy: implausible env size 10000"})
+
+	env, err = Create(envFile, Size16K)
+	c.Assert(err, IsNil)
+	c.Assert(env.Save(), IsNil)
+	report, err = Verify(envFile, Config{})
+	c.Assert(err, IsNil)
+	c.Check(report.Warnings, HasLen, 0)
+}
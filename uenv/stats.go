@@ -0,0 +1,48 @@
+package uenv
+
+// Stats describes how much of the env is used
+type Stats struct {
+	// Size is the size of the env including the header
+	Size int
+	// Used is the number of bytes used by the header and the
+	// variables
+	Used int
+	// Free is the number of bytes still available, it is negative
+	// if the variables do not fit into the env
+	Free int
+	// Vars is the number of variables
+	Vars int
+	// LargestVar is the name of the variable that uses the most
+	// bytes and LargestVarSize the bytes it uses including the
+	// "=" and the terminating \0
+	LargestVar     string
+	LargestVarSize int
+	// Writes is the number of env copies written by Save, it is the
+	// lifetime total from the WriteCounterFile if one is configured
+	// and can be read and the number of writes since the env was
+	// opened otherwise
+	Writes uint64
+}
+
+// Stats returns the capacity and usage of the env
+func (env *Env) Stats() Stats {
+	stats := Stats{
+		Size:   env.size,
+		Used:   env.headerSize() + env.dataSize(),
+		Free:   env.Free(),
+		Vars:   len(env.data),
+		Writes: env.writes,
+	}
+	if fname := env.config.WriteCounterFile; fname != "" {
+		if writes, err := readWrites(fname); err == nil {
+			stats.Writes = writes
+		}
+	}
+	env.iterEnv(func(key, value string) {
+		if size := len(key) + len(value) + 2; size > stats.LargestVarSize {
+			stats.LargestVar = key
+			stats.LargestVarSize = size
+		}
+	})
+	return stats
+}
@@ -0,0 +1,78 @@
+package uenv
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+type statsTestSuite struct{}
+
+var _ = Suite(&statsTestSuite{})
+
+func (s *statsTestSuite) TestStatsEmpty(c *C) {
+	env := New(Config{Size: 64})
+	c.Check(env.Stats(), DeepEquals, Stats{
+		Size: 64,
+		Used: 7,
+		Free: 57,
+	})
+}
+
+func (s *statsTestSuite) TestStats(c *C) {
+	env := New(Config{Size: 64, HeaderSize: 4})
+	env.Set("a", "1")
+	env.Set("bootargs", "console=ttyS0")
+	env.Set("c", "12345678901234")
+	c.Check(env.Stats(), DeepEquals, Stats{
+		Size:           64,
+		Used:           4 + 4 + 23 + 17 + 1,
+		Free:           64 - 49,
+		Vars:           3,
+		LargestVar:     "bootargs",
+		LargestVarSize: 23,
+	})
+}
+
+func (s *statsTestSuite) TestStatsWrites(c *C) {
+	env, err := CreateStorage(NewMemoryStorage(nil), Config{Size: 64})
+	c.Assert(err, IsNil)
+	c.Check(env.Stats().Writes, Equals, uint64(0))
+	c.Assert(env.Save(), IsNil)
+	c.Assert(env.Save(), IsNil)
+	c.Check(env.Stats().Writes, Equals, uint64(2))
+}
+
+func (s *statsTestSuite) TestStatsWriteCounterFile(c *C) {
+	dir := c.MkDir()
+	counter := filepath.Join(dir, "uboot.env.writes")
+	cfg := Config{Redundant: true, RedundantStorage: NewMemoryStorage(nil), Size: 64, WriteCounterFile: counter}
+	primary := NewMemoryStorage(nil)
+
+	env, err := CreateStorage(primary, cfg)
+	c.Assert(err, IsNil)
+	// a new redundant env writes both copies
+	c.Assert(env.Save(), IsNil)
+	c.Check(env.Stats().Writes, Equals, uint64(2))
+
+	// the counter survives reopening the env
+	env, err = OpenStorage(primary, cfg)
+	c.Assert(err, IsNil)
+	c.Assert(env.Save(), IsNil)
+	c.Check(env.Stats().Writes, Equals, uint64(3))
+	content, err := ioutil.ReadFile(counter)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "3\n")
+}
+
+func (s *statsTestSuite) TestStatsBadWriteCounterFile(c *C) {
+	counter := filepath.Join(c.MkDir(), "uboot.env.writes")
+	c.Assert(ioutil.WriteFile(counter, []byte("garbage"), 0644), IsNil)
+
+	env, err := CreateStorage(NewMemoryStorage(nil), Config{Size: 64, WriteCounterFile: counter})
+	c.Assert(err, IsNil)
+	c.Assert(env.Save(), IsNil)
+	// the writes since open are used instead
+	c.Check(env.Stats().Writes, Equals, uint64(1))
+}
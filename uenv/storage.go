@@ -0,0 +1,282 @@
+package uenv
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+)
+
+var (
+	_ Storage      = (*FileStorage)(nil)
+	_ AtomicWriter = (*FileStorage)(nil)
+	_ AtomicLocker = (*FileStorage)(nil)
+	_ Storage      = (*MemoryStorage)(nil)
+)
+
+// Storage is the backing store of a single env copy. Implementations
+// exist for plain files (and block devices), MTD flash (which covers
+// SPI NOR), UBI volumes, eMMC boot partitions and memory.
+type Storage interface {
+	// ReadAll reads size bytes of the env, if size is zero
+	// everything up to the end of the storage is read.
+	ReadAll(size int) ([]byte, error)
+	// WriteInPlace overwrites the env with content.
+	WriteInPlace(content []byte) error
+	// Erase prepares size bytes of the env for writing, e.g. by
+	// erasing flash blocks. Storage that can be overwritten
+	// directly does nothing.
+	Erase(size int) error
+	// Lock takes an exclusive lock on the storage and returns the
+	// function to release it.
+	Lock() (unlock func() error, err error)
+}
+
+// AtomicWriter is implemented by storage that can replace the env
+// atomically, it is used by Save when Config.AtomicWrite is set.
+type AtomicWriter interface {
+	WriteAtomic(content []byte) error
+}
+
+// AtomicLocker is implemented by AtomicWriter storage that needs a
+// different lock when the env is replaced instead of overwritten, it
+// is used instead of Lock when Config.AtomicWrite is set. The lock is
+// only allowed to create files if write is set.
+type AtomicLocker interface {
+	LockAtomic(write bool) (unlock func() error, err error)
+}
+
+// FileStorage stores the env in a file or a block device at the given
+// offset.
+type FileStorage struct {
+	Path   string
+	Offset int64
+}
+
+// ReadAll reads size bytes of the env from the file
+func (fs *FileStorage) ReadAll(size int) ([]byte, error) {
+	f, err := os.Open(fs.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if size == 0 {
+		if _, err := f.Seek(fs.Offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadAll(io.LimitReader(f, MaxSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(content) > MaxSize {
+			return nil, fmt.Errorf("cannot read env from %s: larger than %v bytes", fs.Path, MaxSize)
+		}
+		return content, nil
+	}
+
+	content := make([]byte, size)
+	if _, err := f.ReadAt(content, fs.Offset); err != nil {
+		return nil, fmt.Errorf("cannot read env from %s at offset %v: %s", fs.Path, fs.Offset, err)
+	}
+	return content, nil
+}
+
+// WriteInPlace overwrites the env in the file
+func (fs *FileStorage) WriteInPlace(content []byte) error {
+	// Note that we overwrite the existing file and do not do
+	// the usual write-rename. The rationale is that we want to
+	// minimize the amount of writes happening on a potential
+	// FAT partition where the env is loaded from. The file will
+	// always be of a fixed size so we know the writes will not
+	// fail because of ENOSPC.
+	//
+	// The size of the env file never changes so we do not
+	// truncate it.
+	//
+	// We also do not O_TRUNC to avoid reallocations on the FS
+	// to minimize risk of fs corruption.
+	f, err := os.OpenFile(fs.Path, os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.WriteAt(content, fs.Offset); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+// WriteAtomic replaces the file with a new file that contains the
+// env, this is only possible if the env is the whole file. The new
+// file gets the mode of the old one or 0644 if there is none.
+func (fs *FileStorage) WriteAtomic(content []byte) error {
+	if fs.Offset != 0 {
+		return fmt.Errorf("cannot atomically write env at offset %v", fs.Offset)
+	}
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(fs.Path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	f, err := ioutil.TempFile(filepath.Dir(fs.Path), filepath.Base(fs.Path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := f.Write(content); err != nil {
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	// open files can not be renamed on Windows
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), fs.Path); err != nil {
+		return err
+	}
+
+	// make the rename durable
+	return syncDir(filepath.Dir(fs.Path))
+}
+
+// Erase does nothing, files can be overwritten directly
+func (fs *FileStorage) Erase(size int) error {
+	return nil
+}
+
+// Lock takes an exclusive lock on the file, a flock on unix systems
+// and a LockFileEx lock on Windows
+func (fs *FileStorage) Lock() (unlock func() error, err error) {
+	f, err := os.Open(fs.Path)
+	if err != nil {
+		return nil, err
+	}
+	return lockOpened(f)
+}
+
+// LockAtomic locks the lock file Path+".lock" instead of the env
+// because WriteAtomic replaces the env, a lock on it would not cover
+// the new file. The lock file is only created for a write, a read
+// locks the env itself if there is no lock file yet: it sees either
+// the old or the new env anyway. Devices and an env whose lock file
+// can not be created, e.g. on a read-only mount, are locked like Lock
+// does.
+func (fs *FileStorage) LockAtomic(write bool) (unlock func() error, err error) {
+	st, err := os.Stat(fs.Path)
+	if err != nil {
+		return nil, err
+	}
+	if !st.Mode().IsRegular() {
+		return fs.Lock()
+	}
+	flags := os.O_RDONLY
+	if write {
+		flags |= os.O_CREATE
+	}
+	f, err := os.OpenFile(fs.Path+".lock", flags, 0644)
+	if err != nil {
+		return fs.Lock()
+	}
+	return lockOpened(f)
+}
+
+// lockOpened locks f and closes it if that fails
+func lockOpened(f *os.File) (unlock func() error, err error) {
+	unlock, err = lockFile(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return unlock, nil
+}
+
+// file returns the storage itself, storage that is backed by a file
+// implements it to find copies that share the same file
+func (fs *FileStorage) file() *FileStorage {
+	return fs
+}
+
+// sameStorage reports whether a and b are the same storage or are
+// backed by the same file, such storage is only locked once
+func sameStorage(a, b Storage) bool {
+	af, aok := a.(interface{ file() *FileStorage })
+	bf, bok := b.(interface{ file() *FileStorage })
+	if aok && bok {
+		return filepath.Clean(af.file().Path) == filepath.Clean(bf.file().Path)
+	}
+	t := reflect.TypeOf(a)
+	if t != reflect.TypeOf(b) || !t.Comparable() {
+		return false
+	}
+	return a == b
+}
+
+// MemoryStorage stores the env in memory
+type MemoryStorage struct {
+	mu     sync.Mutex
+	lockMu sync.Mutex
+	data   []byte
+}
+
+// NewMemoryStorage returns a memory storage with the given content
+func NewMemoryStorage(content []byte) *MemoryStorage {
+	return &MemoryStorage{data: append([]byte(nil), content...)}
+}
+
+// Bytes returns a copy of the content of the storage
+func (ms *MemoryStorage) Bytes() []byte {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return append([]byte(nil), ms.data...)
+}
+
+// ReadAll reads size bytes of the env from memory
+func (ms *MemoryStorage) ReadAll(size int) ([]byte, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if size == 0 {
+		return append([]byte(nil), ms.data...), nil
+	}
+	if size > len(ms.data) {
+		return nil, fmt.Errorf("cannot read %v bytes of env from %v bytes of memory", size, len(ms.data))
+	}
+	return append([]byte(nil), ms.data[:size]...), nil
+}
+
+// WriteInPlace overwrites the env in memory, the memory grows if
+// needed
+func (ms *MemoryStorage) WriteInPlace(content []byte) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if len(content) > len(ms.data) {
+		ms.data = append(ms.data, make([]byte, len(content)-len(ms.data))...)
+	}
+	copy(ms.data, content)
+	return nil
+}
+
+// Erase does nothing
+func (ms *MemoryStorage) Erase(size int) error {
+	return nil
+}
+
+// Lock locks the memory storage
+func (ms *MemoryStorage) Lock() (unlock func() error, err error) {
+	ms.lockMu.Lock()
+	return func() error {
+		ms.lockMu.Unlock()
+		return nil
+	}, nil
+}
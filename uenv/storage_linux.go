@@ -0,0 +1,215 @@
+package uenv
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	_ Storage = (*MTDStorage)(nil)
+	_ Storage = (*UBIStorage)(nil)
+	_ Storage = (*EMMCBootStorage)(nil)
+)
+
+// ioctls from mtd/mtd-abi.h and mtd/ubi-user.h
+const (
+	memGetInfo = 0x80204d01
+	memErase   = 0x40084d02
+	ubiVolUp   = 0x40084f00
+)
+
+// mtdInfo is struct mtd_info_user
+type mtdInfo struct {
+	Type      uint8
+	Flags     uint32
+	Size      uint32
+	EraseSize uint32
+	WriteSize uint32
+	OOBSize   uint32
+	Padding   uint64
+}
+
+// eraseInfo is struct erase_info_user
+type eraseInfo struct {
+	Start  uint32
+	Length uint32
+}
+
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// MTDStorage stores the env on a MTD device like /dev/mtd1, this
+// includes SPI NOR flash. The offset must be aligned to the erase
+// block size.
+type MTDStorage struct {
+	Path   string
+	Offset int64
+}
+
+func (ms *MTDStorage) file() *FileStorage {
+	return &FileStorage{Path: ms.Path, Offset: ms.Offset}
+}
+
+func (ms *MTDStorage) info(f *os.File) (*mtdInfo, error) {
+	var info mtdInfo
+	if err := ioctl(f, memGetInfo, unsafe.Pointer(&info)); err != nil {
+		return nil, fmt.Errorf("cannot get MTD info of %s: %s", ms.Path, err)
+	}
+	return &info, nil
+}
+
+// ReadAll reads size bytes of the env, if size is zero the rest of
+// the MTD device is read
+func (ms *MTDStorage) ReadAll(size int) ([]byte, error) {
+	if size == 0 {
+		f, err := os.Open(ms.Path)
+		if err != nil {
+			return nil, err
+		}
+		info, err := ms.info(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		size = int(int64(info.Size) - ms.Offset)
+	}
+	return ms.file().ReadAll(size)
+}
+
+// WriteInPlace programs the env, it needs to be erased first
+func (ms *MTDStorage) WriteInPlace(content []byte) error {
+	return ms.file().WriteInPlace(content)
+}
+
+// Erase erases the erase blocks that contain size bytes of the env
+func (ms *MTDStorage) Erase(size int) error {
+	f, err := os.OpenFile(ms.Path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := ms.info(f)
+	if err != nil {
+		return err
+	}
+	if info.EraseSize == 0 {
+		// e.g. MTD RAM or MTD_NO_ERASE devices
+		return nil
+	}
+	blockSize := int64(info.EraseSize)
+	if ms.Offset%blockSize != 0 {
+		return fmt.Errorf("cannot erase %s: offset %v is not aligned to erase size %v", ms.Path, ms.Offset, blockSize)
+	}
+	length := (int64(size) + blockSize - 1) / blockSize * blockSize
+	erase := eraseInfo{Start: uint32(ms.Offset), Length: uint32(length)}
+	if err := ioctl(f, memErase, unsafe.Pointer(&erase)); err != nil {
+		return fmt.Errorf("cannot erase %s: %s", ms.Path, err)
+	}
+	return nil
+}
+
+// Lock takes an exclusive flock on the MTD device
+func (ms *MTDStorage) Lock() (unlock func() error, err error) {
+	return ms.file().Lock()
+}
+
+// UBIStorage stores the env in a UBI volume like /dev/ubi0_0, a
+// volume contains a single env copy.
+type UBIStorage struct {
+	Path string
+}
+
+func (us *UBIStorage) file() *FileStorage {
+	return &FileStorage{Path: us.Path}
+}
+
+// ReadAll reads size bytes of the env from the volume
+func (us *UBIStorage) ReadAll(size int) ([]byte, error) {
+	return us.file().ReadAll(size)
+}
+
+// WriteInPlace writes the env as a volume update, UBI makes this
+// atomic: an interrupted update leaves the volume marked as corrupt.
+func (us *UBIStorage) WriteInPlace(content []byte) error {
+	f, err := os.OpenFile(us.Path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	size := int64(len(content))
+	if err := ioctl(f, ubiVolUp, unsafe.Pointer(&size)); err != nil {
+		return fmt.Errorf("cannot start update of UBI volume %s: %s", us.Path, err)
+	}
+	if _, err := f.Write(content); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// Erase does nothing, the volume update takes care of erasing
+func (us *UBIStorage) Erase(size int) error {
+	return nil
+}
+
+// Lock takes an exclusive flock on the volume
+func (us *UBIStorage) Lock() (unlock func() error, err error) {
+	return us.file().Lock()
+}
+
+// sysBlock is the location of the block devices in sysfs
+var sysBlock = "/sys/block"
+
+// EMMCBootStorage stores the env on a eMMC boot partition like
+// /dev/mmcblk0boot1. The kernel makes these read-only by default, the
+// protection is lifted while the env is written.
+type EMMCBootStorage struct {
+	Path   string
+	Offset int64
+}
+
+func (es *EMMCBootStorage) file() *FileStorage {
+	return &FileStorage{Path: es.Path, Offset: es.Offset}
+}
+
+// ReadAll reads size bytes of the env from the boot partition
+func (es *EMMCBootStorage) ReadAll(size int) ([]byte, error) {
+	return es.file().ReadAll(size)
+}
+
+// WriteInPlace overwrites the env, the read-only protection of the
+// boot partition is restored afterwards
+func (es *EMMCBootStorage) WriteInPlace(content []byte) error {
+	forceRo := filepath.Join(sysBlock, filepath.Base(es.Path), "force_ro")
+	old, err := ioutil.ReadFile(forceRo)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(old)) != "0" {
+		if err := ioutil.WriteFile(forceRo, []byte("0"), 0644); err != nil {
+			return err
+		}
+		defer ioutil.WriteFile(forceRo, old, 0644)
+	}
+	return es.file().WriteInPlace(content)
+}
+
+// Erase does nothing, eMMC can be overwritten directly
+func (es *EMMCBootStorage) Erase(size int) error {
+	return nil
+}
+
+// Lock takes an exclusive flock on the boot partition
+func (es *EMMCBootStorage) Lock() (unlock func() error, err error) {
+	return es.file().Lock()
+}
@@ -0,0 +1,40 @@
+package uenv
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+type storageLinuxTestSuite struct{}
+
+var _ = Suite(&storageLinuxTestSuite{})
+
+func (s *storageLinuxTestSuite) TestEMMCBootStorageLiftsForceRo(c *C) {
+	oldSysBlock := sysBlock
+	sysBlock = c.MkDir()
+	defer func() { sysBlock = oldSysBlock }()
+
+	device := filepath.Join(c.MkDir(), "mmcblk0boot1")
+	c.Assert(ioutil.WriteFile(device, make([]byte, 64), 0644), IsNil)
+	forceRo := filepath.Join(sysBlock, "mmcblk0boot1", "force_ro")
+	c.Assert(os.MkdirAll(filepath.Dir(forceRo), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(forceRo, []byte("1\n"), 0644), IsNil)
+
+	es := &EMMCBootStorage{Path: device, Offset: 32}
+	env, err := CreateStorage(es, Config{Size: 32})
+	c.Assert(err, IsNil)
+	env.Set("a", "b")
+	c.Assert(env.Save(), IsNil)
+
+	// protection is restored
+	content, err := ioutil.ReadFile(forceRo)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "1\n")
+
+	env, err = OpenStorage(es, Config{Size: 32})
+	c.Assert(err, IsNil)
+	c.Check(env.Get("a"), Equals, "b")
+}
@@ -0,0 +1,302 @@
+package uenv
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type storageTestSuite struct {
+	envFile string
+}
+
+var _ = Suite(&storageTestSuite{})
+
+func (s *storageTestSuite) SetUpTest(c *C) {
+	s.envFile = filepath.Join(c.MkDir(), "uboot.env")
+}
+
+func (s *storageTestSuite) TestMemoryStorage(c *C) {
+	ms := NewMemoryStorage(make([]byte, 64))
+	env, err := CreateStorage(ms, Config{Size: 32})
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	content := ms.Bytes()
+	c.Check(content, HasLen, 64)
+	c.Check(string(content[5:12]), Equals, "foo=bar")
+
+	env, err = OpenStorage(ms, Config{Size: 32})
+	c.Assert(err, IsNil)
+	c.Check(env.Get("foo"), Equals, "bar")
+}
+
+func (s *storageTestSuite) TestMemoryStorageRedundant(c *C) {
+	primary := NewMemoryStorage(nil)
+	redundant := NewMemoryStorage(nil)
+	cfg := Config{Size: 16, Redundant: true, RedundantStorage: redundant}
+	env, err := CreateStorage(primary, cfg)
+	c.Assert(err, IsNil)
+	env.Set("a", "1")
+	c.Assert(env.Save(), IsNil)
+	env.Set("a", "2")
+	c.Assert(env.Save(), IsNil)
+
+	// the new env wrote both copies, the following save only the
+	// primary one
+	c.Check(string(primary.Bytes()[4:9]), Equals, "\x02a=2\x00")
+	c.Check(string(redundant.Bytes()[4:9]), Equals, "\x01a=1\x00")
+
+	env, err = OpenStorage(primary, cfg)
+	c.Assert(err, IsNil)
+	c.Check(env.Source(), Equals, SourcePrimary)
+	c.Check(env.Get("a"), Equals, "2")
+}
+
+func (s *storageTestSuite) TestMemoryStorageConcurrent(c *C) {
+	ms := NewMemoryStorage(make([]byte, 16))
+	unlock, err := ms.Lock()
+	c.Assert(err, IsNil)
+	defer unlock()
+
+	// the content can be accessed while the storage is locked
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			ms.WriteInPlace(make([]byte, 16+i))
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		_, err := ms.ReadAll(16)
+		c.Assert(err, IsNil)
+		ms.Bytes()
+	}
+	<-done
+	c.Check(ms.Bytes(), HasLen, 115)
+}
+
+func (s *storageTestSuite) TestMemoryStorageShortRead(c *C) {
+	_, err := OpenStorage(NewMemoryStorage(make([]byte, 8)), Config{Size: 16})
+	c.Check(err, ErrorMatches, "cannot read 16 bytes of env from 8 bytes of memory")
+}
+
+func (s *storageTestSuite) TestOpenWithoutStorage(c *C) {
+	_, err := OpenWithConfig("", Config{})
+	c.Check(err, ErrorMatches, "cannot open env without file or storage")
+}
+
+func (s *storageTestSuite) TestFileStorageWriteAtomic(c *C) {
+	env, err := CreateWithConfig(s.envFile, Config{Size: 32, AtomicWrite: true})
+	c.Assert(err, IsNil)
+	c.Assert(env.Save(), IsNil)
+	st1, err := os.Stat(s.envFile)
+	c.Assert(err, IsNil)
+
+	env.Set("a", "b")
+	c.Assert(env.Save(), IsNil)
+	st2, err := os.Stat(s.envFile)
+	c.Assert(err, IsNil)
+	// the file got replaced
+	c.Check(os.SameFile(st1, st2), Equals, false)
+	c.Check(st2.Size(), Equals, int64(32))
+
+	// no leftover temp files, only the lock file
+	files, err := ioutil.ReadDir(filepath.Dir(s.envFile))
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 2)
+	c.Check(files[0].Name(), Equals, "uboot.env")
+	c.Check(files[1].Name(), Equals, "uboot.env.lock")
+
+	env, err = Open(s.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("a"), Equals, "b")
+}
+
+func (s *storageTestSuite) TestFileStorageWriteAtomicLocked(c *C) {
+	c.Assert(ioutil.WriteFile(s.envFile, nil, 0644), IsNil)
+	fs := &FileStorage{Path: s.envFile}
+	unlock, err := fs.LockAtomic(true)
+	c.Assert(err, IsNil)
+	// the env gets replaced while it is locked
+	c.Assert(fs.WriteAtomic([]byte("new")), IsNil)
+
+	// the lock still covers the new file
+	locked := make(chan bool)
+	go func() {
+		unlock2, err := fs.LockAtomic(false)
+		c.Check(err, IsNil)
+		unlock2()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		c.Fatal("lock was taken twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	c.Assert(unlock(), IsNil)
+	<-locked
+}
+
+func (s *storageTestSuite) TestReadCreatesNoLockFile(c *C) {
+	env, err := Create(s.envFile, 32)
+	c.Assert(err, IsNil)
+	c.Assert(env.Save(), IsNil)
+
+	// neither reading nor writing in place creates a lock file and
+	// reading an env that is written atomically does not either
+	_, err = Open(s.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(Update(s.envFile, Config{}, func(env *Env) error {
+		env.Set("a", "b")
+		return nil
+	}), IsNil)
+	_, err = OpenWithConfig(s.envFile, Config{AtomicWrite: true})
+	c.Assert(err, IsNil)
+	files, err := ioutil.ReadDir(filepath.Dir(s.envFile))
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 1)
+	c.Check(files[0].Name(), Equals, "uboot.env")
+}
+
+func (s *storageTestSuite) TestSaveAtomicWriteConcurrent(c *C) {
+	env, err := CreateWithConfig(s.envFile, Config{Size: 32, AtomicWrite: true})
+	c.Assert(err, IsNil)
+	c.Assert(env.Save(), IsNil)
+
+	// concurrent updates through the lock do not lose any write
+	errCh := make(chan error)
+	for i := 0; i < 4; i++ {
+		go func(name string) {
+			errCh <- Update(s.envFile, Config{AtomicWrite: true}, func(env *Env) error {
+				env.Set(name, "1")
+				return nil
+			})
+		}(fmt.Sprintf("v%d", i))
+	}
+	for i := 0; i < 4; i++ {
+		c.Assert(<-errCh, IsNil)
+	}
+	env, err = Open(s.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.String(), Equals, "v0=1\nv1=1\nv2=1\nv3=1\n")
+}
+
+func (s *storageTestSuite) TestFileStorageWriteAtomicKeepsMode(c *C) {
+	fs := &FileStorage{Path: s.envFile}
+	c.Assert(fs.WriteAtomic([]byte("new")), IsNil)
+	st, err := os.Stat(s.envFile)
+	c.Assert(err, IsNil)
+	c.Check(st.Mode().Perm(), Equals, os.FileMode(0644))
+
+	c.Assert(os.Chmod(s.envFile, 0640), IsNil)
+	c.Assert(fs.WriteAtomic([]byte("newer")), IsNil)
+	st, err = os.Stat(s.envFile)
+	c.Assert(err, IsNil)
+	c.Check(st.Mode().Perm(), Equals, os.FileMode(0640))
+}
+
+func (s *storageTestSuite) TestFileStorageWriteAtomicOffset(c *C) {
+	fs := &FileStorage{Path: s.envFile, Offset: 8}
+	c.Check(fs.WriteAtomic(nil), ErrorMatches, "cannot atomically write env at offset 8")
+}
+
+func (s *storageTestSuite) TestFileStorageLock(c *C) {
+	c.Assert(ioutil.WriteFile(s.envFile, nil, 0644), IsNil)
+	fs := &FileStorage{Path: s.envFile}
+	unlock, err := fs.Lock()
+	c.Assert(err, IsNil)
+
+	locked := make(chan bool)
+	go func() {
+		unlock2, err := fs.Lock()
+		c.Check(err, IsNil)
+		unlock2()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		c.Fatal("lock was taken twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	c.Assert(unlock(), IsNil)
+	<-locked
+}
+
+// lockingStorage is a memory storage that records if it is locked
+type lockingStorage struct {
+	*MemoryStorage
+	locked bool
+}
+
+func (ls *lockingStorage) Lock() (unlock func() error, err error) {
+	unlockMemory, err := ls.MemoryStorage.Lock()
+	if err != nil {
+		return nil, err
+	}
+	ls.locked = true
+	return func() error {
+		ls.locked = false
+		return unlockMemory()
+	}, nil
+}
+
+func (ls *lockingStorage) WriteInPlace(content []byte) error {
+	if !ls.locked {
+		return fmt.Errorf("write without lock")
+	}
+	return ls.MemoryStorage.WriteInPlace(content)
+}
+
+func (s *storageTestSuite) TestSaveLocksRedundantStorage(c *C) {
+	primary := &lockingStorage{MemoryStorage: NewMemoryStorage(nil)}
+	redundant := &lockingStorage{MemoryStorage: NewMemoryStorage(nil)}
+	cfg := Config{Size: 16, Redundant: true, RedundantStorage: redundant}
+	env, err := CreateStorage(primary, cfg)
+	c.Assert(err, IsNil)
+	env.Set("a", "1")
+	c.Assert(env.Save(), IsNil)
+	env.Set("a", "2")
+	c.Assert(env.Save(), IsNil)
+	c.Check(primary.locked, Equals, false)
+	c.Check(redundant.locked, Equals, false)
+}
+
+func (s *storageTestSuite) TestSaveSharedFileLockedOnce(c *C) {
+	// both copies in one file, locking each would deadlock
+	c.Assert(ioutil.WriteFile(s.envFile, make([]byte, 32), 0644), IsNil)
+	cfg := Config{
+		Size:             16,
+		Redundant:        true,
+		Storage:          &FileStorage{Path: s.envFile},
+		RedundantStorage: &FileStorage{Path: s.envFile, Offset: 16},
+	}
+	env, err := CreateWithConfig("", cfg)
+	c.Assert(err, IsNil)
+	env.Set("a", "1")
+	c.Assert(env.Save(), IsNil)
+
+	env, err = OpenWithConfig("", cfg)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("a"), Equals, "1")
+}
+
+func (s *storageTestSuite) TestOpenMissingRedundantFile(c *C) {
+	redundantFile := filepath.Join(filepath.Dir(s.envFile), "uboot-redund.env")
+	cfg := Config{Size: 32, Redundant: true, RedundantFile: redundantFile}
+	env, err := CreateWithConfig(s.envFile, cfg)
+	c.Assert(err, IsNil)
+	c.Assert(env.Save(), IsNil)
+	c.Assert(os.Remove(redundantFile), IsNil)
+
+	env, err = OpenWithConfig(s.envFile, cfg)
+	c.Assert(err, IsNil)
+	c.Check(env.Source(), Equals, SourcePrimary)
+}
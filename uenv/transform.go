@@ -0,0 +1,73 @@
+package uenv
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+)
+
+// Transform encodes the payload of the env (everything after the
+// header) before it is written and decodes it after it is read, e.g.
+// to encrypt the env. The CRC covers the encoded payload like in
+// uboot. Encode and Decode must not change the length of the payload.
+type Transform interface {
+	Encode(payload []byte) []byte
+	Decode(payload []byte) []byte
+}
+
+// aesCBC implements the CONFIG_ENV_AES encryption of uboot
+type aesCBC struct {
+	block cipher.Block
+}
+
+// NewAESCBC returns a Transform that encrypts the payload with AES in
+// CBC mode with a zero IV like CONFIG_ENV_AES of older uboot versions.
+// Like uboot only whole 16 byte blocks are encrypted, trailing bytes
+// are stored as they are. uboot uses a 16 byte key, 24 and 32 byte
+// keys are supported as well.
+func NewAESCBC(key []byte) (Transform, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &aesCBC{block: block}, nil
+}
+
+func (a *aesCBC) crypt(mode cipher.BlockMode, payload []byte) []byte {
+	out := append([]byte(nil), payload...)
+	n := len(out) / aes.BlockSize * aes.BlockSize
+	mode.CryptBlocks(out[:n], out[:n])
+	return out
+}
+
+func (a *aesCBC) Encode(payload []byte) []byte {
+	iv := make([]byte, aes.BlockSize)
+	return a.crypt(cipher.NewCBCEncrypter(a.block, iv), payload)
+}
+
+func (a *aesCBC) Decode(payload []byte) []byte {
+	iv := make([]byte, aes.BlockSize)
+	return a.crypt(cipher.NewCBCDecrypter(a.block, iv), payload)
+}
+
+// XORTransform obfuscates the payload by XORing it with the repeated
+// Key, as done by some vendor bootloaders.
+type XORTransform struct {
+	Key []byte
+}
+
+// Encode XORs the payload with the key
+func (x *XORTransform) Encode(payload []byte) []byte {
+	out := make([]byte, len(payload))
+	for i, b := range payload {
+		if len(x.Key) > 0 {
+			b ^= x.Key[i%len(x.Key)]
+		}
+		out[i] = b
+	}
+	return out
+}
+
+// Decode XORs the payload with the key, like Encode
+func (x *XORTransform) Decode(payload []byte) []byte {
+	return x.Encode(payload)
+}
@@ -0,0 +1,78 @@
+package uenv
+
+import (
+	"bytes"
+	"encoding/hex"
+
+	. "gopkg.in/check.v1"
+)
+
+type transformTestSuite struct{}
+
+var _ = Suite(&transformTestSuite{})
+
+func mustHex(c *C, s string) []byte {
+	b, err := hex.DecodeString(s)
+	c.Assert(err, IsNil)
+	return b
+}
+
+func (s *transformTestSuite) TestAESCBCVector(c *C) {
+	// FIPS-197 AES-128 vector, with the zero IV the first CBC block
+	// is the plain AES block
+	t, err := NewAESCBC(mustHex(c, "000102030405060708090a0b0c0d0e0f"))
+	c.Assert(err, IsNil)
+	payload := append(mustHex(c, "00112233445566778899aabbccddeeff"), 0xaa, 0xbb)
+
+	encoded := t.Encode(payload)
+	c.Check(encoded[:16], DeepEquals, mustHex(c, "69c4e0d86a7b0430d8cdb78070b4c55a"))
+	// trailing bytes that are not a whole block are not encrypted
+	c.Check(encoded[16:], DeepEquals, []byte{0xaa, 0xbb})
+	c.Check(t.Decode(encoded), DeepEquals, payload)
+}
+
+func (s *transformTestSuite) TestAESCBCBadKey(c *C) {
+	_, err := NewAESCBC([]byte("short"))
+	c.Check(err, ErrorMatches, "crypto/aes: invalid key size 5")
+}
+
+func (s *transformTestSuite) TestAESCBCEnv(c *C) {
+	t, err := NewAESCBC(bytes.Repeat([]byte{0x42}, 16))
+	c.Assert(err, IsNil)
+	ms := NewMemoryStorage(nil)
+	cfg := Config{Size: 64, Transform: t}
+	env, err := CreateStorage(ms, cfg)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	c.Check(bytes.Contains(ms.Bytes(), []byte("foo=bar")), Equals, false)
+	env, err = OpenStorage(ms, cfg)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("foo"), Equals, "bar")
+
+	// the CRC is over the encrypted payload so a wrong key only
+	// shows up when parsing
+	other, err := NewAESCBC(bytes.Repeat([]byte{0x43}, 16))
+	c.Assert(err, IsNil)
+	_, err = OpenStorage(ms, Config{Size: 64, Transform: other})
+	c.Check(err, NotNil)
+	_, err = OpenStorage(ms, Config{Size: 64})
+	c.Check(err, NotNil)
+}
+
+func (s *transformTestSuite) TestXORTransform(c *C) {
+	t := &XORTransform{Key: []byte{0x01, 0xff}}
+	c.Check(t.Encode([]byte{0x00, 0x00, 0x10}), DeepEquals, []byte{0x01, 0xff, 0x11})
+	c.Check(t.Decode([]byte{0x01, 0xff, 0x11}), DeepEquals, []byte{0x00, 0x00, 0x10})
+
+	ms := NewMemoryStorage(nil)
+	cfg := Config{Size: 32, Transform: t}
+	env, err := CreateStorage(ms, cfg)
+	c.Assert(err, IsNil)
+	env.Set("a", "b")
+	c.Assert(env.Save(), IsNil)
+	env, err = OpenStorage(ms, cfg)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("a"), Equals, "b")
+}
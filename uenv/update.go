@@ -0,0 +1,50 @@
+package uenv
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNoChange can be returned by the function given to Update to leave
+// the env as it is, Update then returns nil
+var ErrNoChange = errors.New("env not changed")
+
+// Update opens the env, calls f to change it and saves it if f returns
+// no error. The storage stays locked from reading the env until it is
+// written, so other processes that lock the env can not change it in
+// between. The save hooks that f adds run around the save.
+func Update(fname string, cfg Config, f func(env *Env) error) error {
+	return UpdateContext(context.Background(), fname, cfg, f)
+}
+
+// UpdateContext is like Update but gives up once ctx is done.
+func UpdateContext(ctx context.Context, fname string, cfg Config, f func(env *Env) error) error {
+	env, err := prepareOpen(fname, cfg)
+	if err != nil {
+		return err
+	}
+	unlock, err := env.lock(ctx, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := env.read(ctx); err != nil {
+		return err
+	}
+	if err := f(env); err == ErrNoChange {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return env.runHooks(func() error {
+		if err := env.checkSize(); err != nil {
+			return err
+		}
+		if err := env.write(ctx); err != nil {
+			return err
+		}
+		env.recordModified()
+		return nil
+	})
+}
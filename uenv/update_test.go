@@ -0,0 +1,95 @@
+package uenv
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	. "gopkg.in/check.v1"
+)
+
+type updateTestSuite struct {
+	envFile string
+}
+
+var _ = Suite(&updateTestSuite{})
+
+func (s *updateTestSuite) SetUpTest(c *C) {
+	s.envFile = filepath.Join(c.MkDir(), "uboot.env")
+	env, err := Create(s.envFile, 4096)
+	c.Assert(err, IsNil)
+	c.Assert(env.Save(), IsNil)
+}
+
+func (s *updateTestSuite) TestUpdate(c *C) {
+	err := Update(s.envFile, Config{}, func(env *Env) error {
+		return env.Set("a", "1")
+	})
+	c.Assert(err, IsNil)
+
+	env, err := Open(s.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("a"), Equals, "1")
+}
+
+func (s *updateTestSuite) TestUpdateError(c *C) {
+	err := Update(s.envFile, Config{}, func(env *Env) error {
+		env.Set("a", "1")
+		return fmt.Errorf("nope")
+	})
+	c.Check(err, ErrorMatches, "nope")
+
+	env, err := Open(s.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("a"), Equals, "")
+}
+
+func (s *updateTestSuite) TestUpdateNoChange(c *C) {
+	err := Update(s.envFile, Config{}, func(env *Env) error {
+		env.Set("a", "1")
+		return ErrNoChange
+	})
+	c.Assert(err, IsNil)
+
+	env, err := Open(s.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("a"), Equals, "")
+}
+
+func (s *updateTestSuite) TestUpdateHooks(c *C) {
+	var calls []string
+	err := Update(s.envFile, Config{}, func(env *Env) error {
+		env.AddPreSaveHook(func(env *Env) error {
+			calls = append(calls, "pre")
+			return nil
+		})
+		env.AddPostSaveHook(func(env *Env, err error) error {
+			calls = append(calls, fmt.Sprintf("post %v", err))
+			return nil
+		})
+		return env.Set("a", "1")
+	})
+	c.Assert(err, IsNil)
+	c.Check(calls, DeepEquals, []string{"pre", "post <nil>"})
+}
+
+func (s *updateTestSuite) TestUpdateIsLocked(c *C) {
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := Update(s.envFile, Config{}, func(env *Env) error {
+				n, _ := strconv.Atoi(env.Get("counter"))
+				return env.Set("counter", strconv.Itoa(n+1))
+			})
+			c.Check(err, IsNil)
+		}()
+	}
+	wg.Wait()
+
+	env, err := Open(s.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("counter"), Equals, "10")
+}
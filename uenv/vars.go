@@ -0,0 +1,17 @@
+package uenv
+
+var _ Vars = (*Env)(nil)
+
+// Vars is the key/value API of an env. It is implemented by Env and by
+// the plain text envs that boot scripts import, like armbianEnv.txt,
+// so that tools can manage both the same way.
+type Vars interface {
+	// Get returns the value of the variable or "" if it is unset.
+	Get(name string) string
+	// Set sets the variable, an empty value unsets it.
+	Set(name, value string) error
+	// Map returns a copy of all variables.
+	Map() map[string]string
+	// Save writes the variables back.
+	Save() error
+}
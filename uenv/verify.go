@@ -0,0 +1,166 @@
+package uenv
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// CopyReport describes the state of a single env copy
+type CopyReport struct {
+	Source Source
+	// Size is the size of the copy including the header
+	Size int
+	// Flags is the flags byte of the copy
+	Flags byte
+	// Err is the reason why the copy is not valid, nil if it is
+	Err error
+	// Vars is the number of variables in the copy
+	Vars int
+	// Duplicates are the variables that are set more than once
+	Duplicates []string
+
+	data map[string]string
+}
+
+// Report is the result of Verify
+type Report struct {
+	Copies []CopyReport
+	// Problems contains a description of every problem found
+	Problems []string
+	// Warnings contains things that are suspicious but not a
+	// problem, e.g. an implausible size
+	Warnings []string
+}
+
+// OK returns true if no problems were found
+func (r *Report) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *Report) addProblem(format string, args ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+func (r *Report) addCopy(cr CopyReport) {
+	if cr.Err != nil {
+		r.addProblem("%s copy: %s", cr.Source, cr.Err)
+	}
+	if len(cr.Duplicates) > 0 {
+		r.addProblem("%s copy: duplicate variables: %s", cr.Source, strings.Join(cr.Duplicates, ", "))
+	}
+	if cr.Err == nil && !PlausibleSize(cr.Size) {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("%s copy: implausible env size %v", cr.Source, cr.Size))
+	}
+	r.Copies = append(r.Copies, cr)
+}
+
+// Verify checks the env like fsck checks a filesystem: the CRC, the
+// size, duplicated variables and for redundant envs that both copies
+// are consistent. An error is only returned if the config is invalid,
+// everything else is reported as a problem.
+func Verify(fname string, cfg Config) (*Report, error) {
+	env, err := prepareOpen(fname, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{}
+	for i, s := range env.storages {
+		report.addCopy(env.verifyCopy(s, Source(i)))
+	}
+	if env.mirror != nil {
+		report.addCopy(env.verifyCopy(env.mirror, SourceMirror))
+	}
+
+	if env.mirror != nil {
+		primary, mirror := report.Copies[0], report.Copies[1]
+		if primary.Err == nil && mirror.Err == nil && !reflect.DeepEqual(primary.data, mirror.data) {
+			report.addProblem("mirror differs from the primary copy")
+		}
+	}
+	if len(env.storages) == 2 {
+		primary, redundant := report.Copies[0], report.Copies[1]
+		if primary.Size != redundant.Size {
+			report.addProblem("size of copies differs: %v != %v", primary.Size, redundant.Size)
+		}
+		if primary.Err == nil && redundant.Err == nil && primary.Flags == redundant.Flags && !reflect.DeepEqual(primary.data, redundant.data) {
+			report.addProblem("copies have the same flags %v but different content", primary.Flags)
+		}
+	}
+
+	return report, nil
+}
+
+func (env *Env) verifyCopy(s Storage, source Source) CopyReport {
+	cr := CopyReport{Source: source}
+	content, err := s.ReadAll(env.config.Size)
+	if err != nil {
+		cr.Err = err
+		return cr
+	}
+	cr.Size = len(content)
+	if err := env.config.checkExpectedSize(cr.Size); err != nil {
+		cr.Err = err
+		return cr
+	}
+	hdrSize := env.config.HeaderSize
+	if hdrSize > 4 && len(content) > 4 {
+		cr.Flags = content[4]
+	}
+	t := env.config.Transform
+	data, _, err := parseImage(content, hdrSize, 0, t, env.config.checksum())
+	if err != nil {
+		cr.Err = err
+		return cr
+	}
+	cr.data = data
+	cr.Vars = len(data)
+	payload := content[hdrSize:]
+	if t != nil {
+		payload = t.Decode(payload)
+	}
+	cr.Duplicates = duplicateKeys(payload)
+
+	return cr
+}
+
+// duplicateKeys returns the sorted keys that are set more than once
+func duplicateKeys(payload []byte) []string {
+	if eof := bytes.Index(payload, []byte{0, 0}); eof >= 0 {
+		payload = payload[:eof]
+	}
+	seen := make(map[string]int)
+	for _, envStr := range bytes.Split(payload, []byte{0}) {
+		if i := bytes.IndexByte(envStr, '='); i > 0 {
+			seen[string(envStr[:i])]++
+		}
+	}
+	var dups []string
+	for key, n := range seen {
+		if n > 1 {
+			dups = append(dups, key)
+		}
+	}
+	sort.Strings(dups)
+	return dups
+}
+
+// Validate checks that the env can be saved and that uboot reads back
+// exactly the variables that are in memory. Values may contain "=",
+// names must not, neither names nor values may contain NUL bytes. For
+// invalid variables an *InvalidVarError is returned.
+func (env *Env) Validate() error {
+	var invalid error
+	env.iterEnv(func(key, value string) {
+		if err := checkVar(key, value, false); err != nil && invalid == nil {
+			invalid = err
+		}
+	})
+	if invalid != nil {
+		return invalid
+	}
+	return RoundTripCheck(env)
+}
@@ -0,0 +1,107 @@
+package uenv
+
+import (
+	"context"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestVerifyOK(c *C) {
+	env, err := Create(u.envFile, 32)
+	c.Assert(err, IsNil)
+	env.Set("a", "1")
+	env.Set("b", "2")
+	c.Assert(env.Save(), IsNil)
+
+	report, err := Verify(u.envFile, Config{})
+	c.Assert(err, IsNil)
+	c.Check(report.OK(), Equals, true)
+	c.Assert(report.Copies, HasLen, 1)
+	c.Check(report.Copies[0].Vars, Equals, 2)
+	c.Check(report.Copies[0].Size, Equals, 32)
+	c.Check(report.Copies[0].Err, IsNil)
+}
+
+func (u *uenvTestSuite) TestVerifyBadCRC(c *C) {
+	env, err := Create(u.envFile, 32)
+	c.Assert(err, IsNil)
+	c.Assert(env.Save(), IsNil)
+	u.corruptByte(c, u.envFile, 0)
+
+	report, err := Verify(u.envFile, Config{})
+	c.Assert(err, IsNil)
+	c.Check(report.OK(), Equals, false)
+	c.Check(report.Problems, HasLen, 1)
+	c.Check(report.Problems[0], Matches, "primary copy: bad CRC: .*")
+}
+
+func (u *uenvTestSuite) TestVerifyDuplicates(c *C) {
+	u.makeUbootEnvFromData(c, []byte("a=1\x00b=2\x00a=3\x00b=4\x00c=5\x00\x00"))
+
+	report, err := Verify(u.envFile, Config{})
+	c.Assert(err, IsNil)
+	c.Check(report.Problems, DeepEquals, []string{"primary copy: duplicate variables: a, b"})
+	c.Check(report.Copies[0].Duplicates, DeepEquals, []string{"a", "b"})
+}
+
+func (u *uenvTestSuite) TestVerifyRedundant(c *C) {
+	env, err := CreateWithConfig(u.envFile, redundantConfig)
+	c.Assert(err, IsNil)
+	env.Set("a", "1")
+	c.Assert(env.Save(), IsNil)
+	env.Set("a", "2")
+	c.Assert(env.Save(), IsNil)
+
+	// different content but different flags is fine
+	report, err := Verify(u.envFile, redundantConfig)
+	c.Assert(err, IsNil)
+	c.Check(report.OK(), Equals, true)
+	c.Check(report.Copies, HasLen, 2)
+
+	u.corruptByte(c, u.envFile, 22)
+	report, err = Verify(u.envFile, redundantConfig)
+	c.Assert(err, IsNil)
+	c.Check(report.Problems, HasLen, 1)
+	c.Check(report.Problems[0], Matches, "redundant copy: bad CRC: .*")
+}
+
+func (u *uenvTestSuite) TestVerifyRedundantSameFlags(c *C) {
+	env, err := CreateWithConfig(u.envFile, redundantConfig)
+	c.Assert(err, IsNil)
+	env.Set("a", "1")
+	c.Assert(env.Save(), IsNil)
+	// write a different env with the same flags into the redundant copy
+	env.Set("a", "2")
+	c.Assert(env.writeImage(context.Background(), env.storages[1], env.image(1)), IsNil)
+
+	report, err := Verify(u.envFile, redundantConfig)
+	c.Assert(err, IsNil)
+	c.Check(report.Problems, DeepEquals, []string{"copies have the same flags 1 but different content"})
+}
+
+func (u *uenvTestSuite) TestVerifyInvalidConfig(c *C) {
+	_, err := Verify("", Config{})
+	c.Check(err, ErrorMatches, "cannot open env without file or storage")
+}
+
+func (u *uenvTestSuite) TestValidate(c *C) {
+	env := New(Config{Size: 4096})
+	env.Set("bootargs", "root=/dev/mmcblk0p2 init=/sbin/init")
+	c.Check(env.Validate(), IsNil)
+
+	// variables that were set without Set
+	env.data["empty="] = "="
+	env.data["nul"] = "a\x00b"
+	err := env.Validate()
+	c.Check(err, ErrorMatches, `invalid variable "empty=": name contains "="`)
+	c.Check(err, FitsTypeOf, &InvalidVarError{})
+
+	delete(env.data, "empty=")
+	c.Check(env.Validate(), ErrorMatches, `invalid variable "nul": value contains a NUL byte`)
+}
+
+func (u *uenvTestSuite) TestValidateTooLarge(c *C) {
+	env := New(Config{Size: 16})
+	env.Set("foo", "1234567890")
+	c.Check(env.Validate(), ErrorMatches, "env too large: 21 bytes needed but env size is 16")
+}
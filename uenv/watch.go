@@ -0,0 +1,37 @@
+package uenv
+
+import (
+	"context"
+	"time"
+)
+
+// Watch opens the env and then polls it every interval, f is called
+// with the changes whenever the content changed. Reads that fail,
+// e.g. because another process is in the middle of writing the env,
+// are ignored and retried with the next poll. Watch returns the error
+// of ctx once it is done or the error of the initial open.
+func Watch(ctx context.Context, fname string, cfg Config, interval time.Duration, f func(changes []Change)) error {
+	old, err := OpenContext(ctx, fname, cfg)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		env, err := OpenContext(ctx, fname, cfg)
+		if err != nil {
+			continue
+		}
+		if changes := Diff(old, env); len(changes) > 0 {
+			f(changes)
+		}
+		old = env
+	}
+}
@@ -0,0 +1,46 @@
+package uenv
+
+import (
+	"context"
+	"io/ioutil"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestWatch(c *C) {
+	env, err := Create(u.envFile, 64)
+	c.Assert(err, IsNil)
+	env.Set("a", "1")
+	c.Assert(env.Save(), IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changesCh := make(chan []Change)
+	errCh := make(chan error)
+	go func() {
+		errCh <- Watch(ctx, u.envFile, Config{}, time.Millisecond, func(changes []Change) {
+			changesCh <- changes
+		})
+	}()
+	// give the watcher time for the initial open
+	time.Sleep(20 * time.Millisecond)
+
+	env.Set("a", "2")
+	c.Assert(env.Save(), IsNil)
+	c.Check(<-changesCh, DeepEquals, []Change{{Key: "a", Old: "1", New: "2"}})
+
+	// a broken env is ignored
+	c.Assert(ioutil.WriteFile(u.envFile, make([]byte, 64), 0644), IsNil)
+	time.Sleep(20 * time.Millisecond)
+	env.Set("b", "3")
+	c.Assert(env.Save(), IsNil)
+	c.Check(<-changesCh, DeepEquals, []Change{{Key: "b", New: "3"}})
+
+	cancel()
+	c.Check(<-errCh, Equals, context.Canceled)
+}
+
+func (u *uenvTestSuite) TestWatchOpenError(c *C) {
+	err := Watch(context.Background(), u.envFile, Config{}, time.Millisecond, nil)
+	c.Check(err, ErrorMatches, ".*: no such file or directory")
+}
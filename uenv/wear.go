@@ -0,0 +1,49 @@
+package uenv
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// countWrite counts a write of an env copy and adds it to the write
+// counter file of the config. The counter is telemetry only, failing
+// to update it does not fail the write.
+func (env *Env) countWrite() {
+	env.writes++
+	if env.config.WriteCounterFile == "" {
+		return
+	}
+	if err := addWrites(env.config.WriteCounterFile, 1); err != nil {
+		env.debug("cannot update write counter", "file", env.config.WriteCounterFile, "error", err)
+	}
+}
+
+// readWrites returns the number of writes stored in the given write
+// counter file, a missing file counts as zero writes
+func readWrites(fname string) (uint64, error) {
+	content, err := ioutil.ReadFile(fname)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	writes, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse write counter %s: %v", fname, err)
+	}
+	return writes, nil
+}
+
+// addWrites adds n writes to the given write counter file
+func addWrites(fname string, n uint64) error {
+	writes, err := readWrites(fname)
+	if err != nil {
+		return err
+	}
+	fs := &FileStorage{Path: fname}
+	return fs.WriteAtomic([]byte(strconv.FormatUint(writes+n, 10) + "\n"))
+}
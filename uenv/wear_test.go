@@ -0,0 +1,94 @@
+package uenv
+
+import (
+	"bytes"
+
+	. "gopkg.in/check.v1"
+)
+
+type wearTestSuite struct{}
+
+var _ = Suite(&wearTestSuite{})
+
+// norStorage behaves like NOR flash: an erase sets all bits and
+// programming can only clear them
+type norStorage struct {
+	*MemoryStorage
+	erases int
+}
+
+func newNORStorage(size int) *norStorage {
+	return &norStorage{MemoryStorage: NewMemoryStorage(bytes.Repeat([]byte{0xff}, size))}
+}
+
+func (ns *norStorage) Erase(size int) error {
+	ns.erases++
+	return ns.MemoryStorage.WriteInPlace(bytes.Repeat([]byte{0xff}, size))
+}
+
+func (ns *norStorage) WriteInPlace(content []byte) error {
+	programmed := ns.Bytes()
+	for i := range content {
+		programmed[i] &= content[i]
+	}
+	return ns.MemoryStorage.WriteInPlace(programmed)
+}
+
+func (s *wearTestSuite) TestOnlyClearsBits(c *C) {
+	ms := NewMemoryStorage([]byte{0xff, 0xf0, 0x0f})
+	c.Check(onlyClearsBits(ms, []byte{0xff, 0xf0, 0x0f}), Equals, true)
+	c.Check(onlyClearsBits(ms, []byte{0x00, 0x80, 0x01}), Equals, true)
+	c.Check(onlyClearsBits(ms, []byte{0xff, 0xf1, 0x0f}), Equals, false)
+	c.Check(onlyClearsBits(ms, []byte{0xff, 0xf0, 0x0f, 0x00}), Equals, false)
+}
+
+func (s *wearTestSuite) TestDifferentialWrite(c *C) {
+	ns := newNORStorage(64)
+	cfg := Config{Size: 64, DifferentialWrite: true}
+	env, err := CreateStorage(ns, cfg)
+	c.Assert(err, IsNil)
+	c.Assert(env.Set("bootcount", "1"), IsNil)
+
+	// erased flash is programmed directly
+	c.Assert(env.Save(), IsNil)
+	c.Check(ns.erases, Equals, 0)
+
+	// a different image needs an erase
+	c.Assert(env.Set("bootcount", "2"), IsNil)
+	c.Assert(env.Save(), IsNil)
+	c.Check(ns.erases, Equals, 1)
+
+	env, err = OpenStorage(ns, cfg)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("bootcount"), Equals, "2")
+
+	// saving the same image again needs no erase either
+	c.Assert(env.Save(), IsNil)
+	c.Check(ns.erases, Equals, 1)
+}
+
+func (s *wearTestSuite) TestNoDifferentialWrite(c *C) {
+	ns := newNORStorage(64)
+	env, err := CreateStorage(ns, Config{Size: 64})
+	c.Assert(err, IsNil)
+	c.Assert(env.Save(), IsNil)
+	c.Assert(env.Save(), IsNil)
+	c.Check(ns.erases, Equals, 2)
+}
+
+func (s *wearTestSuite) TestDifferentialWriteRedundant(c *C) {
+	primary, redundant := newNORStorage(64), newNORStorage(64)
+	cfg := Config{Size: 64, Redundant: true, RedundantStorage: redundant, DifferentialWrite: true}
+	env, err := CreateStorage(primary, cfg)
+	c.Assert(err, IsNil)
+	c.Assert(env.Save(), IsNil)
+	c.Check(primary.erases+redundant.erases, Equals, 0)
+
+	c.Assert(env.Set("a", "1"), IsNil)
+	c.Assert(env.Save(), IsNil)
+	c.Check(primary.erases+redundant.erases, Equals, 1)
+
+	report, err := Verify("", Config{Size: 64, Storage: primary, Redundant: true, RedundantStorage: redundant})
+	c.Assert(err, IsNil)
+	c.Check(report.Problems, HasLen, 0)
+}
@@ -0,0 +1,326 @@
+// Package uimage reads legacy uboot images as created by "mkimage -A
+// arm -T kernel ...". A legacy image is a 64 byte header followed by
+// the image data. Multi-file images (type multi) and scripts start
+// their data with a table of the sizes of their parts.
+package uimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+
+	"github.com/mvo5/uboot-go/decompress"
+)
+
+// Magic is the magic number at the start of a legacy image
+const Magic = 0x27051956
+
+// HeaderSize is the size of the header of a legacy image
+const HeaderSize = 64
+
+// nameSize is the size of the name in the header
+const nameSize = 32
+
+// Type is the image type (IH_TYPE_*)
+type Type uint8
+
+// The image types
+const (
+	TypeInvalid    Type = 0
+	TypeStandalone Type = 1
+	TypeKernel     Type = 2
+	TypeRamdisk    Type = 3
+	TypeMulti      Type = 4
+	TypeFirmware   Type = 5
+	TypeScript     Type = 6
+	TypeFilesystem Type = 7
+	TypeFlatDT     Type = 8
+)
+
+var typeNames = map[Type]string{
+	TypeInvalid:    "invalid",
+	TypeStandalone: "standalone",
+	TypeKernel:     "kernel",
+	TypeRamdisk:    "ramdisk",
+	TypeMulti:      "multi",
+	TypeFirmware:   "firmware",
+	TypeScript:     "script",
+	TypeFilesystem: "filesystem",
+	TypeFlatDT:     "flat_dt",
+}
+
+// String returns the name of the type as used by mkimage -T
+func (t Type) String() string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("type %d", uint8(t))
+}
+
+// Compression is the compression of the image data (IH_COMP_*)
+type Compression uint8
+
+// The compression types
+const (
+	CompNone  Compression = 0
+	CompGzip  Compression = 1
+	CompBzip2 Compression = 2
+	CompLZMA  Compression = 3
+	CompLZO   Compression = 4
+	CompLZ4   Compression = 5
+	CompZstd  Compression = 6
+)
+
+var compNames = map[Compression]string{
+	CompNone:  "none",
+	CompGzip:  "gzip",
+	CompBzip2: "bzip2",
+	CompLZMA:  "lzma",
+	CompLZO:   "lzo",
+	CompLZ4:   "lz4",
+	CompZstd:  "zstd",
+}
+
+// String returns the name of the compression as used by mkimage -C
+func (c Compression) String() string {
+	if name, ok := compNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("compression %d", uint8(c))
+}
+
+// Header is the header of a legacy image
+type Header struct {
+	// HeaderCRC is the crc32 of the header with HeaderCRC set to 0
+	HeaderCRC uint32
+	// Time is the creation time in seconds since the epoch
+	Time uint32
+	// Size is the size of the image data
+	Size uint32
+	// Load is the load address and EntryPoint the entry point
+	Load       uint32
+	EntryPoint uint32
+	// DataCRC is the crc32 of the image data
+	DataCRC uint32
+	// OS and Arch are the IH_OS_* and IH_ARCH_* values
+	OS   uint8
+	Arch uint8
+	Type Type
+	Comp Compression
+	// Name is the image name, at most 32 bytes
+	Name string
+}
+
+// Image is a legacy image
+type Image struct {
+	Header Header
+	Data   []byte
+}
+
+// Parse parses a legacy image, data after the image is ignored. The
+// header CRC is checked but the data CRC is not.
+func Parse(content []byte) (*Image, error) {
+	if len(content) < HeaderSize {
+		return nil, fmt.Errorf("image too small: %v bytes", len(content))
+	}
+	if magic := binary.BigEndian.Uint32(content); magic != Magic {
+		return nil, fmt.Errorf("bad image magic: %#x", magic)
+	}
+	hdr := content[:HeaderSize]
+
+	img := &Image{Header: Header{
+		HeaderCRC:  binary.BigEndian.Uint32(hdr[4:]),
+		Time:       binary.BigEndian.Uint32(hdr[8:]),
+		Size:       binary.BigEndian.Uint32(hdr[12:]),
+		Load:       binary.BigEndian.Uint32(hdr[16:]),
+		EntryPoint: binary.BigEndian.Uint32(hdr[20:]),
+		DataCRC:    binary.BigEndian.Uint32(hdr[24:]),
+		OS:         hdr[28],
+		Arch:       hdr[29],
+		Type:       Type(hdr[30]),
+		Comp:       Compression(hdr[31]),
+		Name:       string(bytes.TrimRight(hdr[32:32+nameSize], "\x00")),
+	}}
+	if crc := headerCRC(hdr); crc != img.Header.HeaderCRC {
+		return nil, fmt.Errorf("bad image header CRC: %v != %v", img.Header.HeaderCRC, crc)
+	}
+	if uint64(img.Header.Size) > uint64(len(content)-HeaderSize) {
+		return nil, fmt.Errorf("image truncated: %v bytes of data expected but only %v bytes found", img.Header.Size, len(content)-HeaderSize)
+	}
+	img.Data = content[HeaderSize : HeaderSize+int(img.Header.Size)]
+	return img, nil
+}
+
+// Read reads a legacy image from r
+func Read(r io.Reader) (*Image, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(content)
+}
+
+// headerCRC returns the crc32 of the header with the header CRC field
+// set to 0
+func headerCRC(hdr []byte) uint32 {
+	tmp := append([]byte(nil), hdr[:HeaderSize]...)
+	copy(tmp[4:8], []byte{0, 0, 0, 0})
+	return crc32.ChecksumIEEE(tmp)
+}
+
+// marshal returns the header as it is stored in the image
+func (hdr *Header) marshal() ([]byte, error) {
+	if len(hdr.Name) > nameSize {
+		return nil, fmt.Errorf("image name too long: %v bytes", len(hdr.Name))
+	}
+	buf := make([]byte, HeaderSize)
+	binary.BigEndian.PutUint32(buf, Magic)
+	binary.BigEndian.PutUint32(buf[4:], hdr.HeaderCRC)
+	binary.BigEndian.PutUint32(buf[8:], hdr.Time)
+	binary.BigEndian.PutUint32(buf[12:], hdr.Size)
+	binary.BigEndian.PutUint32(buf[16:], hdr.Load)
+	binary.BigEndian.PutUint32(buf[20:], hdr.EntryPoint)
+	binary.BigEndian.PutUint32(buf[24:], hdr.DataCRC)
+	buf[28] = hdr.OS
+	buf[29] = hdr.Arch
+	buf[30] = uint8(hdr.Type)
+	buf[31] = uint8(hdr.Comp)
+	copy(buf[32:], hdr.Name)
+	return buf, nil
+}
+
+// MarshalBinary returns the image with the header as it is, the
+// sizes and CRCs in the header are not updated.
+func (img *Image) MarshalBinary() ([]byte, error) {
+	hdr, err := img.Header.marshal()
+	if err != nil {
+		return nil, err
+	}
+	return append(hdr, img.Data...), nil
+}
+
+// hasSizeTable returns true if the data of the image starts with a
+// table of the sizes of its parts
+func (img *Image) hasSizeTable() bool {
+	return img.Header.Type == TypeMulti || img.Header.Type == TypeScript
+}
+
+// Parts returns the parts of multi-file images and scripts, like
+// image_multi_getimg() in uboot. Other images have a single part, the
+// whole data.
+func (img *Image) Parts() ([][]byte, error) {
+	if !img.hasSizeTable() {
+		return [][]byte{img.Data}, nil
+	}
+
+	var sizes []uint32
+	off := 0
+	for {
+		if off+4 > len(img.Data) {
+			return nil, fmt.Errorf("cannot find end of size table")
+		}
+		size := binary.BigEndian.Uint32(img.Data[off:])
+		off += 4
+		if size == 0 {
+			break
+		}
+		sizes = append(sizes, size)
+	}
+
+	parts := make([][]byte, 0, len(sizes))
+	for i, size := range sizes {
+		if uint64(off)+uint64(size) > uint64(len(img.Data)) {
+			left := len(img.Data) - off
+			if left < 0 {
+				left = 0
+			}
+			return nil, fmt.Errorf("part %v truncated: %v bytes expected but only %v bytes left", i, size, left)
+		}
+		parts = append(parts, img.Data[off:off+int(size)])
+		// parts are aligned to 4 bytes
+		off += int(size+3) &^ 3
+	}
+	return parts, nil
+}
+
+// SetParts replaces the data of a multi-file image or script with the
+// given parts, e.g. to patch the DTB of a multi-file image. The header
+// is not updated, use Rehash afterwards.
+func (img *Image) SetParts(parts [][]byte) error {
+	if !img.hasSizeTable() {
+		if len(parts) != 1 {
+			return fmt.Errorf("cannot store %v parts in %s image", len(parts), img.Header.Type)
+		}
+		img.Data = parts[0]
+		return nil
+	}
+
+	buf := bytes.NewBuffer(nil)
+	for i, part := range parts {
+		if len(part) == 0 {
+			return fmt.Errorf("cannot store empty part %v", i)
+		}
+		binary.Write(buf, binary.BigEndian, uint32(len(part)))
+	}
+	binary.Write(buf, binary.BigEndian, uint32(0))
+	for i, part := range parts {
+		buf.Write(part)
+		// like mkimage the last part is not padded
+		if i < len(parts)-1 {
+			buf.Write(make([]byte, (4-len(part)%4)%4))
+		}
+	}
+	img.Data = buf.Bytes()
+	return nil
+}
+
+// VerifyDataCRC checks the size and the CRC of the image data against
+// the header
+func (img *Image) VerifyDataCRC() error {
+	if uint64(len(img.Data)) != uint64(img.Header.Size) {
+		return fmt.Errorf("bad image data size: %v != %v", img.Header.Size, len(img.Data))
+	}
+	if crc := crc32.ChecksumIEEE(img.Data); crc != img.Header.DataCRC {
+		return fmt.Errorf("bad image data CRC: %v != %v", img.Header.DataCRC, crc)
+	}
+	return nil
+}
+
+// Rehash updates the data size, the data CRC and the header CRC after
+// the data or the header got changed
+func (img *Image) Rehash() error {
+	img.Header.Size = uint32(len(img.Data))
+	img.Header.DataCRC = crc32.ChecksumIEEE(img.Data)
+	img.Header.HeaderCRC = 0
+	hdr, err := img.Header.marshal()
+	if err != nil {
+		return err
+	}
+	img.Header.HeaderCRC = headerCRC(hdr)
+	return nil
+}
+
+// Reader returns a reader over the data of the image decompressed as
+// given by the header. Multi-file images and scripts need PartReader.
+func (img *Image) Reader() (io.Reader, error) {
+	if img.hasSizeTable() {
+		return nil, fmt.Errorf("cannot read %s image as a whole, read its parts", img.Header.Type)
+	}
+	return decompress.NewReader(img.Header.Comp.String(), bytes.NewReader(img.Data))
+}
+
+// PartReader returns a reader over the given part of the image
+// decompressed as given by the header
+func (img *Image) PartReader(i int) (io.Reader, error) {
+	parts, err := img.Parts()
+	if err != nil {
+		return nil, err
+	}
+	if i < 0 || i >= len(parts) {
+		return nil, fmt.Errorf("cannot find part %v, image has %v parts", i, len(parts))
+	}
+	return decompress.NewReader(img.Header.Comp.String(), bytes.NewReader(parts[i]))
+}
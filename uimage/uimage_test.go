@@ -0,0 +1,279 @@
+package uimage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type uimageTestSuite struct{}
+
+var _ = Suite(&uimageTestSuite{})
+
+// makeImage returns an image with the given header and data, the size
+// and the CRCs are filled in like mkimage does
+func makeImage(c *C, hdr Header, data []byte) []byte {
+	hdr.Size = uint32(len(data))
+	hdr.DataCRC = crc32.ChecksumIEEE(data)
+	hdr.HeaderCRC = 0
+	buf, err := hdr.marshal()
+	c.Assert(err, IsNil)
+	binary.BigEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(buf))
+	return append(buf, data...)
+}
+
+// sizeTable returns the size table of a multi-file image
+func sizeTable(sizes ...uint32) []byte {
+	buf := bytes.NewBuffer(nil)
+	for _, size := range sizes {
+		binary.Write(buf, binary.BigEndian, size)
+	}
+	binary.Write(buf, binary.BigEndian, uint32(0))
+	return buf.Bytes()
+}
+
+func (s *uimageTestSuite) TestParseKernel(c *C) {
+	hdr := Header{
+		Time:       1459500000,
+		Load:       0x80008000,
+		EntryPoint: 0x80008000,
+		OS:         5,
+		Arch:       2,
+		Type:       TypeKernel,
+		Comp:       CompNone,
+		Name:       "Linux-4.4",
+	}
+	content := makeImage(c, hdr, []byte("kernel"))
+	// trailing data, e.g. on a partition
+	content = append(content, 0xff, 0xff)
+
+	img, err := Parse(content)
+	c.Assert(err, IsNil)
+	c.Check(img.Header.Name, Equals, "Linux-4.4")
+	c.Check(img.Header.Type, Equals, TypeKernel)
+	c.Check(img.Header.Load, Equals, uint32(0x80008000))
+	c.Check(img.Header.Size, Equals, uint32(6))
+	c.Check(img.Data, DeepEquals, []byte("kernel"))
+
+	parts, err := img.Parts()
+	c.Assert(err, IsNil)
+	c.Check(parts, DeepEquals, [][]byte{[]byte("kernel")})
+
+	out, err := img.MarshalBinary()
+	c.Assert(err, IsNil)
+	c.Check(out, DeepEquals, content[:len(content)-2])
+
+	img, err = Read(bytes.NewReader(content))
+	c.Assert(err, IsNil)
+	c.Check(img.Data, DeepEquals, []byte("kernel"))
+}
+
+func (s *uimageTestSuite) TestParseErrors(c *C) {
+	good := makeImage(c, Header{Type: TypeRamdisk, Name: "initrd"}, []byte("ramdisk"))
+
+	_, err := Parse(good[:10])
+	c.Check(err, ErrorMatches, "image too small: 10 bytes")
+	_, err = Parse(make([]byte, 64))
+	c.Check(err, ErrorMatches, "bad image magic: 0x0")
+
+	broken := append([]byte(nil), good...)
+	broken[40] ^= 0xff
+	_, err = Parse(broken)
+	c.Check(err, ErrorMatches, "bad image header CRC: .*")
+
+	_, err = Parse(good[:len(good)-1])
+	c.Check(err, ErrorMatches, "image truncated: 7 bytes of data expected but only 6 bytes found")
+}
+
+func (s *uimageTestSuite) TestMulti(c *C) {
+	data := append(sizeTable(5, 3, 4), []byte("kern\x01\x00\x00\x00rd\x02\x00dtb\x03")...)
+	img, err := Parse(makeImage(c, Header{Type: TypeMulti}, data))
+	c.Assert(err, IsNil)
+
+	parts, err := img.Parts()
+	c.Assert(err, IsNil)
+	c.Check(parts, DeepEquals, [][]byte{
+		[]byte("kern\x01"),
+		[]byte("rd\x02"),
+		[]byte("dtb\x03"),
+	})
+}
+
+func (s *uimageTestSuite) TestScript(c *C) {
+	data := append(sizeTable(11), []byte("echo hello\n")...)
+	img, err := Parse(makeImage(c, Header{Type: TypeScript}, data))
+	c.Assert(err, IsNil)
+
+	parts, err := img.Parts()
+	c.Assert(err, IsNil)
+	c.Check(parts, DeepEquals, [][]byte{[]byte("echo hello\n")})
+}
+
+func (s *uimageTestSuite) TestMultiErrors(c *C) {
+	img := &Image{Header: Header{Type: TypeMulti}, Data: []byte{0, 0, 0, 5}}
+	_, err := img.Parts()
+	c.Check(err, ErrorMatches, "cannot find end of size table")
+
+	img.Data = append(sizeTable(2, 8), 1, 2, 0, 0, 3)
+	_, err = img.Parts()
+	c.Check(err, ErrorMatches, "part 1 truncated: 8 bytes expected but only 1 bytes left")
+}
+
+func gzipped(c *C, data string) []byte {
+	buf := bytes.NewBuffer(nil)
+	w := gzip.NewWriter(buf)
+	_, err := w.Write([]byte(data))
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+	return buf.Bytes()
+}
+
+func (s *uimageTestSuite) TestReader(c *C) {
+	img := &Image{Header: Header{Type: TypeKernel, Comp: CompGzip}, Data: gzipped(c, "kernel")}
+	r, err := img.Reader()
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, "kernel")
+
+	img.Header.Comp = CompLZO
+	_, err = img.Reader()
+	c.Check(err, ErrorMatches, `unsupported compression "lzo"`)
+
+	img.Header.Type = TypeMulti
+	_, err = img.Reader()
+	c.Check(err, ErrorMatches, "cannot read multi image as a whole, read its parts")
+}
+
+func (s *uimageTestSuite) TestPartReader(c *C) {
+	kernel := gzipped(c, "kernel")
+	dtb := gzipped(c, "dtb")
+	img := &Image{Header: Header{Type: TypeMulti, Comp: CompGzip}}
+	c.Assert(img.SetParts([][]byte{kernel, dtb}), IsNil)
+
+	r, err := img.PartReader(1)
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, "dtb")
+
+	_, err = img.PartReader(2)
+	c.Check(err, ErrorMatches, "cannot find part 2, image has 2 parts")
+}
+
+func (s *uimageTestSuite) TestNames(c *C) {
+	c.Check(TypeFirmware.String(), Equals, "firmware")
+	c.Check(TypeStandalone.String(), Equals, "standalone")
+	c.Check(Type(99).String(), Equals, "type 99")
+	c.Check(CompLZ4.String(), Equals, "lz4")
+	c.Check(Compression(99).String(), Equals, "compression 99")
+}
+
+func (s *uimageTestSuite) TestNameTooLong(c *C) {
+	img := &Image{Header: Header{Name: string(make([]byte, 33))}}
+	_, err := img.MarshalBinary()
+	c.Check(err, ErrorMatches, "image name too long: 33 bytes")
+}
+
+func (s *uimageTestSuite) TestVerifyDataCRC(c *C) {
+	img, err := Parse(makeImage(c, Header{Type: TypeKernel}, []byte("kernel")))
+	c.Assert(err, IsNil)
+	c.Check(img.VerifyDataCRC(), IsNil)
+
+	img.Data[0] = 'K'
+	c.Check(img.VerifyDataCRC(), ErrorMatches, "bad image data CRC: .*")
+	img.Data = img.Data[:5]
+	c.Check(img.VerifyDataCRC(), ErrorMatches, "bad image data size: 6 != 5")
+}
+
+func (s *uimageTestSuite) TestRehash(c *C) {
+	content := makeImage(c, Header{Type: TypeKernel, Name: "old"}, []byte("kernel"))
+	img, err := Parse(content)
+	c.Assert(err, IsNil)
+
+	img.Data = []byte("patched kernel")
+	img.Header.Name = "new"
+	c.Assert(img.Rehash(), IsNil)
+	c.Check(img.VerifyDataCRC(), IsNil)
+
+	out, err := img.MarshalBinary()
+	c.Assert(err, IsNil)
+	c.Check(out, DeepEquals, makeImage(c, Header{Type: TypeKernel, Name: "new"}, []byte("patched kernel")))
+	img, err = Parse(out)
+	c.Assert(err, IsNil)
+	c.Check(img.Header.Name, Equals, "new")
+}
+
+func (s *uimageTestSuite) TestPatchMulti(c *C) {
+	data := append(sizeTable(5, 3), []byte("kern\x01\x00\x00\x00dtb")...)
+	img, err := Parse(makeImage(c, Header{Type: TypeMulti}, data))
+	c.Assert(err, IsNil)
+	parts, err := img.Parts()
+	c.Assert(err, IsNil)
+
+	parts[1] = []byte("new dtb")
+	c.Assert(img.SetParts(parts), IsNil)
+	c.Assert(img.Rehash(), IsNil)
+	c.Check(img.Data, DeepEquals, append(sizeTable(5, 7), []byte("kern\x01\x00\x00\x00new dtb")...))
+
+	out, err := img.MarshalBinary()
+	c.Assert(err, IsNil)
+	img, err = Parse(out)
+	c.Assert(err, IsNil)
+	c.Check(img.VerifyDataCRC(), IsNil)
+	parts, err = img.Parts()
+	c.Assert(err, IsNil)
+	c.Check(parts, DeepEquals, [][]byte{[]byte("kern\x01"), []byte("new dtb")})
+}
+
+func (s *uimageTestSuite) TestSetPartsErrors(c *C) {
+	img := &Image{Header: Header{Type: TypeKernel}}
+	c.Check(img.SetParts([][]byte{{1}, {2}}), ErrorMatches, "cannot store 2 parts in kernel image")
+	img.Header.Type = TypeMulti
+	c.Check(img.SetParts([][]byte{{1}, {}}), ErrorMatches, "cannot store empty part 1")
+}
+
+func FuzzParse(f *testing.F) {
+	for _, t := range []struct {
+		typ   Type
+		parts [][]byte
+	}{
+		{TypeKernel, [][]byte{[]byte("kernel")}},
+		{TypeMulti, [][]byte{[]byte("kernel"), []byte("initrd")}},
+	} {
+		img := &Image{Header: Header{Type: t.typ, Name: "fuzz"}}
+		if err := img.SetParts(t.parts); err != nil {
+			f.Fatal(err)
+		}
+		if err := img.Rehash(); err != nil {
+			f.Fatal(err)
+		}
+		content, err := img.MarshalBinary()
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(content)
+	}
+	f.Fuzz(func(t *testing.T, content []byte) {
+		img, err := Parse(content)
+		if err != nil {
+			return
+		}
+		img.VerifyDataCRC()
+		parts, err := img.Parts()
+		if err != nil {
+			return
+		}
+		for i := range parts {
+			img.PartReader(i)
+		}
+	})
+}